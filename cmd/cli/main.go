@@ -0,0 +1,28 @@
+// Command cli runs the wallet commands of the bot in the console with in-memory wallets.
+// It needs no LNbits, no chat platform and no config.yaml. Run it from the repository root
+// so that the translations are found.
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/massmux/SatsMobiBot/internal/commands"
+	"github.com/massmux/SatsMobiBot/internal/commands/cli"
+	"github.com/massmux/SatsMobiBot/internal/i18n"
+	log "github.com/sirupsen/logrus"
+)
+
+func main() {
+	name := flag.String("user", "alice", "name of the first user")
+	deposit := flag.Int64("deposit", 10000, "sats in the wallet of the first user")
+	flag.Parse()
+
+	service := commands.NewMemoryService()
+	service.Deposit(cli.User(*name), *deposit)
+	repl := cli.New(service, *name, os.Stdin, os.Stdout)
+	repl.Dispatcher.Translate = i18n.Translate
+	if err := repl.Run(); err != nil {
+		log.Fatalln(err)
+	}
+}
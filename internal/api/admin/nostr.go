@@ -0,0 +1,38 @@
+package admin
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/massmux/SatsMobiBot/internal/api"
+	log "github.com/sirupsen/logrus"
+)
+
+// zapReceiptKind is the nostr event kind of NIP-57 zap receipts
+const zapReceiptKind = 9735
+
+// NostrRelays returns the publish health of all relays
+func (s Service) NostrRelays(w http.ResponseWriter, r *http.Request) {
+	err := api.WriteResponse(w, s.bot.NostrRelayHealth())
+	if err != nil {
+		log.Errorf("[ADMIN] could not write nostr relays: %v", err)
+	}
+}
+
+// NostrEvents returns the publish status of queued nostr events per relay. By default zap
+// receipts are returned, other event kinds can be selected with ?kind=<kind> (0 for all).
+func (s Service) NostrEvents(w http.ResponseWriter, r *http.Request) {
+	kind := zapReceiptKind
+	if k := r.URL.Query().Get("kind"); k != "" {
+		var err error
+		kind, err = strconv.Atoi(k)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	err := api.WriteResponse(w, s.bot.NostrPublishJobs(kind))
+	if err != nil {
+		log.Errorf("[ADMIN] could not write nostr events: %v", err)
+	}
+}
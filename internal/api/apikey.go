@@ -0,0 +1,167 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	decodepay "github.com/fiatjaf/ln-decodepay"
+	"github.com/massmux/SatsMobiBot/internal/apikey"
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	"github.com/massmux/SatsMobiBot/internal/telegram"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// apiKeyScopes are the scopes that routes require from bot-issued API keys.
+// Routes that are not listed can't be used with API keys.
+var apiKeyScopes = []struct {
+	prefix string
+	scope  apikey.Scope
+}{
+	{"/api/v1/balance", apikey.ScopeBalance},
+	{"/app/api/balance", apikey.ScopeBalance},
+	{"/lndhub/ext/balance", apikey.ScopeBalance},
+	{"/lndhub/ext/getinfo", apikey.ScopeBalance},
+	{"/lndhub/ext/getbtc", apikey.ScopeBalance},
+	{"/api/v1/createinvoice", apikey.ScopeInvoice},
+	{"/api/v1/invoicestatus/", apikey.ScopeInvoice},
+	{"/api/v1/paymentstatus/", apikey.ScopeInvoice},
+	{"/api/v1/invoicestream", apikey.ScopeInvoice},
+	{"/app/api/invoice", apikey.ScopeInvoice},
+	{"/lndhub/ext/addinvoice", apikey.ScopeInvoice},
+	{"/lndhub/ext/checkpayment/", apikey.ScopeInvoice},
+	{"/lndhub/ext/decodeinvoice", apikey.ScopeInvoice},
+	{"/app/api/payments", apikey.ScopeHistory},
+	{"/lndhub/ext/gettxs", apikey.ScopeHistory},
+	{"/lndhub/ext/getuserinvoices", apikey.ScopeHistory},
+	{"/lndhub/ext/getpending", apikey.ScopeHistory},
+	{"/api/v1/payinvoice", apikey.ScopePay},
+	{"/app/api/pay", apikey.ScopePay},
+	{"/app/api/send", apikey.ScopePay},
+	{"/lndhub/ext/payinvoice", apikey.ScopePay},
+}
+
+func routeScope(path string) apikey.Scope {
+	for _, route := range apiKeyScopes {
+		if strings.HasPrefix(path, route.prefix) {
+			return route.scope
+		}
+	}
+	return ""
+}
+
+// statusRecorder remembers the status of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// serveAPIKey authorizes a request with a bot-issued API key. Payments are counted against
+// the daily limit of the key before they are forwarded and given back if they fail.
+func serveAPIKey(database *gorm.DB, authType AuthType, secret string, w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	key, err := apikey.Find(database, secret)
+	if err != nil {
+		log.Warnf("[api] %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	scope := routeScope(r.URL.Path)
+	if !key.Allows(scope, r.URL.Path) {
+		log.Warnf("[api] api key %s not allowed on %s", key.ID, r.URL.Path)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	user := &lnbits.User{}
+	tx := database.Where("name = ?", key.UserName).First(user)
+	if tx.Error != nil || user.Banned || user.Wallet == nil || strings.HasPrefix(user.Wallet.Adminkey, "banned_") {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	apikey.Touch(database, key.ID)
+	if authType.Decoder != nil {
+		// lndhub requests are forwarded to LNbits with the wallet key that the scope needs
+		login, walletKey := "invoice", user.Wallet.Inkey
+		if scope == apikey.ScopePay {
+			login, walletKey = "admin", user.Wallet.Adminkey
+		}
+		r.Header.Set("Authorization", fmt.Sprintf("%s %s", authType.Type, base64.StdEncoding.EncodeToString([]byte(login+":"+walletKey))))
+	}
+	log.Debugf("[api] User: %s (api key %s) Endpoint: %s %s %s", telegram.GetUserStr(user.Telegram), key.ID, r.Method, r.URL.Path, r.URL.RawQuery)
+	r = r.WithContext(context.WithValue(context.WithValue(r.Context(), "user", user), "apikey", key))
+	if scope != apikey.ScopePay {
+		next.ServeHTTP(w, r)
+		return
+	}
+	amount, err := paymentAmount(r)
+	if err != nil {
+		RespondError(w, err.Error())
+		return
+	}
+	if err = apikey.Reserve(database, key.ID, amount); err != nil {
+		log.Warnf("[api] api key %s: %v", key.ID, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(ErrorResponse{Message: err.Error()})
+		return
+	}
+	recorder := &statusRecorder{ResponseWriter: w}
+	next.ServeHTTP(recorder, r)
+	if recorder.status == 0 || recorder.status >= 300 {
+		apikey.Release(database, key.ID, amount)
+	}
+}
+
+// paymentAmount reads the amount in sat of a payment request. The body is restored for the handler.
+func paymentAmount(r *http.Request) (int64, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 64*1024))
+	if err != nil {
+		return 0, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	request := struct {
+		PayRequest string      `json:"pay_req"`
+		Invoice    string      `json:"invoice"`
+		Amount     json.Number `json:"amount"`
+	}{}
+	if err = json.Unmarshal(body, &request); err != nil {
+		return 0, fmt.Errorf("invalid request")
+	}
+	invoice := request.PayRequest
+	if len(invoice) == 0 {
+		invoice = request.Invoice
+	}
+	var amount int64
+	if len(invoice) > 0 {
+		bolt11, err := decodepay.Decodepay(strings.TrimPrefix(strings.ToLower(invoice), "lightning:"))
+		if err != nil {
+			return 0, fmt.Errorf("invalid invoice")
+		}
+		amount = int64(bolt11.MSatoshi / 1000)
+	}
+	if amount == 0 && len(request.Amount) > 0 {
+		amount, _ = strconv.ParseInt(request.Amount.String(), 10, 64)
+	}
+	if amount < 1 {
+		return 0, fmt.Errorf("payment has no amount")
+	}
+	return amount, nil
+}
@@ -6,10 +6,13 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httputil"
+	"strconv"
 	"strings"
 
+	"github.com/massmux/SatsMobiBot/internal/apikey"
 	"github.com/massmux/SatsMobiBot/internal/lnbits"
 	"github.com/massmux/SatsMobiBot/internal/telegram"
+	"github.com/massmux/SatsMobiBot/internal/webapp"
 	"gorm.io/gorm"
 
 	log "github.com/sirupsen/logrus"
@@ -33,6 +36,32 @@ var AuthTypeBasic = AuthType{Type: "Basic"}
 var AuthTypeBearerBase64 = AuthType{Type: "Bearer", Decoder: base64.StdEncoding.DecodeString}
 var AuthTypeNone = AuthType{}
 
+// AuthTypeWebApp is a session of the Telegram Mini App. It is only accepted on webAppRoutes.
+var AuthTypeWebApp = AuthType{Type: "WebApp"}
+
+// webAppRoutes are the route prefixes that accept sessions of the Mini App. Paying invoices
+// over the REST api or lndhub needs the admin key.
+var webAppRoutes = []string{
+	"/app/api/",
+	"/api/v1/balance",
+	"/api/v1/createinvoice",
+	"/api/v1/invoicestatus/",
+	"/api/v1/paymentstatus/",
+	"/api/v1/invoicestream",
+}
+
+func isWebAppRoute(path string) bool {
+	for _, prefix := range webAppRoutes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// WebAppSessions are the sessions that were created with a valid initData of the Mini App
+var WebAppSessions = webapp.NewSessions(webapp.SessionDuration)
+
 // invoice key or admin key requirement
 type AccessKeyType struct {
 	Type string
@@ -55,11 +84,33 @@ func AuthorizationMiddleware(database *gorm.DB, authType AuthType, accessType Ac
 			log.Warn("[api] no auth")
 			return
 		}
+		if token, ok := parseAuthWebApp(auth); ok {
+			if !isWebAppRoute(r.URL.Path) {
+				log.Warnf("[api] webapp session on %s", r.URL.Path)
+				w.WriteHeader(401)
+				return
+			}
+			user, err := webAppSessionUser(database, token)
+			if err != nil {
+				log.Warnf("[api] invalid webapp session: %v", err)
+				w.WriteHeader(401)
+				return
+			}
+			log.Debugf("[api] User: %s (webapp) Endpoint: %s %s %s", telegram.GetUserStr(user.Telegram), r.Method, r.URL.Path, r.URL.RawQuery)
+			r = r.WithContext(context.WithValue(r.Context(), "user", user))
+			next.ServeHTTP(w, r)
+			return
+		}
 		_, password, ok := parseAuth(authType, auth)
 		if !ok {
 			w.WriteHeader(401)
 			return
 		}
+		// bot-issued api keys are limited to their scopes instead of the access type of the route
+		if apikey.IsKey(password) {
+			serveAPIKey(database, authType, password, w, r, next)
+			return
+		}
 		// first we make sure that the password is not already "banned_"
 		if strings.Contains(password, "_") || strings.HasPrefix(password, "banned_") {
 			w.WriteHeader(401)
@@ -91,6 +142,30 @@ func AuthorizationMiddleware(database *gorm.DB, authType AuthType, accessType Ac
 	}
 }
 
+// parseAuthWebApp returns the session token of "WebApp <token>"
+func parseAuthWebApp(auth string) (token string, ok bool) {
+	token, _, ok = parseAuth(AuthTypeWebApp, auth)
+	return token, ok && len(token) > 0
+}
+
+// webAppSessionUser loads the wallet of the session. Sessions have the rights of the admin key.
+func webAppSessionUser(database *gorm.DB, token string) (*lnbits.User, error) {
+	session, ok := WebAppSessions.Get(token)
+	if !ok {
+		return nil, fmt.Errorf("session expired")
+	}
+	user := &lnbits.User{}
+	tx := database.Where("name = ?", strconv.FormatInt(session.TelegramID, 10)).First(user)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	if user.Banned || user.Wallet == nil || strings.HasPrefix(user.Wallet.Adminkey, "banned_") {
+		WebAppSessions.Delete(token)
+		return nil, fmt.Errorf("user %d has no active wallet", session.TelegramID)
+	}
+	return user, nil
+}
+
 // parseAuth parses an HTTP Basic Authentication string.
 // "Bearer QWxhZGRpbjpvcGVuIHNlc2FtZQ==" returns ("Aladdin", "open sesame", true).
 func parseAuth(authType AuthType, auth string) (username, password string, ok bool) {
@@ -0,0 +1,168 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/massmux/SatsMobiBot/internal"
+	"github.com/massmux/SatsMobiBot/internal/telegram"
+	"github.com/massmux/SatsMobiBot/internal/webapp"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+// webAppCurrencies are the display currencies that can be selected in the Mini App
+var webAppCurrencies = []string{"btc", "usd", "eur", "gbp"}
+
+type WebAppAuthRequest struct {
+	InitData string `json:"init_data"`
+}
+
+type WebAppAuthResponse struct {
+	webapp.Session
+	Username string `json:"username"`
+}
+
+type WebAppSendRequest struct {
+	Amount int64  `json:"amount"`
+	To     string `json:"to"`
+	Memo   string `json:"memo"`
+}
+
+type WebAppSendResponse struct {
+	Amount int64  `json:"amount"`
+	To     string `json:"to"`
+}
+
+type WebAppPayResponse struct {
+	Amount int64 `json:"amount"`
+}
+
+type WebAppSettings struct {
+	Currency string `json:"currency"`
+}
+
+// WebAppAuth exchanges the initData of the Mini App for a short-lived session. The session
+// token is sent as "Authorization: WebApp <token>" to all authorized routes.
+func (s Service) WebAppAuth(w http.ResponseWriter, r *http.Request) {
+	var authRequest WebAppAuthRequest
+	if err := json.NewDecoder(r.Body).Decode(&authRequest); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	initData, err := webapp.Validate(authRequest.InitData, internal.Configuration.Telegram.ApiKey, webapp.InitDataMaxAge)
+	if err != nil {
+		log.Warnf("[webapp] %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	user, err := telegram.GetLnbitsUser(&tb.User{ID: initData.User.ID}, *s.Bot)
+	if err != nil || user.Wallet == nil || user.Banned {
+		RespondError(w, "no wallet. Start the bot first")
+		return
+	}
+	session, err := WebAppSessions.Create(initData.User.ID)
+	if err != nil {
+		RespondError(w, "could not create session")
+		return
+	}
+	log.Infof("[webapp] session for %s", telegram.GetUserStr(user.Telegram))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(WebAppAuthResponse{Session: session, Username: telegram.GetUserStr(user.Telegram)})
+}
+
+// WebAppLogout ends the session of the request
+func (s Service) WebAppLogout(w http.ResponseWriter, r *http.Request) {
+	if token, ok := parseAuthWebApp(r.Header.Get("Authorization")); ok {
+		WebAppSessions.Delete(token)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// WebAppPayments returns the latest payments of the wallet
+func (s Service) WebAppPayments(w http.ResponseWriter, r *http.Request) {
+	user := telegram.LoadUser(r.Context())
+	payments, err := s.Bot.Client.Payments(*user.Wallet)
+	if err != nil {
+		RespondError(w, "could not get payments")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(payments)
+}
+
+// WebAppSend sends sats to a @username, a matrix id or a linked npub
+func (s Service) WebAppSend(w http.ResponseWriter, r *http.Request) {
+	user := telegram.LoadUser(r.Context())
+	var sendRequest WebAppSendRequest
+	if err := json.NewDecoder(r.Body).Decode(&sendRequest); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := s.Bot.WebAppSend(user, strings.TrimSpace(sendRequest.To), sendRequest.Amount, sendRequest.Memo)
+	if err != nil {
+		RespondError(w, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(WebAppSendResponse{Amount: sendRequest.Amount, To: to.String()})
+}
+
+// WebAppPay pays an invoice after checking the balance, for example one that was scanned in the Mini App
+func (s Service) WebAppPay(w http.ResponseWriter, r *http.Request) {
+	user := telegram.LoadUser(r.Context())
+	var payRequest PayInvoiceRequest
+	if err := json.NewDecoder(r.Body).Decode(&payRequest); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	amount, err := s.Bot.WalletService().Pay(telegram.CommandUser(user), strings.TrimSpace(payRequest.PayRequest))
+	if err != nil {
+		RespondError(w, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(WebAppPayResponse{Amount: amount})
+}
+
+// WebAppSettings returns the settings of the user on GET and updates them on POST
+func (s Service) WebAppSettings(w http.ResponseWriter, r *http.Request) {
+	user, err := telegram.GetLnbitsUserWithSettings(telegram.LoadUser(r.Context()).Telegram, *s.Bot)
+	if err != nil {
+		RespondError(w, "could not load settings")
+		return
+	}
+	if r.Method == http.MethodPost {
+		var settings WebAppSettings
+		if err = json.NewDecoder(r.Body).Decode(&settings); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		currency := strings.ToLower(settings.Currency)
+		if !isWebAppCurrency(currency) {
+			RespondError(w, "invalid currency")
+			return
+		}
+		user.Settings.Display.DisplayCurrency = currency
+		if err = telegram.UpdateUserRecord(user, *s.Bot); err != nil {
+			RespondError(w, "could not update settings")
+			return
+		}
+	}
+	currency := user.Settings.Display.DisplayCurrency
+	if currency == "" {
+		currency = "btc"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(WebAppSettings{Currency: strings.ToLower(currency)})
+}
+
+func isWebAppCurrency(currency string) bool {
+	for _, c := range webAppCurrencies {
+		if c == currency {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,256 @@
+// Package apikey implements API keys that the bot issues with /api keys. Unlike the LNbits
+// wallet keys they are limited to scopes, endpoints, a daily payment limit and an expiry.
+// Only the SHA256 hash of a key is stored.
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Prefix marks bot-issued API keys. It contains no underscore, which the API treats as banned.
+const Prefix = "sbk-"
+
+// MaxKeysPerUser limits the active keys of a user
+const MaxKeysPerUser = 10
+
+type Scope string
+
+const (
+	ScopeBalance Scope = "balance"
+	ScopeInvoice Scope = "invoice"
+	ScopeHistory Scope = "history"
+	ScopePay     Scope = "pay"
+)
+
+var (
+	ErrInvalidKey    = errors.New("invalid api key")
+	ErrExpired       = errors.New("api key expired")
+	ErrLimitExceeded = errors.New("daily payment limit of api key exceeded")
+	ErrInvalidScope  = errors.New("invalid scope")
+)
+
+// Key is an API key of a wallet
+type Key struct {
+	ID       string `gorm:"primaryKey"`
+	UserName string `gorm:"index"` // Name of the lnbits.User that owns the key
+	Label    string
+	Hash     string `gorm:"uniqueIndex"`
+	// Scopes are the space separated scopes of the key
+	Scopes string
+	// Endpoints are space separated path prefixes. If set, the key only works on these endpoints.
+	Endpoints string
+	// DailyLimit is the amount in sat that the key can pay per day with ScopePay
+	DailyLimit int64
+	SpentDay   string
+	SpentToday int64
+	ExpiresAt  time.Time
+	LastUsedAt time.Time
+	CreatedAt  time.Time
+	Revoked    bool
+}
+
+func (Key) TableName() string {
+	return "api_keys"
+}
+
+// Hash returns the stored hash of a key
+func Hash(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:])
+}
+
+// IsKey reports whether the secret is a bot-issued key and not an LNbits wallet key
+func IsKey(secret string) bool {
+	return strings.HasPrefix(secret, Prefix)
+}
+
+// Generate returns a new secret key and its public ID. The secret is only shown once. The ID
+// is random on its own, so it reveals nothing about the secret.
+func Generate() (secret string, id string, err error) {
+	if secret, err = randomHex(24); err != nil {
+		return "", "", err
+	}
+	if id, err = randomHex(8); err != nil {
+		return "", "", err
+	}
+	return Prefix + secret, id, nil
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Parse parses arguments like "shop invoice balance pay:1000 endpoint:/api/v1/ expires:30d".
+// pay:<sat> allows payments up to <sat> per day. All other words are the label.
+func Parse(args []string) (Key, error) {
+	key := Key{}
+	var scopes, endpoints, label []string
+	for _, arg := range args {
+		lower := strings.ToLower(arg)
+		switch {
+		case lower == string(ScopeBalance) || lower == string(ScopeInvoice) || lower == string(ScopeHistory):
+			scopes = append(scopes, lower)
+		case strings.HasPrefix(lower, string(ScopePay)+":"):
+			limit, err := strconv.ParseInt(strings.TrimPrefix(lower, string(ScopePay)+":"), 10, 64)
+			if err != nil || limit < 1 {
+				return Key{}, fmt.Errorf("%w: %s", ErrInvalidScope, arg)
+			}
+			scopes = append(scopes, string(ScopePay))
+			key.DailyLimit = limit
+		case lower == string(ScopePay):
+			return Key{}, fmt.Errorf("%w: pay needs a daily limit like pay:1000", ErrInvalidScope)
+		case strings.HasPrefix(lower, "endpoint:"):
+			endpoint := strings.TrimPrefix(arg, "endpoint:")
+			if !strings.HasPrefix(endpoint, "/") {
+				return Key{}, fmt.Errorf("%w: %s", ErrInvalidScope, arg)
+			}
+			endpoints = append(endpoints, endpoint)
+		case strings.HasPrefix(lower, "expires:"):
+			days, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(lower, "expires:"), "d"))
+			if err != nil || days < 1 {
+				return Key{}, fmt.Errorf("%w: %s", ErrInvalidScope, arg)
+			}
+			key.ExpiresAt = time.Now().Add(time.Duration(days) * 24 * time.Hour)
+		default:
+			label = append(label, arg)
+		}
+	}
+	if len(scopes) == 0 {
+		return Key{}, fmt.Errorf("%w: no scope", ErrInvalidScope)
+	}
+	key.Scopes = strings.Join(scopes, " ")
+	key.Endpoints = strings.Join(endpoints, " ")
+	key.Label = strings.Join(label, " ")
+	return key, nil
+}
+
+// HasScope reports whether the key was issued with the scope
+func (k Key) HasScope(scope Scope) bool {
+	for _, s := range strings.Fields(k.Scopes) {
+		if Scope(s) == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows reports whether the key can be used for the scope on the path
+func (k Key) Allows(scope Scope, path string) bool {
+	if len(scope) == 0 || !k.HasScope(scope) {
+		return false
+	}
+	endpoints := strings.Fields(k.Endpoints)
+	if len(endpoints) == 0 {
+		return true
+	}
+	for _, endpoint := range endpoints {
+		if strings.HasPrefix(path, endpoint) {
+			return true
+		}
+	}
+	return false
+}
+
+func (k Key) Expired(now time.Time) bool {
+	return !k.ExpiresAt.IsZero() && now.After(k.ExpiresAt)
+}
+
+// Create stores a new key of the user and returns the secret
+func Create(database *gorm.DB, userName string, key Key) (string, Key, error) {
+	var count int64
+	database.Model(&Key{}).Where("user_name = ? AND revoked = ?", userName, false).Count(&count)
+	if count >= MaxKeysPerUser {
+		return "", Key{}, fmt.Errorf("you can have at most %d api keys", MaxKeysPerUser)
+	}
+	secret, id, err := Generate()
+	if err != nil {
+		return "", Key{}, err
+	}
+	key.ID = id
+	key.UserName = userName
+	key.Hash = Hash(secret)
+	key.CreatedAt = time.Now()
+	if tx := database.Create(&key); tx.Error != nil {
+		return "", Key{}, tx.Error
+	}
+	return secret, key, nil
+}
+
+// Find returns the active key of the secret
+func Find(database *gorm.DB, secret string) (*Key, error) {
+	key := &Key{}
+	tx := database.Where("hash = ? AND revoked = ?", Hash(secret), false).First(key)
+	if tx.Error != nil {
+		return nil, ErrInvalidKey
+	}
+	if key.Expired(time.Now()) {
+		return nil, ErrExpired
+	}
+	return key, nil
+}
+
+// List returns the keys of the user that were not revoked
+func List(database *gorm.DB, userName string) ([]Key, error) {
+	var keys []Key
+	tx := database.Where("user_name = ? AND revoked = ?", userName, false).Order("created_at").Find(&keys)
+	return keys, tx.Error
+}
+
+// Revoke disables the key of the user with the ID
+func Revoke(database *gorm.DB, userName string, id string) error {
+	tx := database.Model(&Key{}).Where("id = ? AND user_name = ? AND revoked = ?", id, userName, false).Update("revoked", true)
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return ErrInvalidKey
+	}
+	return nil
+}
+
+// Touch sets the last used timestamp of the key
+func Touch(database *gorm.DB, id string) {
+	database.Model(&Key{}).Where("id = ?", id).Update("last_used_at", time.Now())
+}
+
+func day(now time.Time) string {
+	return now.UTC().Format("2006-01-02")
+}
+
+// Reserve adds the amount to the payments of today. It fails if the daily limit would be exceeded.
+func Reserve(database *gorm.DB, id string, amount int64) error {
+	today := day(time.Now())
+	tx := database.Model(&Key{}).Where("id = ? AND spent_day <> ?", id, today).
+		Updates(map[string]interface{}{"spent_day": today, "spent_today": 0})
+	if tx.Error != nil {
+		return tx.Error
+	}
+	tx = database.Model(&Key{}).Where("id = ? AND spent_day = ? AND spent_today + ? <= daily_limit", id, today, amount).
+		Update("spent_today", gorm.Expr("spent_today + ?", amount))
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		return ErrLimitExceeded
+	}
+	return nil
+}
+
+// Release gives back a reserved amount after a failed payment
+func Release(database *gorm.DB, id string, amount int64) {
+	database.Model(&Key{}).Where("id = ? AND spent_day = ?", id, day(time.Now())).
+		Update("spent_today", gorm.Expr("spent_today - ?", amount))
+}
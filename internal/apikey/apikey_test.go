@@ -0,0 +1,113 @@
+package apikey
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func Test_Parse(t *testing.T) {
+	tests := []struct {
+		name      string
+		args      []string
+		scopes    string
+		endpoints string
+		limit     int64
+		label     string
+		wantErr   bool
+	}{
+		{name: "label and scopes", args: []string{"my", "shop", "invoice", "balance"}, scopes: "invoice balance", label: "my shop"},
+		{name: "pay limit", args: []string{"pos", "pay:1000", "endpoint:/api/v1/payinvoice"}, scopes: "pay", endpoints: "/api/v1/payinvoice", limit: 1000, label: "pos"},
+		{name: "pay without limit", args: []string{"pay"}, wantErr: true},
+		{name: "no scope", args: []string{"label"}, wantErr: true},
+		{name: "invalid expiry", args: []string{"balance", "expires:0d"}, wantErr: true},
+		{name: "relative endpoint", args: []string{"balance", "endpoint:api"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := Parse(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if key.Scopes != tt.scopes || key.Endpoints != tt.endpoints || key.DailyLimit != tt.limit || key.Label != tt.label {
+				t.Errorf("Parse() = %+v", key)
+			}
+		})
+	}
+}
+
+func Test_Allows(t *testing.T) {
+	key := Key{Scopes: "balance invoice", Endpoints: "/api/v1/"}
+	if !key.Allows(ScopeBalance, "/api/v1/balance") {
+		t.Errorf("balance not allowed")
+	}
+	if key.Allows(ScopePay, "/api/v1/payinvoice") {
+		t.Errorf("pay allowed without scope")
+	}
+	if key.Allows(ScopeInvoice, "/lndhub/ext/addinvoice") {
+		t.Errorf("invoice allowed on other endpoint")
+	}
+	if key.Allows("", "/api/v1/balance") {
+		t.Errorf("unknown scope allowed")
+	}
+}
+
+func Test_Generate(t *testing.T) {
+	secret, id, err := Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsKey(secret) || len(secret) != len(Prefix)+48 || len(id) != 16 {
+		t.Fatalf("Generate() = %s, %s", secret, id)
+	}
+	// the public ID must not give away any part of the secret
+	if strings.Contains(secret, id) || strings.Contains(secret, id[:8]) {
+		t.Errorf("ID %s is part of the secret %s", id, secret)
+	}
+	if _, other, _ := Generate(); other == id {
+		t.Errorf("Generate() returned the ID %s twice", id)
+	}
+}
+
+func Test_Keys(t *testing.T) {
+	database, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Skipf("no sqlite: %v", err)
+	}
+	if err = database.AutoMigrate(&Key{}); err != nil {
+		t.Fatal(err)
+	}
+	secret, key, err := Create(database, "1234", Key{Scopes: "pay", DailyLimit: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsKey(secret) || key.Hash == secret {
+		t.Fatalf("Create() secret %s, hash %s", secret, key.Hash)
+	}
+	found, err := Find(database, secret)
+	if err != nil || found.ID != key.ID {
+		t.Fatalf("Find() = %v, %v", found, err)
+	}
+	if err = Reserve(database, key.ID, 60); err != nil {
+		t.Errorf("Reserve(60) = %v", err)
+	}
+	if err = Reserve(database, key.ID, 60); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("Reserve(60) over limit = %v", err)
+	}
+	Release(database, key.ID, 60)
+	if err = Reserve(database, key.ID, 100); err != nil {
+		t.Errorf("Reserve(100) after release = %v", err)
+	}
+	if err = Revoke(database, "other", key.ID); err == nil {
+		t.Errorf("Revoke() of another user succeeded")
+	}
+	if err = Revoke(database, "1234", key.ID); err != nil {
+		t.Errorf("Revoke() = %v", err)
+	}
+	if _, err = Find(database, secret); err == nil {
+		t.Errorf("Find() returned a revoked key")
+	}
+}
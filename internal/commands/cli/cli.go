@@ -0,0 +1,122 @@
+// Package cli is a console frontend of the command layer. Every line is a command of the
+// current user, lines starting with ":" control the console itself.
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/massmux/SatsMobiBot/internal/commands"
+)
+
+const Frontend = "cli"
+
+const helpText = `:user <name>       switch to user <name>
+:deposit <amount>  add sats to the current user (in-memory wallets only)
+:reply <name>      the next command replies to a message of <name>, for tip
+:help              show this help
+:quit              exit`
+
+// depositor is implemented by services with test wallets like commands.MemoryService
+type depositor interface {
+	Deposit(user commands.User, amount int64)
+}
+
+// REPL reads commands from in and writes all messages of the bot to out
+type REPL struct {
+	Dispatcher *commands.Dispatcher
+	in         io.Reader
+	out        io.Writer
+	user       commands.User
+	replyTo    *commands.User
+}
+
+// New returns a console for the service. The first user is called name.
+func New(service commands.Service, name string, in io.Reader, out io.Writer) *REPL {
+	r := &REPL{in: in, out: out, user: User(name)}
+	r.Dispatcher = &commands.Dispatcher{Service: service, Messenger: r}
+	return r
+}
+
+// User returns the console user with the name
+func User(name string) commands.User {
+	name = strings.TrimPrefix(name, "@")
+	return commands.User{Frontend: Frontend, ID: name, Name: "@" + name, LanguageCode: "en"}
+}
+
+// Reply prints the answer to the current user
+func (r *REPL) Reply(msg commands.Message, text string) error {
+	_, err := fmt.Fprintln(r.out, text)
+	return err
+}
+
+// Notify prints a message to another user
+func (r *REPL) Notify(user commands.User, text string) error {
+	_, err := fmt.Fprintf(r.out, "[to %s] %s\n", user, text)
+	return err
+}
+
+// Run reads lines until the input ends or :quit
+func (r *REPL) Run() error {
+	scanner := bufio.NewScanner(r.in)
+	r.prompt()
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, ":") {
+			if quit := r.control(line); quit {
+				return nil
+			}
+		} else if len(line) > 0 {
+			msg := commands.Message{From: r.user, Text: line, ReplyTo: r.replyTo}
+			if err := r.Dispatcher.Handle(msg); err != nil {
+				fmt.Fprintf(r.out, "(%s)\n", err.Error())
+			}
+			r.replyTo = nil
+		}
+		r.prompt()
+	}
+	return scanner.Err()
+}
+
+func (r *REPL) prompt() {
+	fmt.Fprintf(r.out, "%s> ", r.user)
+}
+
+// control runs a console command and returns true if the console should exit
+func (r *REPL) control(line string) bool {
+	fields := strings.Fields(line)
+	switch fields[0] {
+	case ":quit", ":q":
+		return true
+	case ":user":
+		if len(fields) == 2 {
+			r.user = User(fields[1])
+			// make the user known to the service so that others can send to it
+			r.Dispatcher.Service.Balance(r.user)
+			return false
+		}
+	case ":reply":
+		if len(fields) == 2 {
+			to := User(fields[1])
+			r.replyTo = &to
+			return false
+		}
+	case ":deposit":
+		d, ok := r.Dispatcher.Service.(depositor)
+		if !ok {
+			fmt.Fprintln(r.out, "deposits are not supported by this service")
+			return false
+		}
+		if len(fields) == 2 {
+			if amount, err := strconv.ParseInt(fields[1], 10, 64); err == nil && amount > 0 {
+				d.Deposit(r.user, amount)
+				return false
+			}
+		}
+	}
+	fmt.Fprintln(r.out, helpText)
+	return false
+}
@@ -0,0 +1,126 @@
+// Package commands is the frontend-neutral command layer of the bot. Frontends without their
+// own handlers, like nostr DMs, Matrix, the Telegram Mini App or the local console, turn their
+// messages into a Message, the Dispatcher runs the command against a Service and answers through
+// the Messenger of the frontend. The Telegram chat keeps its interactive handlers (confirmation
+// buttons, inline faucets) and doesn't use the Dispatcher or the Service, not even for /balance,
+// /invoice, /send or /tip. The handlers only share helper functions with its Service, so checks
+// like the balance, self payments and faucet rules exist once.
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrNoWallet            = errors.New("user has no wallet")
+	ErrInvalidAmount       = errors.New("invalid amount")
+	ErrInvalidInvoice      = errors.New("invalid invoice")
+	ErrBalanceTooLow       = errors.New("balance too low")
+	ErrUnknownRecipient    = errors.New("unknown recipient")
+	ErrSelfPayment         = errors.New("can't pay yourself")
+	ErrFaucetNotFound      = errors.New("faucet not found")
+	ErrFaucetEmpty         = errors.New("faucet is empty")
+	ErrFaucetAlreadyTaken  = errors.New("already took from faucet")
+	ErrFaucetInvalidAmount = errors.New("invalid faucet amount")
+	ErrFaucetNotEligible   = errors.New("not eligible for faucet")
+)
+
+// User is a user of a frontend. ID is unique within the frontend.
+type User struct {
+	Frontend     string
+	ID           string
+	Name         string
+	LanguageCode string
+}
+
+func (u User) String() string {
+	if len(u.Name) > 0 {
+		return u.Name
+	}
+	return u.ID
+}
+
+// Message is a text message that was sent to the bot
+type Message struct {
+	From User
+	Text string
+	// ReplyTo is the author of the message that this message replies to. It is the receiver of /tip.
+	ReplyTo *User
+	// Chat identifies the group chat of the message. It is empty in private chats.
+	Chat string
+}
+
+// Messenger delivers the answers of the bot on a frontend
+type Messenger interface {
+	// Reply answers the message that contained the command
+	Reply(msg Message, text string) error
+	// Notify sends a message to a user, for example the receiver of a payment
+	Notify(user User, text string) error
+}
+
+// WalletCreator is implemented by services that only create wallets on request. The start
+// command creates the wallet of the user.
+type WalletCreator interface {
+	CreateWallet(user User) error
+}
+
+// FaucetMessenger is implemented by messengers that show new faucets as messages users can
+// claim from directly, for example with a reaction
+type FaucetMessenger interface {
+	ReplyFaucet(msg Message, faucet Faucet, text string) error
+}
+
+// Faucet is a pot of sats from one user that other users can claim a fixed amount from
+type Faucet struct {
+	ID              string
+	From            User
+	Amount          int64
+	RemainingAmount int64
+	PerUserAmount   int64
+}
+
+// Service is the wallet logic that is shared by all frontends
+type Service interface {
+	Balance(user User) (int64, error)
+	// Invoice creates an invoice and returns the payment request
+	Invoice(user User, amount int64, memo string) (string, error)
+	// Pay pays an invoice and returns the paid amount
+	Pay(user User, invoice string) (int64, error)
+	// Resolve returns the user that a recipient like @username refers to
+	Resolve(from User, recipient string) (User, error)
+	// Transfer moves sats between two users of the bot. kind is send, tip or faucet.
+	Transfer(from User, to User, amount int64, memo string, kind string) error
+	CreateFaucet(from User, amount int64, perUserAmount int64) (Faucet, error)
+	// ClaimFaucet transfers the per user amount of the faucet to the user
+	ClaimFaucet(user User, id string) (Faucet, error)
+}
+
+// Command is a parsed command. Args do not include the command name.
+type Command struct {
+	Name string
+	Args []string
+}
+
+// Parse parses text like "invoice 1000 coffee" or "/send 100 @alice".
+// A leading slash and a bot mention (/balance@bot) are ignored.
+func Parse(text string) (Command, error) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return Command{}, fmt.Errorf("empty command")
+	}
+	name := strings.ToLower(strings.TrimPrefix(fields[0], "/"))
+	if i := strings.Index(name, "@"); i > 0 {
+		name = name[:i]
+	}
+	return Command{Name: name, Args: fields[1:]}, nil
+}
+
+// Memo returns the arguments starting at index i joined as a memo
+func (c Command) Memo(i int) string {
+	if len(c.Args) <= i {
+		return ""
+	}
+	return strings.Join(c.Args[i:], " ")
+}
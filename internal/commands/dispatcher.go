@@ -0,0 +1,247 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// errorMessages are the translation keys of the errors a Service returns
+var errorMessages = map[error]string{
+	ErrNoWallet:            "walletCommandNoWalletMessage",
+	ErrInvalidAmount:       "walletCommandInvalidAmountMessage",
+	ErrInvalidInvoice:      "walletCommandInvalidInvoiceMessage",
+	ErrBalanceTooLow:       "walletCommandBalanceTooLowMessage",
+	ErrUnknownRecipient:    "walletCommandUnknownRecipientMessage",
+	ErrSelfPayment:         "walletCommandSendYourselfMessage",
+	ErrFaucetNotFound:      "walletCommandFaucetNotFoundMessage",
+	ErrFaucetEmpty:         "walletCommandFaucetEmptyMessage",
+	ErrFaucetAlreadyTaken:  "walletCommandFaucetAlreadyTakenMessage",
+	ErrFaucetInvalidAmount: "walletCommandFaucetInvalidAmountMessage",
+	ErrFaucetNotEligible:   "walletCommandFaucetNotEligibleMessage",
+}
+
+// Dispatcher runs the commands of a frontend against a Service
+type Dispatcher struct {
+	Service   Service
+	Messenger Messenger
+	// Translate returns the message with the key in the language. If nil, the key is returned.
+	Translate func(languageCode string, key string) string
+	// ParseAmount parses amounts like 1000 or 1k. If nil, only integers are accepted.
+	ParseAmount func(input string) (int64, error)
+}
+
+// Handle runs the command in the message and replies to it. The returned error is only
+// for logging, the user already got an answer. Commands return an empty reply without an
+// error if the messenger already answered.
+func (d *Dispatcher) Handle(msg Message) error {
+	command, err := Parse(msg.Text)
+	if err != nil {
+		return err
+	}
+	var reply string
+	switch command.Name {
+	case "balance":
+		reply, err = d.balance(msg)
+	case "invoice":
+		reply, err = d.invoice(msg, command)
+	case "pay":
+		reply, err = d.pay(msg, command)
+	case "send":
+		reply, err = d.send(msg, command)
+	case "tip":
+		reply, err = d.tip(msg, command)
+	case "faucet":
+		reply, err = d.faucet(msg, command)
+	case "start":
+		if creator, ok := d.Service.(WalletCreator); ok {
+			err = creator.CreateWallet(msg.From)
+		}
+		if err == nil {
+			reply = d.translate(msg.From, "walletCommandHelpMessage")
+		}
+	case "help":
+		reply = d.translate(msg.From, "walletCommandHelpMessage")
+	default:
+		reply, err = d.translate(msg.From, "walletCommandUnknownMessage"), fmt.Errorf("unknown command %s", command.Name)
+	}
+	if err != nil && len(reply) == 0 {
+		reply = d.errorText(msg.From, err)
+	}
+	if err == nil && len(reply) == 0 {
+		return nil
+	}
+	if replyErr := d.Messenger.Reply(msg, reply); replyErr != nil && err == nil {
+		err = replyErr
+	}
+	return err
+}
+
+func (d *Dispatcher) translate(user User, key string) string {
+	if d.Translate == nil {
+		return key
+	}
+	return d.Translate(user.LanguageCode, key)
+}
+
+func (d *Dispatcher) errorText(user User, err error) string {
+	for e, key := range errorMessages {
+		if errors.Is(err, e) {
+			return d.translate(user, key)
+		}
+	}
+	return d.translate(user, "walletCommandErrorMessage")
+}
+
+func (d *Dispatcher) parseAmount(input string) (int64, error) {
+	var amount int64
+	var err error
+	if d.ParseAmount != nil {
+		amount, err = d.ParseAmount(input)
+	} else {
+		amount, err = strconv.ParseInt(input, 10, 64)
+	}
+	if err != nil || amount < 1 {
+		return 0, ErrInvalidAmount
+	}
+	return amount, nil
+}
+
+// balance handles balance
+func (d *Dispatcher) balance(msg Message) (string, error) {
+	balance, err := d.Service.Balance(msg.From)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(d.translate(msg.From, "walletCommandBalanceMessage"), balance), nil
+}
+
+// invoice handles invoice <amount> [memo]
+func (d *Dispatcher) invoice(msg Message, command Command) (string, error) {
+	if len(command.Args) < 1 {
+		return d.translate(msg.From, "walletCommandHelpMessage"), fmt.Errorf("no amount")
+	}
+	amount, err := d.parseAmount(command.Args[0])
+	if err != nil {
+		return "", err
+	}
+	invoice, err := d.Service.Invoice(msg.From, amount, command.Memo(1))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(d.translate(msg.From, "walletCommandInvoiceMessage"), amount, invoice), nil
+}
+
+// pay handles pay <invoice>
+func (d *Dispatcher) pay(msg Message, command Command) (string, error) {
+	if len(command.Args) < 1 {
+		return d.translate(msg.From, "walletCommandHelpMessage"), fmt.Errorf("no invoice")
+	}
+	amount, err := d.Service.Pay(msg.From, command.Args[0])
+	if err != nil {
+		if !isKnownError(err) {
+			return fmt.Sprintf(d.translate(msg.From, "walletCommandPaymentFailedMessage"), err.Error()), err
+		}
+		return "", err
+	}
+	return fmt.Sprintf(d.translate(msg.From, "walletCommandPaidMessage"), amount), nil
+}
+
+func isKnownError(err error) bool {
+	for e := range errorMessages {
+		if errors.Is(err, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// send handles send <amount> <recipient> [memo]
+func (d *Dispatcher) send(msg Message, command Command) (string, error) {
+	if len(command.Args) < 2 {
+		return d.translate(msg.From, "walletCommandHelpMessage"), fmt.Errorf("not enough arguments")
+	}
+	amount, err := d.parseAmount(command.Args[0])
+	if err != nil {
+		return "", err
+	}
+	to, err := d.Service.Resolve(msg.From, command.Args[1])
+	if err != nil {
+		return "", err
+	}
+	return d.transfer(msg, to, amount, command.Memo(2), "send")
+}
+
+// tip handles tip <amount> [memo] as a reply, or tip <amount> <recipient> [memo] on frontends without replies
+func (d *Dispatcher) tip(msg Message, command Command) (string, error) {
+	if len(command.Args) < 1 {
+		return d.translate(msg.From, "walletCommandHelpMessage"), fmt.Errorf("no amount")
+	}
+	amount, err := d.parseAmount(command.Args[0])
+	if err != nil {
+		return "", err
+	}
+	if msg.ReplyTo != nil {
+		return d.transfer(msg, *msg.ReplyTo, amount, command.Memo(1), "tip")
+	}
+	if len(command.Args) < 2 {
+		return d.translate(msg.From, "walletCommandTipReplyMessage"), fmt.Errorf("no reply")
+	}
+	to, err := d.Service.Resolve(msg.From, command.Args[1])
+	if err != nil {
+		return "", err
+	}
+	return d.transfer(msg, to, amount, command.Memo(2), "tip")
+}
+
+func (d *Dispatcher) transfer(msg Message, to User, amount int64, memo string, kind string) (string, error) {
+	if to.Frontend == msg.From.Frontend && to.ID == msg.From.ID {
+		return "", ErrSelfPayment
+	}
+	if err := d.Service.Transfer(msg.From, to, amount, memo, kind); err != nil {
+		return "", err
+	}
+	text := fmt.Sprintf(d.translate(to, "walletCommandReceivedMessage"), msg.From, amount)
+	if len(memo) > 0 {
+		text += "\n✉️ " + memo
+	}
+	_ = d.Messenger.Notify(to, text)
+	return fmt.Sprintf(d.translate(msg.From, "walletCommandSentMessage"), amount, to), nil
+}
+
+// faucet handles faucet <amount> <per_user> and faucet claim <id>
+func (d *Dispatcher) faucet(msg Message, command Command) (string, error) {
+	if len(command.Args) == 2 && strings.ToLower(command.Args[0]) == "claim" {
+		faucet, err := d.Service.ClaimFaucet(msg.From, command.Args[1])
+		if err != nil {
+			return "", err
+		}
+		_ = d.Messenger.Notify(faucet.From, fmt.Sprintf(d.translate(faucet.From, "walletCommandFaucetTakenMessage"),
+			msg.From, faucet.PerUserAmount, faucet.RemainingAmount))
+		return fmt.Sprintf(d.translate(msg.From, "walletCommandFaucetClaimedMessage"), faucet.PerUserAmount, faucet.From), nil
+	}
+	if len(command.Args) < 2 {
+		return d.translate(msg.From, "walletCommandHelpMessage"), fmt.Errorf("not enough arguments")
+	}
+	amount, err := d.parseAmount(command.Args[0])
+	if err != nil {
+		return "", err
+	}
+	perUserAmount, err := d.parseAmount(command.Args[1])
+	if err != nil {
+		return "", err
+	}
+	if perUserAmount > amount || amount%perUserAmount != 0 {
+		return "", ErrFaucetInvalidAmount
+	}
+	faucet, err := d.Service.CreateFaucet(msg.From, amount, perUserAmount)
+	if err != nil {
+		return "", err
+	}
+	text := fmt.Sprintf(d.translate(msg.From, "walletCommandFaucetMessage"), faucet.Amount, faucet.PerUserAmount, faucet.ID)
+	if fm, ok := d.Messenger.(FaucetMessenger); ok {
+		return "", fm.ReplyFaucet(msg, faucet, text)
+	}
+	return text, nil
+}
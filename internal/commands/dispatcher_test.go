@@ -0,0 +1,75 @@
+package commands
+
+import (
+	"testing"
+)
+
+type recordingMessenger struct {
+	replies       []string
+	notifications map[string][]string
+}
+
+func (m *recordingMessenger) Reply(msg Message, text string) error {
+	m.replies = append(m.replies, text)
+	return nil
+}
+
+func (m *recordingMessenger) Notify(user User, text string) error {
+	m.notifications[user.ID] = append(m.notifications[user.ID], text)
+	return nil
+}
+
+var (
+	alice = User{Frontend: "test", ID: "alice", Name: "@alice"}
+	bob   = User{Frontend: "test", ID: "bob", Name: "@bob"}
+)
+
+func Test_Dispatcher(t *testing.T) {
+	service := NewMemoryService()
+	service.Deposit(alice, 1000)
+	service.Deposit(bob, 0)
+	messenger := &recordingMessenger{notifications: make(map[string][]string)}
+	d := &Dispatcher{Service: service, Messenger: messenger}
+
+	tests := []struct {
+		name    string
+		msg     Message
+		wantErr bool
+		alice   int64
+		bob     int64
+	}{
+		{name: "start", msg: Message{From: alice, Text: "/start"}, alice: 1000, bob: 0},
+		{name: "send", msg: Message{From: alice, Text: "/send 100 @bob"}, alice: 900, bob: 100},
+		{name: "tip reply", msg: Message{From: alice, Text: "/tip 50 nice", ReplyTo: &bob}, alice: 850, bob: 150},
+		{name: "tip yourself", msg: Message{From: alice, Text: "/tip 50", ReplyTo: &alice}, wantErr: true, alice: 850, bob: 150},
+		{name: "balance too low", msg: Message{From: bob, Text: "send 1000 @alice"}, wantErr: true, alice: 850, bob: 150},
+		{name: "unknown recipient", msg: Message{From: alice, Text: "send 1 @carol"}, wantErr: true, alice: 850, bob: 150},
+		{name: "invalid amount", msg: Message{From: alice, Text: "send -1 @bob"}, wantErr: true, alice: 850, bob: 150},
+		{name: "faucet", msg: Message{From: alice, Text: "faucet 200 100"}, alice: 850, bob: 150},
+		{name: "claim", msg: Message{From: bob, Text: "faucet claim faucet1"}, alice: 750, bob: 250},
+		{name: "claim twice", msg: Message{From: bob, Text: "faucet claim faucet1"}, wantErr: true, alice: 750, bob: 250},
+		{name: "invoice", msg: Message{From: bob, Text: "invoice 10"}, alice: 750, bob: 250},
+		{name: "pay", msg: Message{From: alice, Text: "pay lnmem12"}, alice: 740, bob: 260},
+		{name: "pay twice", msg: Message{From: alice, Text: "pay lnmem12"}, wantErr: true, alice: 740, bob: 260},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			replies := len(messenger.replies)
+			if err := d.Handle(tt.msg); (err != nil) != tt.wantErr {
+				t.Errorf("Handle() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(messenger.replies) != replies+1 {
+				t.Errorf("Handle() sent %d replies, want 1", len(messenger.replies)-replies)
+			}
+			if balance, _ := service.Balance(alice); balance != tt.alice {
+				t.Errorf("balance of alice = %d, want %d", balance, tt.alice)
+			}
+			if balance, _ := service.Balance(bob); balance != tt.bob {
+				t.Errorf("balance of bob = %d, want %d", balance, tt.bob)
+			}
+		})
+	}
+	if len(messenger.notifications["bob"]) != 2 {
+		t.Errorf("bob got %d notifications, want 2", len(messenger.notifications["bob"]))
+	}
+}
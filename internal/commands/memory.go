@@ -0,0 +1,177 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// memoryInvoicePrefix marks the invoices of the MemoryService. They can only be paid within the same service.
+const memoryInvoicePrefix = "lnmem1"
+
+type memoryInvoice struct {
+	user   string
+	amount int64
+	paid   bool
+}
+
+type memoryFaucet struct {
+	Faucet
+	takers map[string]bool
+}
+
+// MemoryService is a Service with in-memory wallets. It runs full command flows
+// without LNbits or a chat platform, for the console and for tests.
+type MemoryService struct {
+	lock     sync.Mutex
+	users    map[string]User
+	balances map[string]int64
+	invoices map[string]*memoryInvoice
+	faucets  map[string]*memoryFaucet
+	counter  int
+}
+
+func NewMemoryService() *MemoryService {
+	return &MemoryService{
+		users:    make(map[string]User),
+		balances: make(map[string]int64),
+		invoices: make(map[string]*memoryInvoice),
+		faucets:  make(map[string]*memoryFaucet),
+	}
+}
+
+func memoryKey(user User) string {
+	return user.Frontend + ":" + user.ID
+}
+
+func (s *MemoryService) register(user User) {
+	if _, ok := s.users[memoryKey(user)]; !ok {
+		s.users[memoryKey(user)] = user
+	}
+}
+
+func (s *MemoryService) nextID() string {
+	s.counter++
+	return fmt.Sprintf("%d", s.counter)
+}
+
+// Deposit adds sats to the wallet of the user
+func (s *MemoryService) Deposit(user User, amount int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.register(user)
+	s.balances[memoryKey(user)] += amount
+}
+
+// CreateWallet creates an empty wallet for the user
+func (s *MemoryService) CreateWallet(user User) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.register(user)
+	return nil
+}
+
+func (s *MemoryService) Balance(user User) (int64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.register(user)
+	return s.balances[memoryKey(user)], nil
+}
+
+func (s *MemoryService) Invoice(user User, amount int64, memo string) (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.register(user)
+	invoice := memoryInvoicePrefix + s.nextID()
+	s.invoices[invoice] = &memoryInvoice{user: memoryKey(user), amount: amount}
+	return invoice, nil
+}
+
+func (s *MemoryService) Pay(user User, invoice string) (int64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.register(user)
+	inv, ok := s.invoices[strings.ToLower(invoice)]
+	if !ok || inv.paid {
+		return 0, ErrInvalidInvoice
+	}
+	if inv.user == memoryKey(user) {
+		return 0, ErrSelfPayment
+	}
+	if s.balances[memoryKey(user)] < inv.amount {
+		return 0, ErrBalanceTooLow
+	}
+	s.balances[memoryKey(user)] -= inv.amount
+	s.balances[inv.user] += inv.amount
+	inv.paid = true
+	return inv.amount, nil
+}
+
+// Resolve returns known users by @name or ID on the frontend of the sender
+func (s *MemoryService) Resolve(from User, recipient string) (User, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	name := strings.TrimPrefix(recipient, "@")
+	for _, user := range s.users {
+		if user.Frontend == from.Frontend && (user.ID == recipient || user.ID == name || strings.TrimPrefix(user.Name, "@") == name) {
+			return user, nil
+		}
+	}
+	return User{}, ErrUnknownRecipient
+}
+
+func (s *MemoryService) Transfer(from User, to User, amount int64, memo string, kind string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.transfer(from, to, amount)
+}
+
+func (s *MemoryService) transfer(from User, to User, amount int64) error {
+	s.register(from)
+	s.register(to)
+	if s.balances[memoryKey(from)] < amount {
+		return ErrBalanceTooLow
+	}
+	s.balances[memoryKey(from)] -= amount
+	s.balances[memoryKey(to)] += amount
+	return nil
+}
+
+func (s *MemoryService) CreateFaucet(from User, amount int64, perUserAmount int64) (Faucet, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.register(from)
+	if s.balances[memoryKey(from)] < amount {
+		return Faucet{}, ErrBalanceTooLow
+	}
+	faucet := &memoryFaucet{
+		Faucet: Faucet{ID: "faucet" + s.nextID(), From: from, Amount: amount, RemainingAmount: amount, PerUserAmount: perUserAmount},
+		takers: make(map[string]bool),
+	}
+	s.faucets[faucet.ID] = faucet
+	return faucet.Faucet, nil
+}
+
+func (s *MemoryService) ClaimFaucet(user User, id string) (Faucet, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	faucet, ok := s.faucets[id]
+	if !ok {
+		return Faucet{}, ErrFaucetNotFound
+	}
+	if memoryKey(faucet.From) == memoryKey(user) {
+		return Faucet{}, ErrSelfPayment
+	}
+	if faucet.takers[memoryKey(user)] {
+		return Faucet{}, ErrFaucetAlreadyTaken
+	}
+	if faucet.RemainingAmount < faucet.PerUserAmount {
+		return Faucet{}, ErrFaucetEmpty
+	}
+	if err := s.transfer(faucet.From, user, faucet.PerUserAmount); err != nil {
+		return Faucet{}, err
+	}
+	faucet.takers[memoryKey(user)] = true
+	faucet.RemainingAmount -= faucet.PerUserAmount
+	return faucet.Faucet, nil
+}
@@ -16,6 +16,7 @@ var Configuration = struct {
 	Lnbits     LnbitsConfiguration     `yaml:"lnbits"`
 	Generate   GenerateConfiguration   `yaml:"generate"`
 	Nostr      NostrConfiguration      `yaml:"nostr"`
+	Matrix     MatrixConfiguration     `yaml:"matrix"`
 	Pos        PosConfiguration        `yaml:"pos"`
 	Voucherbot VoucherbotConfiguration `yaml:"voucherbot"`
 }{}
@@ -34,7 +35,17 @@ type VoucherbotConfiguration struct {
 }
 
 type NostrConfiguration struct {
-	PrivateKey string `yaml:"private_key"`
+	PrivateKey         string   `yaml:"private_key"`
+	WalletConnectRelay string   `yaml:"wallet_connect_relay"`
+	Relays             []string `yaml:"relays"`
+}
+
+type MatrixConfiguration struct {
+	Homeserver        string `yaml:"homeserver"`
+	UserID            string `yaml:"user_id"`
+	AccessToken       string `yaml:"access_token"`
+	TipReaction       string `yaml:"tip_reaction" default:"⚡"`
+	TipReactionAmount int64  `yaml:"tip_reaction_amount" default:"21"`
 }
 
 type GenerateConfiguration struct {
@@ -29,6 +29,51 @@ func FindUser(database *gorm.DB, username string) (*lnbits.User, *gorm.DB) {
 	return user, tx
 }
 
+// FindUserByNostrName returns the user that registered the custom NIP-05 name
+func FindUserByNostrName(database *gorm.DB, name string) (*lnbits.User, error) {
+	settings := &lnbits.Settings{}
+	tx := database.Where("instr(' ' || nostr_names || ' ', ?) > 0", " "+name+" ").First(settings)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	user := &lnbits.User{}
+	tx = database.Preload("Settings").Where("id = ?", settings.ID).First(user)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return user, nil
+}
+
+// FindUserByNostrPubkey returns the user that linked the nostr pubkey with /nostr link
+func FindUserByNostrPubkey(database *gorm.DB, pubkey string) (*lnbits.User, error) {
+	settings := &lnbits.Settings{}
+	tx := database.Where("nostr_pub_key = ? AND nostr_linked = ?", pubkey, true).First(settings)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	user := &lnbits.User{}
+	tx = database.Preload("Settings").Where("id = ?", settings.ID).First(user)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return user, nil
+}
+
+// FindUserByMatrixID returns the user that linked the matrix account with /matrix link
+func FindUserByMatrixID(database *gorm.DB, mxid string) (*lnbits.User, error) {
+	settings := &lnbits.Settings{}
+	tx := database.Where("matrix_user_id = ?", mxid).First(settings)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	user := &lnbits.User{}
+	tx = database.Preload("Settings").Where("id = ?", settings.ID).First(user)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	return user, nil
+}
+
 func FindUserSettings(user *lnbits.User, settingsTx *gorm.DB) (*lnbits.User, error) {
 	// tx := bot.DB.Users.Preload("Settings").First(user)
 	tx := settingsTx.First(user)
@@ -43,13 +43,21 @@ type Settings struct {
 	Display DisplaySettings `gorm:"embedded;embeddedPrefix:display_"`
 	Node    NodeSettings    `gorm:"embedded;embeddedPrefix:node_"`
 	Nostr   NostrSettings   `gorm:"embedded;embeddedPrefix:nostr_"`
+	Matrix  MatrixSettings  `gorm:"embedded;embeddedPrefix:matrix_"`
 }
 
 type DisplaySettings struct {
 	DisplayCurrency string `json:"displaycurrency"`
 }
 type NostrSettings struct {
-	PubKey string `json:"pubkey"`
+	PubKey        string `json:"pubkey"`
+	Notifications string `json:"notifications"` // nip04 or nip17 to receive notifications as direct messages
+	Relays        string `json:"relays"`        // space separated relays of the user
+	Names         string `json:"names"`         // space separated custom NIP-05 names of the user
+	Linked        bool   `json:"linked"`        // the pubkey was verified with /nostr link and can send commands
+}
+type MatrixSettings struct {
+	UserID string `json:"userid"` // matrix id that was linked with /matrix link and uses this wallet
 }
 type NodeSettings struct {
 	NodeType     string                 `json:"nodetype"`
@@ -74,6 +82,7 @@ const (
 	UserStateShopItemSendItemFile
 	UserEnterShopsDescription
 	UserEnterDallePrompt
+	UserStateShopsImport
 )
 
 type UserStateKey int
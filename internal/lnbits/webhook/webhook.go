@@ -74,6 +74,17 @@ func (w *Server) GetUserByWalletId(walletId string) (*lnbits.User, error) {
 	return user, nil
 }
 
+// receiveGroupTreasury triggers the invoice event of a payment to a group treasury
+func (w *Server) receiveGroupTreasury(webhookEvent Webhook) bool {
+	txInvoiceEvent := &telegram.InvoiceEvent{Invoice: &telegram.Invoice{PaymentHash: webhookEvent.PaymentHash}}
+	if err := w.buntdb.Get(txInvoiceEvent); err != nil || txInvoiceEvent.Callback != telegram.InvoiceCallbackGroupTreasuryReceive {
+		return false
+	}
+	log.Infoln(fmt.Sprintf("[⚡️ WebHook] Group treasury %d received invoice of %d sat.", txInvoiceEvent.Chat.ID, webhookEvent.Amount/1000))
+	go telegram.InvoiceCallback[txInvoiceEvent.Callback].Function(txInvoiceEvent)
+	return true
+}
+
 func (w *Server) newRouter() *mux.Router {
 	router := mux.NewRouter()
 	router.HandleFunc("/", w.receive).Methods(http.MethodPost)
@@ -93,6 +104,11 @@ func (w *Server) receive(writer http.ResponseWriter, request *http.Request) {
 	}
 	user, err := w.GetUserByWalletId(webhookEvent.WalletID)
 	if err != nil {
+		// group treasury wallets don't belong to a user
+		if w.receiveGroupTreasury(webhookEvent) {
+			writer.WriteHeader(200)
+			return
+		}
 		log.Errorf("[Webhook] Error getting user: %s", err.Error())
 		writer.WriteHeader(400)
 		return
@@ -0,0 +1,76 @@
+package lnurl
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/fiatjaf/go-lnurl"
+	"github.com/massmux/SatsMobiBot/internal/api"
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	"github.com/massmux/SatsMobiBot/internal/runtime"
+	"github.com/massmux/SatsMobiBot/internal/telegram"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+// isGroupTreasuryUsername returns true for lightning addresses of group treasuries like group-<name>
+func isGroupTreasuryUsername(username string) bool {
+	return strings.HasPrefix(strings.ToLower(username), telegram.GroupTreasuryLightningAddressPrefix)
+}
+
+// serveGroupTreasuryLNURLpSecond creates an invoice on the treasury wallet of a group
+func (w Lnurl) serveGroupTreasuryLNURLpSecond(username string, amount_msat int64, comment string, payerData lnurl.PayerDataValues) (*lnurl.LNURLPayValues, error) {
+	group, err := w.bot.LoadGroupTreasury(username)
+	if err != nil {
+		return &lnurl.LNURLPayValues{
+			LNURLResponse: lnurl.LNURLResponse{
+				Status: api.StatusError,
+				Reason: "Invalid group."},
+		}, fmt.Errorf("[serveGroupTreasuryLNURLpSecond] %v", err)
+	}
+	payerDataByte := []byte("")
+	if payerData.Email != "" || payerData.LightningAddress != "" || payerData.FreeName != "" {
+		payerDataByte, err = json.Marshal(payerData)
+		if err != nil {
+			return nil, err
+		}
+	}
+	descriptionHash, err := w.DescriptionHash(w.getMetaDataCached(username), string(payerDataByte))
+	if err != nil {
+		return nil, err
+	}
+	invoice, err := group.Treasury.Invoice(
+		lnbits.InvoiceParams{
+			Amount:          amount_msat / 1000,
+			Out:             false,
+			DescriptionHash: descriptionHash,
+			Webhook:         w.WebhookServer},
+		w.c)
+	if err != nil {
+		return &lnurl.LNURLPayValues{
+			LNURLResponse: lnurl.LNURLResponse{
+				Status: api.StatusError,
+				Reason: "Couldn't create invoice."},
+		}, fmt.Errorf("[serveGroupTreasuryLNURLpSecond] Couldn't create invoice: %v", err.Error())
+	}
+	from := extractSenderFromPayerdata(payerData)
+	// the invoice event is sent to the group chat when the invoice is paid
+	runtime.IgnoreError(w.buntdb.Set(
+		telegram.InvoiceEvent{
+			Invoice: &telegram.Invoice{
+				PaymentRequest: invoice.PaymentRequest,
+				PaymentHash:    invoice.PaymentHash,
+				Amount:         amount_msat / 1000,
+				Memo:           comment,
+			},
+			Chat:         &tb.Chat{ID: group.ID},
+			Callback:     telegram.InvoiceCallbackGroupTreasuryReceive,
+			CallbackData: from,
+		}))
+	return &lnurl.LNURLPayValues{
+		LNURLResponse: lnurl.LNURLResponse{Status: api.StatusOk},
+		PR:            invoice.PaymentRequest,
+		Routes:        make([]struct{}, 0),
+		SuccessAction: &lnurl.SuccessAction{Message: "Payment received!", Tag: "message"},
+	}, nil
+}
@@ -222,8 +222,8 @@ func (w Lnurl) serveLNURLpFirst(username string) (*LNURLPayParamsCustom, error)
 	// check if the user has added a nostr key for nip57
 	var allowNostr bool = false
 	var nostrPubkey string = ""
-	// if the bot has a nostr private key
-	if len(internal.Configuration.Nostr.PrivateKey) > 0 {
+	// if the bot has a nostr private key (group treasuries don't send zap receipts)
+	if len(internal.Configuration.Nostr.PrivateKey) > 0 && !isGroupTreasuryUsername(username) {
 		allowNostr = true
 		pk := internal.Configuration.Nostr.PrivateKey
 		pub, _ := nostr.GetPublicKey(pk)
@@ -271,6 +271,9 @@ func (w Lnurl) serveLNURLpSecond(username string, amount_msat int64, comment str
 	if amount_msat < 21_000 {
 		comment = ""
 	}
+	if isGroupTreasuryUsername(username) {
+		return w.serveGroupTreasuryLNURLpSecond(username, amount_msat, comment, payerData)
+	}
 	user, tx := db.FindUser(w.database, username)
 	if tx.Error != nil {
 		return &lnurl.LNURLPayValues{
@@ -0,0 +1,384 @@
+package matrix
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/massmux/SatsMobiBot/internal/commands"
+	log "github.com/sirupsen/logrus"
+)
+
+// Frontend is the frontend of users that are identified by their matrix id (MXID)
+const Frontend = "matrix"
+
+// maxCachedEvents limits the events the adapter remembers for replies and reactions
+const maxCachedEvents = 10000
+
+// User returns the command layer user of a MXID like @alice:example.com
+func User(mxid string) commands.User {
+	return commands.User{Frontend: Frontend, ID: mxid, Name: mxid, LanguageCode: "en"}
+}
+
+// Linker is implemented by services that can link a matrix user to an existing wallet
+type Linker interface {
+	Link(user commands.User, code string) error
+}
+
+// RoomStore remembers the direct chats of the bot with matrix users
+type RoomStore interface {
+	DirectRoom(mxid string) (string, bool)
+	SetDirectRoom(mxid string, roomID string)
+}
+
+type memoryRoomStore struct {
+	lock  sync.Mutex
+	rooms map[string]string
+}
+
+func (s *memoryRoomStore) DirectRoom(mxid string) (string, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	room, ok := s.rooms[mxid]
+	return room, ok
+}
+
+func (s *memoryRoomStore) SetDirectRoom(mxid string, roomID string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.rooms[mxid] = roomID
+}
+
+// Reaction is what a tip reaction on a message of the bot does. Reactions on faucets claim
+// from the faucet, reactions on tipjars give the amount to the owner.
+type Reaction struct {
+	FaucetID string        `json:"faucet_id,omitempty"`
+	Owner    commands.User `json:"owner"`
+	Amount   int64         `json:"amount,omitempty"`
+}
+
+// ReactionStore remembers the faucet and tipjar messages of the bot, so that reactions on them
+// still work after a restart. Stores forget old messages.
+type ReactionStore interface {
+	Reaction(eventID string) (Reaction, bool)
+	SetReaction(eventID string, reaction Reaction)
+}
+
+// memoryReactionStore keeps the last maxCachedEvents messages
+type memoryReactionStore struct {
+	lock      sync.Mutex
+	reactions map[string]Reaction
+	order     []string
+}
+
+func (s *memoryReactionStore) Reaction(eventID string) (Reaction, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	reaction, ok := s.reactions[eventID]
+	return reaction, ok
+}
+
+func (s *memoryReactionStore) SetReaction(eventID string, reaction Reaction) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.reactions[eventID]; !ok {
+		s.order = append(s.order, eventID)
+	}
+	s.reactions[eventID] = reaction
+	for len(s.order) > maxCachedEvents {
+		delete(s.reactions, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+// Adapter runs the commands of the command layer in matrix rooms. Messages starting with
+// ! or / are commands. A reply with tip tips the author of the original message, and the
+// tip reaction tips the author, claims a faucet or gives to a tipjar.
+type Adapter struct {
+	Client     *Client
+	Dispatcher *commands.Dispatcher
+	// Fallback notifies users of other frontends, for example telegram users that receive a tip
+	Fallback commands.Messenger
+	Rooms    RoomStore
+	// Reactions remembers the faucets and tipjars
+	Reactions ReactionStore
+	// TipReaction is the reaction key that tips TipReactionAmount sat
+	TipReaction       string
+	TipReactionAmount int64
+	// SyncTimeout is how long a /sync request waits for new events
+	SyncTimeout time.Duration
+	// RetryDelay is the pause after a failed /sync
+	RetryDelay time.Duration
+
+	lock    sync.Mutex
+	senders map[string]string
+}
+
+// NewAdapter returns an adapter that answers the commands of the dispatcher in matrix
+func NewAdapter(client *Client, dispatcher *commands.Dispatcher) *Adapter {
+	a := &Adapter{
+		Client:            client,
+		Dispatcher:        dispatcher,
+		Rooms:             &memoryRoomStore{rooms: make(map[string]string)},
+		Reactions:         &memoryReactionStore{reactions: make(map[string]Reaction)},
+		TipReaction:       "⚡",
+		TipReactionAmount: 21,
+		SyncTimeout:       30 * time.Second,
+		RetryDelay:        5 * time.Second,
+		senders:           make(map[string]string),
+	}
+	dispatcher.Messenger = a
+	return a
+}
+
+// Run syncs with the homeserver until the context is done. Events from before the start
+// are skipped, so commands are never run twice after a restart.
+func (a *Adapter) Run(ctx context.Context) error {
+	var since string
+	for {
+		timeout := a.SyncTimeout
+		if len(since) == 0 {
+			timeout = 0
+		}
+		response, err := a.Client.Sync(ctx, since, timeout)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			log.Errorf("[matrix] sync: %v", err)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(a.RetryDelay):
+			}
+			continue
+		}
+		for roomID := range response.Rooms.Invite {
+			if err := a.Client.JoinRoom(ctx, roomID); err != nil {
+				log.Errorf("[matrix] join %s: %v", roomID, err)
+			}
+		}
+		if len(since) > 0 {
+			for roomID, room := range response.Rooms.Join {
+				for _, event := range room.Timeline.Events {
+					a.handleEvent(ctx, roomID, event)
+				}
+			}
+		}
+		since = response.NextBatch
+	}
+}
+
+func (a *Adapter) handleEvent(ctx context.Context, roomID string, event Event) {
+	if event.Sender == a.Client.UserID || event.StateKey != nil {
+		return
+	}
+	a.rememberSender(event.EventID, event.Sender)
+	content := Content{}
+	if err := json.Unmarshal(event.Content, &content); err != nil {
+		return
+	}
+	switch event.Type {
+	case EventMessage:
+		a.handleMessage(ctx, roomID, event, content)
+	case EventReaction:
+		a.handleReaction(ctx, roomID, event, content)
+	}
+}
+
+func (a *Adapter) handleMessage(ctx context.Context, roomID string, event Event, content Content) {
+	if content.MsgType != MsgTypeText {
+		return
+	}
+	text := stripReplyFallback(content.Body)
+	if !strings.HasPrefix(text, "!") && !strings.HasPrefix(text, "/") {
+		return
+	}
+	msg := commands.Message{From: User(event.Sender), Text: text[1:], Chat: roomID}
+	if content.RelatesTo != nil && content.RelatesTo.InReplyTo != nil {
+		if sender, ok := a.sender(ctx, roomID, content.RelatesTo.InReplyTo.EventID); ok {
+			replyTo := User(sender)
+			msg.ReplyTo = &replyTo
+		}
+	}
+	command, err := commands.Parse(msg.Text)
+	if err != nil {
+		return
+	}
+	switch command.Name {
+	case "tipjar":
+		err = a.createTipjar(ctx, msg, command)
+	case "link":
+		err = a.link(msg, command)
+	default:
+		err = a.Dispatcher.Handle(msg)
+	}
+	if err != nil {
+		log.Debugf("[matrix] %s: %s: %v", event.Sender, command.Name, err)
+	}
+}
+
+func (a *Adapter) handleReaction(ctx context.Context, roomID string, event Event, content Content) {
+	relation := content.RelatesTo
+	if relation == nil || relation.RelType != RelAnnotation || !a.isTipReaction(relation.Key) {
+		return
+	}
+	msg := commands.Message{From: User(event.Sender), Chat: roomID}
+	reaction, ok := a.Reactions.Reaction(relation.EventID)
+	switch {
+	case ok && len(reaction.FaucetID) > 0:
+		msg.Text = "faucet claim " + reaction.FaucetID
+	case ok:
+		msg.Text = fmt.Sprintf("tip %d", reaction.Amount)
+		msg.ReplyTo = &reaction.Owner
+	default:
+		sender, ok := a.sender(ctx, roomID, relation.EventID)
+		// other messages of the bot, for example forgotten faucets, are not tipped
+		if !ok || sender == a.Client.UserID {
+			return
+		}
+		replyTo := User(sender)
+		msg.Text = fmt.Sprintf("tip %d", a.TipReactionAmount)
+		msg.ReplyTo = &replyTo
+	}
+	if err := a.Dispatcher.Handle(msg); err != nil {
+		log.Debugf("[matrix] %s: reaction: %v", event.Sender, err)
+	}
+}
+
+func (a *Adapter) isTipReaction(key string) bool {
+	return strings.TrimSuffix(key, "\ufe0f") == strings.TrimSuffix(a.TipReaction, "\ufe0f")
+}
+
+// createTipjar handles tipjar <amount>. Every tip reaction on the answer gives the amount to the owner.
+func (a *Adapter) createTipjar(ctx context.Context, msg commands.Message, command commands.Command) error {
+	if len(command.Args) < 1 {
+		return a.Reply(msg, a.translate(msg.From, "matrixTipjarHelpMessage"))
+	}
+	amount, err := a.parseAmount(command.Args[0])
+	if err != nil {
+		return a.Reply(msg, a.translate(msg.From, "walletCommandInvalidAmountMessage"))
+	}
+	text := fmt.Sprintf(a.translate(msg.From, "matrixTipjarMessage"), msg.From, a.TipReaction, amount)
+	eventID, err := a.send(ctx, msg.Chat, text)
+	if err != nil {
+		return err
+	}
+	a.Reactions.SetReaction(eventID, Reaction{Owner: msg.From, Amount: amount})
+	return nil
+}
+
+// link handles link <code> with a code from /matrix link on telegram
+func (a *Adapter) link(msg commands.Message, command commands.Command) error {
+	linker, ok := a.Dispatcher.Service.(Linker)
+	if !ok || len(command.Args) < 1 {
+		return a.Reply(msg, a.translate(msg.From, "matrixLinkInvalidMessage"))
+	}
+	if err := linker.Link(msg.From, command.Args[0]); err != nil {
+		_ = a.Reply(msg, a.translate(msg.From, "matrixLinkInvalidMessage"))
+		return err
+	}
+	return a.Reply(msg, a.translate(msg.From, "matrixLinkedMatrixMessage"))
+}
+
+// Reply answers in the room of the message
+func (a *Adapter) Reply(msg commands.Message, text string) error {
+	_, err := a.send(context.Background(), msg.Chat, text)
+	return err
+}
+
+// ReplyFaucet answers with the faucet. Every tip reaction on the answer claims from the faucet.
+func (a *Adapter) ReplyFaucet(msg commands.Message, faucet commands.Faucet, text string) error {
+	text += "\n" + fmt.Sprintf(a.translate(msg.From, "matrixFaucetReactMessage"), a.TipReaction)
+	eventID, err := a.send(context.Background(), msg.Chat, text)
+	if err != nil {
+		return err
+	}
+	a.Reactions.SetReaction(eventID, Reaction{FaucetID: faucet.ID, Owner: faucet.From})
+	return nil
+}
+
+// Notify sends a direct message to matrix users. Users of other frontends get the message from Fallback.
+func (a *Adapter) Notify(user commands.User, text string) error {
+	if user.Frontend != Frontend {
+		if a.Fallback == nil {
+			return fmt.Errorf("can't notify %s user %s", user.Frontend, user)
+		}
+		return a.Fallback.Notify(user, text)
+	}
+	ctx := context.Background()
+	roomID, ok := a.Rooms.DirectRoom(user.ID)
+	if !ok {
+		var err error
+		roomID, err = a.Client.CreateDirectRoom(ctx, user.ID)
+		if err != nil {
+			return err
+		}
+		a.Rooms.SetDirectRoom(user.ID, roomID)
+	}
+	_, err := a.send(ctx, roomID, text)
+	return err
+}
+
+func (a *Adapter) send(ctx context.Context, roomID string, text string) (string, error) {
+	return a.Client.SendMessage(ctx, roomID, Content{MsgType: MsgTypeNotice, Body: text})
+}
+
+func (a *Adapter) rememberSender(eventID string, sender string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if len(a.senders) >= maxCachedEvents {
+		a.senders = make(map[string]string)
+	}
+	a.senders[eventID] = sender
+}
+
+// sender returns the sender of an event. Events from before the start are fetched from the homeserver.
+func (a *Adapter) sender(ctx context.Context, roomID string, eventID string) (string, bool) {
+	a.lock.Lock()
+	sender, ok := a.senders[eventID]
+	a.lock.Unlock()
+	if ok {
+		return sender, true
+	}
+	event, err := a.Client.GetEvent(ctx, roomID, eventID)
+	if err != nil || len(event.Sender) == 0 {
+		return "", false
+	}
+	a.rememberSender(eventID, event.Sender)
+	return event.Sender, true
+}
+
+func (a *Adapter) translate(user commands.User, key string) string {
+	if a.Dispatcher.Translate == nil {
+		return key
+	}
+	return a.Dispatcher.Translate(user.LanguageCode, key)
+}
+
+func (a *Adapter) parseAmount(input string) (int64, error) {
+	var amount int64
+	var err error
+	if a.Dispatcher.ParseAmount != nil {
+		amount, err = a.Dispatcher.ParseAmount(input)
+	} else {
+		_, err = fmt.Sscanf(input, "%d", &amount)
+	}
+	if err != nil || amount < 1 {
+		return 0, commands.ErrInvalidAmount
+	}
+	return amount, nil
+}
+
+// stripReplyFallback removes the quoted original message that clients put in front of replies
+func stripReplyFallback(body string) string {
+	lines := strings.Split(body, "\n")
+	i := 0
+	for i < len(lines) && strings.HasPrefix(lines[i], "> ") {
+		i++
+	}
+	return strings.TrimSpace(strings.Join(lines[i:], "\n"))
+}
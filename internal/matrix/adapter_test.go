@@ -0,0 +1,156 @@
+package matrix_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/massmux/SatsMobiBot/internal/commands"
+	"github.com/massmux/SatsMobiBot/internal/matrix"
+	"github.com/massmux/SatsMobiBot/internal/matrix/matrixtest"
+)
+
+const room = "!room:local"
+
+var (
+	alice = matrix.User("@alice:local")
+	bob   = matrix.User("@bob:local")
+)
+
+func eventually(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func Test_Adapter(t *testing.T) {
+	server := matrixtest.NewServer("@bot:local", "token")
+	defer server.Close()
+	service := commands.NewMemoryService()
+	service.Deposit(alice, 1000)
+	service.Deposit(bob, 0)
+
+	adapter := matrix.NewAdapter(matrix.NewClient(server.URL, server.UserID, server.AccessToken), &commands.Dispatcher{Service: service})
+	adapter.SyncTimeout = time.Second
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server.Message(room, alice.ID, "!send 1 @bob:local before the start")
+	server.Invite(room)
+	go adapter.Run(ctx)
+	eventually(t, "initial sync", func() bool { return server.Syncs() > 0 && server.Joined(room) })
+
+	balance := func(user commands.User, want int64) func() bool {
+		return func() bool {
+			b, _ := service.Balance(user)
+			return b == want
+		}
+	}
+	sent := func(n int) func() bool {
+		return func() bool { return len(server.Sent(room)) >= n }
+	}
+
+	server.Message(room, alice.ID, "!send 100 @bob:local")
+	eventually(t, "send", balance(bob, 100))
+	eventually(t, "send reply", sent(1))
+
+	bobMessage := server.Message(room, bob.ID, "gm")
+	server.Reply(room, alice.ID, bobMessage, "!tip 50")
+	eventually(t, "tip reply", balance(bob, 150))
+
+	server.React(room, alice.ID, bobMessage, "⚡️")
+	eventually(t, "tip reaction", balance(bob, 171))
+
+	server.Message(room, alice.ID, "/faucet 200 100")
+	eventually(t, "faucet", sent(4))
+	faucet := server.Sent(room)[3]
+	if !strings.Contains(faucet, "matrixFaucetReactMessage") {
+		t.Fatalf("faucet message = %q", faucet)
+	}
+	server.React(room, bob.ID, lastEventID(t, server, faucet), "⚡")
+	eventually(t, "faucet claim", balance(bob, 271))
+
+	server.Message(room, bob.ID, "!tipjar 10")
+	eventually(t, "tipjar", sent(6))
+	tipjar := server.Sent(room)[5]
+	server.React(room, alice.ID, lastEventID(t, server, tipjar), "⚡")
+	eventually(t, "tipjar tip", balance(bob, 281))
+
+	if b, _ := service.Balance(alice); b != 1000-281 {
+		t.Errorf("balance of alice = %d, want %d", b, 1000-281)
+	}
+	// bob got direct messages for the send, both tips and the tipjar, alice for the faucet claim
+	eventually(t, "notifications", func() bool { return len(server.Rooms()) == 3 })
+}
+
+// lastEventID returns the id of the message the bot sent with the body
+func lastEventID(t *testing.T, server *matrixtest.Server, body string) string {
+	t.Helper()
+	for _, event := range server.Events() {
+		if event.Sender == server.UserID && event.Content["body"] == body {
+			return event.EventID
+		}
+	}
+	t.Fatalf("no event with body %q", body)
+	return ""
+}
+
+type mapReactionStore map[string]matrix.Reaction
+
+func (s mapReactionStore) Reaction(eventID string) (matrix.Reaction, bool) {
+	reaction, ok := s[eventID]
+	return reaction, ok
+}
+
+func (s mapReactionStore) SetReaction(eventID string, reaction matrix.Reaction) {
+	s[eventID] = reaction
+}
+
+func Test_Adapter_restart(t *testing.T) {
+	server := matrixtest.NewServer("@bot:local", "token")
+	defer server.Close()
+	service := commands.NewMemoryService()
+	service.Deposit(alice, 1000)
+	service.Deposit(bob, 0)
+	reactions := mapReactionStore{}
+	start := func() context.CancelFunc {
+		adapter := matrix.NewAdapter(matrix.NewClient(server.URL, server.UserID, server.AccessToken), &commands.Dispatcher{Service: service})
+		adapter.SyncTimeout = 100 * time.Millisecond
+		adapter.Reactions = reactions
+		ctx, cancel := context.WithCancel(context.Background())
+		syncs := server.Syncs()
+		go adapter.Run(ctx)
+		eventually(t, "initial sync", func() bool { return server.Syncs() > syncs+1 })
+		return cancel
+	}
+	server.Invite(room)
+	stop := start()
+	server.Message(room, bob.ID, "!tipjar 10")
+	eventually(t, "tipjar", func() bool { return len(server.Sent(room)) >= 1 })
+	tipjar := lastEventID(t, server, server.Sent(room)[0])
+	server.Message(room, bob.ID, "!help")
+	eventually(t, "help", func() bool { return len(server.Sent(room)) >= 2 })
+	help := lastEventID(t, server, server.Sent(room)[1])
+	stop()
+
+	stop = start()
+	defer stop()
+	// reactions on other messages of the bot don't tip the bot
+	server.React(room, alice.ID, help, "⚡")
+	server.React(room, alice.ID, tipjar, "⚡")
+	eventually(t, "tipjar tip after restart", func() bool {
+		b, _ := service.Balance(bob)
+		return b == 10
+	})
+	if b, _ := service.Balance(alice); b != 990 {
+		t.Errorf("balance of alice = %d, want 990", b)
+	}
+	if b, _ := service.Balance(matrix.User(server.UserID)); b != 0 {
+		t.Errorf("balance of the bot = %d, want 0", b)
+	}
+}
@@ -0,0 +1,165 @@
+// Package matrix is the Matrix frontend of the command layer. It talks to the homeserver with
+// the plain client-server API, so it runs against any homeserver and the stand-in in matrixtest.
+package matrix
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	EventMessage  = "m.room.message"
+	EventReaction = "m.reaction"
+	MsgTypeText   = "m.text"
+	MsgTypeNotice = "m.notice"
+	RelAnnotation = "m.annotation"
+)
+
+// Event is a room event of the client-server API
+type Event struct {
+	Type     string          `json:"type"`
+	EventID  string          `json:"event_id"`
+	Sender   string          `json:"sender"`
+	RoomID   string          `json:"room_id,omitempty"`
+	StateKey *string         `json:"state_key,omitempty"`
+	Content  json.RawMessage `json:"content"`
+}
+
+// Content is the content of messages and reactions
+type Content struct {
+	MsgType   string     `json:"msgtype,omitempty"`
+	Body      string     `json:"body,omitempty"`
+	RelatesTo *RelatesTo `json:"m.relates_to,omitempty"`
+}
+
+// RelatesTo links replies and reactions to another event
+type RelatesTo struct {
+	RelType   string     `json:"rel_type,omitempty"`
+	EventID   string     `json:"event_id,omitempty"`
+	Key       string     `json:"key,omitempty"`
+	InReplyTo *InReplyTo `json:"m.in_reply_to,omitempty"`
+}
+
+type InReplyTo struct {
+	EventID string `json:"event_id"`
+}
+
+// SyncResponse contains the parts of a /sync response the bot uses
+type SyncResponse struct {
+	NextBatch string `json:"next_batch"`
+	Rooms     struct {
+		Join map[string]struct {
+			Timeline struct {
+				Events []Event `json:"events"`
+			} `json:"timeline"`
+		} `json:"join"`
+		Invite map[string]json.RawMessage `json:"invite"`
+	} `json:"rooms"`
+}
+
+// Client is a minimal client of the Matrix client-server API
+type Client struct {
+	Homeserver  string
+	UserID      string
+	AccessToken string
+	HTTP        *http.Client
+	txn         int64
+}
+
+func NewClient(homeserver string, userID string, accessToken string) *Client {
+	return &Client{
+		Homeserver:  homeserver,
+		UserID:      userID,
+		AccessToken: accessToken,
+		HTTP:        &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+func (c *Client) do(ctx context.Context, method string, path string, query url.Values, body interface{}, response interface{}) error {
+	u := c.Homeserver + "/_matrix/client/v3" + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.AccessToken)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s: %s %s", method, path, resp.Status, string(b))
+	}
+	if response == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(response)
+}
+
+// Sync returns the events since the batch token. It waits up to timeout for new events.
+func (c *Client) Sync(ctx context.Context, since string, timeout time.Duration) (*SyncResponse, error) {
+	query := url.Values{"timeout": []string{strconv.FormatInt(timeout.Milliseconds(), 10)}}
+	if len(since) > 0 {
+		query.Set("since", since)
+	}
+	response := &SyncResponse{}
+	return response, c.do(ctx, http.MethodGet, "/sync", query, nil, response)
+}
+
+// JoinRoom accepts an invite
+func (c *Client) JoinRoom(ctx context.Context, roomID string) error {
+	return c.do(ctx, http.MethodPost, "/join/"+url.PathEscape(roomID), nil, struct{}{}, nil)
+}
+
+// SendMessage sends a message event to the room and returns its event id
+func (c *Client) SendMessage(ctx context.Context, roomID string, content Content) (string, error) {
+	txn := fmt.Sprintf("%d.%d", time.Now().UnixNano(), atomic.AddInt64(&c.txn, 1))
+	response := struct {
+		EventID string `json:"event_id"`
+	}{}
+	path := fmt.Sprintf("/rooms/%s/send/%s/%s", url.PathEscape(roomID), EventMessage, txn)
+	err := c.do(ctx, http.MethodPut, path, nil, content, &response)
+	return response.EventID, err
+}
+
+// GetEvent returns a single event of a room
+func (c *Client) GetEvent(ctx context.Context, roomID string, eventID string) (*Event, error) {
+	event := &Event{}
+	path := fmt.Sprintf("/rooms/%s/event/%s", url.PathEscape(roomID), url.PathEscape(eventID))
+	return event, c.do(ctx, http.MethodGet, path, nil, nil, event)
+}
+
+// CreateDirectRoom creates a direct chat with the user and returns the room id
+func (c *Client) CreateDirectRoom(ctx context.Context, userID string) (string, error) {
+	request := map[string]interface{}{
+		"invite":    []string{userID},
+		"is_direct": true,
+		"preset":    "trusted_private_chat",
+	}
+	response := struct {
+		RoomID string `json:"room_id"`
+	}{}
+	err := c.do(ctx, http.MethodPost, "/createRoom", nil, request, &response)
+	return response.RoomID, err
+}
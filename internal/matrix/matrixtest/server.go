@@ -0,0 +1,253 @@
+// Package matrixtest is a stand-in for a matrix homeserver. It implements the parts of the
+// client-server API the bot uses, so the matrix adapter can be tested without a homeserver.
+package matrixtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event is an event of the stand-in homeserver
+type Event struct {
+	Type    string                 `json:"type"`
+	EventID string                 `json:"event_id"`
+	Sender  string                 `json:"sender"`
+	RoomID  string                 `json:"room_id"`
+	Content map[string]interface{} `json:"content"`
+}
+
+// Server is a homeserver with a single bot user. Events of other users are added with Inject.
+type Server struct {
+	*httptest.Server
+	UserID      string
+	AccessToken string
+
+	lock    sync.Mutex
+	events  []Event
+	invites []string
+	joined  map[string]bool
+	syncs   int
+	counter int
+	wake    chan struct{}
+}
+
+// NewServer starts a stand-in homeserver for the bot user
+func NewServer(userID string, accessToken string) *Server {
+	s := &Server{
+		UserID:      userID,
+		AccessToken: accessToken,
+		joined:      make(map[string]bool),
+		wake:        make(chan struct{}),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/_matrix/client/v3/sync", s.sync)
+	mux.HandleFunc("/_matrix/client/v3/join/", s.join)
+	mux.HandleFunc("/_matrix/client/v3/rooms/", s.rooms)
+	mux.HandleFunc("/_matrix/client/v3/createRoom", s.createRoom)
+	s.Server = httptest.NewServer(s.authorize(mux))
+	return s
+}
+
+func (s *Server) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+s.AccessToken {
+			writeError(w, http.StatusUnauthorized, "M_UNKNOWN_TOKEN")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	writeJSON(w, map[string]string{"errcode": code})
+}
+
+// add stores an event and wakes up waiting syncs. The lock must be held.
+func (s *Server) add(event Event) Event {
+	s.counter++
+	event.EventID = fmt.Sprintf("$%d", s.counter)
+	s.events = append(s.events, event)
+	close(s.wake)
+	s.wake = make(chan struct{})
+	return event
+}
+
+// Inject adds an event of a user to a room and returns its event id
+func (s *Server) Inject(roomID string, sender string, eventType string, content map[string]interface{}) string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.add(Event{Type: eventType, Sender: sender, RoomID: roomID, Content: content}).EventID
+}
+
+// Message adds a text message of a user to a room
+func (s *Server) Message(roomID string, sender string, body string) string {
+	return s.Inject(roomID, sender, "m.room.message", map[string]interface{}{"msgtype": "m.text", "body": body})
+}
+
+// Reply adds a text message of a user that replies to an event
+func (s *Server) Reply(roomID string, sender string, eventID string, body string) string {
+	return s.Inject(roomID, sender, "m.room.message", map[string]interface{}{
+		"msgtype":      "m.text",
+		"body":         "> <someone> quoted message\n\n" + body,
+		"m.relates_to": map[string]interface{}{"m.in_reply_to": map[string]string{"event_id": eventID}},
+	})
+}
+
+// React adds a reaction of a user to an event
+func (s *Server) React(roomID string, sender string, eventID string, key string) string {
+	return s.Inject(roomID, sender, "m.reaction", map[string]interface{}{
+		"m.relates_to": map[string]string{"rel_type": "m.annotation", "event_id": eventID, "key": key},
+	})
+}
+
+// Invite invites the bot to a room
+func (s *Server) Invite(roomID string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.invites = append(s.invites, roomID)
+}
+
+// Joined reports whether the bot joined the room
+func (s *Server) Joined(roomID string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.joined[roomID]
+}
+
+// Syncs returns the number of finished /sync requests
+func (s *Server) Syncs() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.syncs
+}
+
+// Sent returns the message bodies the bot sent to a room
+func (s *Server) Sent(roomID string) []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var bodies []string
+	for _, event := range s.events {
+		if event.Sender == s.UserID && event.RoomID == roomID {
+			body, _ := event.Content["body"].(string)
+			bodies = append(bodies, body)
+		}
+	}
+	return bodies
+}
+
+// Events returns all events of all rooms
+func (s *Server) Events() []Event {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]Event(nil), s.events...)
+}
+
+// Rooms returns the rooms the bot sent messages to
+func (s *Server) Rooms() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	seen := make(map[string]bool)
+	var rooms []string
+	for _, event := range s.events {
+		if event.Sender == s.UserID && !seen[event.RoomID] {
+			seen[event.RoomID] = true
+			rooms = append(rooms, event.RoomID)
+		}
+	}
+	return rooms
+}
+
+func (s *Server) sync(w http.ResponseWriter, r *http.Request) {
+	since, _ := strconv.Atoi(r.URL.Query().Get("since"))
+	timeout, _ := strconv.Atoi(r.URL.Query().Get("timeout"))
+	s.lock.Lock()
+	if len(s.events) <= since && len(s.invites) == 0 && timeout > 0 {
+		wake := s.wake
+		s.lock.Unlock()
+		select {
+		case <-wake:
+		case <-r.Context().Done():
+		case <-time.After(time.Duration(timeout) * time.Millisecond):
+		}
+		s.lock.Lock()
+	}
+	defer s.lock.Unlock()
+	join := make(map[string]map[string]map[string][]Event)
+	if since > len(s.events) {
+		since = len(s.events)
+	}
+	for _, event := range s.events[since:] {
+		if _, ok := join[event.RoomID]; !ok {
+			join[event.RoomID] = map[string]map[string][]Event{"timeline": {"events": nil}}
+		}
+		join[event.RoomID]["timeline"]["events"] = append(join[event.RoomID]["timeline"]["events"], event)
+	}
+	invite := make(map[string]interface{})
+	for _, roomID := range s.invites {
+		invite[roomID] = map[string]interface{}{}
+	}
+	s.invites = nil
+	s.syncs++
+	writeJSON(w, map[string]interface{}{
+		"next_batch": strconv.Itoa(len(s.events)),
+		"rooms":      map[string]interface{}{"join": join, "invite": invite},
+	})
+}
+
+func (s *Server) join(w http.ResponseWriter, r *http.Request) {
+	roomID := strings.TrimPrefix(r.URL.Path, "/_matrix/client/v3/join/")
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.joined[roomID] = true
+	writeJSON(w, map[string]string{"room_id": roomID})
+}
+
+// rooms serves PUT /rooms/{room}/send/{type}/{txn} and GET /rooms/{room}/event/{event}
+func (s *Server) rooms(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/_matrix/client/v3/rooms/"), "/")
+	switch {
+	case len(parts) == 4 && parts[1] == "send" && r.Method == http.MethodPut:
+		content := make(map[string]interface{})
+		if err := json.NewDecoder(r.Body).Decode(&content); err != nil {
+			writeError(w, http.StatusBadRequest, "M_NOT_JSON")
+			return
+		}
+		s.lock.Lock()
+		event := s.add(Event{Type: parts[2], Sender: s.UserID, RoomID: parts[0], Content: content})
+		s.lock.Unlock()
+		writeJSON(w, map[string]string{"event_id": event.EventID})
+	case len(parts) == 3 && parts[1] == "event" && r.Method == http.MethodGet:
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		for _, event := range s.events {
+			if event.RoomID == parts[0] && event.EventID == parts[2] {
+				writeJSON(w, event)
+				return
+			}
+		}
+		writeError(w, http.StatusNotFound, "M_NOT_FOUND")
+	default:
+		writeError(w, http.StatusNotFound, "M_UNRECOGNIZED")
+	}
+}
+
+func (s *Server) createRoom(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.counter++
+	roomID := fmt.Sprintf("!room%d:local", s.counter)
+	s.joined[roomID] = true
+	writeJSON(w, map[string]string{"room_id": roomID})
+}
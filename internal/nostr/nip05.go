@@ -3,19 +3,31 @@ package nostr
 import (
 	"fmt"
 	"net/http"
+	"strings"
 
+	"github.com/massmux/SatsMobiBot/internal"
 	"github.com/massmux/SatsMobiBot/internal/api"
 	db "github.com/massmux/SatsMobiBot/internal/database"
 	"github.com/massmux/SatsMobiBot/internal/telegram"
+	"github.com/nbd-wtf/go-nostr"
 	"github.com/prometheus/common/log"
 	"gorm.io/gorm"
 )
 
+// rootName is the NIP-05 name of the domain itself (_@domain). It resolves to the pubkey of the bot.
+const rootName = "_"
+
 type Nostr struct {
 	database *gorm.DB
 	bot      *telegram.TipBot
 }
 
+// Nip05Response is the nostr.json document as specified in NIP-05
+type Nip05Response struct {
+	Names  map[string]string   `json:"names"`
+	Relays map[string][]string `json:"relays,omitempty"`
+}
+
 func New(bot *telegram.TipBot) Nostr {
 	return Nostr{
 		database: bot.DB.Users,
@@ -24,33 +36,64 @@ func New(bot *telegram.TipBot) Nostr {
 }
 
 func (n Nostr) Handle(writer http.ResponseWriter, request *http.Request) {
-	username := request.FormValue("name")
-	if username == "" {
-		api.NotFoundHandler(writer, fmt.Errorf("[NostrNip05] Form value 'name' is not set"))
+	// nostr clients fetch the document from the browser
+	writer.Header().Set("Access-Control-Allow-Origin", "*")
+	writer.Header().Set("Content-Type", "application/json")
+	if request.Method == http.MethodOptions {
+		writer.WriteHeader(http.StatusNoContent)
 		return
 	}
-	user, tx := db.FindUser(n.database, username)
-	if tx.Error == nil && user.Telegram != nil {
-		user, err := db.FindUserSettings(user, n.bot.DB.Users.Preload("Settings"))
-		if err != nil {
-			log.Errorf("[NostrNip05] user settings not found")
-			api.NotFoundHandler(writer, fmt.Errorf("user settings error"))
-			return
+	response := Nip05Response{Names: map[string]string{}}
+	name := strings.ToLower(strings.TrimSpace(request.FormValue("name")))
+	if name == "" {
+		// no name requested: return an empty document instead of listing all users
+		if err := api.WriteResponse(writer, response); err != nil {
+			log.Errorf("[NostrNip05] Failed responding: %v", err)
 		}
-		if user.Settings.Nostr.PubKey != "" {
-			data := []byte(fmt.Sprintf(`{
-  "names":{
-    "%s":"%s"
-  }
+		return
+	}
+	pubkey, relays, err := n.lookup(name)
+	if err != nil {
+		api.NotFoundHandler(writer, fmt.Errorf("[NostrNip05] %s: %w", name, err))
+		return
+	}
+	response.Names[name] = pubkey
+	if len(relays) > 0 {
+		response.Relays = map[string][]string{pubkey: relays}
+	}
+	if err = api.WriteResponse(writer, response); err != nil {
+		log.Errorf("[NostrNip05] Failed responding to user %s: %v", name, err)
+	}
 }
-`, username, user.Settings.Nostr.PubKey))
-			_, err = writer.Write(data)
-			if err != nil {
-				log.Errorf("[NostrNip05] Failed responding to user %s", username)
-			}
+
+// lookup resolves a NIP-05 name to a pubkey and the relays of its owner. Names are
+// telegram usernames, anon ids of lightning addresses or custom names registered with /nostr name.
+func (n Nostr) lookup(name string) (string, []string, error) {
+	if name == rootName {
+		if len(internal.Configuration.Nostr.PrivateKey) == 0 {
+			return "", nil, fmt.Errorf("nostr is not configured")
+		}
+		pubkey, err := nostr.GetPublicKey(internal.Configuration.Nostr.PrivateKey)
+		if err != nil {
+			return "", nil, err
+		}
+		return pubkey, internal.Configuration.Nostr.Relays, nil
+	}
+	user, tx := db.FindUser(n.database, name)
+	if tx.Error == nil && user.Telegram != nil {
+		if _, err := db.FindUserSettings(user, n.database.Preload("Settings")); err != nil {
+			return "", nil, fmt.Errorf("user settings error: %w", err)
 		}
 	} else {
-		log.Errorf("[NostrNip05] user not found")
-		api.NotFoundHandler(writer, fmt.Errorf("user not found"))
+		// not a telegram username or anon id, try the custom names
+		custom, err := db.FindUserByNostrName(n.database, name)
+		if err != nil {
+			return "", nil, fmt.Errorf("user not found")
+		}
+		user = custom
+	}
+	if user.Settings == nil || len(user.Settings.Nostr.PubKey) == 0 {
+		return "", nil, fmt.Errorf("user has no nostr pubkey")
 	}
+	return user.Settings.Nostr.PubKey, strings.Fields(user.Settings.Nostr.Relays), nil
 }
@@ -41,6 +41,13 @@ func setOrReturn(objectMap cmap.ConcurrentMap, k2 string) error {
 	return nil
 }
 
+// Release removes the user k2 from the object k1, so the user can access the object again.
+func Release(k1, k2 string) {
+	if i, ok := onceMap.Get(k1); ok {
+		i.(cmap.ConcurrentMap).Remove(k2)
+	}
+}
+
 // Remove removes the key k1 from the map. Should be called after Once was called and
 // the object k1 finished.
 func Remove(k1 string) {
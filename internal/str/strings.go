@@ -55,3 +55,15 @@ func UUIDSha256(u *lnbits.User) string {
 	anon_id := fmt.Sprintf("1x%s", hash[len(hash)-16:]) // starts with 1x because that can't be a valid telegram username
 	return anon_id
 }
+
+// Truncate shortens s to at most n characters without splitting a multi-byte character
+func Truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n])
+}
@@ -243,6 +243,12 @@ func (bot *TipBot) enterAmountHandler(ctx intercept.Context) (intercept.Context,
 			return bot.sendHandler(ctx)
 		}
 		return ctx, errors.Create(errors.InvalidSyntaxError)
+	case "CampaignContributeState":
+		SetUserState(user, bot, lnbits.UserHasEnteredAmount, "")
+		return bot.contributeCampaign(ctx, EnterAmountStateData.ID, amount)
+	case "BountyContributeState":
+		SetUserState(user, bot, lnbits.UserHasEnteredAmount, "")
+		return bot.contributeBounty(ctx, EnterAmountStateData.ID, amount)
 	default:
 		ResetUserState(user, bot)
 		return ctx, errors.Create(errors.InvalidSyntaxError)
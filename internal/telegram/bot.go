@@ -136,6 +136,22 @@ func (bot *TipBot) Start() {
 	go bot.Telegram.Start()
 
 	go bot.restartPersistedTickets()
+	go bot.restartShopWebhookDeliveries()
+	go bot.startGroupMembershipWorker()
+	go bot.startPaymentScheduleWorker()
+	go bot.restartMoneyRequestTimers()
+	go bot.restartSplitBillReminders()
+	go bot.restartRaffleTimers()
+	go bot.restartCampaignTimers()
+	go bot.restartBountyTimers()
+	go bot.restartTreasurySpendTimers()
+	go bot.restartEscrowPayouts()
+	go bot.restartBulkPayouts()
+	go bot.restartInlineExpiryTimers()
+	go bot.startNwcListener()
+	go bot.startNostrPublishWorker()
+	go bot.startNostrDMListener()
+	go bot.startMatrixAdapter()
 	// gracefully shutdown
 	exit := make(chan os.Signal, 1) // we need to reserve to buffer size 1, so the notifier are not blocked
 	// we need to catch SIGTERM and SIGSTOP
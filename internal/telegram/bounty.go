@@ -0,0 +1,583 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/massmux/SatsMobiBot/internal/errors"
+	"github.com/massmux/SatsMobiBot/internal/i18n"
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	"github.com/massmux/SatsMobiBot/internal/runtime"
+	"github.com/massmux/SatsMobiBot/internal/runtime/mutex"
+	"github.com/massmux/SatsMobiBot/internal/storage"
+	"github.com/massmux/SatsMobiBot/internal/str"
+	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/buntdb"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const (
+	BountyIndex             = "bounty:*"
+	bountyExpiry            = 7 * 24 * time.Hour
+	bountyMaxClaims         = 10
+	bountyObjectionDuration = 24 * time.Hour
+)
+
+var (
+	bountyMenu          = &tb.ReplyMarkup{ResizeKeyboard: true}
+	btnContributeBounty = bountyMenu.Data("💰 Contribute", "bounty_contribute")
+	btnCancelBounty     = bountyMenu.Data("🚫 Cancel", "bounty_cancel")
+	btnAwardBounty      = bountyMenu.Data("🏆 Award", "bounty_award")
+	btnObjectBounty     = bountyMenu.Data("✋ Object", "bounty_object")
+)
+
+// BountyContribution is the sum of all amounts a user locked into a bounty
+type BountyContribution struct {
+	User   *lnbits.User `json:"user"`
+	Amount int64        `json:"amount"`
+}
+
+// BountyClaim is a user who replied to the bounty and the contributors who voted for them
+type BountyClaim struct {
+	User  *tb.User `json:"user"`
+	Votes []int64  `json:"votes"` // telegram ids of the contributors
+}
+
+// BountyAward is an award of the poster that is paid out after the objection window
+type BountyAward struct {
+	Claim *tb.User  `json:"claim"`
+	PayAt time.Time `json:"pay_at"`
+}
+
+// Bounty is a paid task in a group. The reward is held in escrow by the bot wallet until the poster,
+// or contributors holding the majority of the reward, award it to a user who replied to the bounty.
+// Once others have contributed, an award of the poster is only paid if no contributor objects in time.
+type Bounty struct {
+	*storage.Base
+	Poster        *lnbits.User         `json:"poster"`
+	Description   string               `json:"description"`
+	Contributions []BountyContribution `json:"contributions"`
+	Claims        []BountyClaim        `json:"claims"`
+	ExpiresAt     time.Time            `json:"expires_at"`
+	PendingAward  *BountyAward         `json:"pending_award"`
+	Objected      bool                 `json:"objected"`
+	Winner        *tb.User             `json:"winner"`
+	Message       *tb.Message          `json:"message"`
+	LanguageCode  string               `json:"languagecode"`
+}
+
+// reward returns the amount held in escrow
+func (bounty *Bounty) reward() int64 {
+	var reward int64
+	for _, c := range bounty.Contributions {
+		reward += c.Amount
+	}
+	return reward
+}
+
+// chat returns the group of the bounty or nil if its message was never sent
+func (bounty *Bounty) chat() *tb.Chat {
+	if bounty.Message == nil {
+		return nil
+	}
+	return bounty.Message.Chat
+}
+
+// contribution returns how much the user has locked into the bounty
+func (bounty *Bounty) contribution(id int64) int64 {
+	for _, c := range bounty.Contributions {
+		if c.User.Telegram.ID == id {
+			return c.Amount
+		}
+	}
+	return 0
+}
+
+// othersContributed returns whether anyone but the poster contributed
+func (bounty *Bounty) othersContributed() bool {
+	for _, c := range bounty.Contributions {
+		if c.User.Telegram.ID != bounty.Poster.Telegram.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// votedAmount returns the sum of the contributions of everyone who voted for the claim
+func (bounty *Bounty) votedAmount(claim BountyClaim) int64 {
+	var amount int64
+	for _, id := range claim.Votes {
+		amount += bounty.contribution(id)
+	}
+	return amount
+}
+
+// bountyHandler is invoked on /bounty <amount> <description> in a group chat
+func (bot *TipBot) bountyHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	poster := LoadUser(ctx)
+	if poster.Wallet == nil {
+		return ctx, errors.Create(errors.UserNoWalletError)
+	}
+	if m.Private() {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "bountyHelpMessage"), int(bountyExpiry.Hours()/24)))
+		return ctx, errors.Create(errors.NoPrivateChatError)
+	}
+	amountStr, err := getArgumentFromCommand(m.Text, 1)
+	if err != nil {
+		bot.trySendMessage(m.Chat, fmt.Sprintf(Translate(ctx, "bountyHelpMessage"), int(bountyExpiry.Hours()/24)))
+		return ctx, err
+	}
+	amount, err := GetAmount(amountStr)
+	if err != nil || amount < 1 {
+		bot.trySendMessage(m.Chat, fmt.Sprintf(Translate(ctx, "bountyHelpMessage"), int(bountyExpiry.Hours()/24)))
+		return ctx, errors.Create(errors.InvalidAmountError)
+	}
+	description := strings.TrimSpace(GetMemoFromCommand(m.Text, 2))
+	if len(description) == 0 {
+		bot.trySendMessage(m.Chat, fmt.Sprintf(Translate(ctx, "bountyHelpMessage"), int(bountyExpiry.Hours()/24)))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	me, err := GetUser(bot.Telegram.Me, *bot)
+	if err != nil {
+		return ctx, err
+	}
+	t := NewTransaction(bot, poster, me, amount, TransactionType("bounty"), TransactionChat(m.Chat))
+	t.Memo = fmt.Sprintf("🎯 Bounty of %s.", GetUserStr(poster.Telegram))
+	success, err := t.Send()
+	if !success || err != nil {
+		bot.trySendMessage(m.Chat, Translate(ctx, "inlineSendBalanceLowMessage"))
+		return ctx, errors.Create(errors.BalanceToLowError)
+	}
+	bounty := &Bounty{
+		Base:          storage.New(storage.ID(fmt.Sprintf("bounty:%d:%s", m.Chat.ID, RandStringRunes(8)))),
+		Poster:        poster,
+		Description:   description,
+		Contributions: []BountyContribution{{User: poster, Amount: amount}},
+		Claims:        make([]BountyClaim, 0),
+		ExpiresAt:     time.Now().Add(bountyExpiry),
+		LanguageCode:  ctx.Value("publicLanguageCode").(string),
+	}
+	bounty.Message = bot.trySendMessageEditable(m.Chat, bot.bountyText(bounty), bot.bountyKeyboard(bounty))
+	if bounty.Message == nil {
+		// nobody can claim a bounty without its message, give the reward back
+		bot.payFromEscrow(poster.Telegram, amount, "bounty", m.Chat, fmt.Sprintf("🎯 Bounty refund to %s.", GetUserStr(poster.Telegram)))
+		return ctx, errors.Create(errors.UnknownError)
+	}
+	runtime.IgnoreError(bounty.Set(bounty, bot.Bunt))
+	bot.startBountyTimer(bounty)
+	log.Infof("[🎯 bounty] %s posted bounty %s of %d sat in %s", GetUserStr(poster.Telegram), bounty.ID, amount, m.Chat.Title)
+	return ctx, nil
+}
+
+func (bot *TipBot) bountyText(bounty *Bounty) string {
+	text := fmt.Sprintf(i18n.Translate(bounty.LanguageCode, "bountyMessage"),
+		GetUserStrMd(bounty.Poster.Telegram), str.MarkdownEscape(bounty.Description), bounty.reward(),
+		len(bounty.Contributions), bounty.ExpiresAt.UTC().Format("2006-01-02 15:04 UTC"))
+	if len(bounty.Claims) > 0 {
+		text += "\n\n" + i18n.Translate(bounty.LanguageCode, "bountyClaimsMessage")
+		reward := bounty.reward()
+		for _, claim := range bounty.Claims {
+			text += fmt.Sprintf("\n• %s – %d/%d sat", GetUserStrMd(claim.User), bounty.votedAmount(claim), reward)
+		}
+	}
+	if bounty.PendingAward != nil {
+		text += "\n\n" + fmt.Sprintf(i18n.Translate(bounty.LanguageCode, "bountyAwardPendingMessage"),
+			GetUserStrMd(bounty.PendingAward.Claim), bounty.PendingAward.PayAt.UTC().Format("2006-01-02 15:04 UTC"))
+	}
+	return text
+}
+
+// bountyKeyboard has an award button for every claim
+func (bot *TipBot) bountyKeyboard(bounty *Bounty) *tb.ReplyMarkup {
+	rows := make([]tb.Row, 0)
+	for i, claim := range bounty.Claims {
+		button := bountyMenu.Data(fmt.Sprintf("%s %s", btnAwardBounty.Text, GetUserStr(claim.User)),
+			btnAwardBounty.Unique, fmt.Sprintf("%s,%d", bounty.ID, i))
+		rows = append(rows, bountyMenu.Row(button))
+	}
+	contributeButton := bountyMenu.Data(btnContributeBounty.Text, btnContributeBounty.Unique, bounty.ID)
+	cancelButton := bountyMenu.Data(btnCancelBounty.Text, btnCancelBounty.Unique, bounty.ID)
+	rows = append(rows, bountyMenu.Row(contributeButton, cancelButton))
+	if bounty.PendingAward != nil {
+		rows = append(rows, bountyMenu.Row(bountyMenu.Data(btnObjectBounty.Text, btnObjectBounty.Unique, bounty.ID)))
+	}
+	bountyMenu.Inline(rows...)
+	return bountyMenu
+}
+
+func (bot *TipBot) loadBounty(id string) (*Bounty, error) {
+	tx := &Bounty{Base: storage.New(storage.ID(id))}
+	sn, err := tx.Get(tx, bot.Bunt)
+	if err != nil {
+		return nil, err
+	}
+	return sn.(*Bounty), nil
+}
+
+// findBountyByMessage returns the open bounty that was posted with the message
+func (bot *TipBot) findBountyByMessage(m *tb.Message) *Bounty {
+	var found *Bounty
+	bot.Bunt.View(func(tx *buntdb.Tx) error {
+		return tx.AscendKeys(fmt.Sprintf("bounty:%d:*", m.Chat.ID), func(key, value string) bool {
+			bounty := &Bounty{}
+			if err := json.Unmarshal([]byte(value), bounty); err != nil || !bounty.Active || bounty.Message == nil {
+				return true
+			}
+			if bounty.Message.ID == m.ID {
+				found = bounty
+				return false
+			}
+			return true // continue iteration
+		})
+	})
+	return found
+}
+
+// bountyReplyInterceptor registers group replies to a bounty message as claims
+func (bot TipBot) bountyReplyInterceptor(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	if m == nil || m.Private() || m.ReplyTo == nil || m.ReplyTo.Sender == nil || m.ReplyTo.Sender.ID != bot.Telegram.Me.ID || m.Sender == nil || m.Sender.IsBot {
+		return ctx, nil
+	}
+	found := bot.findBountyByMessage(m.ReplyTo)
+	if found == nil {
+		return ctx, nil
+	}
+	mutex.Lock(found.ID)
+	defer mutex.Unlock(found.ID)
+	bounty, err := bot.loadBounty(found.ID)
+	if err != nil || !bounty.Active || bounty.Poster.Telegram.ID == m.Sender.ID || len(bounty.Claims) >= bountyMaxClaims {
+		return ctx, nil
+	}
+	for _, claim := range bounty.Claims {
+		if claim.User.ID == m.Sender.ID {
+			return ctx, nil
+		}
+	}
+	bounty.Claims = append(bounty.Claims, BountyClaim{User: m.Sender, Votes: make([]int64, 0)})
+	runtime.IgnoreError(bounty.Set(bounty, bot.Bunt))
+	log.Infof("[🎯 bounty] %s claimed bounty %s", GetUserStr(m.Sender), bounty.ID)
+	bot.tryEditStack(bounty.Message, bounty.ID, bot.bountyText(bounty), bot.bountyKeyboard(bounty))
+	return ctx, nil
+}
+
+// contributeBountyHandler is invoked when a user presses the contribute button.
+// The user is asked for the amount in the private chat.
+func (bot *TipBot) contributeBountyHandler(ctx intercept.Context) (intercept.Context, error) {
+	c := ctx.Callback()
+	bounty, err := bot.loadBounty(c.Data)
+	if err != nil {
+		return ctx, err
+	}
+	if !bounty.Active {
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	user := LoadUser(ctx)
+	if user.Wallet == nil {
+		ctx.Context = context.WithValue(ctx, "callback_response", Translate(ctx, "campaignStartBotMessage"))
+		return ctx, errors.Create(errors.UserNoWalletError)
+	}
+	bot.trySendMessage(user.Telegram, fmt.Sprintf(Translate(ctx, "bountyContributeMessage"), str.MarkdownEscape(bounty.Description)))
+	_, err = bot.askForAmount(ctx, bounty.ID, "BountyContributeState", 0, 0, "")
+	ctx.Context = context.WithValue(ctx, "callback_response", Translate(ctx, "campaignCheckPrivateChatMessage"))
+	return ctx, err
+}
+
+// contributeBounty is invoked by enterAmountHandler after the user entered the amount of a contribution
+func (bot *TipBot) contributeBounty(ctx intercept.Context, id string, amount int64) (intercept.Context, error) {
+	mutex.LockWithContext(ctx, id)
+	defer mutex.UnlockWithContext(ctx, id)
+	bounty, err := bot.loadBounty(id)
+	if err != nil {
+		return ctx, err
+	}
+	user := LoadUser(ctx)
+	if !bounty.Active {
+		bot.trySendMessage(user.Telegram, Translate(ctx, "bountyClosedMessage"))
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	if amount < 1 {
+		bot.trySendMessage(user.Telegram, Translate(ctx, "lnurlInvalidAmountMessage"))
+		return ctx, errors.Create(errors.InvalidAmountError)
+	}
+	me, err := GetUser(bot.Telegram.Me, *bot)
+	if err != nil {
+		return ctx, err
+	}
+	t := NewTransaction(bot, user, me, amount, TransactionType("bounty"), TransactionChat(bounty.Message.Chat))
+	t.Memo = fmt.Sprintf("🎯 Bounty contribution of %s.", GetUserStr(user.Telegram))
+	success, err := t.Send()
+	if !success || err != nil {
+		bot.trySendMessage(user.Telegram, Translate(ctx, "inlineSendBalanceLowMessage"))
+		return ctx, errors.Create(errors.BalanceToLowError)
+	}
+	contributed := false
+	for i := range bounty.Contributions {
+		if bounty.Contributions[i].User.Telegram.ID == user.Telegram.ID {
+			bounty.Contributions[i].Amount += amount
+			contributed = true
+		}
+	}
+	if !contributed {
+		bounty.Contributions = append(bounty.Contributions, BountyContribution{User: user, Amount: amount})
+	}
+	runtime.IgnoreError(bounty.Set(bounty, bot.Bunt))
+	log.Infof("[🎯 bounty] %s contributed %d sat to bounty %s", GetUserStr(user.Telegram), amount, bounty.ID)
+	bot.trySendMessage(user.Telegram, fmt.Sprintf(Translate(ctx, "bountyContributedMessage"), amount))
+	bot.tryEditStack(bounty.Message, bounty.ID, bot.bountyText(bounty), bot.bountyKeyboard(bounty))
+	return ctx, nil
+}
+
+// awardBountyHandler is invoked when the poster or a contributor presses the award button of a claim.
+// The poster awards the bounty directly, contributors vote. Once others have contributed, the award of the
+// poster is paid after bountyObjectionDuration. After a contributor objected, the poster votes like everyone else.
+func (bot *TipBot) awardBountyHandler(ctx intercept.Context) (intercept.Context, error) {
+	c := ctx.Callback()
+	id, indexStr, ok := strings.Cut(c.Data, ",")
+	index, err := strconv.Atoi(indexStr)
+	if !ok || err != nil {
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	mutex.LockWithContext(ctx, id)
+	defer mutex.UnlockWithContext(ctx, id)
+	bounty, err := bot.loadBounty(id)
+	if err != nil {
+		return ctx, err
+	}
+	if !bounty.Active || index < 0 || index >= len(bounty.Claims) {
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	claim := &bounty.Claims[index]
+	if bounty.contribution(c.Sender.ID) == 0 {
+		ctx.Context = context.WithValue(ctx, "callback_response", Translate(ctx, "bountyNotContributorMessage"))
+		return ctx, errors.Create(errors.UnknownError)
+	}
+	if claim.User.ID == c.Sender.ID {
+		ctx.Context = context.WithValue(ctx, "callback_response", Translate(ctx, "bountyVoteYourselfMessage"))
+		return ctx, errors.Create(errors.SelfPaymentError)
+	}
+	direct := c.Sender.ID == bounty.Poster.Telegram.ID && !bounty.Objected
+	if !direct {
+		// every contributor has one vote, weighted by the contribution
+		for i := range bounty.Claims {
+			votes := make([]int64, 0)
+			for _, voter := range bounty.Claims[i].Votes {
+				if voter != c.Sender.ID {
+					votes = append(votes, voter)
+				}
+			}
+			bounty.Claims[i].Votes = votes
+		}
+		claim.Votes = append(claim.Votes, c.Sender.ID)
+		if bounty.votedAmount(*claim)*2 <= bounty.reward() {
+			runtime.IgnoreError(bounty.Set(bounty, bot.Bunt))
+			ctx.Context = context.WithValue(ctx, "callback_response", fmt.Sprintf(Translate(ctx, "bountyVotedMessage"), GetUserStr(claim.User)))
+			bot.tryEditStack(bounty.Message, bounty.ID, bot.bountyText(bounty), bot.bountyKeyboard(bounty))
+			return ctx, nil
+		}
+	}
+	winner, err := GetLnbitsUser(claim.User, *bot)
+	if err != nil || winner.Wallet == nil {
+		ctx.Context = context.WithValue(ctx, "callback_response", Translate(ctx, "bountyWinnerNoWalletMessage"))
+		return ctx, errors.Create(errors.UserNoWalletError)
+	}
+	if direct && bounty.othersContributed() {
+		// the poster could award the reward of others to an account of their own
+		bounty.PendingAward = &BountyAward{Claim: claim.User, PayAt: time.Now().Add(bountyObjectionDuration)}
+		runtime.IgnoreError(bounty.Set(bounty, bot.Bunt))
+		runtime.RemoveTicker(bountyAwardTickerID(bounty.ID))
+		bot.startBountyAwardTimer(bounty)
+		log.Infof("[🎯 bounty] %s awarded bounty %s to %s, paying at %s", GetUserStr(c.Sender), bounty.ID, GetUserStr(claim.User), bounty.PendingAward.PayAt)
+		ctx.Context = context.WithValue(ctx, "callback_response", Translate(ctx, "bountyAwardScheduledMessage"))
+		bot.tryEditStack(bounty.Message, bounty.ID, bot.bountyText(bounty), bot.bountyKeyboard(bounty))
+		return ctx, nil
+	}
+	bot.payBounty(bounty, winner)
+	return ctx, nil
+}
+
+// payBounty closes the bounty and pays the reward to the winner from the escrow. A failed payout is retried.
+func (bot *TipBot) payBounty(bounty *Bounty, winner *lnbits.User) {
+	runtime.RemoveTicker(bounty.ID)
+	runtime.RemoveTicker(bountyAwardTickerID(bounty.ID))
+	reward := bounty.reward()
+	bounty.Winner = winner.Telegram
+	bounty.PendingAward = nil
+	runtime.IgnoreError(bounty.Inactivate(bounty, bot.Bunt))
+	bot.payFromEscrow(winner.Telegram, reward, "bounty", bounty.chat(), fmt.Sprintf("🎯 Bounty awarded to %s.", GetUserStr(winner.Telegram)))
+	log.Infof("[🎯 bounty] Bounty %s of %d sat awarded to %s", bounty.ID, reward, GetUserStr(winner.Telegram))
+	bot.trySendMessage(winner.Telegram, fmt.Sprintf(i18n.Translate(winner.Telegram.LanguageCode, "bountyYouWonMessage"), reward, str.MarkdownEscape(bounty.Description)))
+	if bounty.Message == nil {
+		return
+	}
+	bot.tryEditStack(bounty.Message, bounty.ID, bot.bountyText(bounty)+"\n\n"+fmt.Sprintf(i18n.Translate(bounty.LanguageCode, "bountyAwardedMessage"), GetUserStrMd(winner.Telegram), reward), &tb.ReplyMarkup{})
+}
+
+// objectBountyHandler is invoked when a contributor objects to the pending award of the poster.
+// The award is dropped and the bounty can only be awarded by vote from then on.
+func (bot *TipBot) objectBountyHandler(ctx intercept.Context) (intercept.Context, error) {
+	c := ctx.Callback()
+	mutex.LockWithContext(ctx, c.Data)
+	defer mutex.UnlockWithContext(ctx, c.Data)
+	bounty, err := bot.loadBounty(c.Data)
+	if err != nil {
+		return ctx, err
+	}
+	if !bounty.Active || bounty.PendingAward == nil {
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	if c.Sender.ID == bounty.Poster.Telegram.ID || bounty.contribution(c.Sender.ID) == 0 {
+		ctx.Context = context.WithValue(ctx, "callback_response", Translate(ctx, "bountyNotObjectorMessage"))
+		return ctx, errors.Create(errors.UnknownError)
+	}
+	runtime.RemoveTicker(bountyAwardTickerID(bounty.ID))
+	bounty.PendingAward = nil
+	bounty.Objected = true
+	runtime.IgnoreError(bounty.Set(bounty, bot.Bunt))
+	log.Infof("[🎯 bounty] %s objected to the award of bounty %s", GetUserStr(c.Sender), bounty.ID)
+	ctx.Context = context.WithValue(ctx, "callback_response", Translate(ctx, "bountyObjectedMessage"))
+	if bot.resumeBountyExpiry(bounty) {
+		bot.tryEditStack(bounty.Message, bounty.ID, bot.bountyText(bounty), bot.bountyKeyboard(bounty))
+	}
+	return ctx, nil
+}
+
+// finishBountyAward pays the pending award of the poster once the objection window has passed
+func (bot *TipBot) finishBountyAward(id string) {
+	mutex.Lock(id)
+	defer mutex.Unlock(id)
+	bounty, err := bot.loadBounty(id)
+	if err != nil || !bounty.Active || bounty.PendingAward == nil || time.Now().Before(bounty.PendingAward.PayAt) {
+		return
+	}
+	winner, err := GetLnbitsUser(bounty.PendingAward.Claim, *bot)
+	if err != nil || winner.Wallet == nil {
+		// the award is dropped, so the bounty can still be awarded by vote or expire
+		log.Errorf("[finishBountyAward] winner of bounty %s has no wallet", bounty.ID)
+		bounty.PendingAward = nil
+		runtime.IgnoreError(bounty.Set(bounty, bot.Bunt))
+		if bot.resumeBountyExpiry(bounty) {
+			bot.tryEditStack(bounty.Message, bounty.ID, bot.bountyText(bounty), bot.bountyKeyboard(bounty))
+		}
+		return
+	}
+	bot.payBounty(bounty, winner)
+}
+
+// bountyAwardTickerID is the ticker of the objection window, the bounty id is used by the expiry timer
+func bountyAwardTickerID(id string) string {
+	return id + ":award"
+}
+
+// startBountyAwardTimer pays the pending award after the objection window
+func (bot *TipBot) startBountyAwardTimer(bounty *Bounty) {
+	t := runtime.NewResettableFunction(bountyAwardTickerID(bounty.ID),
+		runtime.WithTimer(time.NewTimer(time.Until(bounty.PendingAward.PayAt))))
+	t.Do(func() {
+		bot.finishBountyAward(bounty.ID)
+	})
+}
+
+// cancelBountyHandler is invoked when the poster cancels the bounty. Everyone is refunded.
+func (bot *TipBot) cancelBountyHandler(ctx intercept.Context) (intercept.Context, error) {
+	c := ctx.Callback()
+	mutex.LockWithContext(ctx, c.Data)
+	defer mutex.UnlockWithContext(ctx, c.Data)
+	bounty, err := bot.loadBounty(c.Data)
+	if err != nil {
+		return ctx, err
+	}
+	if bounty.Poster.Telegram.ID != c.Sender.ID {
+		ctx.Context = context.WithValue(ctx, "callback_response", Translate(ctx, "bountyNotYourBountyMessage"))
+		return ctx, errors.Create(errors.UnknownError)
+	}
+	if !bounty.Active {
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	runtime.RemoveTicker(bounty.ID)
+	runtime.RemoveTicker(bountyAwardTickerID(bounty.ID))
+	bounty.PendingAward = nil
+	bounty.Canceled = true
+	runtime.IgnoreError(bounty.Inactivate(bounty, bot.Bunt))
+	bot.refundBounty(bounty)
+	log.Infof("[🎯 bounty] %s canceled bounty %s", GetUserStr(bounty.Poster.Telegram), bounty.ID)
+	bot.tryEditStack(bounty.Message, bounty.ID, bot.bountyText(bounty)+"\n\n"+i18n.Translate(bounty.LanguageCode, "bountyCanceledMessage"), &tb.ReplyMarkup{})
+	return ctx, nil
+}
+
+// refundBounty pays back every contribution from the escrow. Failed refunds are retried.
+func (bot *TipBot) refundBounty(bounty *Bounty) {
+	for _, c := range bounty.Contributions {
+		bot.payFromEscrow(c.User.Telegram, c.Amount, "bounty", bounty.chat(), fmt.Sprintf("🎯 Bounty refund to %s.", GetUserStr(c.User.Telegram)))
+		bot.trySendMessage(c.User.Telegram, fmt.Sprintf(i18n.Translate(c.User.Telegram.LanguageCode, "bountyRefundedMessage"), c.Amount, str.MarkdownEscape(bounty.Description)))
+	}
+}
+
+// expireBounty refunds a bounty that was not awarded in time
+func (bot *TipBot) expireBounty(id string) {
+	mutex.Lock(id)
+	defer mutex.Unlock(id)
+	bounty, err := bot.loadBounty(id)
+	if err != nil || !bounty.Active || bounty.PendingAward != nil {
+		// a pending award is paid by its own timer. If it is dropped, resumeBountyExpiry expires the bounty.
+		return
+	}
+	bot.closeExpiredBounty(bounty)
+}
+
+// closeExpiredBounty refunds the bounty. The caller holds the lock of the bounty.
+func (bot *TipBot) closeExpiredBounty(bounty *Bounty) {
+	runtime.IgnoreError(bounty.Inactivate(bounty, bot.Bunt))
+	bot.refundBounty(bounty)
+	log.Infof("[🎯 bounty] Bounty %s expired", bounty.ID)
+	if bounty.Message == nil {
+		return
+	}
+	bot.tryEditStack(bounty.Message, bounty.ID, bot.bountyText(bounty)+"\n\n"+i18n.Translate(bounty.LanguageCode, "bountyExpiredMessage"), &tb.ReplyMarkup{})
+}
+
+// resumeBountyExpiry is called when a pending award is dropped. The expiry timer skipped the
+// bounty while the award was pending, so the bounty expires now if its time is up, otherwise
+// the timer is started again. It returns false if the bounty expired. The caller holds the lock of the bounty.
+func (bot *TipBot) resumeBountyExpiry(bounty *Bounty) bool {
+	if time.Now().Before(bounty.ExpiresAt) {
+		bot.startBountyTimer(bounty)
+		return true
+	}
+	bot.closeExpiredBounty(bounty)
+	return false
+}
+
+// startBountyTimer expires the bounty after bountyExpiry
+func (bot *TipBot) startBountyTimer(bounty *Bounty) {
+	t := runtime.NewResettableFunction(bounty.ID,
+		runtime.WithTimer(time.NewTimer(time.Until(bounty.ExpiresAt))))
+	t.Do(func() {
+		bot.expireBounty(bounty.ID)
+	})
+}
+
+// restartBountyTimers restarts the expiry timers of all open bounties
+func (bot *TipBot) restartBountyTimers() {
+	bot.Bunt.View(func(tx *buntdb.Tx) error {
+		return tx.Ascend("bounty", func(key, value string) bool {
+			bounty := &Bounty{}
+			if err := json.Unmarshal([]byte(value), bounty); err != nil {
+				return true
+			}
+			if bounty.Active {
+				bot.startBountyTimer(bounty)
+				if bounty.PendingAward != nil {
+					bot.startBountyAwardTimer(bounty)
+				}
+			}
+			return true // continue iteration
+		})
+	})
+}
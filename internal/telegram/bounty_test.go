@@ -0,0 +1,174 @@
+package telegram
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	"github.com/massmux/SatsMobiBot/internal/runtime"
+	"github.com/tidwall/buntdb"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+// postTestBounty posts a bounty of the poster with a claim of the hunter and returns it
+func postTestBounty(t *testing.T, test *testBot, poster, hunter *lnbits.User, amount int64) *Bounty {
+	chat := &tb.Chat{ID: -500, Type: tb.ChatGroup, Title: "group"}
+	if _, err := test.bountyHandler(test.message(poster, chat, fmt.Sprintf("/bounty %d fix the docs", amount))); err != nil {
+		t.Fatalf("bountyHandler() error = %v", err)
+	}
+	var bounty *Bounty
+	test.Bunt.View(func(tx *buntdb.Tx) error {
+		return tx.AscendKeys(BountyIndex, func(key, value string) bool {
+			bounty, _ = test.loadBounty(key)
+			return false
+		})
+	})
+	if bounty == nil {
+		t.Fatalf("bounty was not posted")
+	}
+	bounty.Claims = append(bounty.Claims, BountyClaim{User: hunter.Telegram, Votes: make([]int64, 0)})
+	runtime.IgnoreError(bounty.Set(bounty, test.Bunt))
+	return bounty
+}
+
+func TestTipBot_awardBountyHandler(t *testing.T) {
+	test := newTestBot(t)
+	poster := test.addUser(t, 2, 1000)
+	hunter := test.addUser(t, 3, 0)
+	bounty := postTestBounty(t, test, poster, hunter, 500)
+
+	// nobody else contributed, the poster awards directly
+	if _, err := test.awardBountyHandler(test.callback(poster, bounty.Message.Chat, bounty.ID+",0")); err != nil {
+		t.Fatalf("awardBountyHandler() error = %v", err)
+	}
+	if got := test.balance(3); got != 500 {
+		t.Errorf("hunter balance = %d, want 500", got)
+	}
+	if _, err := test.awardBountyHandler(test.callback(poster, bounty.Message.Chat, bounty.ID+",0")); err == nil {
+		t.Errorf("awardBountyHandler() awarded a bounty twice")
+	}
+	if got := test.balance(3); got != 500 {
+		t.Errorf("hunter balance = %d after awarding twice, want 500", got)
+	}
+}
+
+func TestTipBot_awardBountyHandler_objection(t *testing.T) {
+	tests := []struct {
+		name       string
+		object     bool
+		wantHunter int64
+	}{
+		{name: "no objection", wantHunter: 800},
+		{name: "objection", object: true, wantHunter: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			test := newTestBot(t)
+			poster := test.addUser(t, 2, 1000)
+			hunter := test.addUser(t, 3, 0)
+			contributor := test.addUser(t, 4, 1000)
+			bounty := postTestBounty(t, test, poster, hunter, 300)
+			if _, err := test.contributeBounty(test.message(contributor, &tb.Chat{ID: 4}, ""), bounty.ID, 500); err != nil {
+				t.Fatalf("contributeBounty() error = %v", err)
+			}
+
+			// the award of the poster waits for objections once others contributed
+			if _, err := test.awardBountyHandler(test.callback(poster, bounty.Message.Chat, bounty.ID+",0")); err != nil {
+				t.Fatalf("awardBountyHandler() error = %v", err)
+			}
+			bounty, _ = test.loadBounty(bounty.ID)
+			if bounty.PendingAward == nil || !bounty.Active || test.balance(3) != 0 {
+				t.Fatalf("award of the poster was paid without objection window")
+			}
+			if _, err := test.objectBountyHandler(test.callback(poster, bounty.Message.Chat, bounty.ID)); err == nil {
+				t.Fatalf("objectBountyHandler() let the poster object")
+			}
+			if tt.object {
+				if _, err := test.objectBountyHandler(test.callback(contributor, bounty.Message.Chat, bounty.ID)); err != nil {
+					t.Fatalf("objectBountyHandler() error = %v", err)
+				}
+			}
+			bounty, _ = test.loadBounty(bounty.ID)
+			if bounty.PendingAward != nil {
+				bounty.PendingAward.PayAt = time.Now().Add(-time.Second)
+				runtime.IgnoreError(bounty.Set(bounty, test.Bunt))
+			}
+			test.finishBountyAward(bounty.ID)
+			if got := test.balance(3); got != tt.wantHunter {
+				t.Errorf("hunter balance = %d, want %d", got, tt.wantHunter)
+			}
+			if !tt.object {
+				return
+			}
+			// after an objection, the poster only has a vote weighted by the contribution
+			if _, err := test.awardBountyHandler(test.callback(poster, bounty.Message.Chat, bounty.ID+",0")); err != nil {
+				t.Fatalf("awardBountyHandler() error = %v", err)
+			}
+			if got := test.balance(3); got != 0 {
+				t.Errorf("hunter balance = %d after the vote of the poster, want 0 without majority", got)
+			}
+			if _, err := test.awardBountyHandler(test.callback(contributor, bounty.Message.Chat, bounty.ID+",0")); err != nil {
+				t.Fatalf("awardBountyHandler() error = %v", err)
+			}
+			if got := test.balance(3); got != 800 {
+				t.Errorf("hunter balance = %d after the vote of the majority, want 800", got)
+			}
+		})
+	}
+}
+
+func TestTipBot_objectBountyHandler_expired(t *testing.T) {
+	test := newTestBot(t)
+	poster := test.addUser(t, 2, 1000)
+	hunter := test.addUser(t, 3, 0)
+	contributor := test.addUser(t, 4, 1000)
+	bounty := postTestBounty(t, test, poster, hunter, 300)
+	if _, err := test.contributeBounty(test.message(contributor, &tb.Chat{ID: 4}, ""), bounty.ID, 500); err != nil {
+		t.Fatalf("contributeBounty() error = %v", err)
+	}
+	if _, err := test.awardBountyHandler(test.callback(poster, bounty.Message.Chat, bounty.ID+",0")); err != nil {
+		t.Fatalf("awardBountyHandler() error = %v", err)
+	}
+
+	// the bounty expires while the award waits for objections
+	bounty, _ = test.loadBounty(bounty.ID)
+	bounty.ExpiresAt = time.Now().Add(-time.Second)
+	runtime.IgnoreError(bounty.Set(bounty, test.Bunt))
+	test.expireBounty(bounty.ID)
+	if bounty, _ = test.loadBounty(bounty.ID); !bounty.Active {
+		t.Fatalf("bounty expired with a pending award")
+	}
+
+	// the objection drops the award, so the bounty is refunded at once
+	if _, err := test.objectBountyHandler(test.callback(contributor, bounty.Message.Chat, bounty.ID)); err != nil {
+		t.Fatalf("objectBountyHandler() error = %v", err)
+	}
+	if bounty, _ = test.loadBounty(bounty.ID); bounty.Active {
+		t.Errorf("bounty is still active after the objection")
+	}
+	if test.balance(2) != 1000 || test.balance(3) != 0 || test.balance(4) != 1000 {
+		t.Errorf("balances = %d, %d, %d, want 1000, 0, 1000", test.balance(2), test.balance(3), test.balance(4))
+	}
+}
+
+func TestTipBot_awardBountyHandler_vote(t *testing.T) {
+	test := newTestBot(t)
+	poster := test.addUser(t, 2, 1000)
+	hunter := test.addUser(t, 3, 0)
+	contributor := test.addUser(t, 4, 1000)
+	bounty := postTestBounty(t, test, poster, hunter, 100)
+	if _, err := test.contributeBounty(test.message(contributor, &tb.Chat{ID: 4}, ""), bounty.ID, 300); err != nil {
+		t.Fatalf("contributeBounty() error = %v", err)
+	}
+	// contributors holding the majority of the reward award it directly
+	if _, err := test.awardBountyHandler(test.callback(contributor, bounty.Message.Chat, bounty.ID+",0")); err != nil {
+		t.Fatalf("awardBountyHandler() error = %v", err)
+	}
+	if got := test.balance(3); got != 400 {
+		t.Errorf("hunter balance = %d, want 400", got)
+	}
+	if got := test.balance(testBotID); got != 0 {
+		t.Errorf("bot balance = %d, want 0", got)
+	}
+}
@@ -0,0 +1,559 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	decodepay "github.com/fiatjaf/ln-decodepay"
+	"github.com/massmux/SatsMobiBot/internal/errors"
+	"github.com/massmux/SatsMobiBot/internal/i18n"
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	"github.com/massmux/SatsMobiBot/internal/runtime"
+	"github.com/massmux/SatsMobiBot/internal/runtime/mutex"
+	"github.com/massmux/SatsMobiBot/internal/storage"
+	"github.com/massmux/SatsMobiBot/internal/str"
+	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
+	"github.com/massmux/SatsMobiBot/pkg/lightning"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/buntdb"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const (
+	bulkPayoutMaxFileSize    = 64 * 1024
+	bulkPayoutMaxRows        = 200
+	bulkPayoutMaxMemoLength  = 200
+	bulkPayoutConcurrency    = 4
+	bulkPayoutTypeUser       = "user"
+	bulkPayoutTypeAddress    = "address"
+	bulkPayoutTypeInvoice    = "invoice"
+	bulkPayoutStatusPaid     = "paid"
+	bulkPayoutStatusFailed   = "failed"
+	bulkPayoutStatusPending  = "pending"
+	bulkPayoutStatusSending  = "sending"
+	bulkPayoutStatusUnknown  = "unknown"
+	BulkPayoutIndex          = "bulk-payout:*"
+	bulkPayoutSummaryMaxRows = 10
+)
+
+var (
+	bulkPayoutMenu          = &tb.ReplyMarkup{ResizeKeyboard: true}
+	btnConfirmBulkPayout    = bulkPayoutMenu.Data("✅ Pay", "bulkpayout_confirm")
+	btnCancelBulkPayout     = bulkPayoutMenu.Data("🚫 Cancel", "bulkpayout_cancel")
+	bulkPayoutResultHeaders = []string{"line", "recipient", "amount", "memo", "status", "payment_hash", "error"}
+	// errBulkPayoutUnknown is returned when a payment failed with an error, but LNbits could not tell whether it went through
+	errBulkPayoutUnknown = fmt.Errorf("payment status unknown")
+)
+
+// BulkPayoutRow is one validated line of an uploaded payout CSV
+type BulkPayoutRow struct {
+	Line        int    `json:"line"`
+	Recipient   string `json:"recipient"`
+	Type        string `json:"type"`
+	Amount      int64  `json:"amount"`
+	Memo        string `json:"memo"`
+	Status      string `json:"status"`
+	PaymentHash string `json:"payment_hash"`
+	Error       string `json:"error"`
+}
+
+// BulkPayout is a batch of payouts uploaded as a CSV document. It waits for the
+// confirmation of the owner before any payment is made.
+type BulkPayout struct {
+	*storage.Base
+	Owner        *lnbits.User     `json:"owner"`
+	FileName     string           `json:"file_name"`
+	Rows         []*BulkPayoutRow `json:"rows"`
+	Total        int64            `json:"total"`
+	Message      *tb.Message      `json:"message"`
+	LanguageCode string           `json:"languagecode"`
+	Done         bool             `json:"done"`
+}
+
+// isBulkPayoutDocument returns true if the document looks like a CSV file
+func isBulkPayoutDocument(document *tb.Document) bool {
+	if document == nil {
+		return false
+	}
+	return document.MIME == "text/csv" || strings.ToLower(filepath.Ext(document.FileName)) == ".csv"
+}
+
+// parseBulkPayoutRow validates one CSV record of the form recipient,amount,memo
+func (bot *TipBot) parseBulkPayoutRow(record []string, line int) (*BulkPayoutRow, error) {
+	row := &BulkPayoutRow{Line: line, Status: bulkPayoutStatusPending}
+	if len(record) < 1 || len(strings.TrimSpace(record[0])) == 0 {
+		return row, fmt.Errorf("missing recipient")
+	}
+	row.Recipient = strings.TrimSpace(record[0])
+	if len(record) > 2 {
+		row.Memo = strings.TrimSpace(strings.Join(record[2:], ","))
+		if len(row.Memo) > bulkPayoutMaxMemoLength {
+			return row, fmt.Errorf("memo too long")
+		}
+	}
+	var err error
+	if len(record) > 1 && len(strings.TrimSpace(record[1])) > 0 {
+		row.Amount, err = GetAmount(strings.TrimSpace(record[1]))
+		if err != nil || row.Amount < 1 {
+			return row, fmt.Errorf("invalid amount %s", record[1])
+		}
+	}
+	switch {
+	case strings.HasPrefix(row.Recipient, "@"):
+		row.Type = bulkPayoutTypeUser
+		row.Recipient = strings.TrimPrefix(row.Recipient, "@")
+		if row.Amount < 1 {
+			return row, fmt.Errorf("missing amount")
+		}
+		if _, err := GetUserByTelegramUsername(row.Recipient, *bot); err != nil {
+			return row, fmt.Errorf("@%s has no wallet", row.Recipient)
+		}
+	case lightning.IsLightningAddress(row.Recipient):
+		row.Type = bulkPayoutTypeAddress
+		if row.Amount < 1 {
+			return row, fmt.Errorf("missing amount")
+		}
+	default:
+		row.Type = bulkPayoutTypeInvoice
+		row.Recipient = strings.TrimPrefix(strings.ToLower(row.Recipient), "lightning:")
+		bolt11, err := decodepay.Decodepay(row.Recipient)
+		if err != nil {
+			return row, fmt.Errorf("unknown recipient")
+		}
+		invoiceAmount := int64(bolt11.MSatoshi / 1000)
+		if invoiceAmount <= 0 {
+			return row, fmt.Errorf("invoice without amount")
+		}
+		if row.Amount > 0 && row.Amount != invoiceAmount {
+			return row, fmt.Errorf("amount does not match invoice")
+		}
+		if time.Unix(int64(bolt11.CreatedAt), 0).Add(time.Duration(bolt11.Expiry) * time.Second).Before(time.Now()) {
+			return row, fmt.Errorf("invoice expired")
+		}
+		row.Amount = invoiceAmount
+	}
+	return row, nil
+}
+
+// readBulkPayoutRows parses and validates the uploaded CSV. All errors are returned with their line numbers.
+func (bot *TipBot) readBulkPayoutRows(r io.Reader) ([]*BulkPayoutRow, []string, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	rows := make([]*BulkPayoutRow, 0)
+	rowErrors := make([]string, 0)
+	for i := 0; ; i++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if i == 0 && len(record) > 0 && strings.EqualFold(strings.TrimSpace(record[0]), "recipient") {
+			// skip the header
+			continue
+		}
+		if len(record) == 0 || (len(record) == 1 && len(strings.TrimSpace(record[0])) == 0) {
+			continue
+		}
+		// the reader skips empty lines, report the line of the file
+		line, _ := reader.FieldPos(0)
+		row, err := bot.parseBulkPayoutRow(record, line)
+		if err != nil {
+			rowErrors = append(rowErrors, fmt.Sprintf("%d: %s", line, err.Error()))
+			continue
+		}
+		rows = append(rows, row)
+	}
+	return rows, rowErrors, nil
+}
+
+// bulkPayoutFileHandler is invoked when a user sends a CSV document in the private chat
+func (bot *TipBot) bulkPayoutFileHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	user := LoadUser(ctx)
+	if user.Wallet == nil {
+		return ctx, errors.Create(errors.UserNoWalletError)
+	}
+	if m.Document.FileSize > bulkPayoutMaxFileSize {
+		bot.trySendMessage(m.Sender, Translate(ctx, "bulkPayoutFileTooLargeMessage"))
+		return ctx, errors.Create(errors.MaxReachedError)
+	}
+	reader, err := bot.Telegram.File(&m.Document.File)
+	if err != nil {
+		log.Errorf("[bulkPayoutFileHandler] %s", err.Error())
+		return ctx, err
+	}
+	defer reader.Close()
+	rows, rowErrors, err := bot.readBulkPayoutRows(io.LimitReader(reader, bulkPayoutMaxFileSize))
+	if err != nil {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "bulkPayoutInvalidFileMessage"), str.MarkdownEscape(err.Error())))
+		return ctx, errors.New(errors.InvalidSyntaxError, err)
+	}
+	if len(rowErrors) > 0 {
+		if len(rowErrors) > bulkPayoutSummaryMaxRows {
+			rowErrors = append(rowErrors[:bulkPayoutSummaryMaxRows], "...")
+		}
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "bulkPayoutInvalidRowsMessage"), str.MarkdownEscape(strings.Join(rowErrors, "\n"))))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	if len(rows) == 0 {
+		bot.trySendMessage(m.Sender, Translate(ctx, "bulkPayoutEmptyMessage"))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	if len(rows) > bulkPayoutMaxRows {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "bulkPayoutTooManyRowsMessage"), bulkPayoutMaxRows))
+		return ctx, errors.Create(errors.MaxReachedError)
+	}
+
+	id := fmt.Sprintf("bulk-payout:%d:%s", user.Telegram.ID, RandStringRunes(8))
+	payout := &BulkPayout{
+		Base:         storage.New(storage.ID(id)),
+		Owner:        user,
+		FileName:     m.Document.FileName,
+		Rows:         rows,
+		LanguageCode: ctx.Value("publicLanguageCode").(string),
+	}
+	for _, row := range rows {
+		payout.Total += row.Amount
+	}
+	balance, err := bot.GetUserBalance(user)
+	if err != nil {
+		return ctx, err
+	}
+
+	confirmMenu := &tb.ReplyMarkup{ResizeKeyboard: true}
+	confirmButton := confirmMenu.Data(Translate(ctx, "bulkPayoutConfirmButtonMessage"), btnConfirmBulkPayout.Unique, id)
+	cancelButton := confirmMenu.Data(Translate(ctx, "cancelButtonMessage"), btnCancelBulkPayout.Unique, id)
+	confirmMenu.Inline(confirmMenu.Row(confirmButton, cancelButton))
+
+	summary := bot.bulkPayoutSummary(payout)
+	if balance < payout.Total {
+		summary += "\n\n" + fmt.Sprintf(Translate(ctx, "bulkPayoutBalanceTooLowMessage"), balance)
+		confirmMenu = &tb.ReplyMarkup{}
+		confirmMenu.Inline(confirmMenu.Row(cancelButton))
+	}
+	payout.Message = bot.trySendMessageEditable(m.Sender, summary, confirmMenu)
+	runtime.IgnoreError(payout.Set(payout, bot.Bunt))
+	log.Infof("[bulkpayout] %s uploaded %d payouts of %d sat", GetUserStr(user.Telegram), len(rows), payout.Total)
+	return ctx, nil
+}
+
+// bulkPayoutSummary lists the first rows and the total of a bulk payout
+func (bot *TipBot) bulkPayoutSummary(payout *BulkPayout) string {
+	lines := make([]string, 0)
+	for i, row := range payout.Rows {
+		if i == bulkPayoutSummaryMaxRows {
+			lines = append(lines, fmt.Sprintf(i18n.Translate(payout.LanguageCode, "bulkPayoutMoreRowsMessage"), len(payout.Rows)-i))
+			break
+		}
+		recipient := row.Recipient
+		switch row.Type {
+		case bulkPayoutTypeUser:
+			recipient = "@" + recipient
+		case bulkPayoutTypeInvoice:
+			recipient = recipient[:16] + "..."
+		}
+		lines = append(lines, fmt.Sprintf("`%s` %d sat", recipient, row.Amount))
+	}
+	return fmt.Sprintf(i18n.Translate(payout.LanguageCode, "bulkPayoutSummaryMessage"), len(payout.Rows), payout.Total, strings.Join(lines, "\n"))
+}
+
+func (bot *TipBot) loadBulkPayout(id string) (*BulkPayout, error) {
+	payout := &BulkPayout{Base: storage.New(storage.ID(id))}
+	if err := bot.Bunt.Get(payout); err != nil {
+		return nil, err
+	}
+	return payout, nil
+}
+
+// confirmBulkPayoutHandler executes all payouts of a confirmed upload
+func (bot *TipBot) confirmBulkPayoutHandler(ctx intercept.Context) (intercept.Context, error) {
+	c := ctx.Callback()
+	mutex.LockWithContext(ctx, c.Data)
+	defer mutex.UnlockWithContext(ctx, c.Data)
+	payout, err := bot.loadBulkPayout(c.Data)
+	if err != nil {
+		return ctx, err
+	}
+	if payout.Owner.Telegram.ID != c.Sender.ID {
+		return ctx, errors.Create(errors.UnknownError)
+	}
+	if !payout.Active {
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	user := LoadUser(ctx)
+	balance, err := bot.GetUserBalance(user)
+	if err != nil {
+		return ctx, err
+	}
+	if balance < payout.Total {
+		ctx.Context = context.WithValue(ctx, "callback_response", Translate(ctx, "bulkPayoutBalanceLowCallbackMessage"))
+		return ctx, errors.Create(errors.BalanceToLowError)
+	}
+	// inactivate before paying so that the payouts can never run twice
+	runtime.IgnoreError(payout.Inactivate(payout, bot.Bunt))
+	payout.Owner = user
+	bot.tryEditStack(payout.Message, payout.ID, bot.bulkPayoutSummary(payout)+"\n\n"+i18n.Translate(payout.LanguageCode, "bulkPayoutProcessingMessage"), &tb.ReplyMarkup{})
+	log.Infof("[bulkpayout] %s confirmed %d payouts of %d sat", GetUserStr(user.Telegram), len(payout.Rows), payout.Total)
+	go bot.executeBulkPayout(payout)
+	return ctx, nil
+}
+
+// cancelBulkPayoutHandler discards an uploaded bulk payout
+func (bot *TipBot) cancelBulkPayoutHandler(ctx intercept.Context) (intercept.Context, error) {
+	c := ctx.Callback()
+	mutex.LockWithContext(ctx, c.Data)
+	defer mutex.UnlockWithContext(ctx, c.Data)
+	payout, err := bot.loadBulkPayout(c.Data)
+	if err != nil {
+		return ctx, err
+	}
+	if payout.Owner.Telegram.ID != c.Sender.ID {
+		return ctx, errors.Create(errors.UnknownError)
+	}
+	if !payout.Active {
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	payout.Canceled = true
+	runtime.IgnoreError(payout.Inactivate(payout, bot.Bunt))
+	bot.tryEditStack(payout.Message, payout.ID, i18n.Translate(payout.LanguageCode, "bulkPayoutCanceledMessage"), &tb.ReplyMarkup{})
+	return ctx, nil
+}
+
+// executeBulkPayout pays all pending rows with a limited number of concurrent payments
+// and sends the results as a CSV document to the owner. The rows are stored before and
+// after their payment, so restartBulkPayouts can finish the payout after a restart.
+func (bot *TipBot) executeBulkPayout(payout *BulkPayout) {
+	semaphore := make(chan struct{}, bulkPayoutConcurrency)
+	wg := sync.WaitGroup{}
+	for _, row := range payout.Rows {
+		if row.Status != bulkPayoutStatusPending {
+			continue
+		}
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(row *BulkPayoutRow) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			bot.updateBulkPayout(payout, func() {
+				row.Status = bulkPayoutStatusSending
+			})
+			paymentHash, err := bot.executeBulkPayoutRow(payout, row)
+			bot.updateBulkPayout(payout, func() {
+				row.PaymentHash = paymentHash
+				row.Status = bulkPayoutRowStatus(err)
+				if err != nil {
+					row.Error = err.Error()
+				}
+			})
+			if err != nil {
+				log.Warnf("[bulkpayout] %s line %d %s: %s", payout.ID, row.Line, row.Status, err.Error())
+			}
+		}(row)
+	}
+	wg.Wait()
+
+	paid, failed, unknown, amount := 0, 0, 0, int64(0)
+	for _, row := range payout.Rows {
+		switch row.Status {
+		case bulkPayoutStatusPaid:
+			paid++
+			amount += row.Amount
+		case bulkPayoutStatusUnknown:
+			unknown++
+		default:
+			failed++
+		}
+	}
+	log.Infof("[bulkpayout] %s finished %s: %d paid, %d failed, %d unknown", GetUserStr(payout.Owner.Telegram), payout.ID, paid, failed, unknown)
+	result := fmt.Sprintf(i18n.Translate(payout.LanguageCode, "bulkPayoutDoneMessage"), paid, amount, failed)
+	if unknown > 0 {
+		result += "\n" + fmt.Sprintf(i18n.Translate(payout.LanguageCode, "bulkPayoutUnknownMessage"), unknown)
+	}
+	bot.tryEditStack(payout.Message, payout.ID, bot.bulkPayoutSummary(payout)+"\n\n"+result, &tb.ReplyMarkup{})
+
+	b, err := bulkPayoutResultCSV(payout)
+	if err != nil {
+		log.Errorf("[bulkpayout] could not write result of %s: %s", payout.ID, err.Error())
+	} else {
+		fileName := strings.TrimSuffix(payout.FileName, filepath.Ext(payout.FileName)) + "-result.csv"
+		bot.trySendMessage(payout.Owner.Telegram, &tb.Document{
+			File:     tb.File{FileReader: bytes.NewReader(b)},
+			FileName: fileName,
+			MIME:     "text/csv",
+			Caption:  result,
+		})
+	}
+	bot.updateBulkPayout(payout, func() {
+		payout.Done = true
+	})
+}
+
+// bulkPayoutRowStatus returns the status of a row whose payment returned err
+func bulkPayoutRowStatus(err error) string {
+	switch {
+	case err == nil:
+		return bulkPayoutStatusPaid
+	case stderrors.Is(err, errBulkPayoutUnknown):
+		return bulkPayoutStatusUnknown
+	default:
+		return bulkPayoutStatusFailed
+	}
+}
+
+// updateBulkPayout changes the payout and stores it. The rows are paid concurrently.
+func (bot *TipBot) updateBulkPayout(payout *BulkPayout, update func()) {
+	mutex.Lock(payout.ID)
+	defer mutex.Unlock(payout.ID)
+	update()
+	runtime.IgnoreError(payout.Set(payout, bot.Bunt))
+}
+
+// executeBulkPayoutRow pays a single row and returns the payment hash
+func (bot *TipBot) executeBulkPayoutRow(payout *BulkPayout, row *BulkPayoutRow) (string, error) {
+	owner := payout.Owner
+	switch row.Type {
+	case bulkPayoutTypeUser:
+		to, err := GetUserByTelegramUsername(row.Recipient, *bot)
+		if err != nil {
+			return "", fmt.Errorf("recipient @%s has no wallet", row.Recipient)
+		}
+		t := NewTransaction(bot, owner, to, row.Amount, TransactionType("bulkpayout"))
+		t.Memo = fmt.Sprintf("📑 Bulk payout from %s to %s.", GetUserStr(owner.Telegram), GetUserStr(to.Telegram))
+		if len(row.Memo) > 0 {
+			t.Memo = fmt.Sprintf("%s Memo: %s", t.Memo, row.Memo)
+		}
+		success, err := t.Send()
+		if !success || err != nil {
+			if err == nil {
+				err = fmt.Errorf("transaction failed")
+			}
+			if len(t.Invoice.PaymentHash) == 0 {
+				// the invoice was not created, nothing was paid
+				return "", err
+			}
+			// the invoice of the recipient is only paid if the sats arrived
+			if err = bot.bulkPayoutPaymentStatus(to, t.Invoice.PaymentHash, err); err != nil {
+				return t.Invoice.PaymentHash, err
+			}
+		}
+		bot.trySendMessage(to.Telegram, fmt.Sprintf(i18n.Translate(to.Telegram.LanguageCode, "sendReceivedMessage"), GetUserStrMd(owner.Telegram), row.Amount))
+		if len(row.Memo) > 0 {
+			bot.trySendMessage(to.Telegram, fmt.Sprintf("✉️ %s", str.MarkdownEscape(row.Memo)))
+		}
+		return t.Invoice.PaymentHash, nil
+	case bulkPayoutTypeAddress:
+		pr, err := bot.fetchLnurlPayInvoice(row.Recipient, row.Amount, row.Memo)
+		if err != nil {
+			return "", err
+		}
+		return bot.payBulkPayoutInvoice(payout, row, pr)
+	default:
+		return bot.payBulkPayoutInvoice(payout, row, row.Recipient)
+	}
+}
+
+// payBulkPayoutInvoice pays an invoice from the wallet of the owner. The payment hash is stored
+// before paying, so that the payment can be checked after a restart.
+func (bot *TipBot) payBulkPayoutInvoice(payout *BulkPayout, row *BulkPayoutRow, pr string) (string, error) {
+	bolt11, err := decodepay.Decodepay(pr)
+	if err != nil {
+		return "", err
+	}
+	bot.updateBulkPayout(payout, func() {
+		row.PaymentHash = bolt11.PaymentHash
+	})
+	if _, err = payout.Owner.Wallet.Pay(lnbits.PaymentParams{Out: true, Bolt11: pr}, bot.Client); err != nil {
+		return bolt11.PaymentHash, bot.bulkPayoutPaymentStatus(payout.Owner, bolt11.PaymentHash, err)
+	}
+	return bolt11.PaymentHash, nil
+}
+
+// bulkPayoutPaymentStatus checks a payment of the wallet that returned err, which doesn't tell if
+// it went through. It returns nil if it was paid and errBulkPayoutUnknown if LNbits can't tell.
+func (bot *TipBot) bulkPayoutPaymentStatus(user *lnbits.User, paymentHash string, err error) error {
+	payment, statusErr := bot.Client.Payment(*user.Wallet, paymentHash)
+	if statusErr != nil {
+		log.Warnf("[bulkpayout] could not check payment %s: %s", paymentHash, statusErr.Error())
+		return fmt.Errorf("%w: %s", errBulkPayoutUnknown, err.Error())
+	}
+	if payment.Paid {
+		return nil
+	}
+	if payment.Details.Pending {
+		return fmt.Errorf("%w: payment is pending", errBulkPayoutUnknown)
+	}
+	return err
+}
+
+// restartBulkPayouts finishes the confirmed payouts that were interrupted by a restart. Rows
+// that were being paid are checked by their payment hash, the pending rows are paid.
+func (bot *TipBot) restartBulkPayouts() {
+	ids := make([]string, 0)
+	bot.Bunt.View(func(tx *buntdb.Tx) error {
+		return tx.Ascend("bulk-payout", func(key, value string) bool {
+			payout := &BulkPayout{}
+			if err := json.Unmarshal([]byte(value), payout); err == nil && !payout.Active && !payout.Canceled && !payout.Done {
+				ids = append(ids, key)
+			}
+			return true // continue iteration
+		})
+	})
+	for _, id := range ids {
+		payout, err := bot.loadBulkPayout(id)
+		if err != nil {
+			continue
+		}
+		for _, row := range payout.Rows {
+			if row.Status != bulkPayoutStatusSending {
+				continue
+			}
+			err = fmt.Errorf("%w: interrupted by a restart", errBulkPayoutUnknown)
+			if len(row.PaymentHash) > 0 && row.Type != bulkPayoutTypeUser {
+				err = bot.bulkPayoutPaymentStatus(payout.Owner, row.PaymentHash, fmt.Errorf("interrupted by a restart"))
+			}
+			row.Status = bulkPayoutRowStatus(err)
+			if err != nil {
+				row.Error = err.Error()
+			}
+		}
+		runtime.IgnoreError(payout.Set(payout, bot.Bunt))
+		log.Infof("[bulkpayout] resuming %s after a restart", payout.ID)
+		go bot.executeBulkPayout(payout)
+	}
+}
+
+// bulkPayoutResultCSV writes every row with its status and payment hash
+func bulkPayoutResultCSV(payout *BulkPayout) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	w := csv.NewWriter(buf)
+	if err := w.Write(bulkPayoutResultHeaders); err != nil {
+		return nil, err
+	}
+	for _, row := range payout.Rows {
+		recipient := row.Recipient
+		if row.Type == bulkPayoutTypeUser {
+			recipient = "@" + recipient
+		}
+		record := []string{strconv.Itoa(row.Line), recipient, strconv.FormatInt(row.Amount, 10), row.Memo, row.Status, row.PaymentHash, row.Error}
+		if err := w.Write(record); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
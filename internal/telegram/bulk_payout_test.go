@@ -0,0 +1,254 @@
+package telegram
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strings"
+	"testing"
+	"time"
+
+	decodepay "github.com/fiatjaf/ln-decodepay"
+	"github.com/massmux/SatsMobiBot/internal/runtime"
+	"github.com/massmux/SatsMobiBot/internal/storage"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func TestTipBot_readBulkPayoutRows(t *testing.T) {
+	test := newTestBot(t)
+	test.addUser(t, 2, 0)
+	rows, rowErrors, err := test.readBulkPayoutRows(strings.NewReader(
+		"recipient,amount,memo\n@user2,100,thanks, for all\n\n@nobody,100\n@user2\n@user2,-5\nnot a recipient,10\nalice@example.com\n"))
+	if err != nil {
+		t.Fatalf("readBulkPayoutRows() error = %v", err)
+	}
+	if len(rows) != 1 || rows[0].Recipient != "user2" || rows[0].Amount != 100 || rows[0].Memo != "thanks,for all" || rows[0].Line != 2 {
+		t.Errorf("readBulkPayoutRows() rows = %+v", rows)
+	}
+	want := []string{"4: @nobody has no wallet", "5: missing amount", "6: invalid amount -5", "7: unknown recipient", "8: missing amount"}
+	if strings.Join(rowErrors, "|") != strings.Join(want, "|") {
+		t.Errorf("readBulkPayoutRows() errors = %q, want %q", rowErrors, want)
+	}
+	if _, _, err := test.readBulkPayoutRows(strings.NewReader("\"unterminated,100\n")); err == nil {
+		t.Errorf("readBulkPayoutRows() accepted an invalid CSV")
+	}
+}
+
+// newTestBulkPayout stores a bulk payout of the owner to the users
+func newTestBulkPayout(t *testing.T, test *testBot, owner int64, rows ...*BulkPayoutRow) *BulkPayout {
+	user, err := GetLnbitsUser(&tb.User{ID: owner}, *test.TipBot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payout := &BulkPayout{
+		Base:         storage.New(storage.ID("bulk-payout:test")),
+		Owner:        user,
+		FileName:     "payouts.csv",
+		Rows:         rows,
+		Message:      &tb.Message{ID: 1, Chat: &tb.Chat{ID: owner}},
+		LanguageCode: "en",
+	}
+	for _, row := range rows {
+		payout.Total += row.Amount
+	}
+	if err := payout.Set(payout, test.Bunt); err != nil {
+		t.Fatal(err)
+	}
+	return payout
+}
+
+func TestTipBot_executeBulkPayout(t *testing.T) {
+	test := newTestBot(t)
+	test.addUser(t, 2, 1000)
+	test.addUser(t, 3, 0)
+	test.addUser(t, 4, 0)
+	payout := newTestBulkPayout(t, test, 2,
+		&BulkPayoutRow{Line: 1, Recipient: "user3", Type: bulkPayoutTypeUser, Amount: 100, Status: bulkPayoutStatusPending},
+		&BulkPayoutRow{Line: 2, Recipient: "user4", Type: bulkPayoutTypeUser, Amount: 200, Memo: "bonus", Status: bulkPayoutStatusPending},
+		&BulkPayoutRow{Line: 3, Recipient: "gone", Type: bulkPayoutTypeUser, Amount: 300, Status: bulkPayoutStatusPending},
+	)
+	test.executeBulkPayout(payout)
+
+	if got := test.balance(2); got != 700 {
+		t.Errorf("owner balance = %d, want 700", got)
+	}
+	if got := test.balance(3); got != 100 {
+		t.Errorf("balance of user3 = %d, want 100", got)
+	}
+	if got := test.balance(4); got != 200 {
+		t.Errorf("balance of user4 = %d, want 200", got)
+	}
+	b, err := bulkPayoutResultCSV(payout)
+	if err != nil {
+		t.Fatalf("bulkPayoutResultCSV() error = %v", err)
+	}
+	records, err := csv.NewReader(bytes.NewReader(b)).ReadAll()
+	if err != nil || len(records) != 4 {
+		t.Fatalf("bulkPayoutResultCSV() = %q", b)
+	}
+	for i, want := range []string{bulkPayoutStatusPaid, bulkPayoutStatusPaid, bulkPayoutStatusFailed} {
+		if records[i+1][4] != want {
+			t.Errorf("line %d status = %s, want %s", i+1, records[i+1][4], want)
+		}
+		if want == bulkPayoutStatusPaid && len(records[i+1][5]) == 0 {
+			t.Errorf("line %d has no payment hash", i+1)
+		}
+	}
+	if records[3][6] != "recipient @gone has no wallet" {
+		t.Errorf("line 3 error = %q", records[3][6])
+	}
+}
+
+func TestTipBot_confirmBulkPayoutHandler(t *testing.T) {
+	test := newTestBot(t)
+	owner := test.addUser(t, 2, 250)
+	other := test.addUser(t, 3, 0)
+	payout := newTestBulkPayout(t, test, 2,
+		&BulkPayoutRow{Line: 1, Recipient: "user3", Type: bulkPayoutTypeUser, Amount: 300, Status: bulkPayoutStatusPending},
+	)
+	if _, err := test.confirmBulkPayoutHandler(test.callback(other, &tb.Chat{ID: 3}, payout.ID)); err == nil {
+		t.Fatalf("confirmBulkPayoutHandler() let another user confirm")
+	}
+	// the balance is checked again when the payout is confirmed
+	if _, err := test.confirmBulkPayoutHandler(test.callback(owner, &tb.Chat{ID: 2}, payout.ID)); err == nil {
+		t.Fatalf("confirmBulkPayoutHandler() confirmed above the balance")
+	}
+	test.lnbits.newWallet("wallet2", 1000)
+	if _, err := test.confirmBulkPayoutHandler(test.callback(owner, &tb.Chat{ID: 2}, payout.ID)); err != nil {
+		t.Fatalf("confirmBulkPayoutHandler() error = %v", err)
+	}
+	// a payout only runs once
+	if _, err := test.confirmBulkPayoutHandler(test.callback(owner, &tb.Chat{ID: 2}, payout.ID)); err == nil {
+		t.Fatalf("confirmBulkPayoutHandler() confirmed a payout twice")
+	}
+	for i := 0; i < 100 && test.balance(3) == 0; i++ {
+		time.Sleep(50 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if got := test.balance(3); got != 300 {
+		t.Errorf("recipient balance = %d, want 300", got)
+	}
+	if got := test.balance(2); got != 700 {
+		t.Errorf("owner balance = %d, want 700", got)
+	}
+}
+
+func TestTipBot_cancelBulkPayoutHandler(t *testing.T) {
+	test := newTestBot(t)
+	owner := test.addUser(t, 2, 1000)
+	test.addUser(t, 3, 0)
+	payout := newTestBulkPayout(t, test, 2,
+		&BulkPayoutRow{Line: 1, Recipient: "user3", Type: bulkPayoutTypeUser, Amount: 300, Status: bulkPayoutStatusPending},
+	)
+	if _, err := test.cancelBulkPayoutHandler(test.callback(owner, &tb.Chat{ID: 2}, payout.ID)); err != nil {
+		t.Fatalf("cancelBulkPayoutHandler() error = %v", err)
+	}
+	if _, err := test.confirmBulkPayoutHandler(test.callback(owner, &tb.Chat{ID: 2}, payout.ID)); err == nil {
+		t.Fatalf("confirmBulkPayoutHandler() confirmed a canceled payout")
+	}
+	if got := test.balance(3); got != 0 {
+		t.Errorf("recipient balance = %d, want 0", got)
+	}
+}
+
+// addTestBulkPayoutInvoice lets the fake LNbits pay nwcTestInvoice to a new wallet and returns its payment hash
+func addTestBulkPayoutInvoice(t *testing.T, test *testBot) string {
+	bolt11, err := decodepay.Decodepay(nwcTestInvoice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payee := test.lnbits.newWallet("payee", 0)
+	test.lnbits.mu.Lock()
+	defer test.lnbits.mu.Unlock()
+	test.lnbits.invoices[nwcTestInvoice] = &fakeInvoice{hash: bolt11.PaymentHash, wallet: payee.ID, amount: 250000}
+	return bolt11.PaymentHash
+}
+
+func TestTipBot_executeBulkPayout_invoice(t *testing.T) {
+	tests := []struct {
+		name          string
+		failPays      int
+		ambiguousPays int
+		failStatus    bool
+		wantStatus    string
+		wantPaid      int64
+	}{
+		{name: "paid", wantStatus: bulkPayoutStatusPaid, wantPaid: 250000},
+		{name: "failed", failPays: 1, wantStatus: bulkPayoutStatusFailed},
+		// the error doesn't tell if the payment was sent, the status does
+		{name: "paid with error", ambiguousPays: 1, wantStatus: bulkPayoutStatusPaid, wantPaid: 250000},
+		{name: "unknown status", failPays: 1, failStatus: true, wantStatus: bulkPayoutStatusUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			test := newTestBot(t)
+			test.addUser(t, 2, 300000)
+			hash := addTestBulkPayoutInvoice(t, test)
+			test.lnbits.mu.Lock()
+			test.lnbits.failPays, test.lnbits.ambiguousPays, test.lnbits.failStatus = tt.failPays, tt.ambiguousPays, tt.failStatus
+			test.lnbits.mu.Unlock()
+			payout := newTestBulkPayout(t, test, 2,
+				&BulkPayoutRow{Line: 1, Recipient: nwcTestInvoice, Type: bulkPayoutTypeInvoice, Amount: 250000, Status: bulkPayoutStatusPending},
+			)
+			test.executeBulkPayout(payout)
+
+			row := payout.Rows[0]
+			if row.Status != tt.wantStatus || row.PaymentHash != hash {
+				t.Errorf("row = %+v, want status %s", row, tt.wantStatus)
+			}
+			if got := test.lnbits.balance("payee"); got != tt.wantPaid {
+				t.Errorf("payee balance = %d, want %d", got, tt.wantPaid)
+			}
+			if stored, err := test.loadBulkPayout(payout.ID); err != nil || !stored.Done {
+				t.Errorf("stored payout = %+v, %v", stored, err)
+			}
+		})
+	}
+}
+
+func TestTipBot_restartBulkPayouts(t *testing.T) {
+	test := newTestBot(t)
+	test.addUser(t, 2, 300000)
+	test.addUser(t, 3, 0)
+	hash := addTestBulkPayoutInvoice(t, test)
+	// the restart interrupted the payout after it paid the invoice and before it stored the status
+	test.lnbits.mu.Lock()
+	test.lnbits.balances["wallet2"] -= 250000 * 1000
+	test.lnbits.balances["payee"] += 250000 * 1000
+	test.lnbits.invoices[nwcTestInvoice].paid = true
+	test.lnbits.mu.Unlock()
+	// payouts that were not confirmed are not paid
+	unconfirmed := newTestBulkPayout(t, test, 2,
+		&BulkPayoutRow{Line: 1, Recipient: "user3", Type: bulkPayoutTypeUser, Amount: 1000, Status: bulkPayoutStatusPending},
+	)
+	unconfirmed.ID = "bulk-payout:unconfirmed"
+	runtime.IgnoreError(unconfirmed.Set(unconfirmed, test.Bunt))
+	payout := newTestBulkPayout(t, test, 2,
+		&BulkPayoutRow{Line: 1, Recipient: nwcTestInvoice, Type: bulkPayoutTypeInvoice, Amount: 250000, Status: bulkPayoutStatusSending, PaymentHash: hash},
+		&BulkPayoutRow{Line: 2, Recipient: "user3", Type: bulkPayoutTypeUser, Amount: 100, Status: bulkPayoutStatusSending},
+		&BulkPayoutRow{Line: 3, Recipient: "user3", Type: bulkPayoutTypeUser, Amount: 200, Status: bulkPayoutStatusPending},
+	)
+	runtime.IgnoreError(payout.Inactivate(payout, test.Bunt))
+
+	test.restartBulkPayouts()
+	var stored *BulkPayout
+	for i := 0; i < 100; i++ {
+		if stored, _ = test.loadBulkPayout(payout.ID); stored != nil && stored.Done {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if stored == nil || !stored.Done {
+		t.Fatalf("payout was not finished after the restart")
+	}
+	for i, want := range []string{bulkPayoutStatusPaid, bulkPayoutStatusUnknown, bulkPayoutStatusPaid} {
+		if stored.Rows[i].Status != want {
+			t.Errorf("line %d status = %s, want %s", i+1, stored.Rows[i].Status, want)
+		}
+	}
+	if got := test.balance(3); got != 200 {
+		t.Errorf("balance of user3 = %d, want 200", got)
+	}
+	if got := test.lnbits.balance("payee"); got != 250000 {
+		t.Errorf("payee balance = %d, want 250000", got)
+	}
+}
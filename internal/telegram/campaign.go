@@ -0,0 +1,309 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/massmux/SatsMobiBot/internal/errors"
+	"github.com/massmux/SatsMobiBot/internal/i18n"
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	"github.com/massmux/SatsMobiBot/internal/runtime"
+	"github.com/massmux/SatsMobiBot/internal/runtime/mutex"
+	"github.com/massmux/SatsMobiBot/internal/storage"
+	"github.com/massmux/SatsMobiBot/internal/str"
+	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/buntdb"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const (
+	CampaignIndex          = "campaign:*"
+	campaignMaxDuration    = 90 * 24 * time.Hour
+	campaignMaxTitleLength = 100
+)
+
+var (
+	campaignMenu          = &tb.ReplyMarkup{ResizeKeyboard: true}
+	btnContributeCampaign = campaignMenu.Data("💰 Contribute", "campaign_contribute")
+	btnCancelCampaign     = campaignMenu.Data("🚫 Cancel", "campaign_cancel")
+)
+
+// CampaignContribution is the sum of all contributions of one user
+type CampaignContribution struct {
+	User   *lnbits.User `json:"user"`
+	Amount int64        `json:"amount"`
+}
+
+// Campaign is an all-or-nothing crowdfunding. Contributions are held in escrow by the bot wallet
+// and paid to the creator if the goal is reached by the deadline. Otherwise everyone is refunded.
+type Campaign struct {
+	*storage.Base
+	Creator       *lnbits.User           `json:"creator"`
+	Goal          int64                  `json:"goal"`
+	Title         string                 `json:"title"`
+	Deadline      time.Time              `json:"deadline"`
+	Contributions []CampaignContribution `json:"contributions"`
+	Message       *tb.Message            `json:"message"`
+	LanguageCode  string                 `json:"languagecode"`
+}
+
+// collected returns the amount held in escrow
+func (campaign *Campaign) collected() int64 {
+	var collected int64
+	for _, c := range campaign.Contributions {
+		collected += c.Amount
+	}
+	return collected
+}
+
+// chat returns the group of the campaign or nil if its message was never sent
+func (campaign *Campaign) chat() *tb.Chat {
+	if campaign.Message == nil {
+		return nil
+	}
+	return campaign.Message.Chat
+}
+
+// parseCampaignDeadline accepts a duration like 7d or a date like 2006-01-02
+func parseCampaignDeadline(input string) (time.Time, error) {
+	if d, err := ParseDuration(input); err == nil {
+		return time.Now().Add(d), nil
+	}
+	deadline, err := time.Parse("2006-01-02", input)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid deadline %s", input)
+	}
+	return deadline, nil
+}
+
+// campaignHandler is invoked on /campaign <goal> <deadline> <title> in a group chat
+func (bot *TipBot) campaignHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	creator := LoadUser(ctx)
+	if creator.Wallet == nil {
+		return ctx, errors.Create(errors.UserNoWalletError)
+	}
+	if m.Private() {
+		bot.trySendMessage(m.Sender, Translate(ctx, "campaignHelpMessage"))
+		return ctx, errors.Create(errors.NoPrivateChatError)
+	}
+	words := strings.Fields(m.Text)
+	if len(words) < 4 {
+		bot.trySendMessage(m.Chat, Translate(ctx, "campaignHelpMessage"))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	goal, err := GetAmount(words[1])
+	if err != nil || goal < 1 {
+		bot.trySendMessage(m.Chat, Translate(ctx, "campaignHelpMessage"))
+		return ctx, errors.Create(errors.InvalidAmountError)
+	}
+	deadline, err := parseCampaignDeadline(words[2])
+	if err != nil || time.Until(deadline) < time.Minute || time.Until(deadline) > campaignMaxDuration {
+		bot.trySendMessage(m.Chat, Translate(ctx, "campaignInvalidDeadlineMessage"))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	title := strings.Join(words[3:], " ")
+	title = str.Truncate(title, campaignMaxTitleLength)
+	campaign := &Campaign{
+		Base:          storage.New(storage.ID(fmt.Sprintf("campaign:%d:%s", m.Chat.ID, RandStringRunes(8)))),
+		Creator:       creator,
+		Goal:          goal,
+		Title:         title,
+		Deadline:      deadline,
+		Contributions: make([]CampaignContribution, 0),
+		LanguageCode:  ctx.Value("publicLanguageCode").(string),
+	}
+	campaign.Message = bot.trySendMessageEditable(m.Chat, bot.campaignText(campaign), bot.campaignKeyboard(campaign))
+	if campaign.Message == nil {
+		return ctx, errors.Create(errors.UnknownError)
+	}
+	runtime.IgnoreError(campaign.Set(campaign, bot.Bunt))
+	bot.startCampaignTimer(campaign)
+	log.Infof("[📣 campaign] %s started campaign %s for %d sat in %s", GetUserStr(creator.Telegram), campaign.ID, goal, m.Chat.Title)
+	return ctx, nil
+}
+
+func (bot *TipBot) campaignText(campaign *Campaign) string {
+	collected := campaign.collected()
+	progress := collected
+	if progress > campaign.Goal {
+		progress = campaign.Goal
+	}
+	return fmt.Sprintf(i18n.Translate(campaign.LanguageCode, "campaignMessage"),
+		GetUserStrMd(campaign.Creator.Telegram), str.MarkdownEscape(campaign.Title), campaign.Goal, collected,
+		len(campaign.Contributions), MakeProgressbar(progress, campaign.Goal), campaign.Deadline.UTC().Format("2006-01-02 15:04 UTC"))
+}
+
+func (bot *TipBot) campaignKeyboard(campaign *Campaign) *tb.ReplyMarkup {
+	contributeButton := campaignMenu.Data(btnContributeCampaign.Text, btnContributeCampaign.Unique, campaign.ID)
+	cancelButton := campaignMenu.Data(btnCancelCampaign.Text, btnCancelCampaign.Unique, campaign.ID)
+	campaignMenu.Inline(campaignMenu.Row(contributeButton, cancelButton))
+	return campaignMenu
+}
+
+func (bot *TipBot) loadCampaign(id string) (*Campaign, error) {
+	tx := &Campaign{Base: storage.New(storage.ID(id))}
+	sn, err := tx.Get(tx, bot.Bunt)
+	if err != nil {
+		return nil, err
+	}
+	return sn.(*Campaign), nil
+}
+
+// contributeCampaignHandler is invoked when a user presses the contribute button.
+// The user is asked for the amount in the private chat.
+func (bot *TipBot) contributeCampaignHandler(ctx intercept.Context) (intercept.Context, error) {
+	c := ctx.Callback()
+	campaign, err := bot.loadCampaign(c.Data)
+	if err != nil {
+		return ctx, err
+	}
+	if !campaign.Active || time.Now().After(campaign.Deadline) {
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	user := LoadUser(ctx)
+	if user.Wallet == nil {
+		ctx.Context = context.WithValue(ctx, "callback_response", Translate(ctx, "campaignStartBotMessage"))
+		return ctx, errors.Create(errors.UserNoWalletError)
+	}
+	bot.trySendMessage(user.Telegram, fmt.Sprintf(Translate(ctx, "campaignContributeMessage"), str.MarkdownEscape(campaign.Title)))
+	_, err = bot.askForAmount(ctx, campaign.ID, "CampaignContributeState", 0, 0, "")
+	ctx.Context = context.WithValue(ctx, "callback_response", Translate(ctx, "campaignCheckPrivateChatMessage"))
+	return ctx, err
+}
+
+// contributeCampaign is invoked by enterAmountHandler after the user entered the amount of a contribution
+func (bot *TipBot) contributeCampaign(ctx intercept.Context, id string, amount int64) (intercept.Context, error) {
+	mutex.LockWithContext(ctx, id)
+	defer mutex.UnlockWithContext(ctx, id)
+	campaign, err := bot.loadCampaign(id)
+	if err != nil {
+		return ctx, err
+	}
+	user := LoadUser(ctx)
+	if !campaign.Active || time.Now().After(campaign.Deadline) {
+		bot.trySendMessage(user.Telegram, Translate(ctx, "campaignEndedMessage"))
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	if amount < 1 {
+		bot.trySendMessage(user.Telegram, Translate(ctx, "lnurlInvalidAmountMessage"))
+		return ctx, errors.Create(errors.InvalidAmountError)
+	}
+	me, err := GetUser(bot.Telegram.Me, *bot)
+	if err != nil {
+		return ctx, err
+	}
+	t := NewTransaction(bot, user, me, amount, TransactionType("campaign"), TransactionChat(campaign.Message.Chat))
+	t.Memo = fmt.Sprintf("📣 Campaign contribution of %s.", GetUserStr(user.Telegram))
+	success, err := t.Send()
+	if !success || err != nil {
+		bot.trySendMessage(user.Telegram, Translate(ctx, "inlineSendBalanceLowMessage"))
+		return ctx, errors.Create(errors.BalanceToLowError)
+	}
+	contributed := false
+	for i := range campaign.Contributions {
+		if campaign.Contributions[i].User.Telegram.ID == user.Telegram.ID {
+			campaign.Contributions[i].Amount += amount
+			contributed = true
+		}
+	}
+	if !contributed {
+		campaign.Contributions = append(campaign.Contributions, CampaignContribution{User: user, Amount: amount})
+	}
+	runtime.IgnoreError(campaign.Set(campaign, bot.Bunt))
+	log.Infof("[📣 campaign] %s contributed %d sat to campaign %s", GetUserStr(user.Telegram), amount, campaign.ID)
+	bot.trySendMessage(user.Telegram, fmt.Sprintf(Translate(ctx, "campaignContributedMessage"), amount, str.MarkdownEscape(campaign.Title)))
+	bot.tryEditStack(campaign.Message, campaign.ID, bot.campaignText(campaign), bot.campaignKeyboard(campaign))
+	return ctx, nil
+}
+
+// cancelCampaignHandler is invoked when the creator cancels the campaign. Everyone is refunded.
+func (bot *TipBot) cancelCampaignHandler(ctx intercept.Context) (intercept.Context, error) {
+	c := ctx.Callback()
+	mutex.LockWithContext(ctx, c.Data)
+	defer mutex.UnlockWithContext(ctx, c.Data)
+	campaign, err := bot.loadCampaign(c.Data)
+	if err != nil {
+		return ctx, err
+	}
+	if campaign.Creator.Telegram.ID != c.Sender.ID {
+		ctx.Context = context.WithValue(ctx, "callback_response", Translate(ctx, "campaignNotYourCampaignMessage"))
+		return ctx, errors.Create(errors.UnknownError)
+	}
+	if !campaign.Active {
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	runtime.RemoveTicker(campaign.ID)
+	campaign.Canceled = true
+	runtime.IgnoreError(campaign.Inactivate(campaign, bot.Bunt))
+	bot.refundCampaign(campaign)
+	log.Infof("[📣 campaign] %s canceled campaign %s", GetUserStr(campaign.Creator.Telegram), campaign.ID)
+	bot.tryEditStack(campaign.Message, campaign.ID, bot.campaignText(campaign)+"\n\n"+i18n.Translate(campaign.LanguageCode, "campaignCanceledMessage"), &tb.ReplyMarkup{})
+	return ctx, nil
+}
+
+// refundCampaign pays back every contribution from the escrow. Failed refunds are retried.
+func (bot *TipBot) refundCampaign(campaign *Campaign) {
+	for _, c := range campaign.Contributions {
+		bot.payFromEscrow(c.User.Telegram, c.Amount, "campaign", campaign.chat(), fmt.Sprintf("📣 Campaign refund to %s.", GetUserStr(c.User.Telegram)))
+		bot.trySendMessage(c.User.Telegram, fmt.Sprintf(i18n.Translate(c.User.Telegram.LanguageCode, "campaignRefundedMessage"), c.Amount, str.MarkdownEscape(campaign.Title)))
+	}
+}
+
+// finishCampaign pays the creator if the goal was reached and refunds everyone otherwise
+func (bot *TipBot) finishCampaign(id string) {
+	mutex.Lock(id)
+	defer mutex.Unlock(id)
+	campaign, err := bot.loadCampaign(id)
+	if err != nil || !campaign.Active {
+		return
+	}
+	runtime.IgnoreError(campaign.Inactivate(campaign, bot.Bunt))
+	if campaign.Message == nil {
+		log.Errorf("[finishCampaign] campaign %s has no message, refunding", campaign.ID)
+		bot.refundCampaign(campaign)
+		return
+	}
+	collected := campaign.collected()
+	if collected < campaign.Goal {
+		log.Infof("[📣 campaign] Campaign %s missed its goal (%d/%d sat)", campaign.ID, collected, campaign.Goal)
+		bot.refundCampaign(campaign)
+		bot.tryEditStack(campaign.Message, campaign.ID, bot.campaignText(campaign)+"\n\n"+i18n.Translate(campaign.LanguageCode, "campaignFailedMessage"), &tb.ReplyMarkup{})
+		return
+	}
+	// the payout is retried until it went through
+	creator := campaign.Creator
+	bot.payFromEscrow(creator.Telegram, collected, "campaign", campaign.chat(), fmt.Sprintf("📣 Campaign payout to %s.", GetUserStr(creator.Telegram)))
+	log.Infof("[📣 campaign] Campaign %s reached its goal, paying %d sat to %s", campaign.ID, collected, GetUserStr(creator.Telegram))
+	bot.trySendMessage(creator.Telegram, fmt.Sprintf(i18n.Translate(creator.Telegram.LanguageCode, "campaignPaidOutMessage"), str.MarkdownEscape(campaign.Title), collected))
+	bot.tryEditStack(campaign.Message, campaign.ID, bot.campaignText(campaign)+"\n\n"+i18n.Translate(campaign.LanguageCode, "campaignSucceededMessage"), &tb.ReplyMarkup{})
+}
+
+// startCampaignTimer finishes the campaign at its deadline
+func (bot *TipBot) startCampaignTimer(campaign *Campaign) {
+	t := runtime.NewResettableFunction(campaign.ID,
+		runtime.WithTimer(time.NewTimer(time.Until(campaign.Deadline))))
+	t.Do(func() {
+		bot.finishCampaign(campaign.ID)
+	})
+}
+
+// restartCampaignTimers restarts the deadline timers of all open campaigns
+func (bot *TipBot) restartCampaignTimers() {
+	bot.Bunt.View(func(tx *buntdb.Tx) error {
+		return tx.Ascend("campaign", func(key, value string) bool {
+			campaign := &Campaign{}
+			if err := json.Unmarshal([]byte(value), campaign); err != nil {
+				return true
+			}
+			if campaign.Active {
+				bot.startCampaignTimer(campaign)
+			}
+			return true // continue iteration
+		})
+	})
+}
@@ -0,0 +1,101 @@
+package telegram
+
+import (
+	"testing"
+
+	"github.com/tidwall/buntdb"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+// startTestCampaign starts a campaign with the goal in the group and returns it
+func startTestCampaign(t *testing.T, test *testBot, creatorID int64, goal string) *Campaign {
+	creator := test.addUser(t, creatorID, 0)
+	chat := &tb.Chat{ID: -400, Type: tb.ChatGroup, Title: "group"}
+	if _, err := test.campaignHandler(test.message(creator, chat, "/campaign "+goal+" 7d new roof")); err != nil {
+		t.Fatalf("campaignHandler() error = %v", err)
+	}
+	var campaign *Campaign
+	test.Bunt.View(func(tx *buntdb.Tx) error {
+		return tx.AscendKeys(CampaignIndex, func(key, value string) bool {
+			campaign, _ = test.loadCampaign(key)
+			return false
+		})
+	})
+	if campaign == nil || campaign.Title != "new roof" {
+		t.Fatalf("campaign = %+v", campaign)
+	}
+	return campaign
+}
+
+func TestTipBot_finishCampaign(t *testing.T) {
+	tests := []struct {
+		name        string
+		contributed []int64
+		wantCreator int64
+	}{
+		{name: "goal reached", contributed: []int64{300, 200}, wantCreator: 500},
+		{name: "goal missed", contributed: []int64{300, 100}, wantCreator: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			test := newTestBot(t)
+			campaign := startTestCampaign(t, test, 2, "500")
+			for i, amount := range tt.contributed {
+				user := test.addUser(t, int64(3+i), 1000)
+				if _, err := test.contributeCampaign(test.message(user, &tb.Chat{ID: user.Telegram.ID}, ""), campaign.ID, amount); err != nil {
+					t.Fatalf("contributeCampaign() error = %v", err)
+				}
+			}
+			// contributions are held by the bot wallet
+			if got := test.balance(testBotID); got != tt.contributed[0]+tt.contributed[1] {
+				t.Errorf("bot balance = %d, want %d", got, tt.contributed[0]+tt.contributed[1])
+			}
+			test.finishCampaign(campaign.ID)
+			if got := test.balance(2); got != tt.wantCreator {
+				t.Errorf("creator balance = %d, want %d", got, tt.wantCreator)
+			}
+			for i, amount := range tt.contributed {
+				want := 1000 - amount
+				if tt.wantCreator == 0 {
+					want = 1000
+				}
+				if got := test.balance(int64(3 + i)); got != want {
+					t.Errorf("contributor balance = %d, want %d", got, want)
+				}
+			}
+			if got := test.balance(testBotID); got != 0 {
+				t.Errorf("bot balance = %d after the campaign, want 0", got)
+			}
+			// a finished campaign is not paid twice
+			test.finishCampaign(campaign.ID)
+			if got := test.balance(2); got != tt.wantCreator {
+				t.Errorf("creator balance = %d after finishing twice, want %d", got, tt.wantCreator)
+			}
+		})
+	}
+}
+
+func TestTipBot_cancelCampaignHandler(t *testing.T) {
+	test := newTestBot(t)
+	campaign := startTestCampaign(t, test, 2, "500")
+	contributor := test.addUser(t, 3, 1000)
+	if _, err := test.contributeCampaign(test.message(contributor, &tb.Chat{ID: 3}, ""), campaign.ID, 300); err != nil {
+		t.Fatalf("contributeCampaign() error = %v", err)
+	}
+	// only the creator can cancel
+	if _, err := test.cancelCampaignHandler(test.callback(contributor, campaign.Message.Chat, campaign.ID)); err == nil {
+		t.Fatalf("cancelCampaignHandler() let another user cancel")
+	}
+	if _, err := test.cancelCampaignHandler(test.callback(campaign.Creator, campaign.Message.Chat, campaign.ID)); err != nil {
+		t.Fatalf("cancelCampaignHandler() error = %v", err)
+	}
+	if got := test.balance(3); got != 1000 {
+		t.Errorf("contributor balance = %d after cancel, want 1000", got)
+	}
+	if _, err := test.contributeCampaign(test.message(contributor, &tb.Chat{ID: 3}, ""), campaign.ID, 100); err == nil {
+		t.Errorf("contributeCampaign() contributed to a canceled campaign")
+	}
+	if got := test.balance(3); got != 1000 {
+		t.Errorf("contributor balance = %d, want 1000", got)
+	}
+}
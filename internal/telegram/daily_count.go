@@ -0,0 +1,74 @@
+package telegram
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/massmux/SatsMobiBot/internal/runtime"
+	"github.com/massmux/SatsMobiBot/internal/runtime/mutex"
+	"github.com/massmux/SatsMobiBot/internal/storage"
+)
+
+// DailyCount counts how often a user did something on one UTC day. It is persisted in bunt,
+// so daily limits survive restarts and always reset at midnight UTC. Users are identified by
+// their telegram id or, for wallets without a telegram account, by walletLockKey.
+type DailyCount struct {
+	*storage.Base
+	Day   string `json:"day"`
+	Count int    `json:"count"`
+}
+
+func dailyCountKey(name string, userKey string) string {
+	return fmt.Sprintf("daily-count:%s:%s", name, userKey)
+}
+
+func utcDay(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+// loadDailyCount returns the count of today. Counts of earlier days start again at zero.
+func (bot *TipBot) loadDailyCount(key string) *DailyCount {
+	today := utcDay(time.Now())
+	tx := &DailyCount{Base: storage.New(storage.ID(key)), Day: today}
+	sn, err := tx.Get(tx, bot.Bunt)
+	if err != nil {
+		return tx
+	}
+	count := sn.(*DailyCount)
+	if count.Day != today {
+		count.Day = today
+		count.Count = 0
+	}
+	return count
+}
+
+// dailyCount returns how often the user did name today
+func (bot *TipBot) dailyCount(name string, userKey string) int {
+	return bot.loadDailyCount(dailyCountKey(name, userKey)).Count
+}
+
+// takeDailyCount increases the count of today if it is below limit. It returns false if the limit was reached.
+func (bot *TipBot) takeDailyCount(name string, userKey string, limit int) bool {
+	key := dailyCountKey(name, userKey)
+	mutex.Lock(key)
+	defer mutex.Unlock(key)
+	count := bot.loadDailyCount(key)
+	if count.Count >= limit {
+		return false
+	}
+	count.Count++
+	runtime.IgnoreError(count.Set(count, bot.Bunt))
+	return true
+}
+
+// releaseDailyCount gives back a count that was taken for an action that failed
+func (bot *TipBot) releaseDailyCount(name string, userKey string) {
+	key := dailyCountKey(name, userKey)
+	mutex.Lock(key)
+	defer mutex.Unlock(key)
+	count := bot.loadDailyCount(key)
+	if count.Count > 0 {
+		count.Count--
+		runtime.IgnoreError(count.Set(count, bot.Bunt))
+	}
+}
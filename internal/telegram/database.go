@@ -11,6 +11,7 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/massmux/SatsMobiBot/internal/apikey"
 	"github.com/massmux/SatsMobiBot/internal/database"
 	"github.com/massmux/SatsMobiBot/internal/str"
 
@@ -58,6 +59,66 @@ func createBunt(file string) *storage.DB {
 	if err != nil {
 		panic(err)
 	}
+	err = bunt.CreateIndex("shop-webhook", ShopWebhookIndex, buntdb.IndexString)
+	log.Infof("[blunt] index 3 created in %s", time.Since(t1))
+	if err != nil {
+		panic(err)
+	}
+	err = bunt.CreateIndex("schedule", PaymentScheduleIndex, buntdb.IndexString)
+	log.Infof("[blunt] index 4 created in %s", time.Since(t1))
+	if err != nil {
+		panic(err)
+	}
+	err = bunt.CreateIndex("money-request", MoneyRequestIndex, buntdb.IndexString)
+	log.Infof("[blunt] index 5 created in %s", time.Since(t1))
+	if err != nil {
+		panic(err)
+	}
+	err = bunt.CreateIndex("splitbill", SplitBillIndex, buntdb.IndexString)
+	log.Infof("[blunt] index 6 created in %s", time.Since(t1))
+	if err != nil {
+		panic(err)
+	}
+	err = bunt.CreateIndex("raffle", RaffleIndex, buntdb.IndexString)
+	log.Infof("[blunt] index 7 created in %s", time.Since(t1))
+	if err != nil {
+		panic(err)
+	}
+	err = bunt.CreateIndex("campaign", CampaignIndex, buntdb.IndexString)
+	log.Infof("[blunt] index 8 created in %s", time.Since(t1))
+	if err != nil {
+		panic(err)
+	}
+	err = bunt.CreateIndex("bounty", BountyIndex, buntdb.IndexString)
+	log.Infof("[blunt] index 9 created in %s", time.Since(t1))
+	if err != nil {
+		panic(err)
+	}
+	err = bunt.CreateIndex("nwc", NwcConnectionIndex, buntdb.IndexString)
+	log.Infof("[blunt] index 10 created in %s", time.Since(t1))
+	if err != nil {
+		panic(err)
+	}
+	err = bunt.CreateIndex("nostr-publish", NostrPublishIndex, buntdb.IndexString)
+	log.Infof("[blunt] index 11 created in %s", time.Since(t1))
+	if err != nil {
+		panic(err)
+	}
+	err = bunt.CreateIndex("treasury-spend", TreasurySpendIndex, buntdb.IndexString)
+	log.Infof("[blunt] index 12 created in %s", time.Since(t1))
+	if err != nil {
+		panic(err)
+	}
+	err = bunt.CreateIndex("escrow-payout", EscrowPayoutIndex, buntdb.IndexString)
+	log.Infof("[blunt] index 13 created in %s", time.Since(t1))
+	if err != nil {
+		panic(err)
+	}
+	err = bunt.CreateIndex("bulk-payout", BulkPayoutIndex, buntdb.IndexString)
+	log.Infof("[blunt] index 14 created in %s", time.Since(t1))
+	if err != nil {
+		panic(err)
+	}
 	log.Infof("[blunt] total time: %s", time.Since(t1))
 	return bunt
 }
@@ -117,6 +178,10 @@ func AutoMigration() *Databases {
 	if err != nil {
 		panic(err)
 	}
+	err = orm.AutoMigrate(&apikey.Key{})
+	if err != nil {
+		panic(err)
+	}
 
 	txLogger, err := gorm.Open(sqlite.Open(internal.Configuration.Database.TransactionsPath), &gorm.Config{DisableForeignKeyConstraintWhenMigrating: true, FullSaveAssociations: true})
 	if err != nil {
@@ -135,6 +200,10 @@ func AutoMigration() *Databases {
 	if err != nil {
 		panic(err)
 	}
+	err = groupsDb.AutoMigrate(&GroupMember{})
+	if err != nil {
+		panic(err)
+	}
 
 	return &Databases{
 		Users:        orm,
@@ -0,0 +1,172 @@
+package telegram
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/massmux/SatsMobiBot/internal/runtime"
+	"github.com/massmux/SatsMobiBot/internal/runtime/mutex"
+	"github.com/massmux/SatsMobiBot/internal/storage"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/buntdb"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const (
+	EscrowPayoutIndex           = "escrow-payout:*"
+	escrowPayoutInitialBackoff  = time.Minute
+	escrowPayoutMaxBackoff      = 6 * time.Hour
+	escrowPayoutWarningAttempts = 10
+)
+
+// EscrowPayout is a payment from the bot wallet that is owed to a user, like a raffle pot or a refund.
+// It is persisted in bunt before it is paid and retried with backoff until it went through.
+type EscrowPayout struct {
+	*storage.Base
+	ToID        int64     `json:"to_id"`
+	Amount      int64     `json:"amount"`
+	Type        string    `json:"type"`
+	Memo        string    `json:"memo"`
+	ChatID      int64     `json:"chat_id"`
+	ChatName    string    `json:"chat_name"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastError   string    `json:"last_error"`
+}
+
+// escrowPayoutBackoff returns the waiting time before the next payout attempt
+func escrowPayoutBackoff(attempts int) time.Duration {
+	d := escrowPayoutInitialBackoff
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= escrowPayoutMaxBackoff {
+			return escrowPayoutMaxBackoff
+		}
+	}
+	return d
+}
+
+// errEscrowPayoutUnknown is returned when a payment was sent, but LNbits could not tell whether it went through
+var errEscrowPayoutUnknown = fmt.Errorf("payment status unknown")
+
+// payFromEscrow pays amount from the bot wallet to the user. The payout is persisted first
+// and retried until it succeeds. It returns whether the first attempt went through.
+func (bot *TipBot) payFromEscrow(to *tb.User, amount int64, transactionType string, chat *tb.Chat, memo string) bool {
+	if amount < 1 {
+		return true
+	}
+	payout := &EscrowPayout{
+		Base:        storage.New(storage.ID(fmt.Sprintf("escrow-payout:%s:%s", transactionType, RandStringRunes(16)))),
+		ToID:        to.ID,
+		Amount:      amount,
+		Type:        transactionType,
+		Memo:        memo,
+		NextAttempt: time.Now(),
+	}
+	if chat != nil {
+		payout.ChatID, payout.ChatName = chat.ID, chat.Title
+	}
+	if err := payout.Set(payout, bot.Bunt); err != nil {
+		log.Errorf("[payFromEscrow] Could not persist payout of %d sat to %d: %s", amount, to.ID, err.Error())
+	}
+	return bot.tryEscrowPayout(payout.ID)
+}
+
+// tryEscrowPayout attempts a persisted payout. On failure, the next attempt is scheduled with backoff.
+// Payouts whose status can't be checked after an ambiguous error are not retried.
+func (bot *TipBot) tryEscrowPayout(id string) bool {
+	mutex.Lock(id)
+	defer mutex.Unlock(id)
+	payout := &EscrowPayout{Base: storage.New(storage.ID(id))}
+	sn, err := payout.Get(payout, bot.Bunt)
+	if err != nil {
+		// already paid out
+		return true
+	}
+	payout = sn.(*EscrowPayout)
+	payout.Attempts++
+	err = bot.sendEscrowPayout(payout)
+	if err == errEscrowPayoutUnknown {
+		// a retry could pay twice from the bot wallet, the payout has to be checked by hand
+		log.Errorf("[escrowPayout] payout %s of %d sat to %d has an unknown status and is marked as paid, check the bot wallet", payout.ID, payout.Amount, payout.ToID)
+		runtime.IgnoreError(payout.Delete(payout, bot.Bunt))
+		runtime.RemoveTicker(payout.ID)
+		return true
+	}
+	if err == nil {
+		log.Infof("[escrowPayout] paid %d sat to %d (%s) after %d attempts", payout.Amount, payout.ToID, payout.ID, payout.Attempts)
+		runtime.IgnoreError(payout.Delete(payout, bot.Bunt))
+		runtime.RemoveTicker(payout.ID)
+		return payout.Attempts == 1
+	}
+	payout.LastError = err.Error()
+	payout.NextAttempt = time.Now().Add(escrowPayoutBackoff(payout.Attempts))
+	if payout.Attempts >= escrowPayoutWarningAttempts {
+		log.Errorf("[escrowPayout] payout %s of %d sat to %d still failing after %d attempts: %s", payout.ID, payout.Amount, payout.ToID, payout.Attempts, payout.LastError)
+	} else {
+		log.Warnf("[escrowPayout] payout %s of %d sat to %d failed (attempt %d): %s", payout.ID, payout.Amount, payout.ToID, payout.Attempts, payout.LastError)
+	}
+	runtime.IgnoreError(payout.Set(payout, bot.Bunt))
+	bot.startEscrowPayoutTimer(payout)
+	return false
+}
+
+func (bot *TipBot) sendEscrowPayout(payout *EscrowPayout) error {
+	me, err := GetUser(bot.Telegram.Me, *bot)
+	if err != nil {
+		return err
+	}
+	to, err := GetLnbitsUser(&tb.User{ID: payout.ToID}, *bot)
+	if err != nil {
+		return err
+	}
+	t := NewTransaction(bot, me, to, payout.Amount, TransactionType(payout.Type))
+	t.ChatID, t.ChatName = payout.ChatID, payout.ChatName
+	t.Memo = payout.Memo
+	success, err := t.Send()
+	if success {
+		return nil
+	}
+	if err == nil {
+		err = fmt.Errorf("transaction failed")
+	}
+	if len(t.Invoice.PaymentHash) == 0 {
+		// the invoice was not created, nothing was paid
+		return err
+	}
+	// the payment was sent, but failed with an error that doesn't tell if the sats arrived.
+	// The invoice of the recipient is only paid if they did.
+	payment, statusErr := bot.Client.Payment(*to.Wallet, t.Invoice.PaymentHash)
+	if statusErr != nil {
+		log.Warnf("[escrowPayout] could not check payment %s of payout %s: %s", t.Invoice.PaymentHash, payout.ID, statusErr.Error())
+		return errEscrowPayoutUnknown
+	}
+	if payment.Paid {
+		return nil
+	}
+	return err
+}
+
+// startEscrowPayoutTimer schedules the next payout attempt
+func (bot *TipBot) startEscrowPayoutTimer(payout *EscrowPayout) {
+	t := runtime.NewResettableFunction(payout.ID,
+		runtime.WithTimer(time.NewTimer(time.Until(payout.NextAttempt))))
+	t.Do(func() {
+		bot.tryEscrowPayout(payout.ID)
+	})
+}
+
+// restartEscrowPayouts reschedules all pending payouts after a restart
+func (bot *TipBot) restartEscrowPayouts() {
+	bot.Bunt.View(func(tx *buntdb.Tx) error {
+		return tx.Ascend("escrow-payout", func(key, value string) bool {
+			payout := &EscrowPayout{}
+			if err := json.Unmarshal([]byte(value), payout); err != nil {
+				return true
+			}
+			bot.startEscrowPayoutTimer(payout)
+			return true // continue iteration
+		})
+	})
+}
@@ -0,0 +1,99 @@
+package telegram
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/tidwall/buntdb"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+// escrowPayouts returns the pending payouts
+func escrowPayouts(test *testBot) []*EscrowPayout {
+	payouts := make([]*EscrowPayout, 0)
+	test.Bunt.View(func(tx *buntdb.Tx) error {
+		return tx.AscendKeys(EscrowPayoutIndex, func(key, value string) bool {
+			payout := &EscrowPayout{}
+			if err := json.Unmarshal([]byte(value), payout); err == nil {
+				payouts = append(payouts, payout)
+			}
+			return true
+		})
+	})
+	return payouts
+}
+
+func Test_escrowPayoutBackoff(t *testing.T) {
+	if got := escrowPayoutBackoff(1); got != escrowPayoutInitialBackoff {
+		t.Errorf("escrowPayoutBackoff(1) = %v, want %v", got, escrowPayoutInitialBackoff)
+	}
+	if got := escrowPayoutBackoff(3); got != 4*escrowPayoutInitialBackoff {
+		t.Errorf("escrowPayoutBackoff(3) = %v, want %v", got, 4*escrowPayoutInitialBackoff)
+	}
+	if got := escrowPayoutBackoff(100); got != escrowPayoutMaxBackoff {
+		t.Errorf("escrowPayoutBackoff(100) = %v, want %v", got, escrowPayoutMaxBackoff)
+	}
+}
+
+func TestTipBot_payFromEscrow(t *testing.T) {
+	tests := []struct {
+		name          string
+		failPays      int
+		ambiguousPays int
+		failStatus    bool
+		botBalance    int64
+		wantFirst     bool // the first attempt went through
+		wantPending   bool // the payout is retried
+		wantPaid      int64
+	}{
+		{name: "paid", botBalance: 1000, wantFirst: true, wantPaid: 100},
+		{name: "failed", failPays: 1, botBalance: 1000, wantPending: true},
+		{name: "balance too low", botBalance: 50, wantPending: true},
+		{name: "ambiguous error, paid", ambiguousPays: 1, botBalance: 1000, wantFirst: true, wantPaid: 100},
+		{name: "ambiguous error, unknown status", ambiguousPays: 1, failStatus: true, botBalance: 1000, wantFirst: true, wantPaid: 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			test := newTestBot(t)
+			test.lnbits.newWallet("wallet1", tt.botBalance)
+			to := test.addUser(t, 2, 0)
+			test.lnbits.failPays, test.lnbits.ambiguousPays, test.lnbits.failStatus = tt.failPays, tt.ambiguousPays, tt.failStatus
+
+			first := test.payFromEscrow(to.Telegram, 100, "raffle", &tb.Chat{ID: -1, Title: "group"}, "prize")
+			if first != tt.wantFirst {
+				t.Errorf("payFromEscrow() = %v, want %v", first, tt.wantFirst)
+			}
+			if got := test.balance(2); got != tt.wantPaid {
+				t.Errorf("recipient balance = %d, want %d", got, tt.wantPaid)
+			}
+			payouts := escrowPayouts(test)
+			if (len(payouts) > 0) != tt.wantPending {
+				t.Fatalf("%d pending payouts, want pending %v", len(payouts), tt.wantPending)
+			}
+			if !tt.wantPending {
+				return
+			}
+			payout := payouts[0]
+			if payout.Attempts != 1 || len(payout.LastError) == 0 || !payout.NextAttempt.After(time.Now()) {
+				t.Errorf("payout = %+v, want a failed attempt with backoff", payout)
+			}
+			// the retry goes through once the wallet can pay
+			test.lnbits.newWallet("wallet1", 1000)
+			if test.tryEscrowPayout(payout.ID) {
+				t.Errorf("tryEscrowPayout() reported a retry as first attempt")
+			}
+			if got := test.balance(2); got != 100 {
+				t.Errorf("recipient balance = %d after retry, want 100", got)
+			}
+			if len(escrowPayouts(test)) != 0 {
+				t.Errorf("payout still pending after it was paid")
+			}
+			// paid payouts are not paid again
+			test.tryEscrowPayout(payout.ID)
+			if got := test.balance(2); got != 100 {
+				t.Errorf("recipient balance = %d after second retry, want 100", got)
+			}
+		})
+	}
+}
@@ -2,13 +2,16 @@ package telegram
 
 import (
 	"context"
+	stderrors "errors"
 	"fmt"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/massmux/SatsMobiBot/internal/commands"
 	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
 
+	"github.com/massmux/SatsMobiBot/internal/runtime"
 	"github.com/massmux/SatsMobiBot/internal/runtime/mutex"
 	"github.com/massmux/SatsMobiBot/internal/runtime/once"
 	"github.com/massmux/SatsMobiBot/internal/storage"
@@ -32,17 +35,22 @@ var (
 
 type InlineFaucet struct {
 	*storage.Base
-	Message         string         `json:"inline_faucet_message"`
-	Amount          int64          `json:"inline_faucet_amount"`
-	RemainingAmount int64          `json:"inline_faucet_remainingamount"`
-	PerUserAmount   int64          `json:"inline_faucet_peruseramount"`
-	From            *lnbits.User   `json:"inline_faucet_from"`
-	To              []*lnbits.User `json:"inline_faucet_to"`
-	Memo            string         `json:"inline_faucet_memo"`
-	NTotal          int            `json:"inline_faucet_ntotal"`
-	NTaken          int            `json:"inline_faucet_ntaken"`
-	UserNeedsWallet bool           `json:"inline_faucet_userneedswallet"`
-	LanguageCode    string         `json:"languagecode"`
+	Message         string            `json:"inline_faucet_message"`
+	Amount          int64             `json:"inline_faucet_amount"`
+	RemainingAmount int64             `json:"inline_faucet_remainingamount"`
+	PerUserAmount   int64             `json:"inline_faucet_peruseramount"`
+	From            *lnbits.User      `json:"inline_faucet_from"`
+	To              []*lnbits.User    `json:"inline_faucet_to"`
+	Memo            string            `json:"inline_faucet_memo"`
+	NTotal          int               `json:"inline_faucet_ntotal"`
+	NTaken          int               `json:"inline_faucet_ntaken"`
+	UserNeedsWallet bool              `json:"inline_faucet_userneedswallet"`
+	ExpiresAt       time.Time         `json:"inline_faucet_expires_at"`
+	Editable        *tb.StoredMessage `json:"inline_faucet_editable"` // needed to close the faucet when it expires
+	Rules           FaucetRules       `json:"inline_faucet_rules"`
+	CaptchaFailed   []int64           `json:"inline_faucet_captcha_failed"` // users locked out after a wrong captcha answer
+	ChatID          int64             `json:"inline_faucet_chat_id"`        // only known for faucets created with /faucet
+	LanguageCode    string            `json:"languagecode"`
 }
 
 func (bot TipBot) mapFaucetLanguage(ctx context.Context, command string) context.Context {
@@ -54,6 +62,10 @@ func (bot TipBot) mapFaucetLanguage(ctx context.Context, command string) context
 }
 
 func (bot TipBot) createFaucet(ctx context.Context, text string, sender *tb.User) (*InlineFaucet, error) {
+	text, rules, err := parseFaucetRules(text)
+	if err != nil {
+		return nil, err
+	}
 	amount, err := decodeAmountFromCommand(text)
 	if err != nil {
 		return nil, errors.New(errors.DecodeAmountError, err)
@@ -82,13 +94,20 @@ func (bot TipBot) createFaucet(ctx context.Context, text string, sender *tb.User
 	if balance < amount {
 		return nil, errors.New(errors.BalanceToLowError, fmt.Errorf("[faucet] Balance of user %s too low", fromUserStr))
 	}
-	// // check for memo in command
-	memo := GetMemoFromCommand(text, 3)
+	// check for expiry and memo in command
+	expiresAt, memoWord, err := getInlineExpiryFromCommand(text, 3)
+	if err != nil {
+		return nil, err
+	}
+	memo := GetMemoFromCommand(text, memoWord)
 
 	inlineMessage := fmt.Sprintf(Translate(ctx, "inlineFaucetMessage"), perUserAmount, GetUserStrMd(sender), amount, amount, 0, nTotal, MakeProgressbar(amount, amount))
 	if len(memo) > 0 {
 		inlineMessage = inlineMessage + fmt.Sprintf(Translate(ctx, "inlineFaucetAppendMemo"), memo)
 	}
+	if !expiresAt.IsZero() {
+		inlineMessage = inlineMessage + fmt.Sprintf(Translate(ctx, "inlineAppendExpiry"), expiresAt.UTC().Format("2006-01-02 15:04 UTC"))
+	}
 	id := fmt.Sprintf("faucet:%s:%d", RandStringRunes(10), amount)
 
 	return &InlineFaucet{
@@ -102,6 +121,8 @@ func (bot TipBot) createFaucet(ctx context.Context, text string, sender *tb.User
 		NTaken:          0,
 		RemainingAmount: amount,
 		UserNeedsWallet: false,
+		ExpiresAt:       expiresAt,
+		Rules:           rules,
 		LanguageCode:    ctx.Value("publicLanguageCode").(string),
 	}, nil
 
@@ -126,6 +147,10 @@ func (bot TipBot) makeFaucet(ctx context.Context, m *tb.Message, query bool) (*I
 			bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "inlineFaucetHelpText"), Translate(ctx, "inlineFaucetInvalidPeruserAmountMessage")))
 			bot.tryDeleteMessage(m)
 			return nil, err
+		case errors.InvalidSyntaxError:
+			bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "inlineFaucetHelpText"), Translate(ctx, "inlineFaucetInvalidOptionsMessage")))
+			bot.tryDeleteMessage(m)
+			return nil, err
 		case errors.GetBalanceError:
 			// log.Errorln(err.Error())
 			bot.tryDeleteMessage(m)
@@ -156,6 +181,9 @@ func (bot TipBot) makeQueryFaucet(ctx intercept.Context) (*InlineFaucet, error)
 		case errors.InvalidAmountPerUserError:
 			bot.inlineQueryReplyWithError(ctx, TranslateUser(ctx, "inlineFaucetInvalidPeruserAmountMessage"), fmt.Sprintf(TranslateUser(ctx, "inlineQueryFaucetDescription"), bot.Telegram.Me.Username))
 			return nil, err
+		case errors.InvalidSyntaxError:
+			bot.inlineQueryReplyWithError(ctx, TranslateUser(ctx, "inlineFaucetInvalidOptionsMessage"), fmt.Sprintf(TranslateUser(ctx, "inlineQueryFaucetDescription"), bot.Telegram.Me.Username))
+			return nil, err
 		case errors.GetBalanceError:
 			bot.inlineQueryReplyWithError(ctx, TranslateUser(ctx, "inlineQueryFaucetTitle"), fmt.Sprintf(TranslateUser(ctx, "inlineQueryFaucetDescription"), bot.Telegram.Me.Username))
 			return nil, err
@@ -192,6 +220,7 @@ func (bot TipBot) faucetHandler(ctx intercept.Context) (intercept.Context, error
 		log.Warnf("[faucet] %s", err.Error())
 		return ctx, err
 	}
+	inlineFaucet.ChatID = ctx.Message().Chat.ID
 	fromUserStr := GetUserStr(ctx.Message().Sender)
 	mFaucet := bot.trySendMessage(ctx.Message().Chat, inlineFaucet.Message, bot.makeFaucetKeyboard(ctx, inlineFaucet.ID))
 	log.Infof("[faucet] %s created faucet %s: %d sat (%d per user)", fromUserStr, inlineFaucet.ID, inlineFaucet.Amount, inlineFaucet.PerUserAmount)
@@ -200,6 +229,10 @@ func (bot TipBot) faucetHandler(ctx intercept.Context) (intercept.Context, error
 	if mFaucet != nil && mFaucet.Chat != nil {
 		log.Infof("[faucet] Link: https://t.me/c/%s/%d", strconv.FormatInt(mFaucet.Chat.ID, 10)[4:], mFaucet.ID)
 	}
+	inlineFaucet.Editable = inlineEditable(mFaucet)
+	if !inlineFaucet.ExpiresAt.IsZero() && inlineFaucet.Editable != nil {
+		bot.startInlineFaucetTimer(inlineFaucet)
+	}
 	return ctx, inlineFaucet.Set(inlineFaucet, bot.Bunt)
 }
 
@@ -246,9 +279,22 @@ func (bot *TipBot) acceptInlineFaucetHandler(ctx intercept.Context) (intercept.C
 	c := ctx.Callback()
 	to := LoadUser(ctx)
 	tx := &InlineFaucet{Base: storage.New(storage.ID(c.Data))}
+	fn, err := tx.Get(tx, bot.Bunt)
+	if err != nil {
+		log.Errorf("[acceptInlineFaucetHandler] c.Data: %s, Error: %s", c.Data, err.Error())
+		return ctx, err
+	}
+	// the once guard of singletonCallbackInterceptor is released if the user is not eligible yet,
+	// so they can collect after solving the captcha
+	if rule := bot.checkFaucetRules(ctx, fn.(*InlineFaucet), to); len(rule) > 0 {
+		log.Infof("[faucet] %s is not eligible for faucet %s: %s", GetUserStr(c.Sender), tx.ID, rule)
+		once.Release(c.Data, strconv.FormatInt(c.Sender.ID, 10))
+		ctx.Context = context.WithValue(ctx, "callback_response", Translate(ctx, rule))
+		return ctx, errors.Create(errors.NotActiveError)
+	}
 	mutex.LockWithContext(ctx, tx.ID)
 	defer mutex.UnlockWithContext(ctx, tx.ID)
-	fn, err := tx.Get(tx, bot.Bunt)
+	fn, err = tx.Get(tx, bot.Bunt)
 	if err != nil {
 		log.Errorf("[acceptInlineFaucetHandler] c.Data: %s, Error: %s", c.Data, err.Error())
 		return ctx, err
@@ -263,33 +309,23 @@ func (bot *TipBot) acceptInlineFaucetHandler(ctx intercept.Context) (intercept.C
 		bot.finishFaucet(ctx, c, inlineFaucet)
 		return ctx, errors.Create(errors.NotActiveError)
 	}
+	// failsafe for expired faucets whose timer did not fire yet
+	if !inlineFaucet.ExpiresAt.IsZero() && time.Now().After(inlineFaucet.ExpiresAt) {
+		bot.closeExpiredFaucet(c, inlineFaucet)
+		runtime.IgnoreError(inlineFaucet.Set(inlineFaucet, bot.Bunt))
+		return ctx, errors.Create(errors.NotActiveError)
+	}
 	// log faucet link if possible
 	if c.Message != nil && c.Message.Chat != nil {
 		log.Infof("[faucet] Link: https://t.me/c/%s/%d", strconv.FormatInt(c.Message.Chat.ID, 10)[4:], c.Message.ID)
 	}
 
-	if from.Telegram.ID == to.Telegram.ID {
-		log.Debugf("[faucet] %s is the owner faucet %s", GetUserStr(to.Telegram), inlineFaucet.ID)
-		ctx.Context = context.WithValue(ctx, "callback_response", Translate(ctx, "sendYourselfMessage"))
-		return ctx, errors.Create(errors.SelfPaymentError)
-	}
-	// check if to user has already taken from the faucet
-	for _, a := range inlineFaucet.To {
-		if a.Telegram.ID == to.Telegram.ID {
-			// to user is already in To slice, has taken from facuet
-			log.Debugf("[faucet] %s:%d already took from faucet %s", GetUserStr(to.Telegram), to.Telegram.ID, inlineFaucet.ID)
-			ctx.Context = context.WithValue(ctx, "callback_response", Translate(ctx, "inlineFaucetAlreadyTookMessage"))
-			return ctx, errors.Create(errors.UnknownError)
-		}
-	}
-
 	defer inlineFaucet.Set(inlineFaucet, bot.Bunt)
 
 	if inlineFaucet.RemainingAmount >= inlineFaucet.PerUserAmount {
 		toUserStrMd := GetUserStrMd(to.Telegram)
 		fromUserStrMd := GetUserStrMd(from.Telegram)
 		toUserStr := GetUserStr(to.Telegram)
-		fromUserStr := GetUserStr(from.Telegram)
 		// check if user exists and create a wallet if not
 		_, exists := bot.UserExists(to.Telegram)
 		if !exists {
@@ -301,17 +337,20 @@ func (bot *TipBot) acceptInlineFaucetHandler(ctx intercept.Context) (intercept.C
 			}
 		}
 
-		if !to.Initialized {
-			inlineFaucet.UserNeedsWallet = true
-		}
-
-		// todo: user new get username function to get userStrings
-		transactionMemo := fmt.Sprintf("🚰 Faucet from %s to %s.", fromUserStr, toUserStr)
-		t := NewTransaction(bot, from, to, inlineFaucet.PerUserAmount, TransactionType("faucet"))
-		t.Memo = transactionMemo
-
-		success, err := t.Send()
-		if !success {
+		err = bot.claimFaucet(inlineFaucet, to)
+		switch {
+		case stderrors.Is(err, commands.ErrSelfPayment):
+			log.Debugf("[faucet] %s is the owner faucet %s", toUserStr, inlineFaucet.ID)
+			ctx.Context = context.WithValue(ctx, "callback_response", Translate(ctx, "sendYourselfMessage"))
+			return ctx, errors.Create(errors.SelfPaymentError)
+		case stderrors.Is(err, commands.ErrFaucetAlreadyTaken):
+			log.Debugf("[faucet] %s:%d already took from faucet %s", toUserStr, to.Telegram.ID, inlineFaucet.ID)
+			ctx.Context = context.WithValue(ctx, "callback_response", Translate(ctx, "inlineFaucetAlreadyTookMessage"))
+			return ctx, errors.Create(errors.UnknownError)
+		case stderrors.Is(err, commands.ErrFaucetNotEligible):
+			ctx.Context = context.WithValue(ctx, "callback_response", Translate(ctx, "faucetRuleDailyMessage"))
+			return ctx, errors.Create(errors.NotActiveError)
+		case err != nil:
 			// bot.trySendMessage(from.Telegram, Translate(ctx, "sendErrorMessage"))
 			errMsg := fmt.Sprintf("[faucet] Transaction failed: %s", err.Error())
 			log.Warnln(errMsg)
@@ -323,11 +362,11 @@ func (bot *TipBot) acceptInlineFaucetHandler(ctx intercept.Context) (intercept.C
 			bot.finishFaucet(ctx, c, inlineFaucet)
 			return ctx, errors.New(errors.UnknownError, err)
 		}
+		if !to.Initialized {
+			inlineFaucet.UserNeedsWallet = true
+		}
 
-		log.Infof("[💸 faucet] Faucet %s from %s to %s:%d (%d sat).", inlineFaucet.ID, fromUserStr, toUserStr, to.Telegram.ID, inlineFaucet.PerUserAmount)
-		inlineFaucet.NTaken += 1
-		inlineFaucet.To = append(inlineFaucet.To, to)
-		inlineFaucet.RemainingAmount = inlineFaucet.RemainingAmount - inlineFaucet.PerUserAmount
+		log.Infof("[💸 faucet] Faucet %s from %s to %s:%d (%d sat).", inlineFaucet.ID, GetUserStr(from.Telegram), toUserStr, to.Telegram.ID, inlineFaucet.PerUserAmount)
 		go func() {
 			to_message := fmt.Sprintf(i18n.Translate(to.Telegram.LanguageCode, "inlineFaucetReceivedMessage"), fromUserStrMd, inlineFaucet.PerUserAmount)
 			ctx.Context = context.WithValue(ctx, "callback_response", to_message)
@@ -341,6 +380,9 @@ func (bot *TipBot) acceptInlineFaucetHandler(ctx intercept.Context) (intercept.C
 		if len(memo) > 0 {
 			inlineFaucet.Message = inlineFaucet.Message + fmt.Sprintf(i18n.Translate(inlineFaucet.LanguageCode, "inlineFaucetAppendMemo"), memo)
 		}
+		if !inlineFaucet.ExpiresAt.IsZero() {
+			inlineFaucet.Message = inlineFaucet.Message + fmt.Sprintf(i18n.Translate(inlineFaucet.LanguageCode, "inlineAppendExpiry"), inlineFaucet.ExpiresAt.UTC().Format("2006-01-02 15:04 UTC"))
+		}
 		if inlineFaucet.UserNeedsWallet {
 			inlineFaucet.Message += "\n\n" + fmt.Sprintf(i18n.Translate(inlineFaucet.LanguageCode, "inlineFaucetCreateWalletMessage"), GetUserStr(bot.Telegram.Me))
 		}
@@ -360,6 +402,35 @@ func (bot *TipBot) acceptInlineFaucetHandler(ctx intercept.Context) (intercept.C
 	return ctx, nil
 }
 
+// claimFaucet transfers the per user amount of the faucet to the user. The caller holds the lock of the
+// faucet, checked its rules and stores the faucet afterwards. The faucet buttons in telegram and faucet
+// claim of the other frontends use it.
+func (bot *TipBot) claimFaucet(inlineFaucet *InlineFaucet, to *lnbits.User) error {
+	if inlineFaucet.From.ID == to.ID {
+		return commands.ErrSelfPayment
+	}
+	for _, a := range inlineFaucet.To {
+		if a.ID == to.ID {
+			return commands.ErrFaucetAlreadyTaken
+		}
+	}
+	if inlineFaucet.RemainingAmount < inlineFaucet.PerUserAmount {
+		return commands.ErrFaucetEmpty
+	}
+	// the daily limit is checked again under the lock of the user's counter
+	if !bot.takeFaucetClaim(inlineFaucet.Rules, to) {
+		return fmt.Errorf("%w: daily limit reached", commands.ErrFaucetNotEligible)
+	}
+	if err := bot.transfer(inlineFaucet.From, to, inlineFaucet.PerUserAmount, "faucet", ""); err != nil {
+		bot.releaseDailyCount(faucetDailyCount, walletLockKey(to))
+		return err
+	}
+	inlineFaucet.NTaken += 1
+	inlineFaucet.To = append(inlineFaucet.To, to)
+	inlineFaucet.RemainingAmount -= inlineFaucet.PerUserAmount
+	return nil
+}
+
 func (bot *TipBot) cancelInlineFaucet(ctx context.Context, c *tb.Callback, ignoreID bool) (context.Context, error) {
 	tx := &InlineFaucet{Base: storage.New(storage.ID(c.Data))}
 	mutex.LockWithContext(ctx, tx.ID)
@@ -404,6 +475,42 @@ func (bot *TipBot) finishFaucet(ctx context.Context, c *tb.Callback, inlineFauce
 	inlineFaucet.Active = false
 }
 
+// closeExpiredFaucet edits the faucet message to its final state and sends the summary to the creator
+func (bot *TipBot) closeExpiredFaucet(to tb.Editable, inlineFaucet *InlineFaucet) {
+	inlineFaucet.Message = fmt.Sprintf(i18n.Translate(inlineFaucet.LanguageCode, "inlineFaucetExpiredMessage"), inlineFaucet.Amount-inlineFaucet.RemainingAmount, inlineFaucet.NTaken)
+	if inlineFaucet.UserNeedsWallet {
+		inlineFaucet.Message += "\n\n" + fmt.Sprintf(i18n.Translate(inlineFaucet.LanguageCode, "inlineFaucetCreateWalletMessage"), GetUserStrMd(bot.Telegram.Me))
+	}
+	bot.tryEditStack(to, inlineFaucet.ID, inlineFaucet.Message, &tb.ReplyMarkup{})
+	log.Infof("[faucet] Faucet %s expired. Remaining: %d sat", inlineFaucet.ID, inlineFaucet.RemainingAmount)
+	once.Remove(inlineFaucet.ID)
+	if inlineFaucet.Active && inlineFaucet.From.Telegram.ID != 0 {
+		bot.trySendMessage(inlineFaucet.From.Telegram, listFaucetTakers(inlineFaucet))
+	}
+	inlineFaucet.Active = false
+}
+
+// startInlineFaucetTimer closes the faucet when it expires
+func (bot *TipBot) startInlineFaucetTimer(inlineFaucet *InlineFaucet) {
+	t := runtime.NewResettableFunction(inlineFaucet.ID,
+		runtime.WithTimer(time.NewTimer(time.Until(inlineFaucet.ExpiresAt))))
+	t.Do(func() {
+		mutex.Lock(inlineFaucet.ID)
+		defer mutex.Unlock(inlineFaucet.ID)
+		tx := &InlineFaucet{Base: storage.New(storage.ID(inlineFaucet.ID))}
+		fn, err := tx.Get(tx, bot.Bunt)
+		if err != nil {
+			return
+		}
+		inlineFaucet := fn.(*InlineFaucet)
+		if !inlineFaucet.Active || inlineFaucet.Editable == nil {
+			return
+		}
+		bot.closeExpiredFaucet(inlineFaucet.Editable, inlineFaucet)
+		runtime.IgnoreError(inlineFaucet.Set(inlineFaucet, bot.Bunt))
+	})
+}
+
 func listFaucetTakers(inlineFaucet *InlineFaucet) string {
 	var to_str string
 	to_str = fmt.Sprintf("🚰 *Faucet summary*\n\nMemo: %s\nCapacity: %d sat\nTakers: %d\nRemaining: %d sat\n\n*Takers:*\n\n", inlineFaucet.Memo, inlineFaucet.Amount, inlineFaucet.NTaken, inlineFaucet.RemainingAmount)
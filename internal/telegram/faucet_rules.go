@@ -0,0 +1,333 @@
+package telegram
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eko/gocache/store"
+	"github.com/massmux/SatsMobiBot/internal/errors"
+	"github.com/massmux/SatsMobiBot/internal/i18n"
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	"github.com/massmux/SatsMobiBot/internal/runtime"
+	"github.com/massmux/SatsMobiBot/internal/runtime/mutex"
+	"github.com/massmux/SatsMobiBot/internal/storage"
+	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/buntdb"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const (
+	faucetRulesMaxAge          = 365 * 24 * time.Hour
+	faucetRulesMaxSignals      = 3
+	faucetRulesMaxDailyClaims  = 100
+	faucetCaptchaSolvedExpiry  = time.Hour
+	faucetCaptchaPendingExpiry = 5 * time.Minute
+	chatMemberSeenCacheExpiry  = 24 * time.Hour
+	faucetCaptchaMaxTerm       = 20
+	faucetCaptchaChoices       = 8
+	faucetDailyCount           = "faucet-claims"
+)
+
+var (
+	faucetCaptchaMenu = &tb.ReplyMarkup{ResizeKeyboard: true}
+	btnFaucetCaptcha  = faucetCaptchaMenu.Data("", "faucet_captcha")
+)
+
+// FaucetRules are the eligibility rules the creator of a faucet can set with flags on /faucet
+type FaucetRules struct {
+	MinWalletAge   time.Duration `json:"min_wallet_age"`
+	MinSignals     int           `json:"min_signals"`      // username, last name and profile photo
+	MinMemberAge   time.Duration `json:"min_member_age"`   // since the bot first saw the user in the chat
+	Captcha        bool          `json:"captcha"`          // users have to solve a captcha before collecting
+	MaxDailyClaims int           `json:"max_daily_claims"` // claims of the user from all faucets on one UTC day
+}
+
+// FaucetCaptcha is a pending captcha challenge of a user for a faucet
+type FaucetCaptcha struct {
+	FaucetID string      `json:"faucet_id"`
+	UserID   int64       `json:"user_id"`
+	Answer   int         `json:"answer"`
+	Message  *tb.Message `json:"message"`
+}
+
+// ChatMemberSeen stores when the bot first saw a user in a group chat
+type ChatMemberSeen struct {
+	*storage.Base
+}
+
+// parseFaucetRules removes the rule flags from the faucet command and returns the rules.
+// Flags: --age=<duration> --signals=<n> --member=<duration> --captcha --daily=<n>
+func parseFaucetRules(text string) (string, FaucetRules, error) {
+	rules := FaucetRules{}
+	words := make([]string, 0)
+	for _, word := range strings.Split(text, " ") {
+		if !strings.HasPrefix(word, "--") {
+			words = append(words, word)
+			continue
+		}
+		flag, value, _ := strings.Cut(strings.TrimPrefix(word, "--"), "=")
+		var err error
+		switch strings.ToLower(flag) {
+		case "age":
+			rules.MinWalletAge, err = ParseDuration(value)
+			if err == nil && rules.MinWalletAge > faucetRulesMaxAge {
+				err = fmt.Errorf("age too long")
+			}
+		case "member":
+			rules.MinMemberAge, err = ParseDuration(value)
+			if err == nil && rules.MinMemberAge > faucetRulesMaxAge {
+				err = fmt.Errorf("membership too long")
+			}
+		case "signals":
+			rules.MinSignals, err = strconv.Atoi(value)
+			if err == nil && (rules.MinSignals < 1 || rules.MinSignals > faucetRulesMaxSignals) {
+				err = fmt.Errorf("invalid number of signals")
+			}
+		case "daily":
+			rules.MaxDailyClaims, err = strconv.Atoi(value)
+			if err == nil && (rules.MaxDailyClaims < 1 || rules.MaxDailyClaims > faucetRulesMaxDailyClaims) {
+				err = fmt.Errorf("invalid daily limit")
+			}
+		case "captcha":
+			rules.Captcha = true
+		default:
+			err = fmt.Errorf("unknown flag %s", flag)
+		}
+		if err != nil {
+			return text, rules, errors.New(errors.InvalidSyntaxError, fmt.Errorf("invalid flag %s: %w", word, err))
+		}
+	}
+	return strings.Join(words, " "), rules, nil
+}
+
+// recordChatMemberSeen remembers when the bot first saw the sender of a group message. The record
+// expires faucetRulesMaxAge after the sender was last seen, no --member rule can ask for longer.
+func (bot TipBot) recordChatMemberSeen(m *tb.Message) {
+	if m == nil || m.Private() || m.Sender == nil || m.Sender.IsBot {
+		return
+	}
+	key := fmt.Sprintf("member-seen:%d:%d", m.Chat.ID, m.Sender.ID)
+	if _, err := bot.Cache.Get(key); err == nil {
+		return
+	}
+	runtime.IgnoreError(bot.Bunt.Update(func(tx *buntdb.Tx) error {
+		value, err := tx.Get(key)
+		if err != nil {
+			b, err := json.Marshal(&ChatMemberSeen{Base: storage.New(storage.ID(key))})
+			if err != nil {
+				return err
+			}
+			value = string(b)
+		}
+		// renews the expiry and keeps the time the member was first seen
+		_, _, err = tx.Set(key, value, &buntdb.SetOptions{Expires: true, TTL: faucetRulesMaxAge})
+		return err
+	}))
+	bot.Cache.Set(key, true, &store.Options{Expiration: chatMemberSeenCacheExpiry})
+}
+
+// chatMemberSeenInterceptor records when the sender of a group message was first seen for the --member rule of faucets
+func (bot TipBot) chatMemberSeenInterceptor(ctx intercept.Context) (intercept.Context, error) {
+	bot.recordChatMemberSeen(ctx.Message())
+	return ctx, nil
+}
+
+// chatMemberSince returns when the bot first saw the user in the chat
+func (bot *TipBot) chatMemberSince(chatID, userID int64) (time.Time, error) {
+	seen := &ChatMemberSeen{Base: storage.New(storage.ID(fmt.Sprintf("member-seen:%d:%d", chatID, userID)))}
+	if err := bot.Bunt.Get(seen); err != nil {
+		return time.Time{}, err
+	}
+	return seen.CreatedAt, nil
+}
+
+// takeFaucetClaim counts a claim of the user for the current UTC day. It returns false
+// if the user already reached the daily limit of the faucet.
+func (bot *TipBot) takeFaucetClaim(rules FaucetRules, user *lnbits.User) bool {
+	limit := rules.MaxDailyClaims
+	if limit == 0 {
+		limit = math.MaxInt32
+	}
+	return bot.takeDailyCount(faucetDailyCount, walletLockKey(user), limit)
+}
+
+// telegramAccountSignals counts the signs of a real Telegram account: a username, a last name and a profile photo
+func (bot *TipBot) telegramAccountSignals(user *tb.User) int {
+	signals := 0
+	if len(user.Username) > 0 {
+		signals++
+	}
+	if len(user.LastName) > 0 {
+		signals++
+	}
+	if photos, err := bot.Telegram.ProfilePhotosOf(user); err == nil && len(photos) > 0 {
+		signals++
+	}
+	return signals
+}
+
+// checkFaucetRules returns the translation key of the first rule the user does not meet, or an empty string.
+// Users who still have to solve the captcha get one in the chat of the faucet.
+func (bot *TipBot) checkFaucetRules(ctx intercept.Context, inlineFaucet *InlineFaucet, user *lnbits.User) string {
+	rule := bot.faucetRuleViolation(inlineFaucet, user)
+	if rule == "faucetRuleCaptchaMessage" {
+		bot.sendFaucetCaptcha(ctx, inlineFaucet, user.Telegram)
+	}
+	return rule
+}
+
+// faucetRuleViolation returns the translation key of the first rule the user does not meet, or an empty
+// string. Wallets without a telegram account, like separate matrix wallets, can't meet the rules about the
+// telegram account, the group membership and the captcha.
+func (bot *TipBot) faucetRuleViolation(inlineFaucet *InlineFaucet, user *lnbits.User) string {
+	rules := inlineFaucet.Rules
+	telegramAccount := user.Telegram != nil && user.Telegram.ID != 0
+	if rules.MaxDailyClaims > 0 && bot.dailyCount(faucetDailyCount, walletLockKey(user)) >= rules.MaxDailyClaims {
+		return "faucetRuleDailyMessage"
+	}
+	if rules.MinWalletAge > 0 && (user.Wallet == nil || user.CreatedAt.IsZero() || time.Since(user.CreatedAt) < rules.MinWalletAge) {
+		return "faucetRuleWalletAgeMessage"
+	}
+	if rules.MinSignals > 0 && (!telegramAccount || bot.telegramAccountSignals(user.Telegram) < rules.MinSignals) {
+		return "faucetRuleSignalsMessage"
+	}
+	if rules.MinMemberAge > 0 {
+		if inlineFaucet.ChatID == 0 || !telegramAccount {
+			return "faucetRuleMemberMessage"
+		}
+		member, err := bot.Telegram.ChatMemberOf(&tb.Chat{ID: inlineFaucet.ChatID}, user.Telegram)
+		if err != nil || member.Role == tb.Left || member.Role == tb.Kicked {
+			return "faucetRuleMemberMessage"
+		}
+		since, err := bot.chatMemberSince(inlineFaucet.ChatID, user.Telegram.ID)
+		if err != nil || time.Since(since) < rules.MinMemberAge {
+			return "faucetRuleMemberMessage"
+		}
+	}
+	if rules.Captcha {
+		if !telegramAccount {
+			return "faucetRuleCaptchaMessage"
+		}
+		for _, id := range inlineFaucet.CaptchaFailed {
+			if id == user.Telegram.ID {
+				return "faucetCaptchaLockedMessage"
+			}
+		}
+		if _, err := bot.Cache.Get(fmt.Sprintf("faucet-captcha-solved:%s:%d", inlineFaucet.ID, user.Telegram.ID)); err != nil {
+			return "faucetRuleCaptchaMessage"
+		}
+	}
+	return ""
+}
+
+// secureIntn returns a uniform random number in [0, n)
+func secureIntn(n int) int {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		panic(err)
+	}
+	return int(i.Int64())
+}
+
+// faucetCaptchaChallenge returns the terms of an addition and the shuffled answer choices, one of them correct
+func faucetCaptchaChallenge() (a, b int, choices []int) {
+	a, b = secureIntn(faucetCaptchaMaxTerm)+1, secureIntn(faucetCaptchaMaxTerm)+1
+	choices = []int{a + b}
+	for len(choices) < faucetCaptchaChoices {
+		choice := secureIntn(2*faucetCaptchaMaxTerm-1) + 2
+		taken := false
+		for _, c := range choices {
+			taken = taken || c == choice
+		}
+		if !taken {
+			choices = append(choices, choice)
+		}
+	}
+	for i := len(choices) - 1; i > 0; i-- {
+		j := secureIntn(i + 1)
+		choices[i], choices[j] = choices[j], choices[i]
+	}
+	return a, b, choices
+}
+
+// sendFaucetCaptcha sends a simple arithmetic challenge with answer buttons to the chat of the faucet
+func (bot *TipBot) sendFaucetCaptcha(ctx intercept.Context, inlineFaucet *InlineFaucet, user *tb.User) {
+	pendingKey := fmt.Sprintf("faucet-captcha-pending:%s:%d", inlineFaucet.ID, user.ID)
+	if _, err := bot.Cache.Get(pendingKey); err == nil {
+		return
+	}
+	a, b, choices := faucetCaptchaChallenge()
+	captcha := &FaucetCaptcha{FaucetID: inlineFaucet.ID, UserID: user.ID, Answer: a + b}
+	id := RandStringRunes(8)
+	buttons := make([]tb.Btn, 0)
+	for _, choice := range choices {
+		buttons = append(buttons, faucetCaptchaMenu.Data(strconv.Itoa(choice), btnFaucetCaptcha.Unique, fmt.Sprintf("%s,%d", id, choice)))
+	}
+	menu := &tb.ReplyMarkup{}
+	menu.Inline(menu.Split(faucetCaptchaChoices/2, buttons)...)
+	var to tb.Recipient = user
+	if c := ctx.Callback(); c != nil && c.Message != nil && c.Message.Chat != nil {
+		to = c.Message.Chat
+	}
+	captcha.Message = bot.trySendMessageEditable(to, fmt.Sprintf(i18n.Translate(inlineFaucet.LanguageCode, "faucetCaptchaMessage"), GetUserStrMd(user), a, b), menu)
+	if captcha.Message == nil {
+		return
+	}
+	bot.Cache.Set(fmt.Sprintf("faucet-captcha:%s", id), captcha, &store.Options{Expiration: faucetCaptchaPendingExpiry})
+	bot.Cache.Set(pendingKey, true, &store.Options{Expiration: faucetCaptchaPendingExpiry})
+}
+
+// faucetCaptchaHandler is invoked when a user answers a faucet captcha.
+// A wrong answer locks the user out of the faucet.
+func (bot *TipBot) faucetCaptchaHandler(ctx intercept.Context) (intercept.Context, error) {
+	c := ctx.Callback()
+	id, choiceStr, ok := strings.Cut(c.Data, ",")
+	choice, err := strconv.Atoi(choiceStr)
+	if !ok || err != nil {
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	cached, err := bot.Cache.Get(fmt.Sprintf("faucet-captcha:%s", id))
+	if err != nil {
+		bot.tryDeleteMessage(c.Message)
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	captcha := cached.(*FaucetCaptcha)
+	if captcha.UserID != c.Sender.ID {
+		ctx.Context = context.WithValue(ctx, "callback_response", Translate(ctx, "faucetCaptchaNotYoursMessage"))
+		return ctx, errors.Create(errors.UnknownError)
+	}
+	runtime.IgnoreError(bot.Cache.Delete(fmt.Sprintf("faucet-captcha:%s", id)))
+	runtime.IgnoreError(bot.Cache.Delete(fmt.Sprintf("faucet-captcha-pending:%s:%d", captcha.FaucetID, captcha.UserID)))
+	bot.tryDeleteMessage(captcha.Message)
+	if choice != captcha.Answer {
+		log.Infof("[faucet] %s failed the captcha of faucet %s", GetUserStr(c.Sender), captcha.FaucetID)
+		bot.lockFaucetCaptcha(captcha)
+		ctx.Context = context.WithValue(ctx, "callback_response", Translate(ctx, "faucetCaptchaWrongMessage"))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	bot.Cache.Set(fmt.Sprintf("faucet-captcha-solved:%s:%d", captcha.FaucetID, captcha.UserID), true, &store.Options{Expiration: faucetCaptchaSolvedExpiry})
+	ctx.Context = context.WithValue(ctx, "callback_response", Translate(ctx, "faucetCaptchaSolvedMessage"))
+	return ctx, nil
+}
+
+// lockFaucetCaptcha remembers that the user failed the captcha of the faucet
+func (bot *TipBot) lockFaucetCaptcha(captcha *FaucetCaptcha) {
+	mutex.Lock(captcha.FaucetID)
+	defer mutex.Unlock(captcha.FaucetID)
+	tx := &InlineFaucet{Base: storage.New(storage.ID(captcha.FaucetID))}
+	fn, err := tx.Get(tx, bot.Bunt)
+	if err != nil {
+		return
+	}
+	inlineFaucet := fn.(*InlineFaucet)
+	inlineFaucet.CaptchaFailed = append(inlineFaucet.CaptchaFailed, captcha.UserID)
+	runtime.IgnoreError(inlineFaucet.Set(inlineFaucet, bot.Bunt))
+}
@@ -0,0 +1,170 @@
+package telegram
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/massmux/SatsMobiBot/internal/runtime/once"
+	"github.com/tidwall/buntdb"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func Test_parseFaucetRules(t *testing.T) {
+	tests := []struct {
+		text      string
+		wantText  string
+		wantRules FaucetRules
+		wantErr   bool
+	}{
+		{text: "/faucet 1000 100", wantText: "/faucet 1000 100"},
+		{text: "/faucet 1000 100 --captcha", wantText: "/faucet 1000 100", wantRules: FaucetRules{Captcha: true}},
+		{text: "/faucet --age=7d 1000 100 memo", wantText: "/faucet 1000 100 memo", wantRules: FaucetRules{MinWalletAge: 7 * 24 * time.Hour}},
+		{text: "/faucet 1000 100 --member=12h --signals=2", wantText: "/faucet 1000 100", wantRules: FaucetRules{MinMemberAge: 12 * time.Hour, MinSignals: 2}},
+		{text: "/faucet 1000 100 --DAILY=3 --Captcha", wantText: "/faucet 1000 100", wantRules: FaucetRules{MaxDailyClaims: 3, Captcha: true}},
+		{text: "/faucet 1000 100 --daily=0", wantErr: true},
+		{text: "/faucet 1000 100 --daily=101", wantErr: true},
+		{text: "/faucet 1000 100 --daily=many", wantErr: true},
+		{text: "/faucet 1000 100 --signals=4", wantErr: true},
+		{text: "/faucet 1000 100 --signals=0", wantErr: true},
+		{text: "/faucet 1000 100 --age=400d", wantErr: true},
+		{text: "/faucet 1000 100 --member=soon", wantErr: true},
+		{text: "/faucet 1000 100 --age", wantErr: true},
+		{text: "/faucet 1000 100 --vip", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			text, rules, err := parseFaucetRules(tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseFaucetRules() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if text != tt.wantText {
+				t.Errorf("parseFaucetRules() text = %q, want %q", text, tt.wantText)
+			}
+			if rules != tt.wantRules {
+				t.Errorf("parseFaucetRules() rules = %+v, want %+v", rules, tt.wantRules)
+			}
+		})
+	}
+}
+
+func Test_faucetCaptchaChallenge(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		a, b, choices := faucetCaptchaChallenge()
+		if a < 1 || b < 1 || a > faucetCaptchaMaxTerm || b > faucetCaptchaMaxTerm {
+			t.Fatalf("faucetCaptchaChallenge() terms %d and %d out of range", a, b)
+		}
+		if len(choices) != faucetCaptchaChoices {
+			t.Fatalf("faucetCaptchaChallenge() returned %d choices, want %d", len(choices), faucetCaptchaChoices)
+		}
+		seen := make(map[int]bool)
+		for _, choice := range choices {
+			if seen[choice] {
+				t.Fatalf("faucetCaptchaChallenge() choices %v are not distinct", choices)
+			}
+			seen[choice] = true
+		}
+		if !seen[a+b] {
+			t.Fatalf("faucetCaptchaChallenge() choices %v miss the answer %d", choices, a+b)
+		}
+	}
+}
+
+func TestTipBot_faucetCaptchaHandler(t *testing.T) {
+	test := newTestBot(t)
+	creator := test.addUser(t, 2, 1000)
+	taker := test.addUser(t, 3, 0)
+	chat := &tb.Chat{ID: -700, Type: tb.ChatGroup}
+	faucet, err := test.createFaucet(test.message(creator, chat, ""), "/faucet 100 10 --captcha", creator.Telegram)
+	if err != nil {
+		t.Fatalf("createFaucet() error = %v", err)
+	}
+	if err := faucet.Set(faucet, test.Bunt); err != nil {
+		t.Fatal(err)
+	}
+	// the first press sends the captcha and releases the once guard
+	ctx, err := test.singletonCallbackInterceptor(test.callback(taker, chat, faucet.ID))
+	if err != nil {
+		t.Fatalf("singletonCallbackInterceptor() error = %v", err)
+	}
+	if _, err := test.acceptInlineFaucetHandler(ctx); err == nil {
+		t.Fatalf("acceptInlineFaucetHandler() paid without captcha")
+	}
+	if err := once.Once(faucet.ID, strconv.FormatInt(taker.Telegram.ID, 10)); err != nil {
+		t.Fatalf("once guard was not released after the captcha was sent")
+	}
+	once.Release(faucet.ID, strconv.FormatInt(taker.Telegram.ID, 10))
+	captchaID := ""
+	for _, key := range test.cacheKeys("faucet-captcha:") {
+		captchaID = strings.TrimPrefix(key, "faucet-captcha:")
+	}
+	cached, err := test.Cache.Get("faucet-captcha:" + captchaID)
+	if err != nil {
+		t.Fatalf("captcha was not sent")
+	}
+	captcha := cached.(*FaucetCaptcha)
+	if _, err := test.faucetCaptchaHandler(test.callback(creator, chat, fmt.Sprintf("%s,%d", captchaID, captcha.Answer))); err == nil {
+		t.Fatalf("faucetCaptchaHandler() accepted the answer of another user")
+	}
+	if _, err := test.faucetCaptchaHandler(test.callback(taker, chat, fmt.Sprintf("%s,%d", captchaID, captcha.Answer+1))); err == nil {
+		t.Fatalf("faucetCaptchaHandler() accepted a wrong answer")
+	}
+	// a wrong answer locks the user out of the faucet
+	if _, err := test.acceptInlineFaucetHandler(test.callback(taker, chat, faucet.ID)); err == nil {
+		t.Fatalf("acceptInlineFaucetHandler() paid after a wrong captcha answer")
+	}
+	if _, err := test.Cache.Get(fmt.Sprintf("faucet-captcha-pending:%s:%d", faucet.ID, taker.Telegram.ID)); err == nil {
+		t.Errorf("a new captcha was sent after a wrong answer")
+	}
+	if got := test.balance(3); got != 0 {
+		t.Errorf("taker balance = %d, want 0", got)
+	}
+}
+
+func TestTipBot_recordChatMemberSeen(t *testing.T) {
+	test := newTestBot(t)
+	user := test.addUser(t, 2, 0)
+	chat := &tb.Chat{ID: -700, Type: tb.ChatGroup}
+	key := fmt.Sprintf("member-seen:%d:%d", chat.ID, user.Telegram.ID)
+
+	// /rain only remembers the activity in memory
+	test.recordGroupActivity(test.message(user, chat, "hello").Message())
+	if _, err := test.chatMemberSince(chat.ID, user.Telegram.ID); err == nil {
+		t.Fatalf("recordGroupActivity() stored the member")
+	}
+
+	test.recordChatMemberSeen(test.message(user, chat, "hello").Message())
+	first, err := test.chatMemberSince(chat.ID, user.Telegram.ID)
+	if err != nil {
+		t.Fatalf("chatMemberSince() error = %v", err)
+	}
+	ttl := func() (ttl time.Duration) {
+		test.Bunt.View(func(tx *buntdb.Tx) error {
+			ttl, err = tx.TTL(key)
+			return err
+		})
+		return ttl
+	}
+	if got := ttl(); got <= faucetRulesMaxAge-time.Minute || got > faucetRulesMaxAge {
+		t.Errorf("TTL = %s, want %s", got, faucetRulesMaxAge)
+	}
+	// seeing the member again renews the expiry and keeps the first time
+	test.Bunt.Update(func(tx *buntdb.Tx) error {
+		value, _ := tx.Get(key)
+		_, _, err := tx.Set(key, value, &buntdb.SetOptions{Expires: true, TTL: time.Hour})
+		return err
+	})
+	test.cache.Flush()
+	test.recordChatMemberSeen(test.message(user, chat, "hello").Message())
+	if since, err := test.chatMemberSince(chat.ID, user.Telegram.ID); err != nil || !since.Equal(first) {
+		t.Errorf("chatMemberSince() = %s, %v, want %s", since, err, first)
+	}
+	if got := ttl(); got <= faucetRulesMaxAge-time.Minute {
+		t.Errorf("TTL = %s after the member was seen again, want %s", got, faucetRulesMaxAge)
+	}
+}
@@ -31,5 +31,8 @@ func (bot *TipBot) fileHandler(ctx intercept.Context) (intercept.Context, error)
 
 		return c(ctx)
 	}
+	if isBulkPayoutDocument(m.Document) {
+		return bot.bulkPayoutFileHandler(ctx)
+	}
 	return ctx, errors.Create(errors.NoFileFoundError)
 }
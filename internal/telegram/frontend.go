@@ -0,0 +1,297 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	lnurl "github.com/fiatjaf/go-lnurl"
+	"github.com/massmux/SatsMobiBot/internal/commands"
+	db "github.com/massmux/SatsMobiBot/internal/database"
+	"github.com/massmux/SatsMobiBot/internal/errors"
+	"github.com/massmux/SatsMobiBot/internal/i18n"
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	"github.com/massmux/SatsMobiBot/internal/matrix"
+	"github.com/massmux/SatsMobiBot/internal/runtime"
+	"github.com/massmux/SatsMobiBot/internal/runtime/mutex"
+	"github.com/massmux/SatsMobiBot/internal/storage"
+	"github.com/massmux/SatsMobiBot/internal/str"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+// TelegramFrontend is the frontend of users that are identified by their telegram id
+const TelegramFrontend = "telegram"
+
+// CommandUser returns the command layer user of a telegram wallet or of a separate matrix wallet
+func CommandUser(user *lnbits.User) commands.User {
+	if isMatrixWallet(user) {
+		return matrix.User(strings.TrimPrefix(user.Name, matrixWalletPrefix))
+	}
+	return commands.User{
+		Frontend:     TelegramFrontend,
+		ID:           strconv.FormatInt(user.Telegram.ID, 10),
+		Name:         GetUserStr(user.Telegram),
+		LanguageCode: user.Telegram.LanguageCode,
+	}
+}
+
+// telegramUser returns the telegram user of a command layer user
+func telegramUser(user commands.User) (*tb.User, error) {
+	if user.Frontend != TelegramFrontend {
+		return nil, fmt.Errorf("user %s is not a telegram user", user)
+	}
+	id, err := strconv.ParseInt(user.ID, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &tb.User{ID: id, Username: strings.TrimPrefix(user.Name, "@"), LanguageCode: user.LanguageCode}, nil
+}
+
+// TelegramMessenger delivers messages of the command layer to telegram users
+type TelegramMessenger struct {
+	bot *TipBot
+}
+
+func (m TelegramMessenger) Reply(msg commands.Message, text string) error {
+	return m.Notify(msg.From, text)
+}
+
+func (m TelegramMessenger) Notify(user commands.User, text string) error {
+	to, err := telegramUser(user)
+	if err != nil {
+		return err
+	}
+	m.bot.trySendMessage(to, str.MarkdownEscape(text))
+	return nil
+}
+
+// WalletService is the command layer service of the telegram wallets. Other frontends
+// use it to act on telegram wallets, for example nostr DMs of linked accounts. The telegram
+// chat handlers don't go through it or the commands.Dispatcher, they only share these helpers
+// with it: transfer for sends, tips and faucets, faucetRuleViolation and claimFaucet for faucet
+// claims, createInvoiceWithEvent for invoices and checkInvoicePayment and payInvoice for payments.
+type WalletService struct {
+	bot *TipBot
+}
+
+func (bot *TipBot) WalletService() WalletService {
+	return WalletService{bot: bot}
+}
+
+// CommandDispatcher returns a dispatcher for the telegram wallets that answers through the messenger
+func (bot *TipBot) CommandDispatcher(messenger commands.Messenger) *commands.Dispatcher {
+	return &commands.Dispatcher{
+		Service:     bot.WalletService(),
+		Messenger:   messenger,
+		Translate:   i18n.Translate,
+		ParseAmount: GetAmount,
+	}
+}
+
+// wallet loads the wallet of the user. Receivers without a wallet get one if create is true.
+func (s WalletService) wallet(user commands.User, create bool) (*lnbits.User, error) {
+	if user.Frontend == matrix.Frontend {
+		return s.bot.matrixWallet(user.ID, create)
+	}
+	tgUser, err := telegramUser(user)
+	if err != nil {
+		return nil, err
+	}
+	wallet, err := GetLnbitsUser(tgUser, *s.bot)
+	if err == nil && wallet.Wallet != nil {
+		return wallet, nil
+	}
+	if !create {
+		return nil, commands.ErrNoWallet
+	}
+	return s.bot.CreateWalletForTelegramUser(tgUser)
+}
+
+// CreateWallet creates the wallet of the user on start
+func (s WalletService) CreateWallet(user commands.User) error {
+	_, err := s.wallet(user, true)
+	return err
+}
+
+func (s WalletService) Balance(user commands.User) (int64, error) {
+	wallet, err := s.wallet(user, false)
+	if err != nil {
+		return 0, err
+	}
+	return s.bot.GetUserBalance(wallet)
+}
+
+func (s WalletService) Invoice(user commands.User, amount int64, memo string) (string, error) {
+	wallet, err := s.wallet(user, false)
+	if err != nil {
+		return "", err
+	}
+	if isMatrixWallet(wallet) {
+		// separate matrix wallets have no telegram chat for the payment notification
+		invoice, err := wallet.Wallet.Invoice(lnbits.InvoiceParams{Out: false, Amount: amount, Memo: memo}, s.bot.Client)
+		if err != nil {
+			return "", err
+		}
+		return invoice.PaymentRequest, nil
+	}
+	ctx := context.WithValue(context.Background(), "publicLanguageCode", user.LanguageCode)
+	invoice, err := s.bot.createInvoiceWithEvent(ctx, wallet, amount, memo, "", InvoiceCallbackGeneric, "")
+	if err != nil {
+		return "", err
+	}
+	return invoice.PaymentRequest, nil
+}
+
+func (s WalletService) Pay(user commands.User, invoice string) (int64, error) {
+	wallet, err := s.wallet(user, false)
+	if err != nil {
+		return 0, err
+	}
+	mutex.Lock(walletLockKey(wallet))
+	defer mutex.Unlock(walletLockKey(wallet))
+	paymentRequest := strings.TrimPrefix(strings.ToLower(invoice), "lightning:")
+	bolt11, _, err := s.bot.checkInvoicePayment(wallet, paymentRequest)
+	if e, ok := err.(errors.TipBotError); ok {
+		switch e.Code {
+		case errors.InvalidSyntaxError, errors.InvalidAmountError:
+			return 0, commands.ErrInvalidInvoice
+		case errors.BalanceToLowError:
+			return 0, commands.ErrBalanceTooLow
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+	// the payment is stored like a confirmed /pay
+	amount := int64(bolt11.MSatoshi / 1000)
+	payData := &PayData{
+		Base:          storage.New(storage.ID(fmt.Sprintf("pay:%s-%d-%s", walletLockKey(wallet), amount, RandStringRunes(5)))),
+		From:          wallet,
+		Invoice:       paymentRequest,
+		Amount:        amount,
+		Memo:          bolt11.Description,
+		LanguageCode:  user.LanguageCode,
+		SuccessAction: &lnurl.SuccessAction{},
+	}
+	runtime.IgnoreError(payData.Set(payData, s.bot.Bunt))
+	if err = s.bot.payInvoice(wallet, payData); err != nil {
+		return 0, err
+	}
+	log.Infof("[%s] %s paid invoice of %d sat", user.Frontend, GetUserStr(wallet.Telegram), amount)
+	return amount, nil
+}
+
+// Resolve returns the wallet of a @username, a matrix id or a nostr pubkey that was linked with /nostr link
+func (s WalletService) Resolve(from commands.User, recipient string) (commands.User, error) {
+	var to *lnbits.User
+	var err error
+	if strings.HasPrefix(recipient, "@") && strings.Contains(recipient, ":") {
+		return matrix.User(recipient), nil
+	} else if strings.HasPrefix(recipient, "npub") {
+		prefix, pubkey, decodeErr := nip19.Decode(recipient)
+		if decodeErr != nil || prefix != "npub" {
+			return commands.User{}, commands.ErrUnknownRecipient
+		}
+		to, err = db.FindUserByNostrPubkey(s.bot.DB.Users, pubkey.(string))
+	} else {
+		to, err = GetUserByTelegramUsername(strings.TrimPrefix(recipient, "@"), *s.bot)
+	}
+	if err != nil {
+		return commands.User{}, commands.ErrUnknownRecipient
+	}
+	return CommandUser(to), nil
+}
+
+func (s WalletService) Transfer(from commands.User, to commands.User, amount int64, memo string, kind string) error {
+	fromWallet, err := s.wallet(from, false)
+	if err != nil {
+		return err
+	}
+	toWallet, err := s.wallet(to, true)
+	if err != nil {
+		return err
+	}
+	mutex.Lock(walletLockKey(fromWallet))
+	defer mutex.Unlock(walletLockKey(fromWallet))
+	return s.bot.transfer(fromWallet, toWallet, amount, kind, memo)
+}
+
+// walletLockKey is the mutex key of a wallet. Telegram wallets use the key of the lockInterceptor.
+func walletLockKey(user *lnbits.User) string {
+	if user.Telegram != nil && user.Telegram.ID != 0 {
+		return strconv.FormatInt(user.Telegram.ID, 10)
+	}
+	return user.Name
+}
+
+// CreateFaucet stores a faucet without a telegram message. It is claimed with faucet claim <id>.
+func (s WalletService) CreateFaucet(from commands.User, amount int64, perUserAmount int64) (commands.Faucet, error) {
+	wallet, err := s.wallet(from, false)
+	if err != nil {
+		return commands.Faucet{}, err
+	}
+	balance, err := s.bot.GetUserBalance(wallet)
+	if err != nil {
+		return commands.Faucet{}, err
+	}
+	if balance < amount {
+		return commands.Faucet{}, commands.ErrBalanceTooLow
+	}
+	id := fmt.Sprintf("faucet:%s:%d", RandStringRunes(10), amount)
+	inlineFaucet := &InlineFaucet{
+		Base:            storage.New(storage.ID(id)),
+		Amount:          amount,
+		From:            wallet,
+		PerUserAmount:   perUserAmount,
+		NTotal:          int(amount / perUserAmount),
+		RemainingAmount: amount,
+		LanguageCode:    from.LanguageCode,
+	}
+	runtime.IgnoreError(inlineFaucet.Set(inlineFaucet, s.bot.Bunt))
+	log.Infof("[faucet] %s created faucet %s via %s", GetUserStr(wallet.Telegram), id, from.Frontend)
+	return commandFaucet(inlineFaucet), nil
+}
+
+func (s WalletService) ClaimFaucet(user commands.User, id string) (commands.Faucet, error) {
+	to, err := s.wallet(user, true)
+	if err != nil {
+		return commands.Faucet{}, err
+	}
+	inlineFaucet := &InlineFaucet{Base: storage.New(storage.ID(id))}
+	mutex.Lock(inlineFaucet.ID)
+	defer mutex.Unlock(inlineFaucet.ID)
+	if err = s.bot.Bunt.Get(inlineFaucet); err != nil || !inlineFaucet.Active {
+		return commands.Faucet{}, commands.ErrFaucetNotFound
+	}
+	if !inlineFaucet.ExpiresAt.IsZero() && time.Now().After(inlineFaucet.ExpiresAt) {
+		return commands.Faucet{}, commands.ErrFaucetNotFound
+	}
+	// the rules of the creator apply to all frontends. Rules that need telegram, like the captcha,
+	// can only be met by linked accounts that already met them in telegram.
+	if rule := s.bot.faucetRuleViolation(inlineFaucet, to); len(rule) > 0 {
+		log.Infof("[faucet] %s is not eligible for faucet %s via %s: %s", GetUserStr(to.Telegram), inlineFaucet.ID, user.Frontend, rule)
+		return commands.Faucet{}, fmt.Errorf("%w: %s", commands.ErrFaucetNotEligible, rule)
+	}
+	if err = s.bot.claimFaucet(inlineFaucet, to); err != nil {
+		return commands.Faucet{}, err
+	}
+	if inlineFaucet.RemainingAmount < inlineFaucet.PerUserAmount {
+		inlineFaucet.Active = false
+	}
+	runtime.IgnoreError(inlineFaucet.Set(inlineFaucet, s.bot.Bunt))
+	return commandFaucet(inlineFaucet), nil
+}
+
+func commandFaucet(inlineFaucet *InlineFaucet) commands.Faucet {
+	return commands.Faucet{
+		ID:              inlineFaucet.ID,
+		From:            CommandUser(inlineFaucet.From),
+		Amount:          inlineFaucet.Amount,
+		RemainingAmount: inlineFaucet.RemainingAmount,
+		PerUserAmount:   inlineFaucet.PerUserAmount,
+	}
+}
@@ -0,0 +1,123 @@
+package telegram
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/massmux/SatsMobiBot/internal/commands"
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	"github.com/massmux/SatsMobiBot/internal/matrix"
+	"github.com/massmux/SatsMobiBot/internal/storage"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+// createTestFaucet creates a faucet of the user with the rules through the WalletService
+func createTestFaucet(t *testing.T, test *testBot, from *lnbits.User, rules FaucetRules) commands.Faucet {
+	faucet, err := test.WalletService().CreateFaucet(CommandUser(from), 300, 100)
+	if err != nil {
+		t.Fatalf("CreateFaucet() error = %v", err)
+	}
+	inlineFaucet := &InlineFaucet{Base: storage.New(storage.ID(faucet.ID))}
+	if err = test.Bunt.Get(inlineFaucet); err != nil {
+		t.Fatal(err)
+	}
+	inlineFaucet.Rules = rules
+	if err = inlineFaucet.Set(inlineFaucet, test.Bunt); err != nil {
+		t.Fatal(err)
+	}
+	return faucet
+}
+
+func TestWalletService_ClaimFaucet(t *testing.T) {
+	test := newTestBot(t)
+	service := test.WalletService()
+	creator := test.addUser(t, 2, 2000)
+	alice := test.addUser(t, 3, 0)
+	mxid := "@bob:example.org"
+	bob := &lnbits.User{
+		ID:       "lnbitsbob",
+		Name:     matrixWalletPrefix + mxid,
+		Telegram: &tb.User{FirstName: mxid},
+		Wallet:   test.lnbits.newWallet("walletbob", 0),
+	}
+	if err := test.DB.Users.Create(bob).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	faucet := createTestFaucet(t, test, creator, FaucetRules{})
+	if _, err := service.ClaimFaucet(CommandUser(creator), faucet.ID); !errors.Is(err, commands.ErrSelfPayment) {
+		t.Errorf("ClaimFaucet() of the creator error = %v", err)
+	}
+	if _, err := service.ClaimFaucet(CommandUser(alice), faucet.ID); err != nil {
+		t.Fatalf("ClaimFaucet() error = %v", err)
+	}
+	if _, err := service.ClaimFaucet(CommandUser(alice), faucet.ID); !errors.Is(err, commands.ErrFaucetAlreadyTaken) {
+		t.Errorf("second ClaimFaucet() error = %v", err)
+	}
+	if got := test.balance(3); got != 100 {
+		t.Errorf("balance = %d, want 100", got)
+	}
+
+	// the daily limit counts the claims of separate matrix wallets too
+	daily := FaucetRules{MaxDailyClaims: 1}
+	first, second := createTestFaucet(t, test, creator, daily), createTestFaucet(t, test, creator, daily)
+	if _, err := service.ClaimFaucet(matrix.User(mxid), first.ID); err != nil {
+		t.Fatalf("ClaimFaucet() of the matrix wallet error = %v", err)
+	}
+	if _, err := service.ClaimFaucet(matrix.User(mxid), second.ID); !errors.Is(err, commands.ErrFaucetNotEligible) {
+		t.Errorf("ClaimFaucet() over the daily limit error = %v", err)
+	}
+	if got := test.lnbits.balance("walletbob"); got != 100 {
+		t.Errorf("balance of the matrix wallet = %d, want 100", got)
+	}
+
+	// the captcha has to be solved in telegram, which wallets without telegram account can't
+	captcha := createTestFaucet(t, test, creator, FaucetRules{Captcha: true})
+	if _, err := service.ClaimFaucet(CommandUser(alice), captcha.ID); !errors.Is(err, commands.ErrFaucetNotEligible) {
+		t.Errorf("ClaimFaucet() without solved captcha error = %v", err)
+	}
+	test.Cache.Set(fmt.Sprintf("faucet-captcha-solved:%s:%d", captcha.ID, alice.Telegram.ID), true, nil)
+	if _, err := service.ClaimFaucet(CommandUser(alice), captcha.ID); err != nil {
+		t.Errorf("ClaimFaucet() with solved captcha error = %v", err)
+	}
+	if _, err := service.ClaimFaucet(matrix.User(mxid), captcha.ID); !errors.Is(err, commands.ErrFaucetNotEligible) {
+		t.Errorf("ClaimFaucet() of the matrix wallet with captcha error = %v", err)
+	}
+}
+
+func TestTipBot_transfer(t *testing.T) {
+	test := newTestBot(t)
+	alice := test.addUser(t, 2, 100)
+	bob := test.addUser(t, 3, 0)
+	if err := test.transfer(alice, alice, 10, "send", ""); !errors.Is(err, commands.ErrSelfPayment) {
+		t.Errorf("transfer() to self error = %v", err)
+	}
+	if err := test.transfer(alice, bob, 200, "send", ""); !errors.Is(err, commands.ErrBalanceTooLow) {
+		t.Errorf("transfer() over the balance error = %v", err)
+	}
+	if err := test.transfer(alice, bob, 60, "tip", "thanks"); err != nil {
+		t.Fatalf("transfer() error = %v", err)
+	}
+	if test.balance(2) != 40 || test.balance(3) != 60 {
+		t.Errorf("balances = %d, %d, want 40, 60", test.balance(2), test.balance(3))
+	}
+	transaction := &Transaction{}
+	if err := test.DB.Transactions.Where("success = ?", true).First(transaction).Error; err != nil {
+		t.Fatal(err)
+	}
+	if transaction.Type != "tip" || transaction.Memo != "🏅 Tip from @user2 to @user3. ✉️ thanks" {
+		t.Errorf("transaction = %s %q", transaction.Type, transaction.Memo)
+	}
+	// the telegram handlers keep the memo of the sender out of the transaction memo
+	if err := test.transfer(bob, alice, 10, "send", ""); err != nil {
+		t.Fatalf("transfer() error = %v", err)
+	}
+	transaction = &Transaction{}
+	if err := test.DB.Transactions.Where("success = ? AND type = ?", true, "send").First(transaction).Error; err != nil {
+		t.Fatal(err)
+	}
+	if transaction.Memo != "💸 Send from @user3 to @user2." {
+		t.Errorf("transaction memo = %q", transaction.Memo)
+	}
+}
@@ -0,0 +1,295 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/massmux/SatsMobiBot/internal"
+	"github.com/massmux/SatsMobiBot/internal/i18n"
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	"github.com/massmux/SatsMobiBot/internal/str"
+	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	"github.com/skip2/go-qrcode"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+// GroupMember tracks until when a member has paid the recurring fee of a group
+type GroupMember struct {
+	GroupID      int64     `json:"group_id" gorm:"primaryKey;autoIncrement:false"`
+	UserID       int64     `json:"user_id" gorm:"primaryKey;autoIncrement:false"`
+	Username     string    `json:"username"`
+	PaidUntil    time.Time `json:"paid_until"`
+	ReminderSent bool      `json:"reminder_sent"`
+	Removed      bool      `json:"removed"`
+}
+
+const (
+	groupMembershipCheckInterval  = time.Hour
+	groupMembershipReminderBefore = 3 * 24 * time.Hour
+	groupMembershipDefaultGrace   = 3 // days
+	groupMembershipRenewalMemo    = "🎟 Membership renewal for group %s"
+)
+
+// isRecurring returns true if the ticket has to be paid every period
+func (ticket *Ticket) isRecurring() bool {
+	return ticket != nil && ticket.Price > 0 && ticket.Period > 0
+}
+
+// parseTicketPeriod parses the period of a recurring ticket and returns it in days.
+// accepts "weekly", "monthly", "yearly" or a number of days like "30d".
+func parseTicketPeriod(period string) (int64, error) {
+	switch strings.ToLower(period) {
+	case "weekly", "week":
+		return 7, nil
+	case "monthly", "month":
+		return 30, nil
+	case "yearly", "year":
+		return 365, nil
+	}
+	days, err := strconv.ParseInt(strings.TrimSuffix(strings.ToLower(period), "d"), 10, 64)
+	if err != nil || days < 1 || days > 365 {
+		return 0, fmt.Errorf("invalid period: %s", period)
+	}
+	return days, nil
+}
+
+// nextPaidUntil adds one period of days to a membership. An expired membership starts again now.
+func nextPaidUntil(paidUntil time.Time, now time.Time, period int64) time.Time {
+	start := now
+	if paidUntil.After(start) {
+		start = paidUntil
+	}
+	return start.Add(time.Duration(period) * 24 * time.Hour)
+}
+
+// extendGroupMembership adds one period to the paid-until date of a group member
+func (bot *TipBot) extendGroupMembership(group *Group, member *tb.User) *GroupMember {
+	if !group.Ticket.isRecurring() || member == nil {
+		return nil
+	}
+	groupMember := &GroupMember{GroupID: group.ID, UserID: member.ID}
+	bot.DB.Groups.Where(groupMember).First(groupMember)
+	groupMember.Username = GetUserStr(member)
+	groupMember.PaidUntil = nextPaidUntil(groupMember.PaidUntil, time.Now(), group.Ticket.Period)
+	groupMember.ReminderSent = false
+	groupMember.Removed = false
+	bot.DB.Groups.Save(groupMember)
+	log.Infof("[group] %s paid membership of group %s until %s", groupMember.Username, group.Name, groupMember.PaidUntil.Format("2006-01-02"))
+	return groupMember
+}
+
+// startGroupMembershipWorker periodically checks all recurring memberships
+func (bot *TipBot) startGroupMembershipWorker() {
+	ticker := time.NewTicker(groupMembershipCheckInterval)
+	for {
+		bot.checkGroupMemberships()
+		<-ticker.C
+	}
+}
+
+// checkGroupMemberships sends renewal invoices before a membership expires and removes
+// members from the group after the grace period.
+func (bot *TipBot) checkGroupMemberships() {
+	var groups []Group
+	tx := bot.DB.Groups.Where("ticket_period > 0 AND ticket_price > 0").Find(&groups)
+	if tx.Error != nil {
+		log.Errorf("[checkGroupMemberships] %s", tx.Error.Error())
+		return
+	}
+	for i := range groups {
+		group := &groups[i]
+		var members []GroupMember
+		bot.DB.Groups.Where("group_id = ? AND removed = ?", group.ID, false).Find(&members)
+		for j := range members {
+			member := &members[j]
+			grace := time.Duration(group.Ticket.GracePeriod) * 24 * time.Hour
+			switch {
+			case time.Now().After(member.PaidUntil.Add(grace)):
+				bot.removeUnpaidGroupMember(group, member)
+			case time.Now().After(member.PaidUntil.Add(-groupMembershipReminderBefore)) && !member.ReminderSent:
+				if err := bot.sendGroupMembershipRenewal(group, member.UserID); err != nil {
+					log.Errorf("[checkGroupMemberships] %s", err.Error())
+					continue
+				}
+				member.ReminderSent = true
+				bot.DB.Groups.Save(member)
+			}
+		}
+	}
+}
+
+// removeUnpaidGroupMember kicks a member who did not renew the membership. The member can join again after paying.
+func (bot *TipBot) removeUnpaidGroupMember(group *Group, member *GroupMember) {
+	chat := &tb.Chat{ID: group.ID}
+	// admins and the creator are never removed. If the admins are unknown, try again later.
+	admins, err := bot.Telegram.AdminsOf(chat)
+	if err != nil {
+		log.Errorf("[removeUnpaidGroupMember] could not get admins of group %s: %s", group.Name, err.Error())
+		return
+	}
+	for _, admin := range admins {
+		if admin.User != nil && admin.User.ID == member.UserID {
+			log.Debugf("[removeUnpaidGroupMember] %s is admin of group %s", member.Username, group.Name)
+			return
+		}
+	}
+	err = bot.Telegram.Ban(chat, &tb.ChatMember{User: &tb.User{ID: member.UserID}})
+	if err != nil {
+		log.Errorf("[removeUnpaidGroupMember] could not remove %s from group %s: %s", member.Username, group.Name, err.Error())
+		return
+	}
+	// unban immediately so that the member can join again after paying
+	err = bot.Telegram.Unban(chat, &tb.User{ID: member.UserID})
+	if err != nil {
+		log.Errorf("[removeUnpaidGroupMember] %s", err.Error())
+	}
+	member.Removed = true
+	bot.DB.Groups.Save(member)
+	log.Infof("[group] removed %s from group %s (membership expired %s)", member.Username, group.Name, member.PaidUntil.Format("2006-01-02"))
+	lang := bot.groupMemberLanguageCode(member.UserID)
+	bot.trySendMessage(&tb.User{ID: member.UserID}, fmt.Sprintf(i18n.Translate(lang, "groupMembershipRemovedMessage"), str.MarkdownEscape(group.Title), group.Name))
+}
+
+// sendGroupMembershipRenewal sends a renewal invoice to the member via DM
+func (bot *TipBot) sendGroupMembershipRenewal(group *Group, userID int64) error {
+	lang := bot.groupMemberLanguageCode(userID)
+	// users without a wallet can still pay the invoice
+	payer, _ := GetLnbitsUser(&tb.User{ID: userID}, *bot)
+	ctx := context.WithValue(context.Background(), "publicLanguageCode", lang)
+	memo := fmt.Sprintf(groupMembershipRenewalMemo, group.Name)
+	invoiceEvent, err := bot.createGroupTicketInvoice(ctx, payer, group, memo, InvoiceCallbackGroupMembershipRenewal, fmt.Sprintf("%d_%d", group.ID, userID))
+	if err != nil {
+		return err
+	}
+	qr, err := qrcode.Encode(invoiceEvent.PaymentRequest, qrcode.Medium, 256)
+	if err != nil {
+		return err
+	}
+	groupMember := &GroupMember{GroupID: group.ID, UserID: userID}
+	bot.DB.Groups.Where(groupMember).First(groupMember)
+	bot.trySendMessage(payer.Telegram, &tb.Photo{File: tb.File{FileReader: bytes.NewReader(qr)}, Caption: fmt.Sprintf("`%s`", invoiceEvent.PaymentRequest)})
+	bot.trySendMessage(payer.Telegram, fmt.Sprintf(i18n.Translate(lang, "groupMembershipRenewalMessage"), str.MarkdownEscape(group.Title), groupMember.PaidUntil.Format("2006-01-02"), group.Ticket.Price, group.Ticket.Period))
+	return nil
+}
+
+// groupMembershipRenewedHandler is called when a renewal invoice was paid
+func (bot *TipBot) groupMembershipRenewedHandler(event Event) {
+	invoiceEvent := event.(*InvoiceEvent)
+	group, err := bot.loadGroup(strconv.FormatInt(invoiceEvent.Chat.ID, 10))
+	if err != nil {
+		log.Errorf("[groupMembershipRenewedHandler] %s", err.Error())
+		return
+	}
+	groupMember := bot.extendGroupMembership(group, invoiceEvent.Payer.Telegram)
+	if groupMember == nil {
+		return
+	}
+	bot.trySendMessage(invoiceEvent.Payer.Telegram, fmt.Sprintf(i18n.Translate(invoiceEvent.LanguageCode, "groupMembershipRenewedMessage"), str.MarkdownEscape(group.Title), groupMember.PaidUntil.Format("2006-01-02")))
+
+	// take a commission
+	ticketSat := group.Ticket.Price
+	if commissionSat := getTicketCommission(group.Ticket); commissionSat > 0 {
+		me, err := GetUser(bot.Telegram.Me, *bot)
+		if err != nil {
+			log.Errorf("[groupMembershipRenewedHandler] Could not get bot user from DB: %s", err.Error())
+			return
+		}
+		ticketSat = group.Ticket.Price - commissionSat
+		invoice, err := me.Wallet.Invoice(
+			lnbits.InvoiceParams{
+				Out:     false,
+				Amount:  commissionSat,
+				Memo:    "🎟 Membership commission for group " + group.Title,
+				Webhook: internal.Configuration.Lnbits.WebhookServer},
+			bot.Client)
+		if err != nil {
+			log.Errorf("[groupMembershipRenewedHandler] Could not create an invoice: %s", err.Error())
+			return
+		}
+		_, err = invoiceEvent.User.Wallet.Pay(lnbits.PaymentParams{Out: true, Bolt11: invoice.PaymentRequest}, bot.Client)
+		if err != nil {
+			log.Errorf("[groupMembershipRenewedHandler] Could not pay commission of %s: %s", GetUserStr(invoiceEvent.User.Telegram), err)
+			return
+		}
+	}
+	bot.trySendMessage(invoiceEvent.User.Telegram, fmt.Sprintf(i18n.Translate(invoiceEvent.LanguageCode, "groupReceiveMembershipRenewal"), ticketSat, group.Title, GetUserStrMd(invoiceEvent.Payer.Telegram)))
+}
+
+// groupRenewHandler is invoked if the user calls "/group renew <group_name>" in a private chat
+func (bot *TipBot) groupRenewHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	if !m.Private() {
+		return ctx, fmt.Errorf("not private chat")
+	}
+	groupName, err := getArgumentFromCommand(m.Text, 2)
+	if err != nil {
+		bot.trySendMessage(m.Chat, Translate(ctx, "groupRenewHelpMessage"))
+		return ctx, err
+	}
+	group := &Group{}
+	tx := bot.DB.Groups.Where("name = ? COLLATE NOCASE", strings.ToLower(groupName)).First(group)
+	if tx.Error != nil || !group.Ticket.isRecurring() {
+		bot.trySendMessage(m.Chat, Translate(ctx, "groupNotFoundMessage"))
+		return ctx, fmt.Errorf("group not found")
+	}
+	groupMember := &GroupMember{GroupID: group.ID, UserID: m.Sender.ID}
+	if tx := bot.DB.Groups.Where(groupMember).First(groupMember); tx.Error == nil && groupMember.Removed {
+		// removed members need a new invite link
+		bot.trySendMessage(m.Chat, fmt.Sprintf(Translate(ctx, "groupMembershipRemovedMessage"), str.MarkdownEscape(group.Title), group.Name))
+		return ctx, fmt.Errorf("member was removed")
+	}
+	return ctx, bot.sendGroupMembershipRenewal(group, m.Sender.ID)
+}
+
+// groupMembersHandler is invoked if an admin calls "/group members" in the group chat.
+// The report is sent to the admin in a private message.
+func (bot *TipBot) groupMembersHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	if m.Private() {
+		bot.trySendMessage(m.Chat, Translate(ctx, "groupAddGroupHelpMessage"))
+		return ctx, fmt.Errorf("not in group")
+	}
+	if !bot.isAdmin(m.Chat, m.Sender) {
+		return ctx, fmt.Errorf("not admin")
+	}
+	group, err := bot.loadGroup(strconv.FormatInt(m.Chat.ID, 10))
+	if err != nil || !group.Ticket.isRecurring() {
+		bot.trySendMessage(m.Sender, Translate(ctx, "groupMembersNoRecurringMessage"))
+		return ctx, fmt.Errorf("no recurring ticket")
+	}
+	var members []GroupMember
+	bot.DB.Groups.Where("group_id = ?", group.ID).Order("paid_until desc").Find(&members)
+
+	var paid, unpaid, removed []string
+	for _, member := range members {
+		line := fmt.Sprintf("%s (%s)", str.MarkdownEscape(member.Username), member.PaidUntil.Format("2006-01-02"))
+		switch {
+		case member.Removed:
+			removed = append(removed, line)
+		case member.PaidUntil.After(time.Now()):
+			paid = append(paid, line)
+		default:
+			unpaid = append(unpaid, line)
+		}
+	}
+	report := fmt.Sprintf(Translate(ctx, "groupMembersReportMessage"), str.MarkdownEscape(group.Title), group.Ticket.Price, group.Ticket.Period,
+		len(paid), strings.Join(paid, "\n"),
+		len(unpaid), strings.Join(unpaid, "\n"),
+		len(removed), strings.Join(removed, "\n"))
+	bot.trySendMessage(m.Sender, report)
+	return ctx, nil
+}
+
+// groupMemberLanguageCode returns the language code of a member or english
+func (bot *TipBot) groupMemberLanguageCode(userID int64) string {
+	user, err := GetLnbitsUser(&tb.User{ID: userID}, *bot)
+	if err != nil || user.Telegram == nil || len(user.Telegram.LanguageCode) == 0 {
+		return "en"
+	}
+	return user.Telegram.LanguageCode
+}
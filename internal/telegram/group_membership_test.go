@@ -0,0 +1,55 @@
+package telegram
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_parseTicketPeriod(t *testing.T) {
+	tests := []struct {
+		period  string
+		want    int64
+		wantErr bool
+	}{
+		{period: "weekly", want: 7},
+		{period: "Monthly", want: 30},
+		{period: "year", want: 365},
+		{period: "14d", want: 14},
+		{period: "90", want: 90},
+		{period: "0d", wantErr: true},
+		{period: "366d", wantErr: true},
+		{period: "-1", wantErr: true},
+		{period: "daily", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.period, func(t *testing.T) {
+			got, err := parseTicketPeriod(tt.period)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTicketPeriod() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseTicketPeriod() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_nextPaidUntil(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		paidUntil time.Time
+		want      time.Time
+	}{
+		{name: "new member", paidUntil: time.Time{}, want: now.Add(30 * 24 * time.Hour)},
+		{name: "expired", paidUntil: now.Add(-10 * 24 * time.Hour), want: now.Add(30 * 24 * time.Hour)},
+		{name: "early renewal", paidUntil: now.Add(2 * 24 * time.Hour), want: now.Add(32 * 24 * time.Hour)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nextPaidUntil(tt.paidUntil, now, 30); !got.Equal(tt.want) {
+				t.Errorf("nextPaidUntil() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
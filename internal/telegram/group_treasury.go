@@ -0,0 +1,620 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	decodepay "github.com/fiatjaf/ln-decodepay"
+	"github.com/massmux/SatsMobiBot/internal"
+	"github.com/massmux/SatsMobiBot/internal/errors"
+	"github.com/massmux/SatsMobiBot/internal/i18n"
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	"github.com/massmux/SatsMobiBot/internal/runtime"
+	"github.com/massmux/SatsMobiBot/internal/runtime/mutex"
+	"github.com/massmux/SatsMobiBot/internal/storage"
+	"github.com/massmux/SatsMobiBot/internal/str"
+	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/buntdb"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const (
+	GroupTreasuryLightningAddressPrefix = "group-"
+	groupTreasuryDefaultThreshold       = 1000
+	groupTreasuryDefaultApprovals       = 2
+	groupTreasuryDefaultRollingCap      = 5000
+	groupTreasurySpendExpiry            = 24 * time.Hour
+	groupTreasuryCapWindow              = 24 * time.Hour
+	TreasurySpendIndex                  = "treasury-spend:*"
+)
+
+var (
+	treasurySpendMenu       = &tb.ReplyMarkup{ResizeKeyboard: true}
+	btnApproveTreasurySpend = treasurySpendMenu.Data("✅ Approve", "treasury_approve")
+	btnRejectTreasurySpend  = treasurySpendMenu.Data("🚫 Reject", "treasury_reject")
+	treasurySpendTypeSend   = "send"
+	treasurySpendTypePay    = "pay"
+)
+
+// TreasurySpend is a spend from a group treasury that waits for the approval of the group admins
+type TreasurySpend struct {
+	*storage.Base
+	GroupID      int64       `json:"group_id"`
+	Requester    *tb.User    `json:"requester"`
+	Bolt11       string      `json:"bolt11"`
+	Recipient    *tb.User    `json:"recipient"`    // for sends, the invoice of the recipient is created when the spend is paid
+	InvoiceMemo  string      `json:"invoice_memo"` // memo of the recipient's invoice
+	Amount       int64       `json:"amount"`
+	Memo         string      `json:"memo"`
+	Approvals    []int64     `json:"approvals"` // Telegram IDs of the admins that approved, without the requester
+	Required     int         `json:"required"`
+	Message      *tb.Message `json:"message"`
+	LanguageCode string      `json:"languagecode"`
+}
+
+var (
+	errTreasuryOwnSpend        = fmt.Errorf("requester can't approve the own spend")
+	errTreasuryAlreadyApproved = fmt.Errorf("admin already approved")
+)
+
+// addApproval records the approval of an admin. It returns true when the spend has enough approvals.
+func (spend *TreasurySpend) addApproval(adminID int64) (bool, error) {
+	if adminID == spend.Requester.ID {
+		return false, errTreasuryOwnSpend
+	}
+	for _, id := range spend.Approvals {
+		if id == adminID {
+			return false, errTreasuryAlreadyApproved
+		}
+	}
+	spend.Approvals = append(spend.Approvals, adminID)
+	return len(spend.Approvals) >= spend.Required, nil
+}
+
+// TreasuryWindow holds the spends of a treasury that were paid without approvals during the
+// last groupTreasuryCapWindow. Their sum is limited by the rolling cap of the treasury.
+type TreasuryWindow struct {
+	*storage.Base
+	Spends []TreasuryWindowSpend `json:"spends"`
+}
+
+type TreasuryWindowSpend struct {
+	Time   time.Time `json:"time"`
+	Amount int64     `json:"amount"`
+}
+
+func treasuryWindowKey(groupID int64) string {
+	return fmt.Sprintf("treasury-window:%d", groupID)
+}
+
+// prune removes the spends that are older than the window
+func (window *TreasuryWindow) prune(now time.Time) {
+	spends := window.Spends[:0]
+	for _, spend := range window.Spends {
+		if now.Sub(spend.Time) < groupTreasuryCapWindow {
+			spends = append(spends, spend)
+		}
+	}
+	window.Spends = spends
+}
+
+// spent returns the sum of the spends in the window
+func (window *TreasuryWindow) spent(now time.Time) int64 {
+	window.prune(now)
+	var sum int64
+	for _, spend := range window.Spends {
+		sum += spend.Amount
+	}
+	return sum
+}
+
+// treasuryRollingCap returns the amount that admins can spend without approvals per window.
+// Treasuries that were created without a cap can spend one threshold.
+func (group *Group) treasuryRollingCap() int64 {
+	if group.TreasuryRollingCap > 0 {
+		return group.TreasuryRollingCap
+	}
+	return group.TreasuryThreshold
+}
+
+// treasurySpendNeedsApproval reports whether a spend needs approvals. Spends above the threshold
+// always do, smaller spends once the rolling cap of the treasury would be exceeded.
+func treasurySpendNeedsApproval(group *Group, spent, amount int64) bool {
+	return amount > group.TreasuryThreshold || spent+amount > group.treasuryRollingCap()
+}
+
+// takeTreasuryWindow adds a spend without approvals to the window of the treasury. It returns
+// false if the spend needs approvals.
+func (bot *TipBot) takeTreasuryWindow(group *Group, amount int64) bool {
+	key := treasuryWindowKey(group.ID)
+	mutex.Lock(key)
+	defer mutex.Unlock(key)
+	now := time.Now()
+	window := bot.loadTreasuryWindow(key)
+	if treasurySpendNeedsApproval(group, window.spent(now), amount) {
+		return false
+	}
+	window.Spends = append(window.Spends, TreasuryWindowSpend{Time: now, Amount: amount})
+	runtime.IgnoreError(window.Set(window, bot.Bunt))
+	return true
+}
+
+// releaseTreasuryWindow removes a spend that could not be paid from the window
+func (bot *TipBot) releaseTreasuryWindow(group *Group, amount int64) {
+	key := treasuryWindowKey(group.ID)
+	mutex.Lock(key)
+	defer mutex.Unlock(key)
+	window := bot.loadTreasuryWindow(key)
+	for i := len(window.Spends) - 1; i >= 0; i-- {
+		if window.Spends[i].Amount == amount {
+			window.Spends = append(window.Spends[:i], window.Spends[i+1:]...)
+			break
+		}
+	}
+	runtime.IgnoreError(window.Set(window, bot.Bunt))
+}
+
+func (bot *TipBot) loadTreasuryWindow(key string) *TreasuryWindow {
+	tx := &TreasuryWindow{Base: storage.New(storage.ID(key))}
+	sn, err := tx.Get(tx, bot.Bunt)
+	if err != nil {
+		return tx
+	}
+	return sn.(*TreasuryWindow)
+}
+
+// hasTreasury returns true if the group has a treasury wallet
+func (group *Group) hasTreasury() bool {
+	return group.Treasury != nil && len(group.Treasury.ID) > 0
+}
+
+// LightningAddress returns the lightning address of the group treasury
+func (group *Group) LightningAddress() string {
+	return fmt.Sprintf("%s%s@%s", GroupTreasuryLightningAddressPrefix, group.Name, internal.Configuration.Bot.LNURLHostUrl.Hostname())
+}
+
+// LoadGroupTreasury returns the group with a treasury for a lightning address username like group-<name>
+func (bot *TipBot) LoadGroupTreasury(username string) (*Group, error) {
+	name := strings.TrimPrefix(strings.ToLower(username), GroupTreasuryLightningAddressPrefix)
+	group := &Group{}
+	tx := bot.DB.Groups.Where("name = ? COLLATE NOCASE", name).First(group)
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	if !group.hasTreasury() {
+		return nil, fmt.Errorf("group %s has no treasury", name)
+	}
+	return group, nil
+}
+
+// groupTreasuryHandler is invoked if the user calls "/group treasury [pay|send|settings] ..." in a group chat
+func (bot *TipBot) groupTreasuryHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	if m.Private() {
+		bot.trySendMessage(m.Chat, Translate(ctx, "groupTreasuryHelpMessage"))
+		return ctx, fmt.Errorf("not in group")
+	}
+	user := LoadUser(ctx)
+	if !bot.isAdmin(m.Chat, user.Telegram) {
+		return ctx, fmt.Errorf("not admin")
+	}
+	group, err := bot.loadGroup(strconv.FormatInt(m.Chat.ID, 10))
+	if err != nil {
+		bot.trySendMessage(m.Chat, Translate(ctx, "groupTreasuryNoGroupMessage"))
+		return ctx, err
+	}
+	if !group.hasTreasury() {
+		if !bot.isOwner(m.Chat, user.Telegram) {
+			bot.trySendMessage(m.Chat, Translate(ctx, "groupTreasuryNoGroupMessage"))
+			return ctx, fmt.Errorf("not owner")
+		}
+		err = bot.createGroupTreasury(group)
+		if err != nil {
+			bot.trySendMessage(m.Chat, Translate(ctx, "errorTryLaterMessage"))
+			return ctx, err
+		}
+	}
+	cmd, err := getArgumentFromCommand(m.Text, 2)
+	if err != nil {
+		return bot.groupTreasuryBalanceHandler(ctx, group)
+	}
+	switch strings.ToLower(cmd) {
+	case treasurySpendTypePay:
+		return bot.groupTreasuryPayHandler(ctx, group)
+	case treasurySpendTypeSend:
+		return bot.groupTreasurySendHandler(ctx, group)
+	case "settings":
+		return bot.groupTreasurySettingsHandler(ctx, group)
+	default:
+		bot.trySendMessage(m.Chat, Translate(ctx, "groupTreasuryHelpMessage"))
+	}
+	return ctx, nil
+}
+
+// createGroupTreasury creates a new LNbits wallet for the group under the bot's LNbits user
+func (bot *TipBot) createGroupTreasury(group *Group) error {
+	me, err := GetUser(bot.Telegram.Me, *bot)
+	if err != nil {
+		log.Errorf("[createGroupTreasury] Could not get bot user from DB: %s", err.Error())
+		return err
+	}
+	wallet, err := bot.Client.CreateWallet(me.ID, GroupTreasuryLightningAddressPrefix+group.Name, internal.Configuration.Lnbits.AdminId)
+	if err != nil {
+		log.Errorf("[createGroupTreasury] Could not create wallet: %s", err.Error())
+		return err
+	}
+	group.Treasury = &wallet
+	group.TreasuryThreshold = groupTreasuryDefaultThreshold
+	group.TreasuryApprovals = groupTreasuryDefaultApprovals
+	group.TreasuryRollingCap = groupTreasuryDefaultRollingCap
+	bot.DB.Groups.Save(group)
+	log.Infof("[group] Created treasury wallet %s for group %s", wallet.ID, group.Name)
+	return nil
+}
+
+// groupTreasuryBalanceHandler shows the balance and the settings of the treasury
+func (bot *TipBot) groupTreasuryBalanceHandler(ctx intercept.Context, group *Group) (intercept.Context, error) {
+	wallet, err := bot.Client.Info(*group.Treasury)
+	if err != nil {
+		log.Errorf("[groupTreasuryBalanceHandler] %s", err.Error())
+		bot.trySendMessage(ctx.Message().Chat, Translate(ctx, "errorTryLaterMessage"))
+		return ctx, err
+	}
+	bot.trySendMessage(ctx.Message().Chat, fmt.Sprintf(Translate(ctx, "groupTreasuryBalanceMessage"),
+		str.MarkdownEscape(group.Title), wallet.Balance/1000, group.LightningAddress(), group.TreasuryThreshold, group.treasuryRollingCap(), group.TreasuryApprovals))
+	return ctx, nil
+}
+
+// groupTreasurySettingsHandler is invoked on "/group treasury settings <threshold> <approvals> [<cap>]"
+func (bot *TipBot) groupTreasurySettingsHandler(ctx intercept.Context, group *Group) (intercept.Context, error) {
+	m := ctx.Message()
+	if !bot.isOwner(m.Chat, m.Sender) {
+		return ctx, fmt.Errorf("not owner")
+	}
+	thresholdStr, err := getArgumentFromCommand(m.Text, 3)
+	if err != nil {
+		bot.trySendMessage(m.Chat, Translate(ctx, "groupTreasuryHelpMessage"))
+		return ctx, err
+	}
+	approvalsStr, err := getArgumentFromCommand(m.Text, 4)
+	if err != nil {
+		bot.trySendMessage(m.Chat, Translate(ctx, "groupTreasuryHelpMessage"))
+		return ctx, err
+	}
+	threshold, err := GetAmount(thresholdStr)
+	if err != nil {
+		threshold, err = strconv.ParseInt(thresholdStr, 10, 64)
+	}
+	approvals, err2 := strconv.Atoi(approvalsStr)
+	if err != nil || err2 != nil || threshold < 0 || approvals < 1 {
+		bot.trySendMessage(m.Chat, Translate(ctx, "groupTreasuryHelpMessage"))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	// without a cap, admins can spend five times the threshold per 24 hours
+	rollingCap := threshold * 5
+	if capStr, err := getArgumentFromCommand(m.Text, 5); err == nil {
+		rollingCap, err = GetAmount(capStr)
+		if err != nil || rollingCap < threshold {
+			bot.trySendMessage(m.Chat, Translate(ctx, "groupTreasuryHelpMessage"))
+			return ctx, errors.Create(errors.InvalidAmountError)
+		}
+	}
+	group.TreasuryThreshold = threshold
+	group.TreasuryApprovals = approvals
+	group.TreasuryRollingCap = rollingCap
+	bot.DB.Groups.Save(group)
+	bot.trySendMessage(m.Chat, fmt.Sprintf(Translate(ctx, "groupTreasurySettingsMessage"), threshold, rollingCap, approvals))
+	return ctx, nil
+}
+
+// groupTreasuryPayHandler is invoked on "/group treasury pay <invoice>"
+func (bot *TipBot) groupTreasuryPayHandler(ctx intercept.Context, group *Group) (intercept.Context, error) {
+	m := ctx.Message()
+	paymentRequest, err := getArgumentFromCommand(m.Text, 3)
+	if err != nil {
+		bot.trySendMessage(m.Chat, Translate(ctx, "groupTreasuryHelpMessage"))
+		return ctx, err
+	}
+	paymentRequest = strings.TrimPrefix(strings.ToLower(paymentRequest), "lightning:")
+	bolt11, err := decodepay.Decodepay(paymentRequest)
+	if err != nil {
+		bot.trySendMessage(m.Chat, Translate(ctx, "invalidInvoiceHelpMessage"))
+		return ctx, errors.New(errors.InvalidSyntaxError, err)
+	}
+	amount := int64(bolt11.MSatoshi / 1000)
+	if amount <= 0 {
+		bot.trySendMessage(m.Chat, Translate(ctx, "invoiceNoAmountMessage"))
+		return ctx, errors.Create(errors.InvalidAmountError)
+	}
+	spend := bot.newTreasurySpend(ctx, group, amount, str.MarkdownEscape(bolt11.Description))
+	spend.Bolt11 = paymentRequest
+	return bot.requestTreasurySpend(ctx, group, spend)
+}
+
+// groupTreasurySendHandler is invoked on "/group treasury send <amount> <@user> [<memo>]"
+func (bot *TipBot) groupTreasurySendHandler(ctx intercept.Context, group *Group) (intercept.Context, error) {
+	m := ctx.Message()
+	amountStr, err := getArgumentFromCommand(m.Text, 3)
+	if err != nil {
+		bot.trySendMessage(m.Chat, Translate(ctx, "groupTreasuryHelpMessage"))
+		return ctx, err
+	}
+	amount, err := GetAmount(amountStr)
+	if err != nil || amount < 1 {
+		bot.trySendMessage(m.Chat, Translate(ctx, "groupTreasuryHelpMessage"))
+		return ctx, errors.Create(errors.InvalidAmountError)
+	}
+	toUserStr, err := getArgumentFromCommand(m.Text, 4)
+	if err != nil {
+		bot.trySendMessage(m.Chat, Translate(ctx, "groupTreasuryHelpMessage"))
+		return ctx, err
+	}
+	toUserStr = strings.TrimPrefix(toUserStr, "@")
+	toUser, err := GetUserByTelegramUsername(toUserStr, *bot)
+	if err != nil || toUser.Wallet == nil {
+		bot.trySendMessage(m.Chat, fmt.Sprintf(Translate(ctx, "sendUserHasNoWalletMessage"), str.MarkdownEscape("@"+toUserStr)))
+		return ctx, errors.Create(errors.UserNoWalletError)
+	}
+	memo := GetMemoFromCommand(m.Text, 5)
+	spend := bot.newTreasurySpend(ctx, group, amount, fmt.Sprintf("to %s", GetUserStrMd(toUser.Telegram)))
+	spend.Recipient = toUser.Telegram
+	spend.InvoiceMemo = fmt.Sprintf("🏦 From the treasury of %s", group.Title)
+	if len(memo) > 0 {
+		spend.InvoiceMemo = fmt.Sprintf("%s: %s", spend.InvoiceMemo, memo)
+	}
+	return bot.requestTreasurySpend(ctx, group, spend)
+}
+
+func (bot *TipBot) newTreasurySpend(ctx intercept.Context, group *Group, amount int64, memo string) *TreasurySpend {
+	return &TreasurySpend{
+		Base:         storage.New(storage.ID(fmt.Sprintf("treasury-spend:%d:%s", group.ID, RandStringRunes(8)))),
+		GroupID:      group.ID,
+		Requester:    ctx.Message().Sender,
+		Amount:       amount,
+		Memo:         memo,
+		Approvals:    []int64{},
+		Required:     group.TreasuryApprovals,
+		LanguageCode: ctx.Value("publicLanguageCode").(string),
+	}
+}
+
+// requestTreasurySpend pays immediately up to the threshold while the rolling cap of the treasury
+// is not exceeded. Otherwise, the spend has to be approved by other admins than the requester.
+func (bot *TipBot) requestTreasurySpend(ctx intercept.Context, group *Group, spend *TreasurySpend) (intercept.Context, error) {
+	m := ctx.Message()
+	if bot.takeTreasuryWindow(group, spend.Amount) {
+		if err := bot.executeTreasurySpend(group, spend); err != nil {
+			bot.releaseTreasuryWindow(group, spend.Amount)
+		}
+		return ctx, nil
+	}
+	voters, err := bot.treasuryVoters(m.Chat, m.Sender)
+	if err != nil {
+		log.Errorf("[requestTreasurySpend] could not get admins of %s: %s", group.Name, err.Error())
+		bot.trySendMessage(m.Chat, Translate(ctx, "errorTryLaterMessage"))
+		return ctx, err
+	}
+	if voters < spend.Required {
+		bot.trySendMessage(m.Chat, fmt.Sprintf(Translate(ctx, "groupTreasuryNotEnoughAdminsMessage"), spend.Required, voters))
+		return ctx, fmt.Errorf("not enough admins to approve")
+	}
+	text := fmt.Sprintf(i18n.Translate(spend.LanguageCode, "groupTreasurySpendMessage"), GetUserStrMd(spend.Requester), spend.Amount, spend.Memo, len(spend.Approvals), spend.Required)
+	spend.Message = bot.trySendMessageEditable(m.Chat, text, bot.treasurySpendMenu(spend))
+	if spend.Message == nil {
+		return ctx, fmt.Errorf("could not send spend request")
+	}
+	runtime.IgnoreError(spend.Set(spend, bot.Bunt))
+	bot.startTreasurySpendTimer(spend)
+	return ctx, nil
+}
+
+// treasuryVoters returns the number of human admins that can approve a spend of the requester
+func (bot *TipBot) treasuryVoters(chat *tb.Chat, requester *tb.User) (int, error) {
+	admins, err := bot.Telegram.AdminsOf(chat)
+	if err != nil {
+		return 0, err
+	}
+	voters := 0
+	for _, admin := range admins {
+		if admin.User != nil && !admin.User.IsBot && admin.User.ID != requester.ID {
+			voters++
+		}
+	}
+	return voters, nil
+}
+
+// startTreasurySpendTimer expires the spend when it was not approved in time
+func (bot *TipBot) startTreasurySpendTimer(spend *TreasurySpend) {
+	t := runtime.NewResettableFunction(spend.ID,
+		runtime.WithTimer(time.NewTimer(time.Until(spend.CreatedAt.Add(groupTreasurySpendExpiry)))))
+	t.Do(func() {
+		bot.expireTreasurySpend(spend.ID)
+	})
+}
+
+// expireTreasurySpend inactivates a pending spend and removes its buttons
+func (bot *TipBot) expireTreasurySpend(id string) {
+	mutex.Lock(id)
+	defer mutex.Unlock(id)
+	tx := &TreasurySpend{Base: storage.New(storage.ID(id))}
+	sn, err := tx.Get(tx, bot.Bunt)
+	if err != nil {
+		return
+	}
+	spend := sn.(*TreasurySpend)
+	if !spend.Active {
+		return
+	}
+	runtime.IgnoreError(spend.Inactivate(spend, bot.Bunt))
+	bot.tryEditMessage(spend.Message, i18n.Translate(spend.LanguageCode, "groupTreasurySpendExpiredMessage"), &tb.ReplyMarkup{})
+	log.Infof("[group] treasury spend %s expired", spend.ID)
+}
+
+// restartTreasurySpendTimers restarts the expiry timers of all pending spends
+func (bot *TipBot) restartTreasurySpendTimers() {
+	bot.Bunt.View(func(tx *buntdb.Tx) error {
+		return tx.Ascend("treasury-spend", func(key, value string) bool {
+			spend := &TreasurySpend{}
+			if err := json.Unmarshal([]byte(value), spend); err != nil {
+				return true
+			}
+			if spend.Active {
+				bot.startTreasurySpendTimer(spend)
+			}
+			return true // continue iteration
+		})
+	})
+}
+
+func (bot *TipBot) treasurySpendMenu(spend *TreasurySpend) *tb.ReplyMarkup {
+	approveButton := treasurySpendMenu.Data(btnApproveTreasurySpend.Text, btnApproveTreasurySpend.Unique, spend.ID)
+	rejectButton := treasurySpendMenu.Data(btnRejectTreasurySpend.Text, btnRejectTreasurySpend.Unique, spend.ID)
+	treasurySpendMenu.Inline(treasurySpendMenu.Row(approveButton, rejectButton))
+	return treasurySpendMenu
+}
+
+// loadTreasurySpend loads a pending spend and checks that the admin may vote on it
+func (bot *TipBot) loadTreasurySpend(ctx intercept.Context) (*TreasurySpend, *Group, error) {
+	c := ctx.Callback()
+	tx := &TreasurySpend{Base: storage.New(storage.ID(c.Data))}
+	sn, err := tx.Get(tx, bot.Bunt)
+	if err != nil {
+		return nil, nil, err
+	}
+	spend := sn.(*TreasurySpend)
+	if !spend.Active {
+		return nil, nil, errors.Create(errors.NotActiveError)
+	}
+	if !bot.isAdmin(c.Message.Chat, c.Sender) {
+		ctx.Context = context.WithValue(ctx, "callback_response", "🚫 Only admins can vote.")
+		return nil, nil, fmt.Errorf("not admin")
+	}
+	group, err := bot.loadGroup(strconv.FormatInt(spend.GroupID, 10))
+	if err != nil {
+		return nil, nil, err
+	}
+	if time.Since(spend.CreatedAt) > groupTreasurySpendExpiry {
+		runtime.IgnoreError(spend.Inactivate(spend, bot.Bunt))
+		bot.tryEditMessage(spend.Message, i18n.Translate(spend.LanguageCode, "groupTreasurySpendExpiredMessage"), &tb.ReplyMarkup{})
+		return nil, nil, errors.Create(errors.NotActiveError)
+	}
+	return spend, group, nil
+}
+
+// approveTreasurySpendHandler is invoked when an admin approves a spend
+func (bot *TipBot) approveTreasurySpendHandler(ctx intercept.Context) (intercept.Context, error) {
+	c := ctx.Callback()
+	mutex.LockWithContext(ctx, c.Data)
+	defer mutex.UnlockWithContext(ctx, c.Data)
+	spend, group, err := bot.loadTreasurySpend(ctx)
+	if err != nil {
+		return ctx, err
+	}
+	approved, err := spend.addApproval(c.Sender.ID)
+	switch err {
+	case errTreasuryOwnSpend:
+		ctx.Context = context.WithValue(ctx, "callback_response", "🚫 Other admins have to approve your spend.")
+		return ctx, nil
+	case errTreasuryAlreadyApproved:
+		ctx.Context = context.WithValue(ctx, "callback_response", "You already approved.")
+		return ctx, nil
+	}
+	if approved {
+		runtime.RemoveTicker(spend.ID)
+		bot.executeTreasurySpend(group, spend)
+		return ctx, nil
+	}
+	runtime.IgnoreError(spend.Set(spend, bot.Bunt))
+	text := fmt.Sprintf(i18n.Translate(spend.LanguageCode, "groupTreasurySpendMessage"), GetUserStrMd(spend.Requester), spend.Amount, spend.Memo, len(spend.Approvals), spend.Required)
+	bot.tryEditMessage(spend.Message, text, bot.treasurySpendMenu(spend))
+	return ctx, nil
+}
+
+// rejectTreasurySpendHandler is invoked when an admin rejects a spend
+func (bot *TipBot) rejectTreasurySpendHandler(ctx intercept.Context) (intercept.Context, error) {
+	c := ctx.Callback()
+	mutex.LockWithContext(ctx, c.Data)
+	defer mutex.UnlockWithContext(ctx, c.Data)
+	spend, _, err := bot.loadTreasurySpend(ctx)
+	if err != nil {
+		return ctx, err
+	}
+	spend.Canceled = true
+	runtime.IgnoreError(spend.Inactivate(spend, bot.Bunt))
+	runtime.RemoveTicker(spend.ID)
+	bot.tryEditMessage(spend.Message, fmt.Sprintf(i18n.Translate(spend.LanguageCode, "groupTreasurySpendRejectedMessage"), spend.Amount, GetUserStrMd(c.Sender)), &tb.ReplyMarkup{})
+	return ctx, nil
+}
+
+// treasurySpendInvoice returns the invoice of the spend. Sends to users get a new invoice of the
+// recipient, an invoice created with the request could have expired while admins approved it.
+func (bot *TipBot) treasurySpendInvoice(spend *TreasurySpend) (string, error) {
+	if spend.Recipient == nil {
+		return spend.Bolt11, nil
+	}
+	to, err := GetLnbitsUser(spend.Recipient, *bot)
+	if err != nil || to.Wallet == nil {
+		return "", fmt.Errorf("recipient %s has no wallet", GetUserStr(spend.Recipient))
+	}
+	invoice, err := to.Wallet.Invoice(
+		lnbits.InvoiceParams{
+			Out:    false,
+			Amount: spend.Amount,
+			Memo:   spend.InvoiceMemo},
+		bot.Client)
+	if err != nil {
+		return "", err
+	}
+	return invoice.PaymentRequest, nil
+}
+
+// executeTreasurySpend pays the invoice from the group treasury
+func (bot *TipBot) executeTreasurySpend(group *Group, spend *TreasurySpend) error {
+	chat := &tb.Chat{ID: group.ID}
+	if spend.Base != nil && spend.Message != nil {
+		runtime.IgnoreError(spend.Inactivate(spend, bot.Bunt))
+	}
+	bolt11, err := bot.treasurySpendInvoice(spend)
+	if err == nil {
+		_, err = group.Treasury.Pay(lnbits.PaymentParams{Out: true, Bolt11: bolt11}, bot.Client)
+	}
+	if err != nil {
+		log.Errorf("[executeTreasurySpend] Could not pay from treasury of %s: %s", group.Name, err.Error())
+		text := fmt.Sprintf(i18n.Translate(spend.LanguageCode, "groupTreasurySpendFailedMessage"), spend.Amount, str.MarkdownEscape(err.Error()))
+		if spend.Message != nil {
+			bot.tryEditMessage(spend.Message, text, &tb.ReplyMarkup{})
+		} else {
+			bot.trySendMessage(chat, text)
+		}
+		return err
+	}
+	log.Infof("[group] Treasury of %s paid %d sat (%s) requested by %s", group.Name, spend.Amount, spend.Memo, GetUserStr(spend.Requester))
+	text := fmt.Sprintf(i18n.Translate(spend.LanguageCode, "groupTreasurySpendPaidMessage"), spend.Amount, spend.Memo, GetUserStrMd(spend.Requester))
+	if spend.Message != nil {
+		bot.tryEditMessage(spend.Message, text, &tb.ReplyMarkup{})
+	} else {
+		bot.trySendMessage(chat, text)
+	}
+	return nil
+}
+
+// groupTreasuryReceiveEvent is called when the lightning address of a group treasury received a payment
+func (bot *TipBot) groupTreasuryReceiveEvent(event Event) {
+	invoiceEvent := event.(*InvoiceEvent)
+	if invoiceEvent.Chat == nil {
+		return
+	}
+	text := fmt.Sprintf(i18n.Translate(invoiceEvent.LanguageCode, "groupTreasuryReceivedMessage"), invoiceEvent.Amount)
+	if len(invoiceEvent.CallbackData) > 0 {
+		text += fmt.Sprintf("\nFrom: %s", str.MarkdownEscape(invoiceEvent.CallbackData))
+	}
+	if len(invoiceEvent.Memo) > 0 {
+		text += fmt.Sprintf("\n✉️ %s", str.MarkdownEscape(invoiceEvent.Memo))
+	}
+	bot.trySendMessage(invoiceEvent.Chat, text)
+}
@@ -0,0 +1,129 @@
+package telegram
+
+import (
+	"testing"
+	"time"
+
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func Test_treasurySpendNeedsApproval(t *testing.T) {
+	group := &Group{TreasuryThreshold: 1000, TreasuryRollingCap: 3000}
+	tests := []struct {
+		name   string
+		spent  int64
+		amount int64
+		want   bool
+	}{
+		{name: "below threshold", amount: 500},
+		{name: "at threshold", amount: 1000},
+		{name: "above threshold", amount: 1001, want: true},
+		{name: "within cap", spent: 2000, amount: 1000},
+		{name: "above cap", spent: 2500, amount: 600, want: true},
+		{name: "cap used up", spent: 3000, amount: 1, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := treasurySpendNeedsApproval(group, tt.spent, tt.amount); got != tt.want {
+				t.Errorf("treasurySpendNeedsApproval() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	// treasuries without a cap can spend one threshold per window
+	if !treasurySpendNeedsApproval(&Group{TreasuryThreshold: 1000}, 600, 600) {
+		t.Errorf("treasurySpendNeedsApproval() without cap allowed more than the threshold")
+	}
+}
+
+func TestTreasuryWindow_spent(t *testing.T) {
+	now := time.Now()
+	window := &TreasuryWindow{Spends: []TreasuryWindowSpend{
+		{Time: now.Add(-25 * time.Hour), Amount: 1000},
+		{Time: now.Add(-23 * time.Hour), Amount: 200},
+		{Time: now.Add(-time.Minute), Amount: 300},
+	}}
+	if got := window.spent(now); got != 500 {
+		t.Errorf("spent() = %d, want 500", got)
+	}
+	if len(window.Spends) != 2 {
+		t.Errorf("spent() kept %d spends, want 2", len(window.Spends))
+	}
+	if got := window.spent(now.Add(2 * time.Hour)); got != 300 {
+		t.Errorf("spent() = %d, want 300", got)
+	}
+}
+
+func TestTreasurySpend_addApproval(t *testing.T) {
+	spend := &TreasurySpend{Requester: &tb.User{ID: 1}, Approvals: []int64{}, Required: 2}
+	if _, err := spend.addApproval(1); err != errTreasuryOwnSpend {
+		t.Fatalf("addApproval() of the requester error = %v", err)
+	}
+	approved, err := spend.addApproval(2)
+	if err != nil || approved {
+		t.Fatalf("addApproval() = %v, %v after one approval", approved, err)
+	}
+	if _, err = spend.addApproval(2); err != errTreasuryAlreadyApproved {
+		t.Fatalf("addApproval() twice error = %v", err)
+	}
+	approved, err = spend.addApproval(3)
+	if err != nil || !approved {
+		t.Fatalf("addApproval() = %v, %v after two approvals", approved, err)
+	}
+}
+
+func TestTipBot_takeTreasuryWindow(t *testing.T) {
+	test := newTestBot(t)
+	group := &Group{ID: -100, TreasuryThreshold: 1000, TreasuryRollingCap: 2500}
+	for _, amount := range []int64{1000, 1000, 500} {
+		if !test.takeTreasuryWindow(group, amount) {
+			t.Fatalf("takeTreasuryWindow(%d) needs approval within the cap", amount)
+		}
+	}
+	// repeated spends below the threshold need approval once the cap is used up
+	if test.takeTreasuryWindow(group, 1) {
+		t.Fatalf("takeTreasuryWindow() paid above the cap")
+	}
+	test.releaseTreasuryWindow(group, 500)
+	if !test.takeTreasuryWindow(group, 400) {
+		t.Fatalf("takeTreasuryWindow() did not release a failed spend")
+	}
+	if test.takeTreasuryWindow(&Group{ID: -100, TreasuryThreshold: 1000, TreasuryRollingCap: 2500}, 200) {
+		t.Fatalf("takeTreasuryWindow() did not persist the window")
+	}
+}
+
+func TestTipBot_executeTreasurySpend(t *testing.T) {
+	test := newTestBot(t)
+	test.addUser(t, 2, 0)
+	group := &Group{ID: -100, Name: "group", Title: "Group", Treasury: test.lnbits.newWallet("treasury", 5000)}
+	spend := &TreasurySpend{
+		GroupID:     group.ID,
+		Requester:   &tb.User{ID: 3},
+		Recipient:   &tb.User{ID: 2},
+		InvoiceMemo: "🏦 From the treasury of Group",
+		Amount:      1500,
+		Approvals:   []int64{},
+	}
+	// the invoice of the recipient is only created when the spend is paid
+	if len(test.lnbits.invoices) != 0 {
+		t.Fatalf("invoice created before the spend was executed")
+	}
+	if err := test.executeTreasurySpend(group, spend); err != nil {
+		t.Fatalf("executeTreasurySpend() error = %v", err)
+	}
+	if got := test.balance(2); got != 1500 {
+		t.Errorf("recipient balance = %d, want 1500", got)
+	}
+	if got := test.lnbits.balance("treasury"); got != 3500 {
+		t.Errorf("treasury balance = %d, want 3500", got)
+	}
+	spend.Amount = 5000
+	if err := test.executeTreasurySpend(group, spend); err == nil {
+		t.Errorf("executeTreasurySpend() paid more than the treasury balance")
+	}
+	spend.Recipient = &tb.User{ID: 4}
+	spend.Amount = 100
+	if err := test.executeTreasurySpend(group, spend); err == nil {
+		t.Errorf("executeTreasurySpend() paid a recipient without wallet")
+	}
+}
@@ -56,6 +56,8 @@ type Ticket struct {
 	BaseFee      int64        `json:"base_fee"`
 	CutCheap     int64        `json:"cut_cheap"` // Percent to cut from ticket price
 	BaseFeeCheap int64        `json:"base_fee_cheap"`
+	Period       int64        `json:"period"`       // days until a membership has to be renewed, 0 for one-time tickets
+	GracePeriod  int64        `json:"grace_period"` // days after expiry before unpaid members are removed
 }
 type Group struct {
 	Name  string   `json:"name"`
@@ -64,6 +66,12 @@ type Group struct {
 	Owner *tb.User `gorm:"embedded;embeddedPrefix:owner_"`
 	// Chat   *tb.Chat `gorm:"embedded;embeddedPrefix:chat_"`
 	Ticket *Ticket `gorm:"embedded;embeddedPrefix:ticket_"`
+	// Treasury is the LNbits wallet of the group, spends above TreasuryThreshold need TreasuryApprovals admins.
+	// Smaller spends also need them once they sum up to more than TreasuryRollingCap in 24 hours.
+	Treasury           *lnbits.Wallet `gorm:"embedded;embeddedPrefix:treasury_"`
+	TreasuryThreshold  int64          `json:"treasury_threshold"`
+	TreasuryApprovals  int            `json:"treasury_approvals"`
+	TreasuryRollingCap int64          `json:"treasury_rolling_cap"`
 }
 type CreateChatInviteLink struct {
 	ChatID             int64  `json:"chat_id"`
@@ -142,6 +150,18 @@ func (bot TipBot) groupHandler(ctx intercept.Context) (intercept.Context, error)
 		if splits[1] == "ticket" {
 			return bot.handleJoinTicketPayWall(ctx)
 		}
+		if splits[1] == "renew" {
+			return bot.groupRenewHandler(ctx)
+		}
+		if splits[1] == "members" {
+			return bot.groupMembersHandler(ctx)
+		}
+		if splits[1] == "paytopost" {
+			return bot.groupPayToPostHandler(ctx)
+		}
+		if splits[1] == "treasury" {
+			return bot.groupTreasuryHandler(ctx)
+		}
 		if splits[1] == "remove" {
 			// todo -- implement this
 			// return bot.addGroupHandler(ctx, m)
@@ -363,6 +383,9 @@ func (bot *TipBot) groupGetInviteLinkHandler(event Event) {
 
 	// send confirmation text with the ticket to the user
 	bot.trySendMessage(ticketEvent.Payer.Telegram, fmt.Sprintf(i18n.Translate(ticketEvent.LanguageCode, "groupClickToJoinMessage"), resp.Result.InviteLink, ticketEvent.Group.Title))
+	if groupMember := bot.extendGroupMembership(ticketEvent.Group, ticketEvent.Payer.Telegram); groupMember != nil {
+		bot.trySendMessage(ticketEvent.Payer.Telegram, fmt.Sprintf(i18n.Translate(ticketEvent.LanguageCode, "groupMembershipPaidUntilMessage"), groupMember.PaidUntil.Format("2006-01-02")))
+	}
 
 	// send a notification to the group that sold the ticket
 	bot.trySendMessage(&tb.Chat{ID: ticketEvent.Group.ID}, fmt.Sprintf(i18n.Translate(ticketEvent.LanguageCode, "groupTicketIssuedGroupMessage"), GetUserStrMd(ticketEvent.Payer.Telegram)))
@@ -479,6 +502,14 @@ func (bot TipBot) addJoinTicketPayWallHandler(ctx intercept.Context) (intercept.
 			return ctx, err
 		}
 	}
+	period := int64(0) // default is a one-time ticket
+	if period_str, err := getArgumentFromCommand(m.Text, 3); err == nil {
+		period, err = parseTicketPeriod(period_str)
+		if err != nil {
+			bot.trySendMessage(m.Chat, Translate(ctx, "groupAddGroupHelpMessage"))
+			return ctx, err
+		}
+	}
 
 	ticket := &Ticket{
 		Price:        amount,
@@ -488,6 +519,8 @@ func (bot TipBot) addJoinTicketPayWallHandler(ctx intercept.Context) (intercept.
 		BaseFee:      100,
 		CutCheap:     10,
 		BaseFeeCheap: 10,
+		Period:       period,
+		GracePeriod:  groupMembershipDefaultGrace,
 	}
 
 	group = &Group{
@@ -551,6 +584,14 @@ func (bot TipBot) addGroupHandler(ctx intercept.Context) (intercept.Context, err
 			return ctx, err
 		}
 	}
+	period := int64(0) // default is a one-time ticket
+	if period_str, err := getArgumentFromCommand(m.Text, 4); err == nil {
+		period, err = parseTicketPeriod(period_str)
+		if err != nil {
+			bot.trySendMessage(m.Chat, Translate(ctx, "groupAddGroupHelpMessage"))
+			return ctx, err
+		}
+	}
 
 	ticket := &Ticket{
 		Price:        amount,
@@ -560,6 +601,8 @@ func (bot TipBot) addGroupHandler(ctx intercept.Context) (intercept.Context, err
 		BaseFee:      100,
 		CutCheap:     10,
 		BaseFeeCheap: 10,
+		Period:       period,
+		GracePeriod:  groupMembershipDefaultGrace,
 	}
 
 	group = &Group{
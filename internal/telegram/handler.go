@@ -116,7 +116,7 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 		// 	},
 		// },
 		{
-			Endpoints: []interface{}{"/tip", "/t", "/honk", "/zap"},
+			Endpoints: []interface{}{"/tip", "/t", "/honk"},
 			Handler:   bot.tipHandler,
 			Interceptor: &Interceptor{
 				Before: []intercept.Func{
@@ -131,6 +131,22 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 				},
 			},
 		},
+		{
+			Endpoints: []interface{}{"/zap"},
+			Handler:   bot.zapHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.loadReplyToInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
 		{
 			Endpoints: []interface{}{"/pay"},
 			Handler:   bot.payHandler,
@@ -326,6 +342,22 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 				},
 			},
 		},
+		{
+			Endpoints: []interface{}{"/matrix"},
+			Handler:   bot.matrixHandler,
+			Interceptor: &Interceptor{
+				Before: []intercept.Func{
+					bot.requirePrivateChatInterceptor,
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
 		{
 			Endpoints: []interface{}{"/node"},
 			Handler:   bot.nodeHandler,
@@ -595,6 +627,151 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 				},
 			},
 		},
+		{
+			Endpoints: []interface{}{"/request"},
+			Handler:   bot.requestHandler,
+			Interceptor: &Interceptor{
+
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{"/requests"},
+			Handler:   bot.requestsHandler,
+			Interceptor: &Interceptor{
+
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{"/rain"},
+			Handler:   bot.rainHandler,
+			Interceptor: &Interceptor{
+
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{"/splitbill"},
+			Handler:   bot.splitBillHandler,
+			Interceptor: &Interceptor{
+
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{"/schedule"},
+			Handler:   bot.scheduleHandler,
+			Interceptor: &Interceptor{
+
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{"/raffle"},
+			Handler:   bot.raffleHandler,
+			Interceptor: &Interceptor{
+
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{"/campaign"},
+			Handler:   bot.campaignHandler,
+			Interceptor: &Interceptor{
+
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{"/bounty"},
+			Handler:   bot.bountyHandler,
+			Interceptor: &Interceptor{
+
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
+		{
+			Endpoints: []interface{}{"/nwc"},
+			Handler:   bot.nwcHandler,
+			Interceptor: &Interceptor{
+
+				Before: []intercept.Func{
+					bot.requirePrivateChatInterceptor,
+					bot.localizerInterceptor,
+					bot.logMessageInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+				},
+			},
+		},
 		{
 			Endpoints: []interface{}{"/advanced"},
 			Handler:   bot.advancedHelpHandler,
@@ -748,6 +925,7 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 
 				Before: []intercept.Func{
 					bot.requirePrivateChatInterceptor,
+					bot.localizerInterceptor,
 					bot.logMessageInterceptor,
 					bot.loadUserInterceptor}},
 		},
@@ -757,6 +935,10 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 			Interceptor: &Interceptor{
 
 				Before: []intercept.Func{
+					bot.payToPostInterceptor,          // Enforce pay-to-post in group chats
+					bot.groupActivityInterceptor,      // Remember recently active group members for /rain
+					bot.chatMemberSeenInterceptor,     // Remember when group members were first seen for /faucet --member
+					bot.bountyReplyInterceptor,        // Register replies to bounties as claims
 					bot.requirePrivateChatInterceptor, // Respond to any text only in private chat
 					bot.localizerInterceptor,
 					bot.logMessageInterceptor,
@@ -923,7 +1105,6 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 					bot.singletonCallbackInterceptor,
 					bot.localizerInterceptor,
 					bot.loadUserInterceptor,
-					bot.answerCallbackInterceptor,
 					bot.lockInterceptor,
 				},
 				OnDefer: []intercept.Func{
@@ -1429,6 +1610,261 @@ func (bot TipBot) getHandler() []InterceptionWrapper {
 					bot.unlockInterceptor,
 				}},
 		},
+		{
+			Endpoints: []interface{}{&btnPayMoneyRequest},
+			Handler:   bot.payMoneyRequestHandler,
+			Interceptor: &Interceptor{
+
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+					bot.answerCallbackInterceptor,
+				}},
+		},
+		{
+			Endpoints: []interface{}{&btnDeclineMoneyRequest},
+			Handler:   bot.declineMoneyRequestHandler,
+			Interceptor: &Interceptor{
+
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+					bot.answerCallbackInterceptor,
+				}},
+		},
+		{
+			Endpoints: []interface{}{&btnPaySplitBill},
+			Handler:   bot.paySplitBillHandler,
+			Interceptor: &Interceptor{
+
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+					bot.answerCallbackInterceptor,
+				}},
+		},
+		{
+			Endpoints: []interface{}{&btnCloseSplitBill},
+			Handler:   bot.closeSplitBillHandler,
+			Interceptor: &Interceptor{
+
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.loadUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+					bot.answerCallbackInterceptor,
+				}},
+		},
+		{
+			Endpoints: []interface{}{&btnJoinRaffle},
+			Handler:   bot.joinRaffleHandler,
+			Interceptor: &Interceptor{
+
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+					bot.answerCallbackInterceptor,
+				}},
+		},
+		{
+			Endpoints: []interface{}{&btnCancelRaffle},
+			Handler:   bot.cancelRaffleHandler,
+			Interceptor: &Interceptor{
+
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.loadUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+					bot.answerCallbackInterceptor,
+				}},
+		},
+		{
+			Endpoints: []interface{}{&btnContributeCampaign},
+			Handler:   bot.contributeCampaignHandler,
+			Interceptor: &Interceptor{
+
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+					bot.answerCallbackInterceptor,
+				}},
+		},
+		{
+			Endpoints: []interface{}{&btnCancelCampaign},
+			Handler:   bot.cancelCampaignHandler,
+			Interceptor: &Interceptor{
+
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.loadUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+					bot.answerCallbackInterceptor,
+				}},
+		},
+		{
+			Endpoints: []interface{}{&btnContributeBounty},
+			Handler:   bot.contributeBountyHandler,
+			Interceptor: &Interceptor{
+
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+					bot.answerCallbackInterceptor,
+				}},
+		},
+		{
+			Endpoints: []interface{}{&btnAwardBounty},
+			Handler:   bot.awardBountyHandler,
+			Interceptor: &Interceptor{
+
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+					bot.answerCallbackInterceptor,
+				}},
+		},
+		{
+			Endpoints: []interface{}{&btnObjectBounty},
+			Handler:   bot.objectBountyHandler,
+			Interceptor: &Interceptor{
+
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.loadUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+					bot.answerCallbackInterceptor,
+				}},
+		},
+		{
+			Endpoints: []interface{}{&btnCancelBounty},
+			Handler:   bot.cancelBountyHandler,
+			Interceptor: &Interceptor{
+
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.loadUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+					bot.answerCallbackInterceptor,
+				}},
+		},
+		{
+			Endpoints: []interface{}{&btnFaucetCaptcha},
+			Handler:   bot.faucetCaptchaHandler,
+			Interceptor: &Interceptor{
+
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.tryLoadUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+					bot.answerCallbackInterceptor,
+				}},
+		},
+		{
+			Endpoints: []interface{}{&btnConfirmBulkPayout},
+			Handler:   bot.confirmBulkPayoutHandler,
+			Interceptor: &Interceptor{
+
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+					bot.answerCallbackInterceptor,
+				}},
+		},
+		{
+			Endpoints: []interface{}{&btnCancelBulkPayout},
+			Handler:   bot.cancelBulkPayoutHandler,
+			Interceptor: &Interceptor{
+
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.requireUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+					bot.answerCallbackInterceptor,
+				}},
+		},
+		{
+			Endpoints: []interface{}{&btnApproveTreasurySpend},
+			Handler:   bot.approveTreasurySpendHandler,
+			Interceptor: &Interceptor{
+
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.loadUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+					bot.answerCallbackInterceptor,
+				}},
+		},
+		{
+			Endpoints: []interface{}{&btnRejectTreasurySpend},
+			Handler:   bot.rejectTreasurySpendHandler,
+			Interceptor: &Interceptor{
+
+				Before: []intercept.Func{
+					bot.localizerInterceptor,
+					bot.loadUserInterceptor,
+					bot.lockInterceptor,
+				},
+				OnDefer: []intercept.Func{
+					bot.unlockInterceptor,
+					bot.answerCallbackInterceptor,
+				}},
+		},
 		{
 			Endpoints: []interface{}{&shopItemCancelBuyButton},
 			Handler:   bot.displayShopItemHandler,
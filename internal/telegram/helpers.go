@@ -1,8 +1,10 @@
 package telegram
 
 import (
+	"fmt"
 	"math"
 	"math/rand"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -28,7 +30,8 @@ func GetMemoFromCommand(command string, fromWord int) string {
 		memo = strings.SplitN(command, " ", fromWord+1)[fromWord]
 		memoMaxLen := 159
 		if len(memo) > memoMaxLen {
-			memo = memo[:memoMaxLen]
+			// drop a multi-byte character that was cut in half
+			memo = strings.ToValidUTF8(memo[:memoMaxLen], "")
 		}
 	}
 	return memo
@@ -49,3 +52,29 @@ func MakeTipjarbar(current int64, total int64) string {
 	progressbar += strings.Repeat("⬜️", MAX_BARS-int(progress))
 	return progressbar
 }
+
+// ParseDuration parses durations like 30m, 12h, 3d or 2w. Everything else is
+// handed to time.ParseDuration.
+func ParseDuration(input string) (time.Duration, error) {
+	if len(input) > 1 {
+		var unit time.Duration
+		switch input[len(input)-1] {
+		case 'd':
+			unit = 24 * time.Hour
+		case 'w':
+			unit = 7 * 24 * time.Hour
+		}
+		if unit > 0 {
+			n, err := strconv.Atoi(input[:len(input)-1])
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid duration %s", input)
+			}
+			return time.Duration(n) * unit, nil
+		}
+	}
+	d, err := time.ParseDuration(input)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid duration %s", input)
+	}
+	return d, nil
+}
@@ -0,0 +1,86 @@
+package telegram
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/massmux/SatsMobiBot/internal/errors"
+	"github.com/tidwall/buntdb"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const (
+	inlineMinExpiry = time.Minute
+	inlineMaxExpiry = 30 * 24 * time.Hour
+)
+
+// getInlineExpiryFromCommand reads the optional expiry of a faucet or tipjar from the given word,
+// for example the 1h in "/faucet 2100 21 1h". It returns the word index where the memo starts.
+func getInlineExpiryFromCommand(text string, which int) (expiresAt time.Time, memoWord int, err error) {
+	arg, err := getArgumentFromCommand(text, which)
+	if err != nil {
+		return time.Time{}, which, nil
+	}
+	d, err := ParseDuration(arg)
+	if err != nil {
+		// not a duration, the memo starts here
+		return time.Time{}, which, nil
+	}
+	if d < inlineMinExpiry || d > inlineMaxExpiry {
+		return time.Time{}, which, errors.New(errors.InvalidSyntaxError, fmt.Errorf("invalid expiry %s", arg))
+	}
+	return time.Now().Add(d), which + 1, nil
+}
+
+// inlineEditable returns an editable for a message that the bot sent to a chat
+func inlineEditable(m *tb.Message) *tb.StoredMessage {
+	if m == nil || m.Chat == nil {
+		return nil
+	}
+	return &tb.StoredMessage{MessageID: strconv.Itoa(m.ID), ChatID: m.Chat.ID}
+}
+
+// startInlineExpiryTimer starts the expiry timer of an inline faucet or tipjar that was
+// chosen from an inline query. Inline messages are edited by their inline message id.
+func (bot *TipBot) startInlineExpiryTimer(inlineObject interface{}, inlineMessageID string) {
+	switch o := inlineObject.(type) {
+	case *InlineFaucet:
+		if !o.ExpiresAt.IsZero() {
+			o.Editable = &tb.StoredMessage{MessageID: inlineMessageID}
+			bot.startInlineFaucetTimer(o)
+		}
+	case *InlineTipjar:
+		if !o.ExpiresAt.IsZero() {
+			o.Editable = &tb.StoredMessage{MessageID: inlineMessageID}
+			bot.startInlineTipjarTimer(o)
+		}
+	}
+}
+
+// restartInlineExpiryTimers restarts the expiry timers of all open faucets and tipjars
+func (bot *TipBot) restartInlineExpiryTimers() {
+	bot.Bunt.View(func(tx *buntdb.Tx) error {
+		tx.AscendKeys("faucet:*", func(key, value string) bool {
+			inlineFaucet := &InlineFaucet{}
+			if err := json.Unmarshal([]byte(value), inlineFaucet); err != nil {
+				return true
+			}
+			if inlineFaucet.Active && !inlineFaucet.ExpiresAt.IsZero() && inlineFaucet.Editable != nil {
+				bot.startInlineFaucetTimer(inlineFaucet)
+			}
+			return true // continue iteration
+		})
+		return tx.AscendKeys("tipjar:*", func(key, value string) bool {
+			inlineTipjar := &InlineTipjar{}
+			if err := json.Unmarshal([]byte(value), inlineTipjar); err != nil {
+				return true
+			}
+			if inlineTipjar.Active && !inlineTipjar.ExpiresAt.IsZero() && inlineTipjar.Editable != nil {
+				bot.startInlineTipjarTimer(inlineTipjar)
+			}
+			return true // continue iteration
+		})
+	})
+}
@@ -0,0 +1,132 @@
+package telegram
+
+import (
+	"testing"
+	"time"
+
+	"github.com/massmux/SatsMobiBot/internal/storage"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func Test_getInlineExpiryFromCommand(t *testing.T) {
+	tests := []struct {
+		text         string
+		wantExpiry   time.Duration
+		wantMemoWord int
+		wantErr      bool
+	}{
+		{text: "/faucet 2100 21", wantMemoWord: 3},
+		{text: "/faucet 2100 21 coffee", wantMemoWord: 3},
+		{text: "/faucet 2100 21 1h coffee", wantExpiry: time.Hour, wantMemoWord: 4},
+		{text: "/faucet 2100 21 30s", wantErr: true},
+		{text: "/faucet 2100 21 90d", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			expiresAt, memoWord, err := getInlineExpiryFromCommand(tt.text, 3)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getInlineExpiryFromCommand() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if memoWord != tt.wantMemoWord {
+				t.Errorf("getInlineExpiryFromCommand() memo word = %d, want %d", memoWord, tt.wantMemoWord)
+			}
+			if tt.wantExpiry == 0 && !expiresAt.IsZero() {
+				t.Errorf("getInlineExpiryFromCommand() expires at %v without expiry", expiresAt)
+			}
+			if d := time.Until(expiresAt); tt.wantExpiry > 0 && (d > tt.wantExpiry || d < tt.wantExpiry-time.Minute) {
+				t.Errorf("getInlineExpiryFromCommand() expires in %v, want %v", d, tt.wantExpiry)
+			}
+		})
+	}
+}
+
+// waitInactive waits until the stored faucet or tipjar was closed by its timer
+func waitInactive(t *testing.T, test *testBot, id string) {
+	for i := 0; i < 100; i++ {
+		base := &storage.Base{ID: id}
+		if err := test.Bunt.Get(base); err == nil && !base.Active {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("%s was not closed at its expiry", id)
+}
+
+func TestTipBot_startInlineFaucetTimer(t *testing.T) {
+	test := newTestBot(t)
+	creator := test.addUser(t, 2, 1000)
+	taker := test.addUser(t, 3, 0)
+	chat := &tb.Chat{ID: -600, Type: tb.ChatGroup}
+	faucet, err := test.createFaucet(test.message(creator, chat, ""), "/faucet 100 10 1h free sats", creator.Telegram)
+	if err != nil {
+		t.Fatalf("createFaucet() error = %v", err)
+	}
+	if faucet.Memo != "free sats" || faucet.ExpiresAt.IsZero() {
+		t.Fatalf("faucet = %q, expires at %v", faucet.Memo, faucet.ExpiresAt)
+	}
+	faucet.ExpiresAt = time.Now().Add(100 * time.Millisecond)
+	faucet.Editable = &tb.StoredMessage{MessageID: "1", ChatID: chat.ID}
+	if err := faucet.Set(faucet, test.Bunt); err != nil {
+		t.Fatal(err)
+	}
+	test.startInlineFaucetTimer(faucet)
+	waitInactive(t, test, faucet.ID)
+
+	if _, err := test.acceptInlineFaucetHandler(test.callback(taker, chat, faucet.ID)); err == nil {
+		t.Errorf("acceptInlineFaucetHandler() paid from an expired faucet")
+	}
+	if got := test.balance(2); got != 1000 {
+		t.Errorf("creator balance = %d, want 1000", got)
+	}
+}
+
+func TestTipBot_acceptInlineFaucetHandler_expired(t *testing.T) {
+	test := newTestBot(t)
+	creator := test.addUser(t, 2, 1000)
+	taker := test.addUser(t, 3, 0)
+	chat := &tb.Chat{ID: -600, Type: tb.ChatGroup}
+	faucet, err := test.createFaucet(test.message(creator, chat, ""), "/faucet 100 10 1h", creator.Telegram)
+	if err != nil {
+		t.Fatalf("createFaucet() error = %v", err)
+	}
+	// the timer did not fire, for example during a restart
+	faucet.ExpiresAt = time.Now().Add(-time.Second)
+	if err := faucet.Set(faucet, test.Bunt); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := test.acceptInlineFaucetHandler(test.callback(taker, chat, faucet.ID)); err == nil {
+		t.Errorf("acceptInlineFaucetHandler() paid from an expired faucet")
+	}
+	if got := test.balance(3); got != 0 {
+		t.Errorf("taker balance = %d, want 0", got)
+	}
+	waitInactive(t, test, faucet.ID)
+}
+
+func TestTipBot_startInlineTipjarTimer(t *testing.T) {
+	test := newTestBot(t)
+	creator := test.addUser(t, 2, 0)
+	giver := test.addUser(t, 3, 1000)
+	chat := &tb.Chat{ID: -600, Type: tb.ChatGroup}
+	tipjar, err := test.createTipjar(test.message(creator, chat, ""), "/tipjar 100 10 1h", creator.Telegram)
+	if err != nil {
+		t.Fatalf("createTipjar() error = %v", err)
+	}
+	tipjar.ExpiresAt = time.Now().Add(100 * time.Millisecond)
+	tipjar.Editable = &tb.StoredMessage{MessageID: "1", ChatID: chat.ID}
+	if err := tipjar.Set(tipjar, test.Bunt); err != nil {
+		t.Fatal(err)
+	}
+	test.startInlineTipjarTimer(tipjar)
+	waitInactive(t, test, tipjar.ID)
+
+	if _, err := test.acceptInlineTipjarHandler(test.callback(giver, chat, tipjar.ID)); err == nil {
+		t.Errorf("acceptInlineTipjarHandler() paid into an expired tipjar")
+	}
+	if got := test.balance(3); got != 1000 {
+		t.Errorf("giver balance = %d, want 1000", got)
+	}
+}
@@ -110,6 +110,8 @@ func (bot TipBot) anyChosenInlineHandler(ctx intercept.Context) (intercept.Conte
 		log.Errorf("[anyChosenInlineHandler] could not find inline object in cache. %v", err.Error())
 		return ctx, err
 	}
+	// expiring faucets and tipjars are closed by a timer that needs the inline message id
+	bot.startInlineExpiryTimer(inlineObject, ctx.InlineResult().MessageID)
 	switch inlineObject.(type) {
 	case storage.Storable:
 		// persist inline object in bunt
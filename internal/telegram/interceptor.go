@@ -201,6 +201,8 @@ const photoTag = "<Photo>"
 
 func (bot TipBot) logMessageInterceptor(ctx intercept.Context) (intercept.Context, error) {
 	if ctx.Message() != nil {
+		bot.recordGroupActivity(ctx.Message())
+		bot.recordChatMemberSeen(ctx.Message())
 
 		if ctx.Message().Text != "" {
 			log_string := fmt.Sprintf("[%s:%d %s:%d] %s", ctx.Message().Chat.Title, ctx.Message().Chat.ID, GetUserStr(ctx.Message().Sender), ctx.Message().Sender.ID, ctx.Message().Text)
@@ -36,13 +36,16 @@ var InvoiceCallback InvoiceEventCallback
 
 func initInvoiceEventCallbacks(bot *TipBot) {
 	InvoiceCallback = InvoiceEventCallback{
-		InvoiceCallbackGeneric:         EventHandler{Function: bot.notifyInvoiceReceivedEvent, Type: EventTypeInvoice},
-		InvoiceCallbackInlineReceive:   EventHandler{Function: bot.inlineReceiveEvent, Type: EventTypeInvoice},
-		InvoiceCallbackLNURLPayReceive: EventHandler{Function: bot.lnurlReceiveEvent, Type: EventTypeInvoice},
-		InvoiceCallbackGroupTicket:     EventHandler{Function: bot.groupGetInviteLinkHandler, Type: EventTypeInvoice},
-		InvoiceCallbackSatdressProxy:   EventHandler{Function: bot.satdressProxyRelayPaymentHandler, Type: EventTypeInvoice},
-		InvoiceCallbackGenerateDalle:   EventHandler{Function: bot.generateDalleImages, Type: EventTypeInvoice},
-		InvoiceCallbackPayJoinTicket:   EventHandler{Function: bot.stopJoinTicketTimer, Type: EventTypeInvoice},
+		InvoiceCallbackGeneric:                EventHandler{Function: bot.notifyInvoiceReceivedEvent, Type: EventTypeInvoice},
+		InvoiceCallbackInlineReceive:          EventHandler{Function: bot.inlineReceiveEvent, Type: EventTypeInvoice},
+		InvoiceCallbackLNURLPayReceive:        EventHandler{Function: bot.lnurlReceiveEvent, Type: EventTypeInvoice},
+		InvoiceCallbackGroupTicket:            EventHandler{Function: bot.groupGetInviteLinkHandler, Type: EventTypeInvoice},
+		InvoiceCallbackSatdressProxy:          EventHandler{Function: bot.satdressProxyRelayPaymentHandler, Type: EventTypeInvoice},
+		InvoiceCallbackGenerateDalle:          EventHandler{Function: bot.generateDalleImages, Type: EventTypeInvoice},
+		InvoiceCallbackPayJoinTicket:          EventHandler{Function: bot.stopJoinTicketTimer, Type: EventTypeInvoice},
+		InvoiceCallbackGroupMembershipRenewal: EventHandler{Function: bot.groupMembershipRenewedHandler, Type: EventTypeInvoice},
+		InvoiceCallbackPayToPost:              EventHandler{Function: bot.payToPostPaidHandler, Type: EventTypeInvoice},
+		InvoiceCallbackGroupTreasuryReceive:   EventHandler{Function: bot.groupTreasuryReceiveEvent, Type: EventTypeInvoice},
 	}
 }
 
@@ -56,6 +59,9 @@ const (
 	InvoiceCallbackSatdressProxy
 	InvoiceCallbackGenerateDalle
 	InvoiceCallbackPayJoinTicket
+	InvoiceCallbackGroupMembershipRenewal
+	InvoiceCallbackPayToPost
+	InvoiceCallbackGroupTreasuryReceive
 )
 
 const (
@@ -219,6 +225,10 @@ func (bot *TipBot) notifyInvoiceReceivedEvent(event Event) {
 		errmsg := fmt.Sprintf("could not get balance of user %s", GetUserStr(invoiceEvent.User.Telegram))
 		log.Errorln(errmsg)
 	}
+	// lightning address payments are notified in lnurlReceiveEvent
+	if invoiceEvent.Callback != InvoiceCallbackLNURLPayReceive {
+		go bot.sendNostrNotification(invoiceEvent.User, "nostrDMPaymentReceivedMessage", invoiceEvent.Amount)
+	}
 
 	if invoiceEvent.UserCurrency == "" || strings.ToLower(invoiceEvent.UserCurrency) == "btc" {
 		bot.trySendMessage(invoiceEvent.User.Telegram, fmt.Sprintf(i18n.Translate(invoiceEvent.User.Telegram.LanguageCode, "invoiceReceivedMessage"), invoiceEvent.Amount))
@@ -257,6 +267,14 @@ func (bot *TipBot) lnurlReceiveEvent(event Event) {
 	tx := &LNURLInvoice{Invoice: &Invoice{PaymentHash: invoiceEvent.PaymentHash}}
 	err := bot.Bunt.Get(tx)
 	log.Debugf("[lnurl-p] Received invoice for %s of %d sat.", GetUserStr(invoiceEvent.User.Telegram), tx.Amount)
+	switch {
+	case err == nil && len(tx.Nip57Receipt.Sig) > 0:
+		go bot.sendNostrNotification(invoiceEvent.User, "nostrDMZapReceivedMessage", invoiceEvent.Amount)
+	case err == nil && len(tx.Comment) > 0:
+		go bot.sendNostrNotification(invoiceEvent.User, "nostrDMPaymentReceivedCommentMessage", invoiceEvent.Amount, tx.Comment)
+	default:
+		go bot.sendNostrNotification(invoiceEvent.User, "nostrDMPaymentReceivedMessage", invoiceEvent.Amount)
+	}
 	if err == nil {
 		// filter: if tx.Comment includes a URL, return if tx.Amount is less than 100 sat
 		if len(tx.Comment) > 0 && tx.Amount < 100 {
@@ -288,7 +306,11 @@ func (bot *TipBot) lnurlReceiveEvent(event Event) {
 		if len(tx.Nip57Receipt.Sig) > 0 {
 			// zapEventSerialized, _ := json.Marshal(tx.Nip57Receipt)
 			bot.trySendMessage(tx.User.Telegram, "💜 This was a zap on nostr.")
-			go bot.publishNostrEvent(tx.Nip57Receipt, tx.Nip57ReceiptRelays)
+			relays := tx.Nip57ReceiptRelays
+			if user, err := GetLnbitsUserWithSettings(tx.User.Telegram, *bot); err == nil {
+				relays = append(relays, userNostrRelays(user)...)
+			}
+			go bot.publishNostrEvent(tx.Nip57Receipt, relays)
 		}
 	}
 }
@@ -3,11 +3,14 @@ package telegram
 import (
 	"bytes"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
 
 	"github.com/massmux/SatsMobiBot/internal"
+	"github.com/massmux/SatsMobiBot/internal/apikey"
+	"github.com/massmux/SatsMobiBot/internal/str"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/skip2/go-qrcode"
@@ -53,6 +56,9 @@ func (bot *TipBot) lndhubHandler(ctx intercept.Context) (intercept.Context, erro
 func (bot *TipBot) apiHandler(ctx intercept.Context) (intercept.Context, error) {
 	m := ctx.Message()
 	fromUser := LoadUser(ctx)
+	if action, err := getArgumentFromCommand(m.Text, 1); err == nil && strings.ToLower(action) == "keys" {
+		return bot.apiKeysHandler(ctx)
+	}
 	apimesg := bot.trySendMessageEditable(m.Sender, fmt.Sprintf(Translate(ctx, "apiConnectMessage"), fromUser.Wallet.Adminkey, fromUser.Wallet.Inkey))
 	// auto delete
 	go func() {
@@ -61,3 +67,85 @@ func (bot *TipBot) apiHandler(ctx intercept.Context) (intercept.Context, error)
 	}()
 	return ctx, nil
 }
+
+// apiKeysHandler manages the scoped API keys of the user with /api keys new|list|revoke
+func (bot *TipBot) apiKeysHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	fromUser := LoadUser(ctx)
+	args := strings.Fields(m.Text)[2:]
+	action := ""
+	if len(args) > 0 {
+		action = strings.ToLower(args[0])
+	}
+	switch action {
+	case "new":
+		key, err := apikey.Parse(args[1:])
+		if err != nil {
+			bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "apiKeysInvalidMessage"), err.Error()))
+			return ctx, err
+		}
+		secret, key, err := apikey.Create(bot.DB.Users, fromUser.Name, key)
+		if err != nil {
+			log.Errorf("[apiKeysHandler] could not create api key for %s: %v", GetUserStr(fromUser.Telegram), err)
+			bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "apiKeysInvalidMessage"), err.Error()))
+			return ctx, err
+		}
+		log.Infof("[apiKeysHandler] %s created api key %s", GetUserStr(fromUser.Telegram), key.ID)
+		keymsg := bot.trySendMessageEditable(m.Sender, fmt.Sprintf(Translate(ctx, "apiKeysCreatedMessage"), secret, formatAPIKey(ctx, key)))
+		// the secret is only shown once
+		go func() {
+			time.Sleep(time.Second * 60)
+			bot.tryEditMessage(keymsg, fmt.Sprintf(Translate(ctx, "apiKeysCreatedHiddenMessage"), key.ID))
+		}()
+	case "list":
+		keys, err := apikey.List(bot.DB.Users, fromUser.Name)
+		if err != nil {
+			return ctx, err
+		}
+		if len(keys) == 0 {
+			bot.trySendMessage(m.Sender, Translate(ctx, "apiKeysEmptyMessage"))
+			return ctx, nil
+		}
+		list := ""
+		for _, key := range keys {
+			list += formatAPIKey(ctx, key) + "\n"
+		}
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "apiKeysListMessage"), list))
+	case "revoke":
+		if len(args) < 2 {
+			bot.trySendMessage(m.Sender, Translate(ctx, "apiKeysHelpMessage"))
+			return ctx, nil
+		}
+		if err := apikey.Revoke(bot.DB.Users, fromUser.Name, strings.ToLower(args[1])); err != nil {
+			bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "apiKeysInvalidMessage"), err.Error()))
+			return ctx, err
+		}
+		log.Infof("[apiKeysHandler] %s revoked api key %s", GetUserStr(fromUser.Telegram), args[1])
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "apiKeysRevokedMessage"), args[1]))
+	default:
+		bot.trySendMessage(m.Sender, Translate(ctx, "apiKeysHelpMessage"))
+	}
+	return ctx, nil
+}
+
+func formatAPIKey(ctx intercept.Context, key apikey.Key) string {
+	label := str.MarkdownEscape(key.Label)
+	if len(label) == 0 {
+		label = "-"
+	}
+	limit, expires, lastUsed := "-", Translate(ctx, "apiKeysNeverMessage"), Translate(ctx, "apiKeysNeverMessage")
+	if key.HasScope(apikey.ScopePay) {
+		limit = fmt.Sprintf("%d sat/day", key.DailyLimit)
+	}
+	if !key.ExpiresAt.IsZero() {
+		expires = key.ExpiresAt.UTC().Format("2006-01-02 15:04")
+	}
+	if !key.LastUsedAt.IsZero() {
+		lastUsed = key.LastUsedAt.UTC().Format("2006-01-02 15:04")
+	}
+	endpoints := key.Endpoints
+	if len(endpoints) == 0 {
+		endpoints = "*"
+	}
+	return fmt.Sprintf(Translate(ctx, "apiKeyMessage"), key.ID, label, key.Scopes, endpoints, limit, expires, lastUsed)
+}
@@ -15,10 +15,12 @@ import (
 	"github.com/massmux/SatsMobiBot/internal/errors"
 	"github.com/massmux/SatsMobiBot/internal/runtime/mutex"
 	"github.com/massmux/SatsMobiBot/internal/storage"
+	"github.com/massmux/SatsMobiBot/internal/str"
 
 	"github.com/massmux/SatsMobiBot/internal/lnbits"
 	"github.com/massmux/SatsMobiBot/internal/runtime"
 
+	decodepay "github.com/fiatjaf/ln-decodepay"
 	lnurl "github.com/fiatjaf/go-lnurl"
 	log "github.com/sirupsen/logrus"
 )
@@ -252,3 +254,72 @@ func (bot *TipBot) sendToLightningAddress(ctx intercept.Context, address string,
 	}
 	return bot.lnurlHandler(ctx)
 }
+
+// fetchLnurlPayInvoice resolves a lightning address or LNURL and returns an invoice
+// for amount (in sat) without asking the user. It is used for unattended payments.
+func (bot *TipBot) fetchLnurlPayInvoice(target string, amount int64, comment string) (string, error) {
+	_, params, err := bot.HandleLNURL(target)
+	if err != nil {
+		return "", err
+	}
+	payParams, ok := params.(lnurl.LNURLPayParams)
+	if !ok {
+		return "", fmt.Errorf("%s is not an LNURL-pay", target)
+	}
+	amountMsat := amount * 1000
+	if amountMsat < payParams.MinSendable || amountMsat > payParams.MaxSendable {
+		return "", fmt.Errorf("amount out of bounds (min: %d sat, max: %d sat)", payParams.MinSendable/1000, payParams.MaxSendable/1000)
+	}
+	query := url.Values{}
+	if len(comment) > 0 && payParams.CommentAllowed > 0 {
+		// commentAllowed counts characters, not bytes
+		query.Set("comment", str.Truncate(comment, int(payParams.CommentAllowed)))
+	}
+	return fetchLnurlPayCallback(payParams.Callback, amountMsat, query)
+}
+
+// fetchLnurlPayCallback requests an invoice of amountMsat from an LNURL-pay callback with
+// additional query parameters and checks that the invoice amount matches.
+func fetchLnurlPayCallback(callback string, amountMsat int64, query url.Values) (string, error) {
+	callbackUrl, err := url.Parse(callback)
+	if err != nil {
+		return "", err
+	}
+	client, err := network.GetClientForScheme(callbackUrl)
+	if err != nil {
+		return "", err
+	}
+	qs := callbackUrl.Query()
+	for key, values := range query {
+		qs[key] = values
+	}
+	qs.Set("amount", strconv.FormatInt(amountMsat, 10))
+	callbackUrl.RawQuery = qs.Encode()
+	res, err := client.Get(callbackUrl.String())
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+	var values lnurl.LNURLPayValues
+	if err = json.Unmarshal(body, &values); err != nil {
+		return "", err
+	}
+	if values.Status == "ERROR" || len(values.PR) < 1 {
+		if len(values.Reason) > 0 {
+			return "", fmt.Errorf(values.Reason)
+		}
+		return "", fmt.Errorf("could not receive invoice")
+	}
+	bolt11, err := decodepay.Decodepay(values.PR)
+	if err != nil {
+		return "", err
+	}
+	if bolt11.MSatoshi != amountMsat {
+		return "", fmt.Errorf("invoice amount does not match")
+	}
+	return values.PR, nil
+}
@@ -0,0 +1,260 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/massmux/SatsMobiBot/internal"
+	"github.com/massmux/SatsMobiBot/internal/commands"
+	db "github.com/massmux/SatsMobiBot/internal/database"
+	"github.com/massmux/SatsMobiBot/internal/errors"
+	"github.com/massmux/SatsMobiBot/internal/i18n"
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	"github.com/massmux/SatsMobiBot/internal/matrix"
+	"github.com/massmux/SatsMobiBot/internal/runtime"
+	"github.com/massmux/SatsMobiBot/internal/runtime/mutex"
+	"github.com/massmux/SatsMobiBot/internal/storage"
+	"github.com/massmux/SatsMobiBot/internal/str"
+	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/buntdb"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const (
+	matrixLinkExpiry = 10 * time.Minute
+	// matrixLinkMaxAttempts limits the link attempts of a matrix user per UTC day, so codes can't be guessed
+	matrixLinkMaxAttempts = 5
+	matrixLinkDailyCount  = "matrix-link-attempts"
+	// matrixWalletPrefix is the prefix of the names of the separate wallets of matrix users
+	matrixWalletPrefix = "matrix:"
+)
+
+// MatrixLink is a pending link of a matrix account. The user proves the ownership of the
+// account by sending the code to the bot from matrix.
+type MatrixLink struct {
+	*storage.Base
+	User         *tb.User `json:"user"`
+	LanguageCode string   `json:"languagecode"`
+}
+
+// MatrixRoom is the direct chat of the bot with a matrix user
+type MatrixRoom struct {
+	*storage.Base
+	RoomID string `json:"room_id"`
+}
+
+// matrixRooms stores the direct chats of the matrix adapter
+type matrixRooms struct {
+	bot *TipBot
+}
+
+func (r matrixRooms) DirectRoom(mxid string) (string, bool) {
+	room := &MatrixRoom{Base: storage.New(storage.ID("matrix-room:" + mxid))}
+	if err := r.bot.Bunt.Get(room); err != nil || len(room.RoomID) == 0 {
+		return "", false
+	}
+	return room.RoomID, true
+}
+
+func (r matrixRooms) SetDirectRoom(mxid string, roomID string) {
+	room := &MatrixRoom{Base: storage.New(storage.ID("matrix-room:" + mxid)), RoomID: roomID}
+	runtime.IgnoreError(room.Set(room, r.bot.Bunt))
+}
+
+// matrixReactionExpiry is how long tip reactions on faucets and tipjars in matrix work
+const matrixReactionExpiry = 30 * 24 * time.Hour
+
+// matrixReactions stores the faucets and tipjars of the matrix adapter. They expire after matrixReactionExpiry.
+type matrixReactions struct {
+	bot *TipBot
+}
+
+func (r matrixReactions) Reaction(eventID string) (matrix.Reaction, bool) {
+	reaction := matrix.Reaction{}
+	err := r.bot.Bunt.View(func(tx *buntdb.Tx) error {
+		value, err := tx.Get("matrix-reaction:" + eventID)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal([]byte(value), &reaction)
+	})
+	return reaction, err == nil
+}
+
+func (r matrixReactions) SetReaction(eventID string, reaction matrix.Reaction) {
+	value, err := json.Marshal(reaction)
+	if err != nil {
+		return
+	}
+	runtime.IgnoreError(r.bot.Bunt.Update(func(tx *buntdb.Tx) error {
+		_, _, err := tx.Set("matrix-reaction:"+eventID, string(value), &buntdb.SetOptions{Expires: true, TTL: matrixReactionExpiry})
+		return err
+	}))
+}
+
+// startMatrixAdapter runs the wallet commands in the matrix rooms of the bot user
+func (bot *TipBot) startMatrixAdapter() {
+	config := internal.Configuration.Matrix
+	if len(config.Homeserver) == 0 {
+		return
+	}
+	adapter := matrix.NewAdapter(matrix.NewClient(config.Homeserver, config.UserID, config.AccessToken), bot.CommandDispatcher(nil))
+	adapter.Fallback = TelegramMessenger{bot: bot}
+	adapter.Rooms = matrixRooms{bot: bot}
+	adapter.Reactions = matrixReactions{bot: bot}
+	if len(config.TipReaction) > 0 {
+		adapter.TipReaction = config.TipReaction
+	}
+	if config.TipReactionAmount > 0 {
+		adapter.TipReactionAmount = config.TipReactionAmount
+	}
+	log.Infof("[matrix] Starting adapter for %s on %s", config.UserID, config.Homeserver)
+	if err := adapter.Run(context.Background()); err != nil {
+		log.Errorf("[matrix] adapter stopped: %v", err)
+	}
+}
+
+// matrixWallet returns the wallet of a matrix user. That is the telegram wallet if the
+// account was linked with /matrix link, otherwise a separate wallet. The separate wallet is
+// only created if create is true, when the user receives sats or sends start.
+func (bot *TipBot) matrixWallet(mxid string, create bool) (*lnbits.User, error) {
+	if user, err := db.FindUserByMatrixID(bot.DB.Users, mxid); err == nil && user.Wallet != nil {
+		return user, nil
+	}
+	user := &lnbits.User{}
+	tx := bot.DB.Users.Where("name = ?", matrixWalletPrefix+mxid).First(user)
+	if tx.Error == nil && user.Wallet != nil {
+		return user, nil
+	}
+	if !create {
+		return nil, commands.ErrNoWallet
+	}
+	return bot.createMatrixWallet(mxid)
+}
+
+func (bot *TipBot) createMatrixWallet(mxid string) (*lnbits.User, error) {
+	u, err := bot.Client.CreateUserWithInitialWallet(matrixWalletPrefix+mxid,
+		fmt.Sprintf("matrix (%s)", mxid),
+		internal.Configuration.Lnbits.AdminId,
+		mxid)
+	if err != nil {
+		log.Errorf("[createMatrixWallet] Create wallet error: %s", err.Error())
+		return nil, err
+	}
+	user := &lnbits.User{ID: u.ID, Name: u.Name, Telegram: &tb.User{FirstName: mxid, LanguageCode: "en"}}
+	wallets, err := bot.Client.Wallets(*user)
+	if err != nil || len(wallets) == 0 {
+		log.Errorf("[createMatrixWallet] Get wallet error: %v", err)
+		return nil, fmt.Errorf("could not get wallet of %s", mxid)
+	}
+	user.Wallet = &wallets[0]
+	user.AnonID = fmt.Sprint(str.Int32Hash(user.ID))
+	user.AnonIDSha256 = str.AnonIdSha256(user)
+	user.UUID = str.UUIDSha256(user)
+	user.Initialized = true
+	user.CreatedAt = time.Now()
+	if err = UpdateUserRecord(user, *bot); err != nil {
+		return nil, err
+	}
+	log.Infof("[matrix] Wallet created for %s", mxid)
+	return user, nil
+}
+
+// isMatrixWallet reports whether the wallet is the separate wallet of a matrix user
+func isMatrixWallet(user *lnbits.User) bool {
+	return strings.HasPrefix(user.Name, matrixWalletPrefix)
+}
+
+// matrixHandler is invoked on /matrix link and /matrix unlink
+func (bot *TipBot) matrixHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	config := internal.Configuration.Matrix
+	if len(config.Homeserver) == 0 {
+		bot.trySendMessage(m.Sender, Translate(ctx, "matrixNotAvailableMessage"))
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	action, _ := getArgumentFromCommand(m.Text, 1)
+	switch strings.ToLower(action) {
+	case "link":
+		code, err := newLinkCode()
+		if err != nil {
+			return ctx, err
+		}
+		link := &MatrixLink{
+			Base:         storage.New(storage.ID("matrix-link:" + code)),
+			User:         m.Sender,
+			LanguageCode: ctx.Value("publicLanguageCode").(string),
+		}
+		runtime.IgnoreError(link.Set(link, bot.Bunt))
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "matrixLinkMessage"), config.UserID, code, int(matrixLinkExpiry.Minutes())))
+	case "unlink":
+		user, err := GetLnbitsUserWithSettings(m.Sender, *bot)
+		if err != nil {
+			return ctx, err
+		}
+		user.Settings.Matrix.UserID = ""
+		if err = UpdateUserRecord(user, *bot); err != nil {
+			log.Errorf("[matrixHandler] could not update record of user %s: %v", GetUserStr(user.Telegram), err)
+			return ctx, err
+		}
+		bot.trySendMessage(m.Sender, Translate(ctx, "matrixUnlinkedMessage"))
+	default:
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "matrixHelpMessage"), config.UserID))
+	}
+	return ctx, nil
+}
+
+// Link links the matrix user to the telegram wallet that requested the code. The balance
+// of the separate matrix wallet is moved to the telegram wallet.
+func (s WalletService) Link(user commands.User, code string) error {
+	if user.Frontend != matrix.Frontend {
+		return fmt.Errorf("can't link %s users", user.Frontend)
+	}
+	// every attempt is counted, successful ones are given back
+	if !s.bot.takeDailyCount(matrixLinkDailyCount, user.ID, matrixLinkMaxAttempts) {
+		return fmt.Errorf("too many failed link attempts of %s", user.ID)
+	}
+	link := &MatrixLink{Base: storage.New(storage.ID("matrix-link:" + strings.ToLower(code)))}
+	if err := s.bot.Bunt.Get(link); err != nil || !link.Active || time.Since(link.CreatedAt) > matrixLinkExpiry {
+		return fmt.Errorf("invalid link code")
+	}
+	s.bot.releaseDailyCount(matrixLinkDailyCount, user.ID)
+	runtime.IgnoreError(link.Delete(link, s.bot.Bunt))
+	tgUser, err := GetLnbitsUserWithSettings(link.User, *s.bot)
+	if err != nil {
+		return err
+	}
+	// a matrix account can only be linked to one telegram account
+	if other, err := db.FindUserByMatrixID(s.bot.DB.Users, user.ID); err == nil && other.ID != tgUser.ID {
+		other.Settings.Matrix.UserID = ""
+		runtime.IgnoreError(UpdateUserRecord(other, *s.bot))
+	}
+	tgUser.Settings.Matrix.UserID = user.ID
+	if err = UpdateUserRecord(tgUser, *s.bot); err != nil {
+		log.Errorf("[matrix] could not update record of user %s: %v", GetUserStr(tgUser.Telegram), err)
+		return err
+	}
+	log.Infof("[matrix] %s linked %s", GetUserStr(tgUser.Telegram), user.ID)
+	s.bot.trySendMessage(tgUser.Telegram, fmt.Sprintf(i18n.Translate(link.LanguageCode, "matrixLinkedMessage"), user.ID))
+
+	matrixWallet := &lnbits.User{}
+	if tx := s.bot.DB.Users.Where("name = ?", matrixWalletPrefix+user.ID).First(matrixWallet); tx.Error != nil || matrixWallet.Wallet == nil {
+		return nil
+	}
+	balance, err := s.bot.GetUserBalance(matrixWallet)
+	if err != nil || balance <= 0 {
+		return nil
+	}
+	mutex.Lock(walletLockKey(matrixWallet))
+	defer mutex.Unlock(walletLockKey(matrixWallet))
+	if err = s.bot.transfer(matrixWallet, tgUser, balance, "link", ""); err != nil {
+		log.Errorf("[matrix] could not move %d sat of %s to %s: %v", balance, user.ID, GetUserStr(tgUser.Telegram), err)
+		return nil
+	}
+	s.bot.trySendMessage(tgUser.Telegram, fmt.Sprintf(i18n.Translate(link.LanguageCode, "matrixLinkMovedMessage"), balance))
+	return nil
+}
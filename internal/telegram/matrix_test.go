@@ -0,0 +1,70 @@
+package telegram
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/massmux/SatsMobiBot/internal"
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	"github.com/massmux/SatsMobiBot/internal/matrix"
+	"github.com/tidwall/buntdb"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+// matrixLinkCode runs /matrix link for the user and returns the code of the pending link
+func (test *testBot) matrixLinkCode(t *testing.T, user *lnbits.User) string {
+	homeserver := internal.Configuration.Matrix.Homeserver
+	t.Cleanup(func() { internal.Configuration.Matrix.Homeserver = homeserver })
+	internal.Configuration.Matrix.Homeserver = "https://matrix.example.org"
+	chat := &tb.Chat{ID: user.Telegram.ID, Type: tb.ChatPrivate}
+	if _, err := test.matrixHandler(test.message(user, chat, "/matrix link")); err != nil {
+		t.Fatalf("matrixHandler() error = %v", err)
+	}
+	code := ""
+	test.Bunt.View(func(tx *buntdb.Tx) error {
+		return tx.AscendKeys("matrix-link:*", func(key, value string) bool {
+			code = strings.TrimPrefix(key, "matrix-link:")
+			return false
+		})
+	})
+	return code
+}
+
+func TestWalletService_Link(t *testing.T) {
+	test := newTestBot(t)
+	alice := test.addUser(t, 2, 0)
+	service := test.WalletService()
+
+	code := test.matrixLinkCode(t, alice)
+	if len(code) != 2*linkCodeBytes || strings.Trim(code, "0123456789abcdef") != "" {
+		t.Fatalf("link code = %q", code)
+	}
+	// failed attempts are limited per matrix user, even the right code is refused afterwards
+	mallory := matrix.User("@mallory:example.org")
+	for i := 0; i < matrixLinkMaxAttempts; i++ {
+		if err := service.Link(mallory, "0000000000"); err == nil {
+			t.Fatalf("Link() accepted a wrong code")
+		}
+	}
+	if err := service.Link(mallory, code); err == nil {
+		t.Fatalf("Link() accepted a code after %d failed attempts", matrixLinkMaxAttempts)
+	}
+
+	bob := matrix.User("@bob:example.org")
+	if err := service.Link(bob, "0000000000"); err == nil {
+		t.Fatalf("Link() accepted a wrong code")
+	}
+	if err := service.Link(bob, strings.ToUpper(code)); err != nil {
+		t.Fatalf("Link() error = %v", err)
+	}
+	user, err := GetLnbitsUserWithSettings(alice.Telegram, *test.TipBot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Settings.Matrix.UserID != bob.ID {
+		t.Errorf("linked matrix user = %q, want %q", user.Settings.Matrix.UserID, bob.ID)
+	}
+	if got := test.dailyCount(matrixLinkDailyCount, bob.ID); got != 1 {
+		t.Errorf("link attempts of %s = %d, want only the failed one", bob.ID, got)
+	}
+}
@@ -1,10 +1,8 @@
 package telegram
 
 import (
-	"context"
 	"fmt"
 	"strings"
-	"time"
 
 	"github.com/massmux/SatsMobiBot/internal"
 	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
@@ -17,12 +15,23 @@ var (
 	nosterRegisterMessage       = "📖 Add your nostr pubkey for zap receipts"
 	nostrInfoMessage            = "💜 *Your nostr information*\n\nYour pubkey: `%s`"
 	nostrInfoLNAddrMessage      = "Your Lightning address: `%s`"
-	nostrHelpMessage            = "⚙️ *Nostr commands:*\n`/nostr add <pubkey>` ✅ Add your nostr pubkey.\n`/nostr help` 📖 Show help."
+	nostrHelpMessage            = "⚙️ *Nostr commands:*\n`/nostr add <pubkey>` ✅ Add your nostr pubkey.\n`/nostr notify <nip04|nip17|off>` 🔔 Receive notifications as nostr DMs.\n`/nostr relays [add|remove <url>]` 📡 Manage your relays.\n`/nostr name [remove] <name>` 🪪 Register NIP-05 names.\n`/nostr link` 🔗 Control your wallet with nostr DMs.\n`/nostr help` 📖 Show help."
 	nostrAddedMessage           = "✅ *Nostr pubkey added.*"
 	nostrPrivateKeyErrorMessage = "🚫 This is not your public key but your private key! Very dangerous! Try again with your npub..."
 	nostrPublicKeyErrorMessage  = "🚫 There was an error decoding your public key."
 )
 
+// defaultNostrRelays are used if no relays are configured
+var defaultNostrRelays = []string{"wss://nostr.massmux.com", "wss://relay.nostr.ch", "wss://eden.nostr.land", "wss://nostr.btcmp.com", "wss://nostr.relayer.se", "wss://relay.current.fyi", "wss://nos.lol", "wss://nostr.mom", "wss://relay.nostr.info", "wss://nostr.zebedee.cloud", "wss://nostr-pub.wellorder.net", "wss://relay.snort.social/", "wss://relay.damus.io/", "wss://nostr.oxtr.dev/", "wss://nostr.fmt.wiz.biz/", "wss://brb.io"}
+
+// nostrRelays returns the configured relays the bot publishes to and queries
+func nostrRelays() []string {
+	if len(internal.Configuration.Nostr.Relays) > 0 {
+		return internal.Configuration.Nostr.Relays
+	}
+	return defaultNostrRelays
+}
+
 func uniqueSlice(slice []string) []string {
 	keys := make(map[string]bool)
 	list := []string{}
@@ -62,42 +71,12 @@ func (bot *TipBot) publishNostrEvent(ev nostr.Event, relays []string) {
 
 	// calling Sign sets the event ID field and the event Sig field
 	ev.Sign(pk)
-	log.Debugf("[NOSTR] 🟣 publishing nostr event %s", ev.ID)
-
-	// more relays
-	relays = append(relays, "wss://nostr.massmux.com","wss://relay.nostr.ch", "wss://eden.nostr.land", "wss://nostr.btcmp.com", "wss://nostr.relayer.se", "wss://relay.current.fyi", "wss://nos.lol", "wss://nostr.mom", "wss://relay.nostr.info", "wss://nostr.zebedee.cloud", "wss://nostr-pub.wellorder.net", "wss://relay.snort.social/", "wss://relay.damus.io/", "wss://nostr.oxtr.dev/", "wss://nostr.fmt.wiz.biz/", "wss://brb.io")
-
-	// remove trailing /
-	relays = cleanUrls(relays)
-
-	// unique relays
-	relays = uniqueSlice(relays)
-
-	// crop relays
-	var max_relays int = 50
-	if len(relays) > max_relays {
-		relays = relays[:max_relays]
-	}
-
-	// publish the event to relays
-	for _, url := range relays {
-		go func(url string) {
-			// remove trailing /
-			relay, e := nostr.RelayConnect(context.Background(), url)
-			if e != nil {
-				log.Errorf(e.Error())
-				return
-			}
-			time.Sleep(3 * time.Second)
-
-			status := relay.Publish(context.Background(), ev)
-			log.Debugf("[NOSTR] published to %s: %s", url, status)
-
-			time.Sleep(3 * time.Second)
-			relay.Close()
-		}(url)
+	bot.publishSignedNostrEvent(ev, relays)
+}
 
-	}
+// publishSignedNostrEvent publishes an event that is already signed to the given and the configured relays
+func (bot *TipBot) publishSignedNostrEvent(ev nostr.Event, relays []string) {
+	bot.enqueueNostrEvent(ev, append(relays, nostrRelays()...))
 }
 
 func (bot *TipBot) nostrHandler(ctx intercept.Context) (intercept.Context, error) {
@@ -109,6 +88,14 @@ func (bot *TipBot) nostrHandler(ctx intercept.Context) (intercept.Context, error
 		switch strings.ToLower(splits[1]) {
 		case "add":
 			return bot.addNostrPubkeyHandler(ctx)
+		case "notify":
+			return bot.nostrNotifyHandler(ctx)
+		case "relays":
+			return bot.nostrRelaysHandler(ctx)
+		case "name":
+			return bot.nostrNameHandler(ctx)
+		case "link", "unlink":
+			return bot.nostrLinkHandler(ctx)
 		case "help":
 			return bot.nostrHelpHandler(ctx)
 		}
@@ -149,6 +136,10 @@ func (bot *TipBot) addNostrPubkeyHandler(ctx intercept.Context) (intercept.Conte
 		return ctx, err
 	}
 	// save node in db
+	if user.Settings.Nostr.PubKey != nostrKeyInput {
+		// a new pubkey has to be verified with /nostr link again
+		user.Settings.Nostr.Linked = false
+	}
 	user.Settings.Nostr.PubKey = nostrKeyInput
 	err = UpdateUserRecord(user, *bot)
 	if err != nil {
@@ -0,0 +1,287 @@
+package telegram
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/massmux/SatsMobiBot/internal"
+	"github.com/massmux/SatsMobiBot/internal/commands"
+	db "github.com/massmux/SatsMobiBot/internal/database"
+	"github.com/massmux/SatsMobiBot/internal/errors"
+	"github.com/massmux/SatsMobiBot/internal/i18n"
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	"github.com/massmux/SatsMobiBot/internal/runtime"
+	"github.com/massmux/SatsMobiBot/internal/storage"
+	"github.com/massmux/SatsMobiBot/internal/str"
+	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip04"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/buntdb"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const (
+	nostrLinkExpiry       = 10 * time.Minute
+	nostrDMMaxAge         = 2 * time.Minute
+	nostrDMReconnectDelay = 30 * time.Second
+	linkCodeBytes         = 5
+)
+
+// NostrLink is a pending verification of a nostr pubkey. The user proves the ownership of
+// the key by sending the code in a DM that is signed with the key.
+type NostrLink struct {
+	*storage.Base
+	User         *tb.User `json:"user"`
+	LanguageCode string   `json:"languagecode"`
+}
+
+// newLinkCode returns a random code that the user sends to the bot to prove the ownership of
+// a nostr pubkey or a matrix account. Whoever sends the code can spend from the wallet.
+func newLinkCode() (string, error) {
+	b := make([]byte, linkCodeBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// nostrLinkHandler is invoked on /nostr link and /nostr unlink
+func (bot *TipBot) nostrLinkHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	pk := internal.Configuration.Nostr.PrivateKey
+	if len(pk) == 0 {
+		bot.trySendMessage(m.Sender, Translate(ctx, "nostrNotifyNotAvailableMessage"))
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	user, err := GetLnbitsUserWithSettings(m.Sender, *bot)
+	if err != nil {
+		return ctx, err
+	}
+	if action, _ := getArgumentFromCommand(m.Text, 1); strings.ToLower(action) == "unlink" {
+		user.Settings.Nostr.Linked = false
+		err = UpdateUserRecord(user, *bot)
+		if err != nil {
+			log.Errorf("[nostrLinkHandler] could not update record of user %s: %v", GetUserStr(user.Telegram), err)
+			return ctx, err
+		}
+		bot.trySendMessage(m.Sender, Translate(ctx, "nostrUnlinkedMessage"))
+		return ctx, nil
+	}
+
+	code, err := newLinkCode()
+	if err != nil {
+		return ctx, err
+	}
+	link := &NostrLink{
+		Base:         storage.New(storage.ID("nostr-link:" + code)),
+		User:         m.Sender,
+		LanguageCode: ctx.Value("publicLanguageCode").(string),
+	}
+	runtime.IgnoreError(link.Set(link, bot.Bunt))
+	servicePubkey, err := nostr.GetPublicKey(pk)
+	if err != nil {
+		return ctx, err
+	}
+	npub, err := nip19.EncodePublicKey(servicePubkey)
+	if err != nil {
+		return ctx, err
+	}
+	bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "nostrLinkMessage"), npub, code, int(nostrLinkExpiry.Minutes())))
+	return ctx, nil
+}
+
+// confirmNostrLink links the pubkey that signed the DM to the telegram account that requested the code
+func (bot *TipBot) confirmNostrLink(pubkey string, code string) (string, error) {
+	link := &NostrLink{Base: storage.New(storage.ID("nostr-link:" + strings.ToLower(code)))}
+	if err := bot.Bunt.Get(link); err != nil || !link.Active || time.Since(link.CreatedAt) > nostrLinkExpiry {
+		return i18n.Translate("en", "nostrLinkInvalidMessage"), fmt.Errorf("invalid link code")
+	}
+	runtime.IgnoreError(link.Delete(link, bot.Bunt))
+	user, err := GetLnbitsUserWithSettings(link.User, *bot)
+	if err != nil {
+		return i18n.Translate(link.LanguageCode, "walletCommandErrorMessage"), err
+	}
+	// a pubkey can only be linked to one account
+	if other, err := db.FindUserByNostrPubkey(bot.DB.Users, pubkey); err == nil && other.ID != user.ID {
+		other.Settings.Nostr.Linked = false
+		runtime.IgnoreError(UpdateUserRecord(other, *bot))
+	}
+	user.Settings.Nostr.PubKey = pubkey
+	user.Settings.Nostr.Linked = true
+	err = UpdateUserRecord(user, *bot)
+	if err != nil {
+		log.Errorf("[confirmNostrLink] could not update record of user %s: %v", GetUserStr(user.Telegram), err)
+		return i18n.Translate(link.LanguageCode, "walletCommandErrorMessage"), err
+	}
+	npub, _ := nip19.EncodePublicKey(pubkey)
+	log.Infof("[nostr] %s linked pubkey %s", GetUserStr(user.Telegram), pubkey)
+	bot.trySendMessage(user.Telegram, fmt.Sprintf(i18n.Translate(link.LanguageCode, "nostrLinkedMessage"), npub))
+	return i18n.Translate(link.LanguageCode, "nostrLinkedDMMessage"), nil
+}
+
+// startNostrDMListener listens for direct messages to the bot on all relays of the bot
+func (bot *TipBot) startNostrDMListener() {
+	if len(internal.Configuration.Nostr.PrivateKey) == 0 {
+		return
+	}
+	for _, relay := range nostrRelays() {
+		go func(relayURL string) {
+			for {
+				err := bot.listenNostrDMs(relayURL)
+				if err != nil {
+					log.Debugf("[nostr] DM relay connection to %s lost: %s", relayURL, err.Error())
+				}
+				time.Sleep(nostrDMReconnectDelay)
+			}
+		}(relay)
+	}
+}
+
+// listenNostrDMs handles direct messages to the bot until the relay connection breaks
+func (bot *TipBot) listenNostrDMs(relayURL string) error {
+	servicePubkey, err := nostr.GetPublicKey(internal.Configuration.Nostr.PrivateKey)
+	if err != nil {
+		return err
+	}
+	relay, err := nostr.RelayConnect(context.Background(), relayURL)
+	if err != nil {
+		return err
+	}
+	defer relay.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	since := time.Now().Add(-nostrDMMaxAge)
+	sub := relay.Subscribe(ctx, nostr.Filters{{
+		Kinds: []int{nostr.KindEncryptedDirectMessage},
+		Tags:  nostr.TagMap{"p": []string{servicePubkey}},
+		Since: &since,
+	}})
+	for {
+		select {
+		case ev, ok := <-sub.Events:
+			if !ok {
+				return fmt.Errorf("subscription closed")
+			}
+			go bot.handleNostrDM(ev)
+		case notice := <-relay.Notices:
+			log.Debugf("[nostr] notice from %s: %s", relayURL, notice)
+		case err := <-relay.ConnectionError:
+			return err
+		}
+	}
+}
+
+// acceptNostrDM checks the signature and age of a DM and remembers its id in bunt until the DM
+// ages out. The same DM arrives from every relay and again after a reconnect, but it must only
+// run once.
+func (bot *TipBot) acceptNostrDM(ev *nostr.Event) error {
+	// the DM authenticates the user, so the signature is checked here as well and not
+	// only by the relay client
+	if ok, err := ev.CheckSignature(); err != nil || !ok {
+		return fmt.Errorf("invalid signature")
+	}
+	// old commands must not be replayed
+	if time.Since(ev.CreatedAt) > nostrDMMaxAge || time.Until(ev.CreatedAt) > nostrDMMaxAge {
+		return fmt.Errorf("DM is too old or dated in the future")
+	}
+	return bot.Bunt.Update(func(tx *buntdb.Tx) error {
+		key := fmt.Sprintf("nostr-dm:%s", ev.GetID())
+		if _, err := tx.Get(key); err == nil {
+			return fmt.Errorf("DM was already handled")
+		}
+		_, _, err := tx.Set(key, ev.PubKey, &buntdb.SetOptions{Expires: true, TTL: 2 * nostrDMMaxAge})
+		return err
+	})
+}
+
+// handleNostrDM decrypts a direct message, runs the command for the linked user and replies with a DM
+func (bot *TipBot) handleNostrDM(ev *nostr.Event) {
+	// the id is computed from the content because relays could send a signed event with a forged id
+	id := ev.GetID()
+	if err := bot.acceptNostrDM(ev); err != nil {
+		log.Debugf("[nostr] ignored DM %s: %s", id, err.Error())
+		return
+	}
+
+	pk := internal.Configuration.Nostr.PrivateKey
+	sharedSecret, err := nip04.ComputeSharedSecret(ev.PubKey, pk)
+	if err != nil {
+		return
+	}
+	content, err := nip04.Decrypt(ev.Content, sharedSecret)
+	if err != nil {
+		log.Debugf("[nostr] could not decrypt DM %s: %s", id, err.Error())
+		return
+	}
+	messenger := &nostrMessenger{bot: bot, pubkey: ev.PubKey, eventID: id, sharedSecret: sharedSecret}
+	msg := commands.Message{Text: content}
+	command, err := commands.Parse(content)
+	if err != nil {
+		return
+	}
+	if command.Name == "link" && len(command.Args) > 0 {
+		reply, err := bot.confirmNostrLink(ev.PubKey, command.Args[0])
+		if err != nil {
+			log.Infof("[nostr] link from %s failed: %s", ev.PubKey[:8], err.Error())
+		}
+		runtime.IgnoreError(messenger.Reply(msg, reply))
+		return
+	}
+	user, err := db.FindUserByNostrPubkey(bot.DB.Users, ev.PubKey)
+	if err != nil || user.Wallet == nil {
+		runtime.IgnoreError(messenger.Reply(msg, i18n.Translate("en", "nostrNotLinkedMessage")))
+		return
+	}
+	messenger.user = user
+	msg.From = CommandUser(user)
+	err = bot.CommandDispatcher(messenger).Handle(msg)
+	if err != nil {
+		log.Infof("[nostr] DM command %s from %s: %s", command.Name, ev.PubKey[:8], err.Error())
+		return
+	}
+	if command.Name == "pay" || command.Name == "send" {
+		// tell the user on telegram about payments that were made from nostr
+		bot.trySendMessage(user.Telegram, fmt.Sprintf(i18n.Translate(user.Telegram.LanguageCode, "nostrDMCommandMessage"), str.MarkdownEscape(messenger.reply)))
+	}
+}
+
+// nostrMessenger answers commands of nostr DMs with a DM. Notifications for other users go to telegram.
+type nostrMessenger struct {
+	bot          *TipBot
+	user         *lnbits.User
+	pubkey       string
+	eventID      string
+	sharedSecret []byte
+	reply        string
+}
+
+func (m *nostrMessenger) Reply(msg commands.Message, text string) error {
+	m.reply = text
+	encrypted, err := nip04.Encrypt(text, m.sharedSecret)
+	if err != nil {
+		return err
+	}
+	servicePubkey, err := nostr.GetPublicKey(internal.Configuration.Nostr.PrivateKey)
+	if err != nil {
+		return err
+	}
+	m.bot.publishNostrEvent(nostr.Event{
+		PubKey:    servicePubkey,
+		CreatedAt: time.Now(),
+		Kind:      nostr.KindEncryptedDirectMessage,
+		Tags:      nostr.Tags{nostr.Tag{"p", m.pubkey}, nostr.Tag{"e", m.eventID}},
+		Content:   encrypted,
+	}, userNostrRelays(m.user))
+	return nil
+}
+
+func (m *nostrMessenger) Notify(user commands.User, text string) error {
+	return TelegramMessenger{bot: m.bot}.Notify(user, text)
+}
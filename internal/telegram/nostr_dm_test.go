@@ -0,0 +1,261 @@
+package telegram
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/massmux/SatsMobiBot/internal"
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	"github.com/massmux/SatsMobiBot/internal/storage"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip04"
+	"github.com/tidwall/buntdb"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+// newNostrDMTestBot returns a test bot with a nostr key whose replies go to a closed port
+func newNostrDMTestBot(t *testing.T) *testBot {
+	privateKey, relays := internal.Configuration.Nostr.PrivateKey, internal.Configuration.Nostr.Relays
+	t.Cleanup(func() {
+		internal.Configuration.Nostr.PrivateKey, internal.Configuration.Nostr.Relays = privateKey, relays
+	})
+	internal.Configuration.Nostr.PrivateKey = nostr.GeneratePrivateKey()
+	internal.Configuration.Nostr.Relays = []string{"ws://127.0.0.1:1"}
+	return newTestBot(t)
+}
+
+// nostrLinkCode runs /nostr link for the user and returns the code of the new pending link
+func (test *testBot) nostrLinkCode(t *testing.T, user *lnbits.User) string {
+	pending := func() map[string]bool {
+		codes := make(map[string]bool)
+		test.Bunt.View(func(tx *buntdb.Tx) error {
+			return tx.AscendKeys("nostr-link:*", func(key, value string) bool {
+				codes[strings.TrimPrefix(key, "nostr-link:")] = true
+				return true
+			})
+		})
+		return codes
+	}
+	before := pending()
+	chat := &tb.Chat{ID: user.Telegram.ID, Type: tb.ChatPrivate}
+	if _, err := test.nostrLinkHandler(test.message(user, chat, "/nostr link")); err != nil {
+		t.Fatalf("nostrLinkHandler() error = %v", err)
+	}
+	for code := range pending() {
+		if !before[code] {
+			return code
+		}
+	}
+	t.Fatalf("nostrLinkHandler() created no link")
+	return ""
+}
+
+// nostrDM returns a signed DM with the text from the key to the bot
+func nostrDM(t *testing.T, secret string, text string) *nostr.Event {
+	pubkey, _ := nostr.GetPublicKey(secret)
+	servicePubkey, _ := nostr.GetPublicKey(internal.Configuration.Nostr.PrivateKey)
+	sharedSecret, err := nip04.ComputeSharedSecret(servicePubkey, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := nip04.Encrypt(text, sharedSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := &nostr.Event{
+		PubKey:    pubkey,
+		CreatedAt: time.Now(),
+		Kind:      nostr.KindEncryptedDirectMessage,
+		Tags:      nostr.Tags{nostr.Tag{"p", servicePubkey}},
+		Content:   encrypted,
+	}
+	if err = ev.Sign(secret); err != nil {
+		t.Fatal(err)
+	}
+	return ev
+}
+
+func linkedNostrPubkey(t *testing.T, test *testBot, user *lnbits.User) string {
+	user, err := GetLnbitsUserWithSettings(user.Telegram, *test.TipBot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !user.Settings.Nostr.Linked {
+		return ""
+	}
+	return user.Settings.Nostr.PubKey
+}
+
+func TestTipBot_nostrLinkHandler(t *testing.T) {
+	test := newNostrDMTestBot(t)
+	alice := test.addUser(t, 2, 0)
+	code := test.nostrLinkCode(t, alice)
+	if len(code) != 2*linkCodeBytes || strings.Trim(code, "0123456789abcdef") != "" {
+		t.Errorf("link code = %q", code)
+	}
+	link := &NostrLink{Base: storage.New(storage.ID("nostr-link:" + code))}
+	if err := test.Bunt.Get(link); err != nil || link.User.ID != alice.Telegram.ID {
+		t.Errorf("link = %+v, %v", link, err)
+	}
+}
+
+func TestTipBot_confirmNostrLink(t *testing.T) {
+	test := newNostrDMTestBot(t)
+	alice := test.addUser(t, 2, 0)
+	bob := test.addUser(t, 3, 0)
+	pubkey, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+
+	if _, err := test.confirmNostrLink(pubkey, "unknown"); err == nil {
+		t.Errorf("confirmNostrLink() accepted an unknown code")
+	}
+	code := test.nostrLinkCode(t, alice)
+	if _, err := test.confirmNostrLink(pubkey, strings.ToUpper(code)); err != nil {
+		t.Fatalf("confirmNostrLink() error = %v", err)
+	}
+	if got := linkedNostrPubkey(t, test, alice); got != pubkey {
+		t.Errorf("linked pubkey = %q, want %q", got, pubkey)
+	}
+	// codes can only be used once
+	if _, err := test.confirmNostrLink(pubkey, code); err == nil {
+		t.Errorf("confirmNostrLink() accepted a used code")
+	}
+
+	// expired codes are rejected
+	code = test.nostrLinkCode(t, bob)
+	link := &NostrLink{Base: storage.New(storage.ID("nostr-link:" + code))}
+	if err := test.Bunt.Get(link); err != nil {
+		t.Fatal(err)
+	}
+	link.CreatedAt = time.Now().Add(-nostrLinkExpiry - time.Minute)
+	if err := link.Set(link, test.Bunt); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := test.confirmNostrLink(pubkey, code); err == nil {
+		t.Errorf("confirmNostrLink() accepted an expired code")
+	}
+
+	// linking the pubkey to another account unlinks it from the first one
+	code = test.nostrLinkCode(t, bob)
+	if _, err := test.confirmNostrLink(pubkey, code); err != nil {
+		t.Fatalf("confirmNostrLink() error = %v", err)
+	}
+	if got := linkedNostrPubkey(t, test, bob); got != pubkey {
+		t.Errorf("linked pubkey of the second account = %q, want %q", got, pubkey)
+	}
+	if got := linkedNostrPubkey(t, test, alice); got != "" {
+		t.Errorf("first account is still linked to %q", got)
+	}
+}
+
+func TestTipBot_handleNostrDM(t *testing.T) {
+	test := newNostrDMTestBot(t)
+	alice := test.addUser(t, 2, 0)
+	secret := nostr.GeneratePrivateKey()
+	pubkey, _ := nostr.GetPublicKey(secret)
+
+	code := test.nostrLinkCode(t, alice)
+	// a DM whose content was changed after signing is ignored
+	forged := nostrDM(t, secret, "/link "+code)
+	forged.Content = nostrDM(t, secret, "/link "+code).Content
+	test.handleNostrDM(forged)
+	if got := linkedNostrPubkey(t, test, alice); got != "" {
+		t.Fatalf("forged DM linked %q", got)
+	}
+	// as are DMs that are older than the max age
+	old := nostrDM(t, secret, "/link "+code)
+	old.CreatedAt = time.Now().Add(-2 * nostrDMMaxAge)
+	if err := old.Sign(secret); err != nil {
+		t.Fatal(err)
+	}
+	test.handleNostrDM(old)
+	if got := linkedNostrPubkey(t, test, alice); got != "" {
+		t.Fatalf("old DM linked %q", got)
+	}
+
+	test.handleNostrDM(nostrDM(t, secret, "/link "+code))
+	if got := linkedNostrPubkey(t, test, alice); got != pubkey {
+		t.Errorf("linked pubkey = %q, want %q", got, pubkey)
+	}
+}
+
+// linkTestNostrKey links a new nostr key to the user and returns its secret
+func (test *testBot) linkTestNostrKey(t *testing.T, user *lnbits.User) string {
+	secret := nostr.GeneratePrivateKey()
+	pubkey, _ := nostr.GetPublicKey(secret)
+	if _, err := test.confirmNostrLink(pubkey, test.nostrLinkCode(t, user)); err != nil {
+		t.Fatalf("confirmNostrLink() error = %v", err)
+	}
+	return secret
+}
+
+// nostrReplies decrypts the DMs the bot queued for the key
+func (test *testBot) nostrReplies(t *testing.T, secret string) []string {
+	servicePubkey, _ := nostr.GetPublicKey(internal.Configuration.Nostr.PrivateKey)
+	sharedSecret, err := nip04.ComputeSharedSecret(servicePubkey, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replies := make([]string, 0)
+	test.Bunt.View(func(tx *buntdb.Tx) error {
+		return tx.AscendKeys("nostr-publish:*", func(key, value string) bool {
+			job := &NostrPublishJob{}
+			if json.Unmarshal([]byte(value), job) != nil || job.Event.Kind != nostr.KindEncryptedDirectMessage {
+				return true
+			}
+			if text, err := nip04.Decrypt(job.Event.Content, sharedSecret); err == nil {
+				replies = append(replies, text)
+			}
+			return true
+		})
+	})
+	return replies
+}
+
+func TestTipBot_handleNostrDM_commands(t *testing.T) {
+	test := newNostrDMTestBot(t)
+	alice := test.addUser(t, 2, 1000)
+	test.addUser(t, 3, 0)
+	secret := test.linkTestNostrKey(t, alice)
+
+	test.handleNostrDM(nostrDM(t, secret, "balance"))
+	test.handleNostrDM(nostrDM(t, secret, "send 100 @user3 thanks"))
+	if got := test.balance(3); got != 100 {
+		t.Errorf("balance of the receiver = %d, want 100", got)
+	}
+	replies := strings.Join(test.nostrReplies(t, secret), "\n")
+	if !strings.Contains(replies, "1000 sat") || !strings.Contains(replies, "100 sat") {
+		t.Errorf("replies = %q", replies)
+	}
+}
+
+func TestTipBot_handleNostrDM_duplicates(t *testing.T) {
+	test := newNostrDMTestBot(t)
+	alice := test.addUser(t, 2, 1000)
+	test.addUser(t, 3, 0)
+	secret := test.linkTestNostrKey(t, alice)
+
+	// the same DM from several relays at once
+	ev := nostrDM(t, secret, "send 100 @user3")
+	wg := sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dup := *ev
+			test.handleNostrDM(&dup)
+		}()
+	}
+	wg.Wait()
+	if got := test.balance(3); got != 100 {
+		t.Fatalf("balance after concurrent copies = %d, want 100", got)
+	}
+	// and again after a restart, which empties the cache
+	test.cache.Flush()
+	test.handleNostrDM(ev)
+	if got := test.balance(3); got != 100 {
+		t.Errorf("balance after the DM was delivered again = %d, want 100", got)
+	}
+}
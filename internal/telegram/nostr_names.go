@@ -0,0 +1,136 @@
+package telegram
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/massmux/SatsMobiBot/internal"
+	db "github.com/massmux/SatsMobiBot/internal/database"
+	"github.com/massmux/SatsMobiBot/internal/errors"
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+)
+
+const nostrUserMaxNames = 3
+
+// nostrNameRegex are the characters NIP-05 allows in the local part of an identifier
+var nostrNameRegex = regexp.MustCompile(`^[a-z0-9._-]{3,32}$`)
+
+// userNostrNames returns the custom NIP-05 names of a user. The user has to be loaded with settings.
+func userNostrNames(user *lnbits.User) []string {
+	if user == nil || user.Settings == nil {
+		return []string{}
+	}
+	return strings.Fields(user.Settings.Nostr.Names)
+}
+
+// userNostrIdentifiers returns all NIP-05 identifiers that resolve to the pubkey of the user
+func (bot *TipBot) userNostrIdentifiers(user *lnbits.User) []string {
+	host := strings.ToLower(internal.Configuration.Bot.LNURLHostUrl.Hostname())
+	identifiers := make([]string, 0)
+	if len(user.Telegram.Username) > 0 {
+		identifiers = append(identifiers, fmt.Sprintf("%s@%s", strings.ToLower(user.Telegram.Username), host))
+	}
+	if anon, err := bot.UserGetAnonLightningAddress(user); err == nil {
+		identifiers = append(identifiers, anon)
+	}
+	for _, name := range userNostrNames(user) {
+		identifiers = append(identifiers, fmt.Sprintf("%s@%s", name, host))
+	}
+	return identifiers
+}
+
+// validNostrName checks that the name is a valid NIP-05 name that can not be confused
+// with the anon ids of lightning addresses
+func validNostrName(name string) error {
+	if !nostrNameRegex.MatchString(name) {
+		return fmt.Errorf("invalid nostr name %s", name)
+	}
+	if _, err := strconv.ParseInt(name, 10, 64); err == nil || strings.HasPrefix(name, "0x") || strings.HasPrefix(name, "1x") {
+		return fmt.Errorf("nostr name %s is reserved", name)
+	}
+	return nil
+}
+
+// nostrNameTaken checks whether the name belongs to a telegram user or is registered by another user
+func (bot *TipBot) nostrNameTaken(user *lnbits.User, name string) bool {
+	if owner, tx := db.FindUser(bot.DB.Users, name); tx.Error == nil && owner.ID != user.ID {
+		return true
+	}
+	if owner, err := db.FindUserByNostrName(bot.DB.Users, name); err == nil && owner.ID != user.ID {
+		return true
+	}
+	return false
+}
+
+// nostrNameHandler is invoked on /nostr name, /nostr name <name> and /nostr name remove <name>
+func (bot *TipBot) nostrNameHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	user, err := GetLnbitsUserWithSettings(m.Sender, *bot)
+	if err != nil {
+		return ctx, err
+	}
+	names := userNostrNames(user)
+	arg, err := getArgumentFromCommand(m.Text, 2)
+	if err != nil {
+		text := fmt.Sprintf(Translate(ctx, "nostrNamesMessage"), strings.Join(bot.userNostrIdentifiers(user), "\n"))
+		bot.trySendMessage(m.Sender, text+"\n\n"+Translate(ctx, "nostrNamesHelpMessage"))
+		return ctx, nil
+	}
+	if strings.ToLower(arg) == "remove" {
+		name, err := getArgumentFromCommand(m.Text, 3)
+		if err != nil {
+			bot.trySendMessage(m.Sender, Translate(ctx, "nostrNamesHelpMessage"))
+			return ctx, err
+		}
+		name = strings.ToLower(name)
+		remaining := make([]string, 0)
+		for _, n := range names {
+			if n != name {
+				remaining = append(remaining, n)
+			}
+		}
+		if len(remaining) == len(names) {
+			bot.trySendMessage(m.Sender, Translate(ctx, "nostrNameNotFoundMessage"))
+			return ctx, errors.Create(errors.InvalidSyntaxError)
+		}
+		user.Settings.Nostr.Names = strings.Join(remaining, " ")
+		err = UpdateUserRecord(user, *bot)
+		if err != nil {
+			log.Errorf("[nostrNameHandler] could not update record of user %s: %v", GetUserStr(user.Telegram), err)
+			return ctx, err
+		}
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "nostrNameRemovedMessage"), name))
+		return ctx, nil
+	}
+
+	name := strings.ToLower(arg)
+	if err = validNostrName(name); err != nil {
+		bot.trySendMessage(m.Sender, Translate(ctx, "nostrNameInvalidMessage"))
+		return ctx, errors.New(errors.InvalidSyntaxError, err)
+	}
+	if len(names) >= nostrUserMaxNames {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "nostrNamesMaxMessage"), nostrUserMaxNames))
+		return ctx, errors.Create(errors.MaxReachedError)
+	}
+	if bot.nostrNameTaken(user, name) {
+		bot.trySendMessage(m.Sender, Translate(ctx, "nostrNameTakenMessage"))
+		return ctx, fmt.Errorf("nostr name %s is taken", name)
+	}
+	user.Settings.Nostr.Names = strings.Join(uniqueSlice(append(names, name)), " ")
+	err = UpdateUserRecord(user, *bot)
+	if err != nil {
+		log.Errorf("[nostrNameHandler] could not update record of user %s: %v", GetUserStr(user.Telegram), err)
+		return ctx, err
+	}
+	identifier := fmt.Sprintf("%s@%s", name, strings.ToLower(internal.Configuration.Bot.LNURLHostUrl.Hostname()))
+	text := fmt.Sprintf(Translate(ctx, "nostrNameAddedMessage"), identifier)
+	if len(user.Settings.Nostr.PubKey) == 0 {
+		text += "\n\n" + Translate(ctx, "nostrNotifyNoPubkeyMessage")
+	}
+	bot.trySendMessage(m.Sender, text)
+	return ctx, nil
+}
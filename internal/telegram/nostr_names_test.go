@@ -0,0 +1,61 @@
+package telegram
+
+import (
+	"testing"
+
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func Test_validNostrName(t *testing.T) {
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{name: "satoshi"},
+		{name: "sat.oshi_21-x"},
+		{name: "ab", wantErr: true},
+		{name: "Satoshi", wantErr: true},
+		{name: "sat oshi", wantErr: true},
+		{name: "12345", wantErr: true},
+		{name: "0xabc", wantErr: true},
+		{name: "1xabc", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validNostrName(tt.name); (err != nil) != tt.wantErr {
+				t.Errorf("validNostrName() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTipBot_nostrNameHandler(t *testing.T) {
+	test := newTestBot(t)
+	alice := test.addUser(t, 2, 0)
+	bob := test.addUser(t, 3, 0)
+	private := func(user int64) *tb.Chat { return &tb.Chat{ID: user, Type: tb.ChatPrivate} }
+
+	if _, err := test.nostrNameHandler(test.message(alice, private(2), "/nostr name satoshi")); err != nil {
+		t.Fatalf("nostrNameHandler() error = %v", err)
+	}
+	user, err := GetLnbitsUserWithSettings(alice.Telegram, *test.TipBot)
+	if err != nil || len(userNostrNames(user)) != 1 || userNostrNames(user)[0] != "satoshi" {
+		t.Fatalf("names of the user = %v, %v", userNostrNames(user), err)
+	}
+	// names are unique and can't shadow telegram usernames
+	if _, err := test.nostrNameHandler(test.message(bob, private(3), "/nostr name Satoshi")); err == nil {
+		t.Errorf("nostrNameHandler() registered a taken name")
+	}
+	if _, err := test.nostrNameHandler(test.message(bob, private(3), "/nostr name user2")); err == nil {
+		t.Errorf("nostrNameHandler() registered the username of another user")
+	}
+	if _, err := test.nostrNameHandler(test.message(alice, private(2), "/nostr name remove satoshi")); err != nil {
+		t.Fatalf("nostrNameHandler() remove error = %v", err)
+	}
+	if _, err := test.nostrNameHandler(test.message(bob, private(3), "/nostr name satoshi")); err != nil {
+		t.Errorf("nostrNameHandler() error = %v after the name was removed", err)
+	}
+	if _, err := test.nostrNameHandler(test.message(alice, private(2), "/nostr name remove satoshi")); err == nil {
+		t.Errorf("nostrNameHandler() removed a name of another user")
+	}
+}
@@ -0,0 +1,111 @@
+package telegram
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/massmux/SatsMobiBot/internal"
+	"github.com/massmux/SatsMobiBot/internal/errors"
+	"github.com/massmux/SatsMobiBot/internal/i18n"
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
+	"github.com/massmux/SatsMobiBot/pkg/nip17"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip04"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	nostrNotificationsNip04 = "nip04"
+	nostrNotificationsNip17 = "nip17"
+)
+
+// nostrNotifyHandler is invoked on /nostr notify <nip04|nip17|off>
+func (bot *TipBot) nostrNotifyHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	if len(internal.Configuration.Nostr.PrivateKey) == 0 {
+		bot.trySendMessage(m.Sender, Translate(ctx, "nostrNotifyNotAvailableMessage"))
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	user, err := GetLnbitsUserWithSettings(m.Sender, *bot)
+	if err != nil {
+		return ctx, err
+	}
+	if len(user.Settings.Nostr.PubKey) == 0 {
+		bot.trySendMessage(m.Sender, Translate(ctx, "nostrNotifyNoPubkeyMessage"))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	mode, err := getArgumentFromCommand(m.Text, 2)
+	if err != nil {
+		bot.trySendMessage(m.Sender, Translate(ctx, "nostrNotifyHelpMessage"))
+		return ctx, err
+	}
+	switch strings.ToLower(mode) {
+	case nostrNotificationsNip04, nostrNotificationsNip17:
+		user.Settings.Nostr.Notifications = strings.ToLower(mode)
+	case "off":
+		user.Settings.Nostr.Notifications = ""
+	default:
+		bot.trySendMessage(m.Sender, Translate(ctx, "nostrNotifyHelpMessage"))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	err = UpdateUserRecord(user, *bot)
+	if err != nil {
+		log.Errorf("[nostrNotifyHandler] could not update record of user %s: %v", GetUserStr(user.Telegram), err)
+		return ctx, err
+	}
+	if len(user.Settings.Nostr.Notifications) == 0 {
+		bot.trySendMessage(m.Sender, Translate(ctx, "nostrNotifyDisabledMessage"))
+		return ctx, nil
+	}
+	bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "nostrNotifyEnabledMessage"), user.Settings.Nostr.Notifications))
+	return ctx, nil
+}
+
+// sendNostrNotification sends a notification as an encrypted direct message from the bot key to
+// the nostr pubkey of the user, if the user enabled nostr notifications
+func (bot *TipBot) sendNostrNotification(user *lnbits.User, messageKey string, a ...interface{}) {
+	pk := internal.Configuration.Nostr.PrivateKey
+	if len(pk) == 0 || user == nil || user.Telegram == nil {
+		return
+	}
+	user, err := GetLnbitsUserWithSettings(user.Telegram, *bot)
+	if err != nil || user.Settings == nil || len(user.Settings.Nostr.PubKey) == 0 {
+		return
+	}
+	receiver := user.Settings.Nostr.PubKey
+	message := fmt.Sprintf(i18n.Translate(user.Telegram.LanguageCode, messageKey), a...)
+
+	switch user.Settings.Nostr.Notifications {
+	case nostrNotificationsNip04:
+		sharedSecret, err := nip04.ComputeSharedSecret(receiver, pk)
+		if err != nil {
+			log.Errorf("[sendNostrNotification] could not compute shared secret for %s: %s", GetUserStr(user.Telegram), err.Error())
+			return
+		}
+		content, err := nip04.Encrypt(message, sharedSecret)
+		if err != nil {
+			log.Errorf("[sendNostrNotification] could not encrypt message: %s", err.Error())
+			return
+		}
+		pub, _ := nostr.GetPublicKey(pk)
+		bot.publishNostrEvent(nostr.Event{
+			PubKey:    pub,
+			CreatedAt: time.Now(),
+			Kind:      nostr.KindEncryptedDirectMessage,
+			Tags:      nostr.Tags{nostr.Tag{"p", receiver}},
+			Content:   content,
+		}, userNostrRelays(user))
+	case nostrNotificationsNip17:
+		wrap, err := nip17.GiftWrap(message, receiver, pk)
+		if err != nil {
+			log.Errorf("[sendNostrNotification] could not wrap message for %s: %s", GetUserStr(user.Telegram), err.Error())
+			return
+		}
+		bot.publishSignedNostrEvent(wrap, userNostrRelays(user))
+	default:
+		return
+	}
+	log.Debugf("[sendNostrNotification] sent %s to %s", messageKey, GetUserStr(user.Telegram))
+}
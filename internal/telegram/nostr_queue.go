@@ -0,0 +1,221 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/massmux/SatsMobiBot/internal/runtime"
+	"github.com/massmux/SatsMobiBot/internal/runtime/mutex"
+	"github.com/massmux/SatsMobiBot/internal/storage"
+	"github.com/nbd-wtf/go-nostr"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/buntdb"
+)
+
+const (
+	NostrPublishIndex          = "nostr-publish:*"
+	nostrPublishTick           = time.Minute
+	nostrPublishTimeout        = 10 * time.Second
+	nostrPublishMaxAttempts    = 5
+	nostrPublishInitialBackoff = time.Minute
+	nostrPublishRetention      = 7 * 24 * time.Hour
+	nostrPublishMaxRelays      = 50
+	nostrRelayStatusPending    = "pending"
+	nostrRelayStatusPublished  = "published"
+	nostrRelayStatusFailed     = "failed"
+)
+
+// NostrRelayStatus is the publish status of an event on one relay
+type NostrRelayStatus struct {
+	Status      string    `json:"status"`
+	Attempts    int       `json:"attempts"`
+	LastAttempt time.Time `json:"last_attempt"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// NostrPublishJob is a signed event waiting to be published. It is persisted in bunt
+// so that publishing is retried after failures and restarts.
+type NostrPublishJob struct {
+	*storage.Base
+	Event       nostr.Event                  `json:"event"`
+	Relays      map[string]*NostrRelayStatus `json:"relays"`
+	NextAttempt time.Time                    `json:"next_attempt"`
+	Done        bool                         `json:"done"`
+}
+
+// NostrRelayHealth counts the publish results of a relay since the start of the bot
+type NostrRelayHealth struct {
+	URL         string    `json:"url"`
+	Successes   int       `json:"successes"`
+	Failures    int       `json:"failures"`
+	LastSuccess time.Time `json:"last_success,omitempty"`
+	LastFailure time.Time `json:"last_failure,omitempty"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+var (
+	nostrRelayHealth     = make(map[string]*NostrRelayHealth)
+	nostrRelayHealthLock = sync.Mutex{}
+)
+
+func recordNostrRelayResult(url string, err error) {
+	nostrRelayHealthLock.Lock()
+	defer nostrRelayHealthLock.Unlock()
+	health, ok := nostrRelayHealth[url]
+	if !ok {
+		health = &NostrRelayHealth{URL: url}
+		nostrRelayHealth[url] = health
+	}
+	if err != nil {
+		health.Failures++
+		health.LastFailure = time.Now()
+		health.LastError = err.Error()
+		return
+	}
+	health.Successes++
+	health.LastSuccess = time.Now()
+}
+
+// NostrRelayHealth returns the health of all relays the bot published to
+func (bot *TipBot) NostrRelayHealth() []NostrRelayHealth {
+	nostrRelayHealthLock.Lock()
+	defer nostrRelayHealthLock.Unlock()
+	relays := make([]NostrRelayHealth, 0)
+	for _, health := range nostrRelayHealth {
+		relays = append(relays, *health)
+	}
+	sort.Slice(relays, func(i, j int) bool { return relays[i].URL < relays[j].URL })
+	return relays
+}
+
+// NostrPublishJobs returns the publish jobs of the given event kind, or of all kinds if kind is 0
+func (bot *TipBot) NostrPublishJobs(kind int) []*NostrPublishJob {
+	jobs := make([]*NostrPublishJob, 0)
+	bot.Bunt.View(func(tx *buntdb.Tx) error {
+		return tx.Ascend("nostr-publish", func(key, value string) bool {
+			job := &NostrPublishJob{}
+			if err := json.Unmarshal([]byte(value), job); err != nil {
+				return true
+			}
+			if kind == 0 || job.Event.Kind == kind {
+				jobs = append(jobs, job)
+			}
+			return true // continue iteration
+		})
+	})
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].CreatedAt.After(jobs[j].CreatedAt) })
+	return jobs
+}
+
+// enqueueNostrEvent persists a signed event with the relays it should be published to and starts publishing
+func (bot *TipBot) enqueueNostrEvent(ev nostr.Event, relays []string) {
+	relays = uniqueSlice(cleanUrls(relays))
+	if len(relays) > nostrPublishMaxRelays {
+		relays = relays[:nostrPublishMaxRelays]
+	}
+	job := &NostrPublishJob{
+		Base:        storage.New(storage.ID("nostr-publish:" + ev.ID)),
+		Event:       ev,
+		Relays:      make(map[string]*NostrRelayStatus),
+		NextAttempt: time.Now(),
+	}
+	for _, relay := range relays {
+		job.Relays[relay] = &NostrRelayStatus{Status: nostrRelayStatusPending}
+	}
+	if err := job.Set(job, bot.Bunt); err != nil {
+		log.Errorf("[NOSTR] could not enqueue event %s: %s", ev.ID, err.Error())
+		return
+	}
+	log.Debugf("[NOSTR] 🟣 enqueued nostr event %s for %d relays", ev.ID, len(relays))
+	go bot.publishNostrJob(job.ID)
+}
+
+// publishNostrJob publishes the event to all pending relays. Failed relays are retried with backoff.
+func (bot *TipBot) publishNostrJob(id string) {
+	mutex.Lock(id)
+	defer mutex.Unlock(id)
+	job := &NostrPublishJob{Base: storage.New(storage.ID(id))}
+	if err := bot.Bunt.Get(job); err != nil || job.Done {
+		return
+	}
+	lock := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for url, status := range job.Relays {
+		if status.Status != nostrRelayStatusPending {
+			continue
+		}
+		wg.Add(1)
+		go func(url string, status *NostrRelayStatus) {
+			defer wg.Done()
+			err := publishToRelay(url, job.Event)
+			recordNostrRelayResult(url, err)
+			lock.Lock()
+			defer lock.Unlock()
+			status.Attempts++
+			status.LastAttempt = time.Now()
+			if err == nil {
+				status.Status = nostrRelayStatusPublished
+				status.LastError = ""
+				return
+			}
+			status.LastError = err.Error()
+			if status.Attempts >= nostrPublishMaxAttempts {
+				status.Status = nostrRelayStatusFailed
+			}
+		}(url, status)
+	}
+	wg.Wait()
+
+	pending, attempts := 0, 0
+	for _, status := range job.Relays {
+		if status.Status == nostrRelayStatusPending {
+			pending++
+			if status.Attempts > attempts {
+				attempts = status.Attempts
+			}
+		}
+	}
+	job.Done = pending == 0
+	if !job.Done {
+		job.NextAttempt = time.Now().Add(nostrPublishInitialBackoff * time.Duration(1<<(attempts-1)))
+	}
+	runtime.IgnoreError(job.Set(job, bot.Bunt))
+	log.Debugf("[NOSTR] published nostr event %s, %d relays pending", job.Event.ID, pending)
+}
+
+func publishToRelay(url string, ev nostr.Event) error {
+	ctx, cancel := context.WithTimeout(context.Background(), nostrPublishTimeout)
+	defer cancel()
+	relay, err := nostr.RelayConnect(ctx, url)
+	if err != nil {
+		return err
+	}
+	defer relay.Close()
+	status := relay.Publish(ctx, ev)
+	if status == nostr.PublishStatusFailed {
+		return fmt.Errorf("relay rejected the event")
+	}
+	return nil
+}
+
+// startNostrPublishWorker retries pending publish jobs and removes old finished jobs
+func (bot *TipBot) startNostrPublishWorker() {
+	ticker := time.NewTicker(nostrPublishTick)
+	for {
+		now := time.Now()
+		for _, job := range bot.NostrPublishJobs(0) {
+			if job.Done && now.Sub(job.CreatedAt) > nostrPublishRetention {
+				runtime.IgnoreError(job.Delete(job, bot.Bunt))
+				continue
+			}
+			if !job.Done && !job.NextAttempt.After(now) {
+				bot.publishNostrJob(job.ID)
+			}
+		}
+		<-ticker.C
+	}
+}
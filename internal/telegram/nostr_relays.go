@@ -0,0 +1,112 @@
+package telegram
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/massmux/SatsMobiBot/internal/errors"
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+)
+
+const nostrUserMaxRelays = 10
+
+// userNostrRelays returns the relays of a user. The user has to be loaded with settings.
+func userNostrRelays(user *lnbits.User) []string {
+	if user == nil || user.Settings == nil {
+		return []string{}
+	}
+	return strings.Fields(user.Settings.Nostr.Relays)
+}
+
+// parseNostrRelay returns the normalized relay url or an error if it is not a websocket url
+func parseNostrRelay(relay string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSuffix(strings.TrimSpace(relay), "/"))
+	if err != nil || (u.Scheme != "wss" && u.Scheme != "ws") || len(u.Hostname()) == 0 || u.User != nil {
+		return nil, fmt.Errorf("invalid relay %s", relay)
+	}
+	return u, nil
+}
+
+// validNostrRelay returns the normalized url of a relay the bot may connect to.
+// The bot connects to the relays of users, so their hosts have to resolve to public addresses.
+func validNostrRelay(relay string) (string, error) {
+	u, err := parseNostrRelay(relay)
+	if err != nil {
+		return "", err
+	}
+	ips, err := net.LookupIP(u.Hostname())
+	if err != nil {
+		return "", err
+	}
+	for _, ip := range ips {
+		if !isPublicIP(ip) {
+			return "", fmt.Errorf("relay host %s is not public", u.Hostname())
+		}
+	}
+	return u.String(), nil
+}
+
+// nostrRelaysHandler is invoked on /nostr relays, /nostr relays add <url> and /nostr relays remove <url>
+func (bot *TipBot) nostrRelaysHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	user, err := GetLnbitsUserWithSettings(m.Sender, *bot)
+	if err != nil {
+		return ctx, err
+	}
+	relays := userNostrRelays(user)
+	action, err := getArgumentFromCommand(m.Text, 2)
+	if err != nil {
+		text := fmt.Sprintf(Translate(ctx, "nostrRelaysMessage"), strings.Join(nostrRelays(), "\n"))
+		if len(relays) > 0 {
+			text += "\n\n" + fmt.Sprintf(Translate(ctx, "nostrUserRelaysMessage"), strings.Join(relays, "\n"))
+		}
+		bot.trySendMessage(m.Sender, text+"\n\n"+Translate(ctx, "nostrRelaysHelpMessage"))
+		return ctx, nil
+	}
+	arg, err := getArgumentFromCommand(m.Text, 3)
+	if err != nil {
+		bot.trySendMessage(m.Sender, Translate(ctx, "nostrRelaysHelpMessage"))
+		return ctx, err
+	}
+	u, err := parseNostrRelay(arg)
+	if err != nil {
+		bot.trySendMessage(m.Sender, Translate(ctx, "nostrRelaysInvalidMessage"))
+		return ctx, errors.New(errors.InvalidSyntaxError, err)
+	}
+	relay := u.String()
+	switch strings.ToLower(action) {
+	case "add":
+		if _, err := validNostrRelay(relay); err != nil {
+			bot.trySendMessage(m.Sender, Translate(ctx, "nostrRelaysNotPublicMessage"))
+			return ctx, errors.New(errors.InvalidSyntaxError, err)
+		}
+		if len(relays) >= nostrUserMaxRelays {
+			bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "nostrRelaysMaxMessage"), nostrUserMaxRelays))
+			return ctx, errors.Create(errors.MaxReachedError)
+		}
+		relays = uniqueSlice(append(relays, relay))
+	case "remove":
+		remaining := make([]string, 0)
+		for _, r := range relays {
+			if r != relay {
+				remaining = append(remaining, r)
+			}
+		}
+		relays = remaining
+	default:
+		bot.trySendMessage(m.Sender, Translate(ctx, "nostrRelaysHelpMessage"))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	user.Settings.Nostr.Relays = strings.Join(relays, " ")
+	err = UpdateUserRecord(user, *bot)
+	if err != nil {
+		log.Errorf("[nostrRelaysHandler] could not update record of user %s: %v", GetUserStr(user.Telegram), err)
+		return ctx, err
+	}
+	bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "nostrRelaysUpdatedMessage"), len(relays)))
+	return ctx, nil
+}
@@ -0,0 +1,584 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	decodepay "github.com/fiatjaf/ln-decodepay"
+	"github.com/massmux/SatsMobiBot/internal"
+	"github.com/massmux/SatsMobiBot/internal/errors"
+	"github.com/massmux/SatsMobiBot/internal/i18n"
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	"github.com/massmux/SatsMobiBot/internal/runtime"
+	"github.com/massmux/SatsMobiBot/internal/runtime/mutex"
+	"github.com/massmux/SatsMobiBot/internal/storage"
+	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip04"
+	log "github.com/sirupsen/logrus"
+	"github.com/skip2/go-qrcode"
+	"github.com/tidwall/buntdb"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+// Nostr Wallet Connect (NIP-47)
+const (
+	NwcConnectionIndex     = "nwc:*"
+	nwcKindInfo            = 13194
+	nwcKindRequest         = 23194
+	nwcKindResponse        = 23195
+	nwcMaxConnections      = 10
+	nwcReconnectDelay      = 30 * time.Second
+	nwcRequestMaxAge       = time.Minute
+	nwcRequestMaxSkew      = 30 * time.Second // requests may be dated this far in the future
+	nwcPublishTimeout      = 10 * time.Second
+	nwcSupportedMethods    = "pay_invoice make_invoice get_balance lookup_invoice"
+	nwcErrorUnauthorized   = "UNAUTHORIZED"
+	nwcErrorNotImplemented = "NOT_IMPLEMENTED"
+	nwcErrorBalance        = "INSUFFICIENT_BALANCE"
+	nwcErrorQuota          = "QUOTA_EXCEEDED"
+	nwcErrorNotFound       = "NOT_FOUND"
+	nwcErrorInternal       = "INTERNAL"
+	nwcErrorOther          = "OTHER"
+)
+
+// NwcConnection is a wallet connection of a user. The bot only stores the public key
+// of the connection secret, the secret itself is only shown once to the user.
+type NwcConnection struct {
+	*storage.Base
+	PubKey       string    `json:"pubkey"`
+	User         *tb.User  `json:"user"`
+	Budget       int64     `json:"budget"` // total spending budget in sat, 0 for no budget
+	Spent        int64     `json:"spent"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	LastUsed     time.Time `json:"last_used"`
+	LanguageCode string    `json:"languagecode"`
+}
+
+type nwcRequest struct {
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+type nwcError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type nwcResponse struct {
+	ResultType string      `json:"result_type"`
+	Error      *nwcError   `json:"error,omitempty"`
+	Result     interface{} `json:"result,omitempty"`
+}
+
+// shortID is the id the user enters to revoke a connection
+func (connection *NwcConnection) shortID() string {
+	return connection.PubKey[:8]
+}
+
+func (connection *NwcConnection) expired() bool {
+	return !connection.ExpiresAt.IsZero() && time.Now().After(connection.ExpiresAt)
+}
+
+// nwcEnabled returns true if the bot has a nostr key and a relay for wallet connect
+func nwcEnabled() bool {
+	return len(internal.Configuration.Nostr.PrivateKey) > 0 && len(internal.Configuration.Nostr.WalletConnectRelay) > 0
+}
+
+// nwcHandler is invoked on /nwc new [budget] [expiry], /nwc list and /nwc revoke <id>
+func (bot *TipBot) nwcHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	user := LoadUser(ctx)
+	if user.Wallet == nil {
+		return ctx, errors.Create(errors.UserNoWalletError)
+	}
+	if !nwcEnabled() {
+		bot.trySendMessage(m.Sender, Translate(ctx, "nwcNotAvailableMessage"))
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	cmd, err := getArgumentFromCommand(m.Text, 1)
+	if err != nil {
+		bot.trySendMessage(m.Sender, Translate(ctx, "nwcHelpMessage"))
+		return ctx, nil
+	}
+	switch strings.ToLower(cmd) {
+	case "new":
+		return bot.nwcNewHandler(ctx)
+	case "list":
+		return bot.nwcListHandler(ctx)
+	case "revoke":
+		return bot.nwcRevokeHandler(ctx)
+	}
+	bot.trySendMessage(m.Sender, Translate(ctx, "nwcHelpMessage"))
+	return ctx, errors.Create(errors.InvalidSyntaxError)
+}
+
+// nwcNewHandler creates a new connection and sends the connection URI to the user
+func (bot *TipBot) nwcNewHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	user := LoadUser(ctx)
+	if len(bot.getNwcConnections(user.Telegram.ID)) >= nwcMaxConnections {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "nwcMaxConnectionsMessage"), nwcMaxConnections))
+		return ctx, errors.Create(errors.MaxReachedError)
+	}
+	var budget int64
+	var expiresAt time.Time
+	if arg, err := getArgumentFromCommand(m.Text, 2); err == nil {
+		budget, err = GetAmount(arg)
+		if err != nil {
+			bot.trySendMessage(m.Sender, Translate(ctx, "nwcHelpMessage"))
+			return ctx, errors.New(errors.InvalidAmountError, err)
+		}
+	}
+	if arg, err := getArgumentFromCommand(m.Text, 3); err == nil {
+		d, err := ParseDuration(arg)
+		if err != nil || d <= 0 {
+			bot.trySendMessage(m.Sender, Translate(ctx, "nwcHelpMessage"))
+			return ctx, errors.New(errors.InvalidSyntaxError, fmt.Errorf("invalid expiry %s", arg))
+		}
+		expiresAt = time.Now().Add(d)
+	}
+
+	servicePubkey, err := nostr.GetPublicKey(internal.Configuration.Nostr.PrivateKey)
+	if err != nil {
+		return ctx, err
+	}
+	secret := nostr.GeneratePrivateKey()
+	pubkey, err := nostr.GetPublicKey(secret)
+	if err != nil {
+		return ctx, err
+	}
+	connection := &NwcConnection{
+		Base:         storage.New(storage.ID("nwc:" + pubkey)),
+		PubKey:       pubkey,
+		User:         user.Telegram,
+		Budget:       budget,
+		ExpiresAt:    expiresAt,
+		LanguageCode: ctx.Value("publicLanguageCode").(string),
+	}
+	runtime.IgnoreError(connection.Set(connection, bot.Bunt))
+
+	uri := fmt.Sprintf("nostr+walletconnect://%s?relay=%s&secret=%s", servicePubkey,
+		url.QueryEscape(internal.Configuration.Nostr.WalletConnectRelay), secret)
+	if lnaddr, err := bot.UserGetLightningAddress(user); err == nil && len(lnaddr) > 0 {
+		uri += "&lud16=" + url.QueryEscape(lnaddr)
+	}
+	qr, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		return ctx, err
+	}
+	log.Infof("[nwc] %s created connection %s", GetUserStr(user.Telegram), connection.shortID())
+	bot.trySendMessage(m.Sender, &tb.Photo{File: tb.File{FileReader: bytes.NewReader(qr)}, Caption: fmt.Sprintf("`%s`", uri)})
+	bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "nwcCreatedMessage"), connection.shortID(), bot.nwcLimitsStr(connection)))
+	return ctx, nil
+}
+
+// nwcLimitsStr returns the budget and expiry of a connection for messages
+func (bot *TipBot) nwcLimitsStr(connection *NwcConnection) string {
+	limits := i18n.Translate(connection.LanguageCode, "nwcNoBudgetMessage")
+	if connection.Budget > 0 {
+		limits = fmt.Sprintf(i18n.Translate(connection.LanguageCode, "nwcBudgetMessage"), connection.Spent, connection.Budget)
+	}
+	if !connection.ExpiresAt.IsZero() {
+		limits += ", " + fmt.Sprintf(i18n.Translate(connection.LanguageCode, "nwcExpiresMessage"), connection.ExpiresAt.Format(time.RFC1123))
+	}
+	return limits
+}
+
+// nwcListHandler lists all connections of the user
+func (bot *TipBot) nwcListHandler(ctx intercept.Context) (intercept.Context, error) {
+	user := LoadUser(ctx)
+	connections := bot.getNwcConnections(user.Telegram.ID)
+	if len(connections) == 0 {
+		bot.trySendMessage(user.Telegram, Translate(ctx, "nwcListEmptyMessage"))
+		return ctx, nil
+	}
+	text := Translate(ctx, "nwcListMessage")
+	for _, connection := range connections {
+		text += fmt.Sprintf("\n\n`%s` – %s", connection.shortID(), bot.nwcLimitsStr(connection))
+		if connection.expired() {
+			text += " ⏳"
+		}
+		if !connection.LastUsed.IsZero() {
+			text += "\n" + fmt.Sprintf(Translate(ctx, "nwcLastUsedMessage"), connection.LastUsed.Format(time.RFC1123))
+		}
+	}
+	bot.trySendMessage(user.Telegram, text)
+	return ctx, nil
+}
+
+// nwcRevokeHandler deletes a connection of the user
+func (bot *TipBot) nwcRevokeHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	user := LoadUser(ctx)
+	id, err := getArgumentFromCommand(m.Text, 2)
+	if err != nil {
+		bot.trySendMessage(m.Sender, Translate(ctx, "nwcHelpMessage"))
+		return ctx, err
+	}
+	connection := bot.revokeNwcConnection(user.Telegram.ID, id)
+	if connection == nil {
+		bot.trySendMessage(m.Sender, Translate(ctx, "nwcNotFoundMessage"))
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	log.Infof("[nwc] %s revoked connection %s", GetUserStr(user.Telegram), connection.shortID())
+	bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "nwcRevokedMessage"), connection.shortID()))
+	return ctx, nil
+}
+
+// revokeNwcConnection deletes the connection of the user with the short id. It returns nil if there is none.
+func (bot *TipBot) revokeNwcConnection(userID int64, shortID string) *NwcConnection {
+	for _, connection := range bot.getNwcConnections(userID) {
+		if connection.shortID() != strings.ToLower(shortID) {
+			continue
+		}
+		mutex.Lock(connection.ID)
+		defer mutex.Unlock(connection.ID)
+		connection.Canceled = true
+		runtime.IgnoreError(connection.Delete(connection, bot.Bunt))
+		return connection
+	}
+	return nil
+}
+
+// getNwcConnections returns the connections of a user, or of all users if userID is 0
+func (bot *TipBot) getNwcConnections(userID int64) []*NwcConnection {
+	connections := make([]*NwcConnection, 0)
+	bot.Bunt.View(func(tx *buntdb.Tx) error {
+		return tx.Ascend("nwc", func(key, value string) bool {
+			connection := &NwcConnection{}
+			if err := json.Unmarshal([]byte(value), connection); err != nil || !connection.Active {
+				return true
+			}
+			if userID == 0 || connection.User.ID == userID {
+				connections = append(connections, connection)
+			}
+			return true // continue iteration
+		})
+	})
+	return connections
+}
+
+func (bot *TipBot) loadNwcConnection(pubkey string) (*NwcConnection, error) {
+	connection := &NwcConnection{Base: storage.New(storage.ID("nwc:" + pubkey))}
+	if err := bot.Bunt.Get(connection); err != nil {
+		return nil, err
+	}
+	return connection, nil
+}
+
+// startNwcListener listens for wallet connect requests on the configured relay and reconnects on errors
+func (bot *TipBot) startNwcListener() {
+	if !nwcEnabled() {
+		return
+	}
+	for {
+		err := bot.listenNwcRelay(internal.Configuration.Nostr.WalletConnectRelay)
+		if err != nil {
+			log.Warnf("[nwc] relay connection lost: %s", err.Error())
+		}
+		time.Sleep(nwcReconnectDelay)
+	}
+}
+
+// listenNwcRelay publishes the info event and handles requests until the relay connection breaks
+func (bot *TipBot) listenNwcRelay(relayURL string) error {
+	pk := internal.Configuration.Nostr.PrivateKey
+	servicePubkey, err := nostr.GetPublicKey(pk)
+	if err != nil {
+		return err
+	}
+	relay, err := nostr.RelayConnect(context.Background(), relayURL)
+	if err != nil {
+		return err
+	}
+	defer relay.Close()
+	log.Infof("[nwc] listening on %s", relayURL)
+
+	info := nostr.Event{
+		PubKey:    servicePubkey,
+		CreatedAt: time.Now(),
+		Kind:      nwcKindInfo,
+		Tags:      nostr.Tags{},
+		Content:   nwcSupportedMethods,
+	}
+	if err = info.Sign(pk); err == nil {
+		publishCtx, cancel := context.WithTimeout(context.Background(), nwcPublishTimeout)
+		relay.Publish(publishCtx, info)
+		cancel()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	since := time.Now().Add(-nwcRequestMaxAge)
+	sub := relay.Subscribe(ctx, nostr.Filters{{
+		Kinds: []int{nwcKindRequest},
+		Tags:  nostr.TagMap{"p": []string{servicePubkey}},
+		Since: &since,
+	}})
+	for {
+		select {
+		case ev, ok := <-sub.Events:
+			if !ok {
+				return fmt.Errorf("subscription closed")
+			}
+			go bot.handleNwcRequest(relay, ev)
+		case notice := <-relay.Notices:
+			log.Debugf("[nwc] notice from %s: %s", relayURL, notice)
+		case err := <-relay.ConnectionError:
+			return err
+		}
+	}
+}
+
+// acceptNwcRequest checks the id, signature and age of a request and remembers its id in bunt
+// until the request ages out. Requests that are sent again, by a relay after a reconnect or by
+// someone who recorded them, are rejected.
+func (bot *TipBot) acceptNwcRequest(ev *nostr.Event) error {
+	if ev.GetID() != ev.ID {
+		return fmt.Errorf("invalid id")
+	}
+	if ok, err := ev.CheckSignature(); err != nil || !ok {
+		return fmt.Errorf("invalid signature")
+	}
+	age := time.Since(ev.CreatedAt)
+	if age > nwcRequestMaxAge {
+		return fmt.Errorf("request is too old (%s)", age.Round(time.Second))
+	}
+	if age < -nwcRequestMaxSkew {
+		return fmt.Errorf("request is dated in the future (%s)", -age.Round(time.Second))
+	}
+	return bot.Bunt.Update(func(tx *buntdb.Tx) error {
+		key := fmt.Sprintf("nwc-request:%s", ev.ID)
+		if _, err := tx.Get(key); err == nil {
+			return fmt.Errorf("request was already handled")
+		}
+		_, _, err := tx.Set(key, ev.PubKey, &buntdb.SetOptions{Expires: true, TTL: nwcRequestMaxAge + nwcRequestMaxSkew})
+		return err
+	})
+}
+
+// handleNwcRequest decrypts a request, executes it for the wallet of the connection and publishes the response
+func (bot *TipBot) handleNwcRequest(relay *nostr.Relay, ev *nostr.Event) {
+	if err := bot.acceptNwcRequest(ev); err != nil {
+		log.Warnf("[nwc] rejected request %s: %s", ev.ID, err.Error())
+		return
+	}
+
+	pk := internal.Configuration.Nostr.PrivateKey
+	sharedSecret, err := nip04.ComputeSharedSecret(ev.PubKey, pk)
+	if err != nil {
+		log.Warnf("[nwc] could not compute shared secret for %s: %s", ev.PubKey, err.Error())
+		return
+	}
+	content, err := nip04.Decrypt(ev.Content, sharedSecret)
+	if err != nil {
+		log.Warnf("[nwc] could not decrypt request %s: %s", ev.ID, err.Error())
+		return
+	}
+	request := nwcRequest{}
+	if err = json.Unmarshal([]byte(content), &request); err != nil {
+		log.Warnf("[nwc] invalid request %s: %s", ev.ID, err.Error())
+		return
+	}
+	response := bot.executeNwcRequest(ev.PubKey, request)
+	response.ResultType = request.Method
+	if response.Error != nil {
+		log.Infof("[nwc] %s %s failed: %s", ev.PubKey[:8], request.Method, response.Error.Message)
+	}
+
+	b, err := json.Marshal(response)
+	if err != nil {
+		return
+	}
+	encrypted, err := nip04.Encrypt(string(b), sharedSecret)
+	if err != nil {
+		log.Errorf("[nwc] could not encrypt response: %s", err.Error())
+		return
+	}
+	servicePubkey, _ := nostr.GetPublicKey(pk)
+	responseEvent := nostr.Event{
+		PubKey:    servicePubkey,
+		CreatedAt: time.Now(),
+		Kind:      nwcKindResponse,
+		Tags:      nostr.Tags{nostr.Tag{"p", ev.PubKey}, nostr.Tag{"e", ev.ID}},
+		Content:   encrypted,
+	}
+	if err = responseEvent.Sign(pk); err != nil {
+		log.Errorf("[nwc] could not sign response: %s", err.Error())
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), nwcPublishTimeout)
+	defer cancel()
+	status := relay.Publish(ctx, responseEvent)
+	log.Debugf("[nwc] response to %s published: %s", ev.ID, status)
+}
+
+func nwcErrorResponse(code string, format string, a ...interface{}) nwcResponse {
+	return nwcResponse{Error: &nwcError{Code: code, Message: fmt.Sprintf(format, a...)}}
+}
+
+// executeNwcRequest runs a request of the connection with the given client pubkey
+func (bot *TipBot) executeNwcRequest(pubkey string, request nwcRequest) nwcResponse {
+	mutex.Lock("nwc:" + pubkey)
+	defer mutex.Unlock("nwc:" + pubkey)
+	connection, err := bot.loadNwcConnection(pubkey)
+	if err != nil || !connection.Active {
+		return nwcErrorResponse(nwcErrorUnauthorized, "unknown connection")
+	}
+	if connection.expired() {
+		return nwcErrorResponse(nwcErrorUnauthorized, "connection expired")
+	}
+	user, err := GetLnbitsUser(connection.User, *bot)
+	if err != nil || user.Wallet == nil {
+		return nwcErrorResponse(nwcErrorUnauthorized, "wallet not found")
+	}
+	connection.LastUsed = time.Now()
+	defer func() {
+		runtime.IgnoreError(connection.Set(connection, bot.Bunt))
+	}()
+
+	switch request.Method {
+	case "pay_invoice":
+		return bot.nwcPayInvoice(connection, user, request.Params)
+	case "make_invoice":
+		return bot.nwcMakeInvoice(connection, user, request.Params)
+	case "get_balance":
+		balance, err := bot.GetUserBalance(user)
+		if err != nil {
+			return nwcErrorResponse(nwcErrorInternal, "could not get balance")
+		}
+		return nwcResponse{Result: map[string]interface{}{"balance": balance * 1000}}
+	case "lookup_invoice":
+		return bot.nwcLookupInvoice(user, request.Params)
+	}
+	return nwcErrorResponse(nwcErrorNotImplemented, "method %s is not supported", request.Method)
+}
+
+func (bot *TipBot) nwcPayInvoice(connection *NwcConnection, user *lnbits.User, rawParams json.RawMessage) nwcResponse {
+	params := struct {
+		Invoice string `json:"invoice"`
+	}{}
+	if err := json.Unmarshal(rawParams, &params); err != nil {
+		return nwcErrorResponse(nwcErrorOther, "invalid params")
+	}
+	paymentRequest := strings.TrimPrefix(strings.ToLower(params.Invoice), "lightning:")
+	bolt11, err := decodepay.Decodepay(paymentRequest)
+	if err != nil {
+		return nwcErrorResponse(nwcErrorOther, "invalid invoice")
+	}
+	amount := int64(bolt11.MSatoshi / 1000)
+	if amount <= 0 {
+		return nwcErrorResponse(nwcErrorOther, "invoice without amount")
+	}
+	// the wallet is locked like in the other pay paths so the balance check holds until the payment is sent
+	mutex.Lock(walletLockKey(user))
+	defer mutex.Unlock(walletLockKey(user))
+	if connection.Budget > 0 && connection.Spent+amount > connection.Budget {
+		return nwcErrorResponse(nwcErrorQuota, "budget of %d sat exceeded", connection.Budget)
+	}
+	balance, err := bot.GetUserBalance(user)
+	if err != nil {
+		return nwcErrorResponse(nwcErrorInternal, "could not get balance")
+	}
+	if balance < amount {
+		return nwcErrorResponse(nwcErrorBalance, "balance too low")
+	}
+	// the amount is reserved in the budget before paying, so a crash during the payment can't exceed it
+	connection.Spent += amount
+	runtime.IgnoreError(connection.Set(connection, bot.Bunt))
+	_, err = user.Wallet.Pay(lnbits.PaymentParams{Out: true, Bolt11: paymentRequest}, bot.Client)
+	payment, statusErr := bot.Client.Payment(*user.Wallet, bolt11.PaymentHash)
+	if err != nil {
+		if statusErr != nil {
+			// the payment might still arrive, the amount stays reserved
+			log.Warnf("[nwc] could not check payment %s of connection %s: %s", bolt11.PaymentHash, connection.shortID(), statusErr.Error())
+			return nwcErrorResponse(nwcErrorInternal, "payment status unknown: %s", err.Error())
+		}
+		if !payment.Paid {
+			connection.Spent -= amount
+			return nwcErrorResponse(nwcErrorInternal, "payment failed: %s", err.Error())
+		}
+	}
+	log.Infof("[nwc] %s paid %d sat via connection %s", GetUserStr(user.Telegram), amount, connection.shortID())
+	bot.trySendMessage(user.Telegram, fmt.Sprintf(i18n.Translate(connection.LanguageCode, "nwcPaidMessage"), amount, connection.shortID()))
+	preimage := ""
+	if statusErr == nil {
+		preimage = payment.Preimage
+	}
+	return nwcResponse{Result: map[string]interface{}{"preimage": preimage}}
+}
+
+func (bot *TipBot) nwcMakeInvoice(connection *NwcConnection, user *lnbits.User, rawParams json.RawMessage) nwcResponse {
+	params := struct {
+		Amount      int64  `json:"amount"` // msat
+		Description string `json:"description"`
+	}{}
+	if err := json.Unmarshal(rawParams, &params); err != nil {
+		return nwcErrorResponse(nwcErrorOther, "invalid params")
+	}
+	amount := params.Amount / 1000
+	if amount < 1 {
+		return nwcErrorResponse(nwcErrorOther, "invalid amount")
+	}
+	ctx := context.WithValue(context.Background(), "publicLanguageCode", connection.LanguageCode)
+	invoice, err := bot.createInvoiceWithEvent(ctx, user, amount, params.Description, "", InvoiceCallbackGeneric, "")
+	if err != nil {
+		return nwcErrorResponse(nwcErrorInternal, "could not create invoice")
+	}
+	return nwcResponse{Result: map[string]interface{}{
+		"type":         "incoming",
+		"invoice":      invoice.PaymentRequest,
+		"description":  params.Description,
+		"payment_hash": invoice.PaymentHash,
+		"amount":       amount * 1000,
+		"created_at":   time.Now().Unix(),
+	}}
+}
+
+func (bot *TipBot) nwcLookupInvoice(user *lnbits.User, rawParams json.RawMessage) nwcResponse {
+	params := struct {
+		PaymentHash string `json:"payment_hash"`
+		Invoice     string `json:"invoice"`
+	}{}
+	if err := json.Unmarshal(rawParams, &params); err != nil {
+		return nwcErrorResponse(nwcErrorOther, "invalid params")
+	}
+	paymentHash := params.PaymentHash
+	if len(paymentHash) == 0 {
+		bolt11, err := decodepay.Decodepay(strings.TrimPrefix(strings.ToLower(params.Invoice), "lightning:"))
+		if err != nil {
+			return nwcErrorResponse(nwcErrorOther, "invalid invoice")
+		}
+		paymentHash = bolt11.PaymentHash
+	}
+	payment, err := bot.Client.Payment(*user.Wallet, paymentHash)
+	if err != nil {
+		return nwcErrorResponse(nwcErrorNotFound, "invoice not found")
+	}
+	details := payment.Details
+	result := map[string]interface{}{
+		"type":         "incoming",
+		"invoice":      details.Bolt11,
+		"description":  details.Memo,
+		"payment_hash": paymentHash,
+		"preimage":     payment.Preimage,
+		"amount":       details.Amount,
+		"fees_paid":    details.Fee,
+		"created_at":   details.Time,
+	}
+	// lnbits amounts are in msat and negative for outgoing payments
+	if details.Amount < 0 {
+		result["type"] = "outgoing"
+		result["amount"] = -details.Amount
+	}
+	if payment.Paid {
+		result["settled_at"] = details.Time
+	}
+	return nwcResponse{Result: result}
+}
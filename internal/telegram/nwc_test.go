@@ -0,0 +1,335 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	decodepay "github.com/fiatjaf/ln-decodepay"
+	"github.com/gorilla/websocket"
+	"github.com/massmux/SatsMobiBot/internal"
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	"github.com/massmux/SatsMobiBot/internal/storage"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip04"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// 250000 sat invoice from the BOLT 11 test vectors
+const nwcTestInvoice = "lnbc2500u1pvjluezpp5qqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqypqdq5xysxxatsyp3k7enxv4jsxqzpuaztrnwngzn3kdzw5hydlzf03qdgm2hdq27cqv3agm2awhz5se903vruatfhq77w3ls4evs3ch9zw97j25emudupq63nyw24cg27h2rspfj9srp"
+
+const nwcTestUserID = 1001
+
+// nwcTest is a bot with in-memory databases that is connected to an in-process relay
+type nwcTest struct {
+	bot       *TipBot
+	relay     *nostr.Relay
+	published chan nostr.Event
+}
+
+func newNwcTest(t *testing.T) *nwcTest {
+	test := &nwcTest{published: make(chan nostr.Event, 16)}
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			var message []json.RawMessage
+			if err := conn.ReadJSON(&message); err != nil || len(message) < 2 {
+				return
+			}
+			var label string
+			json.Unmarshal(message[0], &label)
+			switch label {
+			case "EVENT":
+				var ev nostr.Event
+				if err := json.Unmarshal(message[1], &ev); err != nil {
+					return
+				}
+				test.published <- ev
+				conn.WriteJSON([]interface{}{"OK", ev.ID, true, ""})
+			case "REQ":
+				var id string
+				json.Unmarshal(message[1], &id)
+				conn.WriteJSON([]interface{}{"EOSE", id})
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	orm, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := orm.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection would open its own in-memory database
+	sqlDB.SetMaxOpenConns(1)
+	if err = orm.AutoMigrate(&lnbits.User{}); err != nil {
+		t.Fatal(err)
+	}
+	user := &lnbits.User{
+		Name:     "1001",
+		Telegram: &tb.User{ID: nwcTestUserID},
+		Wallet:   &lnbits.Wallet{ID: "wallet", Adminkey: "adminkey", Inkey: "inkey"},
+	}
+	if err = orm.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	test.bot = &TipBot{DB: &Databases{Users: orm}, Bunt: createBunt(":memory:")}
+	internal.Configuration.Nostr.PrivateKey = nostr.GeneratePrivateKey()
+
+	test.relay, err = nostr.RelayConnect(context.Background(), "ws"+strings.TrimPrefix(server.URL, "http"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return test
+}
+
+// newConnection stores a connection for the test user and returns its secret
+func (test *nwcTest) newConnection(t *testing.T, budget, spent int64) (string, *NwcConnection) {
+	secret := nostr.GeneratePrivateKey()
+	pubkey, err := nostr.GetPublicKey(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	connection := &NwcConnection{
+		Base:   storage.New(storage.ID("nwc:" + pubkey)),
+		PubKey: pubkey,
+		User:   &tb.User{ID: nwcTestUserID},
+		Budget: budget,
+		Spent:  spent,
+	}
+	if err = connection.Set(connection, test.bot.Bunt); err != nil {
+		t.Fatal(err)
+	}
+	return secret, connection
+}
+
+// request returns a signed and encrypted request of the connection with the secret
+func (test *nwcTest) request(t *testing.T, secret string, method string, params interface{}, createdAt time.Time) *nostr.Event {
+	pubkey, _ := nostr.GetPublicKey(secret)
+	servicePubkey, _ := nostr.GetPublicKey(internal.Configuration.Nostr.PrivateKey)
+	sharedSecret, err := nip04.ComputeSharedSecret(servicePubkey, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := json.Marshal(map[string]interface{}{"method": method, "params": params})
+	encrypted, err := nip04.Encrypt(string(content), sharedSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := &nostr.Event{
+		PubKey:    pubkey,
+		CreatedAt: createdAt,
+		Kind:      nwcKindRequest,
+		Tags:      nostr.Tags{nostr.Tag{"p", servicePubkey}},
+		Content:   encrypted,
+	}
+	if err = ev.Sign(secret); err != nil {
+		t.Fatal(err)
+	}
+	return ev
+}
+
+// responses decrypts the responses the bot published so far
+func (test *nwcTest) responses(t *testing.T, secret string) []nwcResponse {
+	servicePubkey, _ := nostr.GetPublicKey(internal.Configuration.Nostr.PrivateKey)
+	sharedSecret, err := nip04.ComputeSharedSecret(servicePubkey, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	responses := make([]nwcResponse, 0)
+	for {
+		select {
+		case ev := <-test.published:
+			if ev.Kind != nwcKindResponse {
+				t.Fatalf("published event of kind %d", ev.Kind)
+			}
+			content, err := nip04.Decrypt(ev.Content, sharedSecret)
+			if err != nil {
+				t.Fatal(err)
+			}
+			response := nwcResponse{}
+			if err = json.Unmarshal([]byte(content), &response); err != nil {
+				t.Fatal(err)
+			}
+			responses = append(responses, response)
+		default:
+			return responses
+		}
+	}
+}
+
+func wantNwcError(t *testing.T, responses []nwcResponse, code string) {
+	t.Helper()
+	if len(responses) != 1 {
+		t.Fatalf("got %d responses, want 1", len(responses))
+	}
+	if responses[0].Error == nil || responses[0].Error.Code != code {
+		t.Fatalf("got response %+v, want error %s", responses[0], code)
+	}
+}
+
+func TestNwc_budget(t *testing.T) {
+	test := newNwcTest(t)
+	secret, connection := test.newConnection(t, 300000, 100000)
+	params := map[string]string{"invoice": nwcTestInvoice}
+	test.bot.handleNwcRequest(test.relay, test.request(t, secret, "pay_invoice", params, time.Now()))
+	wantNwcError(t, test.responses(t, secret), nwcErrorQuota)
+
+	stored, err := test.bot.loadNwcConnection(connection.PubKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Spent != 100000 {
+		t.Errorf("spent = %d, want 100000", stored.Spent)
+	}
+	if stored.LastUsed.IsZero() {
+		t.Errorf("last used was not updated")
+	}
+}
+
+func TestNwc_revoke(t *testing.T) {
+	test := newNwcTest(t)
+	secret, connection := test.newConnection(t, 0, 0)
+	if revoked := test.bot.revokeNwcConnection(nwcTestUserID+1, connection.shortID()); revoked != nil {
+		t.Fatalf("revoked the connection of another user")
+	}
+	if revoked := test.bot.revokeNwcConnection(nwcTestUserID, strings.ToUpper(connection.shortID())); revoked == nil {
+		t.Fatalf("connection was not revoked")
+	}
+	if len(test.bot.getNwcConnections(nwcTestUserID)) != 0 {
+		t.Fatalf("revoked connection is still listed")
+	}
+	test.bot.handleNwcRequest(test.relay, test.request(t, secret, "get_balance", nil, time.Now()))
+	wantNwcError(t, test.responses(t, secret), nwcErrorUnauthorized)
+}
+
+func TestNwc_expired(t *testing.T) {
+	test := newNwcTest(t)
+	secret, connection := test.newConnection(t, 0, 0)
+	connection.ExpiresAt = time.Now().Add(-time.Minute)
+	if err := connection.Set(connection, test.bot.Bunt); err != nil {
+		t.Fatal(err)
+	}
+	test.bot.handleNwcRequest(test.relay, test.request(t, secret, "get_balance", nil, time.Now()))
+	wantNwcError(t, test.responses(t, secret), nwcErrorUnauthorized)
+}
+
+func TestNwc_replay(t *testing.T) {
+	test := newNwcTest(t)
+	// requests of unknown connections are answered without a wallet
+	secret := nostr.GeneratePrivateKey()
+
+	ev := test.request(t, secret, "get_balance", nil, time.Now())
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			test.bot.handleNwcRequest(test.relay, ev)
+		}()
+	}
+	wg.Wait()
+	wantNwcError(t, test.responses(t, secret), nwcErrorUnauthorized)
+	// the handled id is persisted in bunt, not in memory
+	restarted := &TipBot{Bunt: test.bot.Bunt}
+	if err := restarted.acceptNwcRequest(ev); err == nil {
+		t.Errorf("accepted a request that was already handled")
+	}
+
+	// the same request with another id
+	copied := *ev
+	copied.ID = strings.Repeat("0", 64)
+	if err := test.bot.acceptNwcRequest(&copied); err == nil {
+		t.Errorf("accepted a request with an invalid id")
+	}
+	// the content of a request with a new date
+	copied = *ev
+	copied.CreatedAt = time.Now().Add(time.Second)
+	copied.ID = copied.GetID()
+	if err := test.bot.acceptNwcRequest(&copied); err == nil {
+		t.Errorf("accepted a request with an invalid signature")
+	}
+
+	tests := []struct {
+		name      string
+		createdAt time.Time
+		wantErr   bool
+	}{
+		{name: "now", createdAt: time.Now()},
+		{name: "recent", createdAt: time.Now().Add(-nwcRequestMaxAge / 2)},
+		{name: "clock skew", createdAt: time.Now().Add(nwcRequestMaxSkew / 2)},
+		{name: "too old", createdAt: time.Now().Add(-2 * nwcRequestMaxAge), wantErr: true},
+		{name: "future", createdAt: time.Now().Add(2 * nwcRequestMaxSkew), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev := test.request(t, secret, "get_balance", nil, tt.createdAt)
+			test.bot.handleNwcRequest(test.relay, ev)
+			responses := test.responses(t, secret)
+			if tt.wantErr && len(responses) != 0 {
+				t.Errorf("got %d responses, want none", len(responses))
+			}
+			if !tt.wantErr {
+				wantNwcError(t, responses, nwcErrorUnauthorized)
+			}
+		})
+	}
+}
+
+func TestTipBot_nwcPayInvoice(t *testing.T) {
+	tests := []struct {
+		name          string
+		failPays      int
+		ambiguousPays int
+		failStatus    bool
+		wantError     bool
+		wantSpent     int64
+	}{
+		{name: "paid", wantSpent: 250000},
+		{name: "failed", failPays: 1, wantError: true, wantSpent: 0},
+		// the error doesn't tell if the payment was sent, the status does
+		{name: "paid with error", ambiguousPays: 1, wantSpent: 250000},
+		{name: "unknown status", failPays: 1, failStatus: true, wantError: true, wantSpent: 250000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			test := newTestBot(t)
+			user := test.addUser(t, 2, 300000)
+			payee := test.lnbits.newWallet("payee", 0)
+			bolt11, err := decodepay.Decodepay(nwcTestInvoice)
+			if err != nil {
+				t.Fatal(err)
+			}
+			test.lnbits.mu.Lock()
+			test.lnbits.invoices[nwcTestInvoice] = &fakeInvoice{hash: bolt11.PaymentHash, wallet: payee.ID, amount: 250000}
+			test.lnbits.failPays, test.lnbits.ambiguousPays, test.lnbits.failStatus = tt.failPays, tt.ambiguousPays, tt.failStatus
+			test.lnbits.mu.Unlock()
+
+			pubkey, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+			connection := &NwcConnection{Base: storage.New(storage.ID("nwc:" + pubkey)), PubKey: pubkey, User: user.Telegram, Budget: 300000}
+			params, _ := json.Marshal(map[string]string{"invoice": nwcTestInvoice})
+			response := test.nwcPayInvoice(connection, user, params)
+			if (response.Error != nil) != tt.wantError {
+				t.Errorf("nwcPayInvoice() = %+v, want error %v", response, tt.wantError)
+			}
+			if connection.Spent != tt.wantSpent {
+				t.Errorf("spent = %d, want %d", connection.Spent, tt.wantSpent)
+			}
+		})
+	}
+}
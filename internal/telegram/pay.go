@@ -16,9 +16,9 @@ import (
 	"github.com/massmux/SatsMobiBot/internal/lnbits"
 	"github.com/massmux/SatsMobiBot/internal/runtime"
 
-	"github.com/massmux/SatsMobiBot/internal/str"
 	lnurl "github.com/fiatjaf/go-lnurl"
 	decodepay "github.com/fiatjaf/ln-decodepay"
+	"github.com/massmux/SatsMobiBot/internal/str"
 	log "github.com/sirupsen/logrus"
 	tb "gopkg.in/lightningtipbot/telebot.v3"
 )
@@ -77,37 +77,28 @@ func (bot *TipBot) payHandler(ctx intercept.Context) (intercept.Context, error)
 	// get rid of the URI prefix
 	paymentRequest = strings.TrimPrefix(paymentRequest, "lightning:")
 
-	// decode invoice
-	bolt11, err := decodepay.Decodepay(paymentRequest)
-	if err != nil {
-		bot.trySendMessage(ctx.Sender(), helpPayInvoiceUsage(ctx, Translate(ctx, "invalidInvoiceHelpMessage")))
-		errmsg := fmt.Sprintf("[/pay] Error: Could not decode invoice: %s", err.Error())
-		log.Errorln(errmsg)
-		return ctx, errors.New(errors.InvalidSyntaxError, err)
-	}
+	// decode invoice and check user balance
+	bolt11, balance, err := bot.checkInvoicePayment(user, paymentRequest)
 	amount := int64(bolt11.MSatoshi / 1000)
-
-	if amount <= 0 {
-		bot.trySendMessage(ctx.Sender(), Translate(ctx, "invoiceNoAmountMessage"))
-		errmsg := fmt.Sprint("[/pay] Error: invoice without amount")
-		log.Warnln(errmsg)
-		return ctx, errors.Create(errors.InvalidAmountError)
-	}
-
-	// check user balance first
-	balance, err := bot.GetUserBalance(user)
 	if err != nil {
-		NewMessage(ctx.Message(), WithDuration(0, bot))
-		errmsg := fmt.Sprintf("[/pay] Error: Could not get user balance: %s", err.Error())
-		log.Errorln(errmsg)
-		bot.trySendMessage(ctx.Sender(), Translate(ctx, "errorTryLaterMessage"))
-		return ctx, errors.New(errors.GetBalanceError, err)
-	}
-
-	if amount > balance {
-		NewMessage(ctx.Message(), WithDuration(0, bot))
-		bot.trySendMessage(ctx.Sender(), fmt.Sprintf(Translate(ctx, "insufficientFundsMessage"), balance, amount))
-		return ctx, errors.Create(errors.InvalidSyntaxError)
+		log.Warnf("[/pay] Error: %s", err.Error())
+		code := errors.UnknownError
+		if e, ok := err.(errors.TipBotError); ok {
+			code = e.Code
+		}
+		switch code {
+		case errors.InvalidSyntaxError:
+			bot.trySendMessage(ctx.Sender(), helpPayInvoiceUsage(ctx, Translate(ctx, "invalidInvoiceHelpMessage")))
+		case errors.InvalidAmountError:
+			bot.trySendMessage(ctx.Sender(), Translate(ctx, "invoiceNoAmountMessage"))
+		case errors.BalanceToLowError:
+			NewMessage(ctx.Message(), WithDuration(0, bot))
+			bot.trySendMessage(ctx.Sender(), fmt.Sprintf(Translate(ctx, "insufficientFundsMessage"), balance, amount))
+		default:
+			NewMessage(ctx.Message(), WithDuration(0, bot))
+			bot.trySendMessage(ctx.Sender(), Translate(ctx, "errorTryLaterMessage"))
+		}
+		return ctx, err
 	}
 	// send warning that the invoice might fail due to missing fee reserve
 	if float64(amount) > float64(balance)*0.98 {
@@ -158,6 +149,42 @@ func (bot *TipBot) payHandler(ctx intercept.Context) (intercept.Context, error)
 	return ctx, nil
 }
 
+// checkInvoicePayment decodes the invoice and checks that the user can pay it.
+// It returns the balance of the user, which is also set if the balance is too low.
+func (bot *TipBot) checkInvoicePayment(user *lnbits.User, paymentRequest string) (decodepay.Bolt11, int64, error) {
+	bolt11, err := decodepay.Decodepay(paymentRequest)
+	if err != nil {
+		return bolt11, 0, errors.New(errors.InvalidSyntaxError, fmt.Errorf("could not decode invoice: %w", err))
+	}
+	amount := int64(bolt11.MSatoshi / 1000)
+	if amount <= 0 {
+		return bolt11, 0, errors.New(errors.InvalidAmountError, fmt.Errorf("invoice without amount"))
+	}
+	balance, err := bot.GetUserBalance(user)
+	if err != nil {
+		return bolt11, 0, errors.New(errors.GetBalanceError, fmt.Errorf("could not get user balance: %w", err))
+	}
+	if amount > balance {
+		return bolt11, balance, errors.New(errors.BalanceToLowError, fmt.Errorf("balance of %d sat too low for %d sat", balance, amount))
+	}
+	return bolt11, balance, nil
+}
+
+// payInvoice pays the invoice of payData from the wallet of the user. The payment hash is stored
+// with payData, which is inactivated so that it can't be paid twice. Every invoice payment of
+// /pay and of the other frontends goes through here.
+func (bot *TipBot) payInvoice(user *lnbits.User, payData *PayData) error {
+	if !payData.Active {
+		return errors.Create(errors.NotActiveError)
+	}
+	invoice, err := user.Wallet.Pay(lnbits.PaymentParams{Out: true, Bolt11: payData.Invoice}, bot.Client)
+	if err != nil {
+		return err
+	}
+	payData.Hash = invoice.PaymentHash
+	return payData.Inactivate(payData, bot.Bunt)
+}
+
 // confirmPayHandler when user clicked pay on payment confirmation
 func (bot *TipBot) confirmPayHandler(ctx intercept.Context) (intercept.Context, error) {
 	tx := &PayData{Base: storage.New(storage.ID(ctx.Data()))}
@@ -210,7 +237,7 @@ func (bot *TipBot) confirmPayHandler(ctx intercept.Context) (intercept.Context,
 
 	log.Infof("[/pay] Attempting %s's invoice %s (%d sat)", userStr, payData.ID, payData.Amount)
 	// pay invoice
-	invoice, err := user.Wallet.Pay(lnbits.PaymentParams{Out: true, Bolt11: payData.Invoice}, bot.Client)
+	err = bot.payInvoice(user, payData)
 	if err != nil {
 		errmsg := fmt.Sprintf("[/pay] Could not pay invoice of %s: %s", userStr, err)
 		err = fmt.Errorf(i18n.Translate(payData.LanguageCode, "invoiceUndefinedErrorMessage"))
@@ -223,7 +250,6 @@ func (bot *TipBot) confirmPayHandler(ctx intercept.Context) (intercept.Context,
 		log.Errorln(errmsg)
 		return ctx, err
 	}
-	payData.Hash = invoice.PaymentHash
 
 	// do balance check for keyboard update
 	_, err = bot.GetUserBalance(user)
@@ -0,0 +1,387 @@
+package telegram
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eko/gocache/store"
+	"github.com/massmux/SatsMobiBot/internal"
+	"github.com/massmux/SatsMobiBot/internal/i18n"
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	"github.com/massmux/SatsMobiBot/internal/runtime"
+	"github.com/massmux/SatsMobiBot/internal/runtime/mutex"
+	"github.com/massmux/SatsMobiBot/internal/storage"
+	"github.com/massmux/SatsMobiBot/internal/str"
+	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	"github.com/skip2/go-qrcode"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const (
+	payToPostModeDay       = "day"
+	payToPostModeMessage   = "message"
+	payToPostInvoiceResend = 10 * time.Minute
+	payToPostDefaultBundle = 10 // messages per invoice in mode message
+	payToPostMemo          = "✍️ Posting fee for group %s"
+)
+
+// PayToPost is the pay-to-post configuration of a group
+type PayToPost struct {
+	*storage.Base
+	ChatID    int64        `json:"chat_id"`
+	ChatTitle string       `json:"chat_title"`
+	Price     int64        `json:"price"`
+	Mode      string       `json:"mode"`       // pay per day or per message
+	Bundle    int          `json:"bundle"`     // messages that are bought with one invoice in mode message
+	MemberAge int64        `json:"member_age"` // members who joined at least this many days ago post for free
+	EnabledAt time.Time    `json:"enabled_at"`
+	Recipient *lnbits.User `json:"recipient"` // receives the fees, either the group owner or the bot
+}
+
+// bundle returns the number of messages per invoice
+func (payToPost *PayToPost) bundle() int {
+	if payToPost.Mode != payToPostModeMessage {
+		return 1
+	}
+	if payToPost.Bundle < 1 {
+		return 1
+	}
+	return payToPost.Bundle
+}
+
+// mustPay returns true if a member who joined at joinedAt has to pay to post. Only members
+// who joined after pay-to-post was enabled pay, until they are older than MemberAge.
+func (payToPost *PayToPost) mustPay(joinedAt time.Time, now time.Time) bool {
+	if joinedAt.IsZero() || joinedAt.Before(payToPost.EnabledAt) {
+		return false
+	}
+	if payToPost.MemberAge > 0 && now.Sub(joinedAt) >= time.Duration(payToPost.MemberAge)*24*time.Hour {
+		return false
+	}
+	return true
+}
+
+// PayToPostPass holds what a member has paid to post in a group
+type PayToPostPass struct {
+	*storage.Base
+	ChatID      int64     `json:"chat_id"`
+	UserID      int64     `json:"user_id"`
+	ValidUntil  time.Time `json:"valid_until"` // mode day
+	Credits     int       `json:"credits"`     // mode message
+	InvoiceSent time.Time `json:"invoice_sent"`
+	JoinedAt    time.Time `json:"joined_at"` // zero if the member joined before pay-to-post was enabled
+}
+
+func payToPostKey(chatID int64) string {
+	return fmt.Sprintf("paytopost:%d", chatID)
+}
+
+func payToPostPassKey(chatID, userID int64) string {
+	return fmt.Sprintf("paytopost-pass:%d_%d", chatID, userID)
+}
+
+// loadPayToPost returns the pay-to-post configuration of a chat
+func (bot *TipBot) loadPayToPost(chatID int64) (*PayToPost, error) {
+	tx := &PayToPost{Base: storage.New(storage.ID(payToPostKey(chatID)))}
+	sn, err := tx.Get(tx, bot.Bunt)
+	if err != nil {
+		return nil, err
+	}
+	payToPost := sn.(*PayToPost)
+	if !payToPost.Active {
+		return nil, fmt.Errorf("pay to post not active")
+	}
+	return payToPost, nil
+}
+
+// loadPayToPostPass returns the pass of a user or a new empty one
+func (bot *TipBot) loadPayToPostPass(chatID, userID int64) *PayToPostPass {
+	tx := &PayToPostPass{Base: storage.New(storage.ID(payToPostPassKey(chatID, userID))), ChatID: chatID, UserID: userID}
+	sn, err := tx.Get(tx, bot.Bunt)
+	if err != nil {
+		return tx
+	}
+	return sn.(*PayToPostPass)
+}
+
+// isAdminCached checks if the user is admin of the chat and caches the result
+// because it is called for every message in pay-to-post groups.
+func (bot *TipBot) isAdminCached(chat *tb.Chat, user *tb.User) bool {
+	key := fmt.Sprintf("chat-admin-%d-%d", chat.ID, user.ID)
+	if isAdmin, err := bot.Cache.Get(key); err == nil {
+		return isAdmin.(bool)
+	}
+	isAdmin := bot.isAdmin(chat, user)
+	bot.Cache.Set(key, isAdmin, &store.Options{Expiration: 10 * time.Minute})
+	return isAdmin
+}
+
+// payToPostInterceptor deletes messages of members in pay-to-post groups that have not paid
+// and sends them an invoice via DM. Messages in private chats are passed through.
+func (bot *TipBot) payToPostInterceptor(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	if m == nil || m.Private() || m.Sender == nil {
+		return ctx, nil
+	}
+	payToPost, err := bot.loadPayToPost(m.Chat.ID)
+	if err != nil {
+		return ctx, nil
+	}
+	if m.Sender.IsBot || bot.isAdminCached(m.Chat, m.Sender) {
+		return ctx, nil
+	}
+	// members with a paid group membership can always post
+	groupMember := &GroupMember{GroupID: m.Chat.ID, UserID: m.Sender.ID}
+	if tx := bot.DB.Groups.Where(groupMember).First(groupMember); tx.Error == nil && groupMember.PaidUntil.After(time.Now()) {
+		return ctx, nil
+	}
+
+	passKey := payToPostPassKey(m.Chat.ID, m.Sender.ID)
+	mutex.Lock(passKey)
+	defer mutex.Unlock(passKey)
+	pass := bot.loadPayToPostPass(m.Chat.ID, m.Sender.ID)
+	if !payToPost.mustPay(pass.JoinedAt, time.Now()) {
+		return ctx, nil
+	}
+	switch payToPost.Mode {
+	case payToPostModeMessage:
+		if pass.Credits > 0 {
+			pass.Credits--
+			runtime.IgnoreError(pass.Set(pass, bot.Bunt))
+			return ctx, nil
+		}
+	default:
+		if pass.ValidUntil.After(time.Now()) {
+			return ctx, nil
+		}
+	}
+
+	// not paid: delete the message and send an invoice
+	bot.tryDeleteMessage(m)
+	if time.Since(pass.InvoiceSent) < payToPostInvoiceResend {
+		return ctx, fmt.Errorf("pay to post invoice already sent")
+	}
+	err = bot.sendPayToPostInvoice(payToPost, m.Sender)
+	if err != nil {
+		log.Errorf("[payToPostInterceptor] %s", err.Error())
+		return ctx, err
+	}
+	pass.InvoiceSent = time.Now()
+	runtime.IgnoreError(pass.Set(pass, bot.Bunt))
+	return ctx, fmt.Errorf("pay to post: message deleted")
+}
+
+// recordPayToPostJoin remembers when members joined a pay-to-post group. Members without a
+// record joined before pay-to-post was enabled and don't have to pay.
+func (bot *TipBot) recordPayToPostJoin(m *tb.Message) {
+	if m == nil || m.Chat == nil {
+		return
+	}
+	if _, err := bot.loadPayToPost(m.Chat.ID); err != nil {
+		return
+	}
+	members := m.UsersJoined
+	if len(members) == 0 && m.UserJoined != nil {
+		members = []tb.User{*m.UserJoined}
+	}
+	for _, member := range members {
+		if member.IsBot {
+			continue
+		}
+		passKey := payToPostPassKey(m.Chat.ID, member.ID)
+		mutex.Lock(passKey)
+		pass := bot.loadPayToPostPass(m.Chat.ID, member.ID)
+		pass.JoinedAt = time.Now()
+		runtime.IgnoreError(pass.Set(pass, bot.Bunt))
+		mutex.Unlock(passKey)
+	}
+}
+
+// sendPayToPostInvoice sends an invoice for the posting fee to the user via DM
+func (bot *TipBot) sendPayToPostInvoice(payToPost *PayToPost, sender *tb.User) error {
+	lang := sender.LanguageCode
+	memo := fmt.Sprintf(payToPostMemo, payToPost.ChatTitle)
+	amount := payToPost.Price * int64(payToPost.bundle())
+	invoice, err := payToPost.Recipient.Wallet.Invoice(
+		lnbits.InvoiceParams{
+			Out:     false,
+			Amount:  amount,
+			Memo:    memo,
+			Webhook: internal.Configuration.Lnbits.WebhookServer},
+		bot.Client)
+	if err != nil {
+		return err
+	}
+	payer, _ := GetLnbitsUser(sender, *bot)
+	invoiceEvent := &InvoiceEvent{
+		Base: storage.New(storage.ID(fmt.Sprintf("invoice:%s", invoice.PaymentHash))),
+		Invoice: &Invoice{PaymentHash: invoice.PaymentHash,
+			PaymentRequest: invoice.PaymentRequest,
+			Amount:         amount,
+			Memo:           memo},
+		User:         payToPost.Recipient,
+		Callback:     InvoiceCallbackPayToPost,
+		CallbackData: fmt.Sprintf("%s_%d", payToPostPassKey(payToPost.ChatID, sender.ID), payToPost.bundle()),
+		LanguageCode: lang,
+		Payer:        payer,
+		Chat:         &tb.Chat{ID: payToPost.ChatID},
+	}
+	runtime.IgnoreError(invoiceEvent.Set(invoiceEvent, bot.Bunt))
+
+	qr, err := qrcode.Encode(invoice.PaymentRequest, qrcode.Medium, 256)
+	if err != nil {
+		return err
+	}
+	what := i18n.Translate(lang, "payToPostPerDayMessage")
+	if payToPost.Mode == payToPostModeMessage {
+		what = fmt.Sprintf(i18n.Translate(lang, "payToPostPerMessageMessage"), payToPost.bundle())
+	}
+	bot.trySendMessage(sender, &tb.Photo{File: tb.File{FileReader: bytes.NewReader(qr)}, Caption: fmt.Sprintf("`%s`", invoice.PaymentRequest)})
+	bot.trySendMessage(sender, fmt.Sprintf(i18n.Translate(lang, "payToPostInvoiceMessage"), str.MarkdownEscape(payToPost.ChatTitle), amount, what))
+	return nil
+}
+
+// payToPostPaidHandler is called when the posting fee was paid
+func (bot *TipBot) payToPostPaidHandler(event Event) {
+	invoiceEvent := event.(*InvoiceEvent)
+	payToPost, err := bot.loadPayToPost(invoiceEvent.Chat.ID)
+	if err != nil {
+		log.Errorf("[payToPostPaidHandler] %s", err.Error())
+		return
+	}
+	// callback data is <pass key>_<credits>
+	splits := strings.Split(strings.TrimPrefix(invoiceEvent.CallbackData, "paytopost-pass:"), "_")
+	if len(splits) != 3 {
+		log.Errorf("[payToPostPaidHandler] invalid callback data %s", invoiceEvent.CallbackData)
+		return
+	}
+	userID, err := strconv.ParseInt(splits[1], 10, 64)
+	if err != nil {
+		log.Errorf("[payToPostPaidHandler] %s", err.Error())
+		return
+	}
+	credits, err := strconv.Atoi(splits[2])
+	if err != nil || credits < 1 {
+		log.Errorf("[payToPostPaidHandler] invalid credits in callback data %s", invoiceEvent.CallbackData)
+		return
+	}
+	passKey := payToPostPassKey(payToPost.ChatID, userID)
+	mutex.Lock(passKey)
+	defer mutex.Unlock(passKey)
+	pass := bot.loadPayToPostPass(payToPost.ChatID, userID)
+	pass.InvoiceSent = time.Time{}
+	if payToPost.Mode == payToPostModeMessage {
+		pass.Credits += credits
+	} else {
+		start := time.Now()
+		if pass.ValidUntil.After(start) {
+			start = pass.ValidUntil
+		}
+		pass.ValidUntil = start.Add(24 * time.Hour)
+	}
+	runtime.IgnoreError(pass.Set(pass, bot.Bunt))
+	log.Infof("[paytopost] user %d paid %d sat to post in %s", userID, invoiceEvent.Amount, payToPost.ChatTitle)
+	bot.trySendMessage(&tb.User{ID: userID}, fmt.Sprintf(i18n.Translate(invoiceEvent.LanguageCode, "payToPostPaidMessage"), str.MarkdownEscape(payToPost.ChatTitle)))
+}
+
+// groupPayToPostHandler is invoked if an admin calls "/group paytopost <amount|off> [message|day] [owner|bot]"
+func (bot *TipBot) groupPayToPostHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	if m.Private() {
+		bot.trySendMessage(m.Chat, Translate(ctx, "payToPostHelpMessage"))
+		return ctx, fmt.Errorf("not in group")
+	}
+	user := LoadUser(ctx)
+	if !bot.isOwner(m.Chat, user.Telegram) {
+		return ctx, fmt.Errorf("not owner")
+	}
+	amountStr, err := getArgumentFromCommand(m.Text, 2)
+	if err != nil {
+		bot.trySendMessage(m.Chat, Translate(ctx, "payToPostHelpMessage"))
+		return ctx, err
+	}
+	if strings.ToLower(amountStr) == "off" {
+		payToPost, err := bot.loadPayToPost(m.Chat.ID)
+		if err == nil {
+			runtime.IgnoreError(payToPost.Inactivate(payToPost, bot.Bunt))
+		}
+		bot.trySendMessage(m.Chat, Translate(ctx, "payToPostDisabledMessage"))
+		return ctx, nil
+	}
+	if !bot.isAdmin(m.Chat, bot.Telegram.Me) {
+		bot.trySendMessage(m.Chat, Translate(ctx, "groupBotIsNotAdminMessage"))
+		return ctx, fmt.Errorf("bot is not admin")
+	}
+	amount, err := GetAmount(amountStr)
+	if err != nil || amount < 1 {
+		bot.trySendMessage(m.Chat, Translate(ctx, "payToPostHelpMessage"))
+		return ctx, err
+	}
+	mode := payToPostModeDay
+	recipient := user
+	bundle := payToPostDefaultBundle
+	var memberAge int64
+	for _, arg := range strings.Fields(m.Text)[3:] {
+		arg = strings.ToLower(arg)
+		switch {
+		case arg == payToPostModeDay || arg == payToPostModeMessage:
+			mode = arg
+		case strings.HasPrefix(arg, "bundle:"):
+			bundle, err = strconv.Atoi(strings.TrimPrefix(arg, "bundle:"))
+			if err != nil || bundle < 1 || bundle > 1000 {
+				bot.trySendMessage(m.Chat, Translate(ctx, "payToPostHelpMessage"))
+				return ctx, fmt.Errorf("invalid bundle %s", arg)
+			}
+		case strings.HasPrefix(arg, "age:"):
+			memberAge, err = strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(arg, "age:"), "d"), 10, 64)
+			if err != nil || memberAge < 0 {
+				bot.trySendMessage(m.Chat, Translate(ctx, "payToPostHelpMessage"))
+				return ctx, fmt.Errorf("invalid age %s", arg)
+			}
+		case arg == "owner":
+			recipient = user
+		case arg == "bot":
+			recipient, err = GetUser(bot.Telegram.Me, *bot)
+			if err != nil {
+				log.Errorf("[groupPayToPostHandler] Could not get bot user from DB: %s", err.Error())
+				return ctx, err
+			}
+		default:
+			bot.trySendMessage(m.Chat, Translate(ctx, "payToPostHelpMessage"))
+			return ctx, fmt.Errorf("invalid argument %s", arg)
+		}
+	}
+	enabledAt := time.Now()
+	if existing, err := bot.loadPayToPost(m.Chat.ID); err == nil && !existing.EnabledAt.IsZero() {
+		// changing the price does not make existing members pay
+		enabledAt = existing.EnabledAt
+	}
+	payToPost := &PayToPost{
+		Base:      storage.New(storage.ID(payToPostKey(m.Chat.ID))),
+		ChatID:    m.Chat.ID,
+		ChatTitle: m.Chat.Title,
+		Price:     amount,
+		Mode:      mode,
+		Bundle:    bundle,
+		MemberAge: memberAge,
+		EnabledAt: enabledAt,
+		Recipient: recipient,
+	}
+	runtime.IgnoreError(payToPost.Set(payToPost, bot.Bunt))
+	log.Infof("[paytopost] enabled in %s: %d sat per %s", m.Chat.Title, amount, mode)
+	text := fmt.Sprintf(Translate(ctx, "payToPostEnabledMessage"), amount, mode, GetUserStrMd(bot.Telegram.Me))
+	if mode == payToPostModeMessage {
+		text += "\n" + fmt.Sprintf(Translate(ctx, "payToPostBundleMessage"), payToPost.bundle(), amount*int64(payToPost.bundle()))
+	}
+	if memberAge > 0 {
+		text += "\n" + fmt.Sprintf(Translate(ctx, "payToPostMemberAgeMessage"), memberAge)
+	} else {
+		text += "\n" + Translate(ctx, "payToPostNewMembersMessage")
+	}
+	bot.trySendMessage(m.Chat, text)
+	return ctx, nil
+}
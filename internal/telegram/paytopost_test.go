@@ -0,0 +1,32 @@
+package telegram
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPayToPost_mustPay(t *testing.T) {
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	enabledAt := now.Add(-10 * 24 * time.Hour)
+	tests := []struct {
+		name      string
+		memberAge int64
+		joinedAt  time.Time
+		want      bool
+	}{
+		{name: "joined before tracking", joinedAt: time.Time{}, want: false},
+		{name: "joined before enabled", joinedAt: enabledAt.Add(-time.Hour), want: false},
+		{name: "new member", joinedAt: now.Add(-time.Hour), want: true},
+		{name: "new member without age limit", joinedAt: enabledAt.Add(time.Hour), want: true},
+		{name: "young member", memberAge: 7, joinedAt: now.Add(-6 * 24 * time.Hour), want: true},
+		{name: "old enough member", memberAge: 7, joinedAt: now.Add(-7 * 24 * time.Hour), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payToPost := &PayToPost{EnabledAt: enabledAt, MemberAge: tt.memberAge}
+			if got := payToPost.mustPay(tt.joinedAt, now); got != tt.want {
+				t.Errorf("mustPay() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,360 @@
+package telegram
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/massmux/SatsMobiBot/internal/errors"
+	"github.com/massmux/SatsMobiBot/internal/i18n"
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	"github.com/massmux/SatsMobiBot/internal/runtime"
+	"github.com/massmux/SatsMobiBot/internal/runtime/mutex"
+	"github.com/massmux/SatsMobiBot/internal/storage"
+	"github.com/massmux/SatsMobiBot/internal/str"
+	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/buntdb"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const (
+	RaffleIndex          = "raffle:*"
+	raffleMinDuration    = time.Minute
+	raffleMaxDuration    = 30 * 24 * time.Hour
+	raffleMaxPrizeLength = 100
+)
+
+var (
+	raffleMenu      = &tb.ReplyMarkup{ResizeKeyboard: true}
+	btnJoinRaffle   = raffleMenu.Data("🎟 Join", "raffle_join")
+	btnCancelRaffle = raffleMenu.Data("🚫 Cancel", "raffle_cancel")
+)
+
+// Raffle is a giveaway in a group. Tickets and a prize in sat are held in escrow by the bot wallet
+// until the draw. The winner is drawn from Seed, whose SHA256 hash Commitment is published at the start.
+type Raffle struct {
+	*storage.Base
+	Creator      *lnbits.User   `json:"creator"`
+	Prize        string         `json:"prize"`        // free text if the prize is not paid in sat
+	PrizeAmount  int64          `json:"prize_amount"` // escrowed prize in sat
+	TicketPrice  int64          `json:"ticket_price"`
+	Participants []*lnbits.User `json:"participants"` // ticket number is index + 1
+	Deadline     time.Time      `json:"deadline"`
+	Seed         string         `json:"seed"` // kept secret until the draw
+	Commitment   string         `json:"commitment"`
+	Winner       *lnbits.User   `json:"winner"`
+	Message      *tb.Message    `json:"message"`
+	LanguageCode string         `json:"languagecode"`
+}
+
+// pot returns the amount that is paid out to the winner
+func (raffle *Raffle) pot() int64 {
+	return raffle.PrizeAmount + raffle.TicketPrice*int64(len(raffle.Participants))
+}
+
+// chat returns the group of the raffle or nil if its message was never sent
+func (raffle *Raffle) chat() *tb.Chat {
+	if raffle.Message == nil {
+		return nil
+	}
+	return raffle.Message.Chat
+}
+
+// hasJoined checks whether the user already has a ticket
+func (raffle *Raffle) hasJoined(id int64) bool {
+	for _, p := range raffle.Participants {
+		if p.Telegram.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// newRaffleSeed returns a random seed and its SHA256 commitment
+func newRaffleSeed() (seed string, commitment string, err error) {
+	b := make([]byte, 32)
+	if _, err = rand.Read(b); err != nil {
+		return "", "", err
+	}
+	seed = hex.EncodeToString(b)
+	hash := sha256.Sum256([]byte(seed))
+	return seed, hex.EncodeToString(hash[:]), nil
+}
+
+// drawRaffleTicket returns the winning ticket index: the seed read as a number modulo the number of tickets
+func drawRaffleTicket(seed string, tickets int) (int, error) {
+	n, ok := new(big.Int).SetString(seed, 16)
+	if !ok || tickets < 1 {
+		return 0, fmt.Errorf("invalid seed")
+	}
+	return int(new(big.Int).Mod(n, big.NewInt(int64(tickets))).Int64()), nil
+}
+
+// raffleHandler is invoked on /raffle <prize> <ticket price|free> <duration> in a group chat
+func (bot *TipBot) raffleHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	creator := LoadUser(ctx)
+	if creator.Wallet == nil {
+		return ctx, errors.Create(errors.UserNoWalletError)
+	}
+	if m.Private() {
+		bot.trySendMessage(m.Sender, Translate(ctx, "raffleHelpMessage"))
+		return ctx, errors.Create(errors.NoPrivateChatError)
+	}
+	words := strings.Fields(m.Text)
+	if len(words) < 4 {
+		bot.trySendMessage(m.Chat, Translate(ctx, "raffleHelpMessage"))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	prize := strings.Join(words[1:len(words)-2], " ")
+	prize = str.Truncate(prize, raffleMaxPrizeLength)
+	var ticketPrice int64
+	if priceStr := words[len(words)-2]; strings.ToLower(priceStr) != "free" {
+		price, err := GetAmount(priceStr)
+		if err != nil || price < 1 {
+			bot.trySendMessage(m.Chat, Translate(ctx, "raffleHelpMessage"))
+			return ctx, errors.Create(errors.InvalidAmountError)
+		}
+		ticketPrice = price
+	}
+	duration, err := ParseDuration(words[len(words)-1])
+	if err != nil || duration < raffleMinDuration || duration > raffleMaxDuration {
+		bot.trySendMessage(m.Chat, Translate(ctx, "raffleInvalidDurationMessage"))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	// a prize in sat is paid into escrow right away
+	var prizeAmount int64
+	if len(words) == 4 {
+		if amount, err := GetAmount(prize); err == nil && amount > 0 {
+			prizeAmount = amount
+		}
+	}
+	if prizeAmount == 0 && ticketPrice == 0 && len(prize) == 0 {
+		bot.trySendMessage(m.Chat, Translate(ctx, "raffleHelpMessage"))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	seed, commitment, err := newRaffleSeed()
+	if err != nil {
+		return ctx, err
+	}
+	raffle := &Raffle{
+		Base:         storage.New(storage.ID(fmt.Sprintf("raffle:%d:%s", m.Chat.ID, RandStringRunes(8)))),
+		Creator:      creator,
+		Prize:        prize,
+		PrizeAmount:  prizeAmount,
+		TicketPrice:  ticketPrice,
+		Participants: make([]*lnbits.User, 0),
+		Deadline:     time.Now().Add(duration),
+		Seed:         seed,
+		Commitment:   commitment,
+		LanguageCode: ctx.Value("publicLanguageCode").(string),
+	}
+	if prizeAmount > 0 {
+		me, err := GetUser(bot.Telegram.Me, *bot)
+		if err != nil {
+			return ctx, err
+		}
+		t := NewTransaction(bot, creator, me, prizeAmount, TransactionType("raffle"), TransactionChat(m.Chat))
+		t.Memo = fmt.Sprintf("🎟 Raffle prize of %s.", GetUserStr(creator.Telegram))
+		success, err := t.Send()
+		if !success || err != nil {
+			bot.trySendMessage(m.Chat, Translate(ctx, "inlineSendBalanceLowMessage"))
+			return ctx, errors.Create(errors.BalanceToLowError)
+		}
+	}
+	raffle.Message = bot.trySendMessageEditable(m.Chat, bot.raffleText(raffle), bot.raffleKeyboard(raffle))
+	if raffle.Message == nil {
+		// nobody can join a raffle without its message, give the prize back
+		bot.payFromEscrow(creator.Telegram, prizeAmount, "raffle", m.Chat, fmt.Sprintf("🎟 Raffle refund to %s.", GetUserStr(creator.Telegram)))
+		return ctx, errors.Create(errors.UnknownError)
+	}
+	runtime.IgnoreError(raffle.Set(raffle, bot.Bunt))
+	bot.startRaffleTimer(raffle)
+	log.Infof("[🎟 raffle] %s started raffle %s in %s", GetUserStr(creator.Telegram), raffle.ID, m.Chat.Title)
+	return ctx, nil
+}
+
+func (bot *TipBot) raffleText(raffle *Raffle) string {
+	prize := str.MarkdownEscape(raffle.Prize)
+	if raffle.PrizeAmount > 0 {
+		prize = fmt.Sprintf("%d sat", raffle.PrizeAmount)
+	}
+	ticket := i18n.Translate(raffle.LanguageCode, "raffleFreeTicket")
+	if raffle.TicketPrice > 0 {
+		ticket = fmt.Sprintf("%d sat", raffle.TicketPrice)
+	}
+	return fmt.Sprintf(i18n.Translate(raffle.LanguageCode, "raffleMessage"),
+		GetUserStrMd(raffle.Creator.Telegram), prize, ticket, len(raffle.Participants), raffle.pot(),
+		raffle.Deadline.UTC().Format("2006-01-02 15:04 UTC"), raffle.Commitment)
+}
+
+func (bot *TipBot) raffleKeyboard(raffle *Raffle) *tb.ReplyMarkup {
+	joinButton := raffleMenu.Data(btnJoinRaffle.Text, btnJoinRaffle.Unique, raffle.ID)
+	cancelButton := raffleMenu.Data(btnCancelRaffle.Text, btnCancelRaffle.Unique, raffle.ID)
+	raffleMenu.Inline(raffleMenu.Row(joinButton, cancelButton))
+	return raffleMenu
+}
+
+func (bot *TipBot) loadRaffle(id string) (*Raffle, error) {
+	tx := &Raffle{Base: storage.New(storage.ID(id))}
+	sn, err := tx.Get(tx, bot.Bunt)
+	if err != nil {
+		return nil, err
+	}
+	return sn.(*Raffle), nil
+}
+
+// joinRaffleHandler is invoked when a user presses the join button and pays the ticket into escrow
+func (bot *TipBot) joinRaffleHandler(ctx intercept.Context) (intercept.Context, error) {
+	c := ctx.Callback()
+	mutex.LockWithContext(ctx, c.Data)
+	defer mutex.UnlockWithContext(ctx, c.Data)
+	raffle, err := bot.loadRaffle(c.Data)
+	if err != nil {
+		return ctx, err
+	}
+	if !raffle.Active || time.Now().After(raffle.Deadline) {
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	user := LoadUser(ctx)
+	if user.Wallet == nil {
+		return ctx, errors.Create(errors.UserNoWalletError)
+	}
+	if user.Telegram.ID == raffle.Creator.Telegram.ID {
+		ctx.Context = context.WithValue(ctx, "callback_response", Translate(ctx, "raffleCreatorCantJoinMessage"))
+		return ctx, errors.Create(errors.SelfPaymentError)
+	}
+	if raffle.hasJoined(user.Telegram.ID) {
+		ctx.Context = context.WithValue(ctx, "callback_response", Translate(ctx, "raffleAlreadyJoinedMessage"))
+		return ctx, errors.Create(errors.MaxReachedError)
+	}
+	if raffle.TicketPrice > 0 {
+		me, err := GetUser(bot.Telegram.Me, *bot)
+		if err != nil {
+			return ctx, err
+		}
+		t := NewTransaction(bot, user, me, raffle.TicketPrice, TransactionType("raffle"), TransactionChat(raffle.Message.Chat))
+		t.Memo = fmt.Sprintf("🎟 Raffle ticket of %s.", GetUserStr(user.Telegram))
+		success, err := t.Send()
+		if !success || err != nil {
+			ctx.Context = context.WithValue(ctx, "callback_response", Translate(ctx, "inlineSendBalanceLowMessage"))
+			return ctx, errors.Create(errors.BalanceToLowError)
+		}
+	}
+	raffle.Participants = append(raffle.Participants, user)
+	runtime.IgnoreError(raffle.Set(raffle, bot.Bunt))
+	log.Infof("[🎟 raffle] %s joined raffle %s", GetUserStr(user.Telegram), raffle.ID)
+	ctx.Context = context.WithValue(ctx, "callback_response", fmt.Sprintf(Translate(ctx, "raffleJoinedMessage"), len(raffle.Participants)))
+	bot.tryEditStack(raffle.Message, raffle.ID, bot.raffleText(raffle), bot.raffleKeyboard(raffle))
+	return ctx, nil
+}
+
+// cancelRaffleHandler is invoked when the creator cancels the raffle. Everyone is refunded.
+func (bot *TipBot) cancelRaffleHandler(ctx intercept.Context) (intercept.Context, error) {
+	c := ctx.Callback()
+	mutex.LockWithContext(ctx, c.Data)
+	defer mutex.UnlockWithContext(ctx, c.Data)
+	raffle, err := bot.loadRaffle(c.Data)
+	if err != nil {
+		return ctx, err
+	}
+	if raffle.Creator.Telegram.ID != c.Sender.ID {
+		ctx.Context = context.WithValue(ctx, "callback_response", Translate(ctx, "raffleNotYourRaffleMessage"))
+		return ctx, errors.Create(errors.UnknownError)
+	}
+	if !raffle.Active {
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	runtime.RemoveTicker(raffle.ID)
+	raffle.Canceled = true
+	runtime.IgnoreError(raffle.Inactivate(raffle, bot.Bunt))
+	bot.refundRaffle(raffle)
+	log.Infof("[🎟 raffle] %s canceled raffle %s", GetUserStr(raffle.Creator.Telegram), raffle.ID)
+	bot.tryEditStack(raffle.Message, raffle.ID, bot.raffleText(raffle)+"\n\n"+i18n.Translate(raffle.LanguageCode, "raffleCanceledMessage"), &tb.ReplyMarkup{})
+	return ctx, nil
+}
+
+// refundRaffle pays back the tickets and the prize from the escrow. Failed refunds are retried.
+func (bot *TipBot) refundRaffle(raffle *Raffle) {
+	if raffle.TicketPrice > 0 {
+		for _, p := range raffle.Participants {
+			bot.payFromEscrow(p.Telegram, raffle.TicketPrice, "raffle", raffle.chat(), fmt.Sprintf("🎟 Raffle refund to %s.", GetUserStr(p.Telegram)))
+		}
+	}
+	if raffle.PrizeAmount > 0 {
+		bot.payFromEscrow(raffle.Creator.Telegram, raffle.PrizeAmount, "raffle", raffle.chat(), fmt.Sprintf("🎟 Raffle refund to %s.", GetUserStr(raffle.Creator.Telegram)))
+	}
+}
+
+// drawRaffle reveals the seed, draws the winner and pays out the pot
+func (bot *TipBot) drawRaffle(id string) {
+	mutex.Lock(id)
+	defer mutex.Unlock(id)
+	raffle, err := bot.loadRaffle(id)
+	if err != nil || !raffle.Active {
+		return
+	}
+	runtime.IgnoreError(raffle.Inactivate(raffle, bot.Bunt))
+	if raffle.Message == nil {
+		log.Errorf("[drawRaffle] raffle %s has no message, refunding", raffle.ID)
+		bot.refundRaffle(raffle)
+		return
+	}
+	if len(raffle.Participants) == 0 {
+		bot.refundRaffle(raffle)
+		bot.tryEditStack(raffle.Message, raffle.ID, bot.raffleText(raffle)+"\n\n"+i18n.Translate(raffle.LanguageCode, "raffleNoParticipantsMessage"), &tb.ReplyMarkup{})
+		return
+	}
+	ticket, err := drawRaffleTicket(raffle.Seed, len(raffle.Participants))
+	if err != nil {
+		log.Errorf("[drawRaffle] %s", err.Error())
+		bot.refundRaffle(raffle)
+		return
+	}
+	raffle.Winner = raffle.Participants[ticket]
+	runtime.IgnoreError(raffle.Set(raffle, bot.Bunt))
+	log.Infof("[🎟 raffle] %s won raffle %s with ticket #%d", GetUserStr(raffle.Winner.Telegram), raffle.ID, ticket+1)
+
+	// the pot is retried until it is paid out
+	bot.payFromEscrow(raffle.Winner.Telegram, raffle.pot(), "raffle", raffle.chat(), fmt.Sprintf("🎟 Raffle pot won by %s.", GetUserStr(raffle.Winner.Telegram)))
+	if raffle.pot() > 0 {
+		bot.trySendMessage(raffle.Winner.Telegram, fmt.Sprintf(i18n.Translate(raffle.Winner.Telegram.LanguageCode, "raffleYouWonMessage"), str.MarkdownEscape(raffle.Message.Chat.Title), raffle.pot()))
+	} else {
+		bot.trySendMessage(raffle.Winner.Telegram, fmt.Sprintf(i18n.Translate(raffle.Winner.Telegram.LanguageCode, "raffleYouWonPrizeMessage"), str.MarkdownEscape(raffle.Message.Chat.Title), GetUserStrMd(raffle.Creator.Telegram)))
+	}
+	text := bot.raffleText(raffle) + "\n\n" + fmt.Sprintf(i18n.Translate(raffle.LanguageCode, "raffleWinnerMessage"),
+		GetUserStrMd(raffle.Winner.Telegram), ticket+1, raffle.Seed, len(raffle.Participants), ticket+1)
+	bot.tryEditStack(raffle.Message, raffle.ID, text, &tb.ReplyMarkup{})
+}
+
+// startRaffleTimer draws the raffle at its deadline
+func (bot *TipBot) startRaffleTimer(raffle *Raffle) {
+	t := runtime.NewResettableFunction(raffle.ID,
+		runtime.WithTimer(time.NewTimer(time.Until(raffle.Deadline))))
+	t.Do(func() {
+		bot.drawRaffle(raffle.ID)
+	})
+}
+
+// restartRaffleTimers restarts the draw timers of all open raffles
+func (bot *TipBot) restartRaffleTimers() {
+	bot.Bunt.View(func(tx *buntdb.Tx) error {
+		return tx.Ascend("raffle", func(key, value string) bool {
+			raffle := &Raffle{}
+			if err := json.Unmarshal([]byte(value), raffle); err != nil {
+				return true
+			}
+			if raffle.Active {
+				bot.startRaffleTimer(raffle)
+			}
+			return true // continue iteration
+		})
+	})
+}
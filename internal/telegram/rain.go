@@ -0,0 +1,185 @@
+package telegram
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/massmux/SatsMobiBot/internal/errors"
+	"github.com/massmux/SatsMobiBot/internal/i18n"
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	"github.com/massmux/SatsMobiBot/internal/str"
+	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const (
+	// group activity is only kept in memory and forgotten after groupActivityRetention
+	groupActivityRetention = time.Hour
+	rainDefaultMinutes     = 10
+	rainDefaultMaxUsers    = 10
+	rainMaxUsers           = 50
+	rainMinAccountAge      = 7 * 24 * time.Hour
+	rainMaxPerUserPerDay   = 3
+)
+
+// groupActivity holds the last message time of every user per group chat
+type groupActivity struct {
+	sync.Mutex
+	chats map[int64]map[int64]time.Time
+}
+
+var recentGroupActivity = &groupActivity{chats: make(map[int64]map[int64]time.Time)}
+
+// record saves the time of a message and forgets activity older than the retention
+func (a *groupActivity) record(chatID, userID int64, t time.Time) {
+	a.Lock()
+	defer a.Unlock()
+	users, ok := a.chats[chatID]
+	if !ok {
+		users = make(map[int64]time.Time)
+		a.chats[chatID] = users
+	}
+	users[userID] = t
+	for id, seen := range users {
+		if t.Sub(seen) > groupActivityRetention {
+			delete(users, id)
+		}
+	}
+}
+
+// since returns the users who wrote in the chat after t
+func (a *groupActivity) since(chatID int64, t time.Time) []int64 {
+	a.Lock()
+	defer a.Unlock()
+	active := make([]int64, 0)
+	for id, seen := range a.chats[chatID] {
+		if seen.After(t) {
+			active = append(active, id)
+		}
+	}
+	return active
+}
+
+// recordGroupActivity remembers that the sender of a group message was active
+func (bot TipBot) recordGroupActivity(m *tb.Message) {
+	if m == nil || m.Private() || m.Sender == nil || m.Sender.IsBot {
+		return
+	}
+	recentGroupActivity.record(m.Chat.ID, m.Sender.ID, time.Now())
+}
+
+// groupActivityInterceptor records group messages that are not handled by any command
+func (bot TipBot) groupActivityInterceptor(ctx intercept.Context) (intercept.Context, error) {
+	bot.recordGroupActivity(ctx.Message())
+	return ctx, nil
+}
+
+// rainDailyCount counts the rains that a user received today
+const rainDailyCount = "rain-received"
+
+// rainHandler is invoked on /rain <amount> [<minutes>] [<max users>] in a group chat
+func (bot *TipBot) rainHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	from := LoadUser(ctx)
+	if from.Wallet == nil {
+		return ctx, errors.Create(errors.UserNoWalletError)
+	}
+	if m.Private() {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "rainHelpMessage"), rainDefaultMinutes, rainDefaultMaxUsers))
+		return ctx, errors.Create(errors.NoPrivateChatError)
+	}
+	amountStr, err := getArgumentFromCommand(m.Text, 1)
+	if err != nil {
+		bot.trySendMessage(m.Chat, fmt.Sprintf(Translate(ctx, "rainHelpMessage"), rainDefaultMinutes, rainDefaultMaxUsers))
+		return ctx, err
+	}
+	amount, err := GetAmount(amountStr)
+	if err != nil || amount < 1 {
+		bot.trySendMessage(m.Chat, fmt.Sprintf(Translate(ctx, "rainHelpMessage"), rainDefaultMinutes, rainDefaultMaxUsers))
+		return ctx, errors.Create(errors.InvalidAmountError)
+	}
+	minutes, maxUsers := rainDefaultMinutes, rainDefaultMaxUsers
+	if arg, err := getArgumentFromCommand(m.Text, 2); err == nil {
+		minutes, err = strconv.Atoi(arg)
+		if err != nil || minutes < 1 || time.Duration(minutes)*time.Minute > groupActivityRetention {
+			bot.trySendMessage(m.Chat, fmt.Sprintf(Translate(ctx, "rainInvalidMinutesMessage"), int(groupActivityRetention.Minutes())))
+			return ctx, errors.Create(errors.InvalidSyntaxError)
+		}
+	}
+	if arg, err := getArgumentFromCommand(m.Text, 3); err == nil {
+		maxUsers, err = strconv.Atoi(arg)
+		if err != nil || maxUsers < 1 || maxUsers > rainMaxUsers {
+			bot.trySendMessage(m.Chat, fmt.Sprintf(Translate(ctx, "rainInvalidMaxUsersMessage"), rainMaxUsers))
+			return ctx, errors.Create(errors.InvalidSyntaxError)
+		}
+	}
+	balance, err := bot.GetUserBalance(from)
+	if err != nil {
+		return ctx, errors.New(errors.GetBalanceError, err)
+	}
+	if balance < amount {
+		bot.trySendMessage(m.Chat, Translate(ctx, "inlineSendBalanceLowMessage"))
+		return ctx, errors.Create(errors.BalanceToLowError)
+	}
+
+	recipients := bot.rainRecipients(m.Chat.ID, from, time.Now().Add(-time.Duration(minutes)*time.Minute), maxUsers, amount)
+	if len(recipients) == 0 {
+		bot.trySendMessage(m.Chat, fmt.Sprintf(Translate(ctx, "rainNoUsersMessage"), minutes))
+		return ctx, nil
+	}
+	share := amount / int64(len(recipients))
+	received := make([]string, 0)
+	for _, to := range recipients {
+		// the user could have reached the cap in another rain meanwhile
+		if !bot.takeDailyCount(rainDailyCount, walletLockKey(to), rainMaxPerUserPerDay) {
+			continue
+		}
+		t := NewTransaction(bot, from, to, share, TransactionType("rain"), TransactionChat(m.Chat))
+		t.Memo = fmt.Sprintf("🌧 Rain from %s to %s.", GetUserStr(from.Telegram), GetUserStr(to.Telegram))
+		success, err := t.Send()
+		if !success || err != nil {
+			log.Warnf("[rain] Transaction from %s to %s failed", GetUserStr(from.Telegram), GetUserStr(to.Telegram))
+			bot.releaseDailyCount(rainDailyCount, walletLockKey(to))
+			break
+		}
+		received = append(received, GetUserStrMd(to.Telegram))
+		bot.trySendMessage(to.Telegram, fmt.Sprintf(i18n.Translate(to.Telegram.LanguageCode, "rainReceivedMessage"), share, GetUserStrMd(from.Telegram), str.MarkdownEscape(m.Chat.Title)))
+	}
+	if len(received) == 0 {
+		bot.trySendMessage(m.Chat, Translate(ctx, "sendErrorMessage"))
+		return ctx, errors.Create(errors.UnknownError)
+	}
+	log.Infof("[🌧 rain] %s rained %d sat on %d users in %s", GetUserStr(from.Telegram), share*int64(len(received)), len(received), m.Chat.Title)
+	bot.trySendMessage(m.Chat, fmt.Sprintf(Translate(ctx, "rainMessage"), GetUserStrMd(from.Telegram), share*int64(len(received)), len(received), share, strings.Join(received, ", ")))
+	return ctx, nil
+}
+
+// rainRecipients returns up to maxUsers random users who wrote after since and are eligible for rain:
+// they have a wallet, their account is old enough and they did not reach the daily cap.
+func (bot *TipBot) rainRecipients(chatID int64, from *lnbits.User, since time.Time, maxUsers int, amount int64) []*lnbits.User {
+	active := recentGroupActivity.since(chatID, since)
+	rand.Shuffle(len(active), func(i, j int) { active[i], active[j] = active[j], active[i] })
+	recipients := make([]*lnbits.User, 0)
+	for _, id := range active {
+		if len(recipients) >= maxUsers || int64(len(recipients)) >= amount {
+			break
+		}
+		if id == from.Telegram.ID || bot.dailyCount(rainDailyCount, strconv.FormatInt(id, 10)) >= rainMaxPerUserPerDay {
+			continue
+		}
+		user, err := GetLnbitsUser(&tb.User{ID: id}, *bot)
+		if err != nil || user.Wallet == nil || user.Telegram == nil || user.Banned {
+			continue
+		}
+		if time.Since(user.CreatedAt) < rainMinAccountAge {
+			continue
+		}
+		recipients = append(recipients, user)
+	}
+	return recipients
+}
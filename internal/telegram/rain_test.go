@@ -0,0 +1,94 @@
+package telegram
+
+import (
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func Test_groupActivity(t *testing.T) {
+	activity := &groupActivity{chats: make(map[int64]map[int64]time.Time)}
+	now := time.Now()
+	activity.record(-1, 1, now.Add(-2*groupActivityRetention))
+	activity.record(-1, 2, now.Add(-20*time.Minute))
+	activity.record(-1, 3, now.Add(-5*time.Minute))
+	activity.record(-2, 4, now)
+	active := activity.since(-1, now.Add(-10*time.Minute))
+	if len(active) != 1 || active[0] != 3 {
+		t.Errorf("since() = %v, want [3]", active)
+	}
+	// activity older than the retention is forgotten
+	active = activity.since(-1, now.Add(-3*groupActivityRetention))
+	sort.Slice(active, func(i, j int) bool { return active[i] < active[j] })
+	if len(active) != 2 || active[0] != 2 || active[1] != 3 {
+		t.Errorf("since() = %v, want [2 3]", active)
+	}
+}
+
+// addRainUser adds a user whose account is old enough for rain and who was active in the chat
+func addRainUser(t *testing.T, test *testBot, chatID, id int64, age time.Duration, balance int64) *lnbits.User {
+	user := test.addUser(t, id, balance)
+	user.CreatedAt = time.Now().Add(-age)
+	if err := test.DB.Users.Model(user).Update("created_at", user.CreatedAt).Error; err != nil {
+		t.Fatal(err)
+	}
+	recentGroupActivity.record(chatID, id, time.Now())
+	return user
+}
+
+func TestTipBot_rainRecipients(t *testing.T) {
+	test := newTestBot(t)
+	chatID := int64(-200)
+	from := addRainUser(t, test, chatID, 2, 30*24*time.Hour, 0)
+	addRainUser(t, test, chatID, 3, 30*24*time.Hour, 0)
+	addRainUser(t, test, chatID, 4, time.Hour, 0)
+	capped := addRainUser(t, test, chatID, 5, 30*24*time.Hour, 0)
+	for i := 0; i < rainMaxPerUserPerDay; i++ {
+		test.takeDailyCount(rainDailyCount, walletLockKey(capped), rainMaxPerUserPerDay)
+	}
+	// users without wallet are skipped
+	recentGroupActivity.record(chatID, 6, time.Now())
+
+	recipients := test.rainRecipients(chatID, from, time.Now().Add(-time.Minute), 10, 1000)
+	if len(recipients) != 1 || recipients[0].Telegram.ID != 3 {
+		t.Fatalf("rainRecipients() returned %d users, want only the old account without cap", len(recipients))
+	}
+	addRainUser(t, test, chatID, 7, 30*24*time.Hour, 0)
+	if got := test.rainRecipients(chatID, from, time.Now().Add(-time.Minute), 1, 1000); len(got) != 1 {
+		t.Errorf("rainRecipients() returned %d users, want max 1", len(got))
+	}
+	if got := test.rainRecipients(chatID, from, time.Now().Add(-time.Minute), 10, 1); len(got) != 1 {
+		t.Errorf("rainRecipients() returned %d users for 1 sat", len(got))
+	}
+}
+
+func TestTipBot_rainHandler(t *testing.T) {
+	test := newTestBot(t)
+	chat := &tb.Chat{ID: -300, Type: tb.ChatGroup, Title: "group"}
+	from := addRainUser(t, test, chat.ID, 2, 30*24*time.Hour, 1000)
+	addRainUser(t, test, chat.ID, 3, 30*24*time.Hour, 0)
+	addRainUser(t, test, chat.ID, 4, 30*24*time.Hour, 0)
+
+	if _, err := test.rainHandler(test.message(from, chat, "/rain 101")); err != nil {
+		t.Fatalf("rainHandler() error = %v", err)
+	}
+	if got := test.balance(2); got != 1000-100 {
+		t.Errorf("sender balance = %d, want 900", got)
+	}
+	for _, id := range []int64{3, 4} {
+		if got := test.balance(id); got != 50 {
+			t.Errorf("balance of %d = %d, want 50", id, got)
+		}
+		if got := test.dailyCount(rainDailyCount, strconv.FormatInt(id, 10)); got != 1 {
+			t.Errorf("daily count of %d = %d, want 1", id, got)
+		}
+	}
+	// rain is never more than the balance
+	if _, err := test.rainHandler(test.message(from, chat, "/rain 5000")); err == nil {
+		t.Errorf("rainHandler() rained more than the balance")
+	}
+}
@@ -0,0 +1,282 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/massmux/SatsMobiBot/internal/errors"
+	"github.com/massmux/SatsMobiBot/internal/i18n"
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	"github.com/massmux/SatsMobiBot/internal/runtime"
+	"github.com/massmux/SatsMobiBot/internal/runtime/mutex"
+	"github.com/massmux/SatsMobiBot/internal/storage"
+	"github.com/massmux/SatsMobiBot/internal/str"
+	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/buntdb"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const (
+	MoneyRequestIndex         = "money-request:*"
+	moneyRequestExpiry        = 24 * time.Hour
+	moneyRequestMaxMemoLength = 200
+)
+
+var (
+	moneyRequestMenu           = &tb.ReplyMarkup{ResizeKeyboard: true}
+	btnPayMoneyRequest         = moneyRequestMenu.Data("✅ Pay", "request_pay")
+	btnDeclineMoneyRequest     = moneyRequestMenu.Data("🚫 Decline", "request_decline")
+	moneyRequestStatusPaid     = "paid"
+	moneyRequestStatusDeclined = "declined"
+	moneyRequestStatusExpired  = "expired"
+)
+
+// MoneyRequest is a request of a user to be paid by another user
+type MoneyRequest struct {
+	*storage.Base
+	From      *lnbits.User `json:"from"` // the user who asks for money
+	To        *lnbits.User `json:"to"`   // the user who is asked to pay
+	Amount    int64        `json:"amount"`
+	Memo      string       `json:"memo"`
+	ExpiresAt time.Time    `json:"expires_at"`
+	Status    string       `json:"status"`
+	Message   *tb.Message  `json:"message"` // the card in the private chat of To
+}
+
+// requestHandler is invoked on /request <amount> <@user> [<memo>]
+func (bot *TipBot) requestHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	user := LoadUser(ctx)
+	if user.Wallet == nil {
+		return ctx, errors.Create(errors.UserNoWalletError)
+	}
+	amountStr, err := getArgumentFromCommand(m.Text, 1)
+	if err != nil {
+		bot.trySendMessage(m.Chat, Translate(ctx, "requestHelpMessage"))
+		return ctx, err
+	}
+	amount, err := GetAmount(amountStr)
+	if err != nil || amount < 1 {
+		bot.trySendMessage(m.Chat, Translate(ctx, "requestHelpMessage"))
+		return ctx, errors.Create(errors.InvalidAmountError)
+	}
+	toUserStr, err := getArgumentFromCommand(m.Text, 2)
+	if err != nil || !strings.HasPrefix(toUserStr, "@") {
+		bot.trySendMessage(m.Chat, Translate(ctx, "requestHelpMessage"))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	to, err := GetUserByTelegramUsername(strings.TrimPrefix(toUserStr, "@"), *bot)
+	if err != nil {
+		bot.trySendMessage(m.Chat, fmt.Sprintf(Translate(ctx, "sendUserHasNoWalletMessage"), str.MarkdownEscape(toUserStr)))
+		return ctx, err
+	}
+	if to.Telegram.ID == user.Telegram.ID {
+		bot.trySendMessage(m.Chat, Translate(ctx, "sendYourselfMessage"))
+		return ctx, errors.Create(errors.SelfPaymentError)
+	}
+	memo := GetMemoFromCommand(m.Text, 3)
+	memo = str.Truncate(memo, moneyRequestMaxMemoLength)
+	request := &MoneyRequest{
+		Base:      storage.New(storage.ID(fmt.Sprintf("money-request:%s", RandStringRunes(10)))),
+		From:      user,
+		To:        to,
+		Amount:    amount,
+		Memo:      memo,
+		ExpiresAt: time.Now().Add(moneyRequestExpiry),
+	}
+	request.Message = bot.trySendMessageEditable(to.Telegram, bot.moneyRequestCardText(request), bot.moneyRequestMenu(request))
+	if request.Message == nil {
+		bot.trySendMessage(m.Chat, Translate(ctx, "requestCouldNotDeliverMessage"))
+		return ctx, fmt.Errorf("could not deliver request to %s", GetUserStr(to.Telegram))
+	}
+	runtime.IgnoreError(request.Set(request, bot.Bunt))
+	bot.startMoneyRequestTimer(request)
+	log.Infof("[request] %s requested %d sat from %s", GetUserStr(user.Telegram), amount, GetUserStr(to.Telegram))
+	bot.trySendMessage(m.Chat, fmt.Sprintf(Translate(ctx, "requestSentMessage"), amount, GetUserStrMd(to.Telegram)))
+	return ctx, nil
+}
+
+func (bot *TipBot) moneyRequestCardText(request *MoneyRequest) string {
+	text := fmt.Sprintf(i18n.Translate(request.To.Telegram.LanguageCode, "requestReceivedMessage"), GetUserStrMd(request.From.Telegram), request.Amount)
+	if len(request.Memo) > 0 {
+		text += fmt.Sprintf("\n✉️ %s", str.MarkdownEscape(request.Memo))
+	}
+	return text
+}
+
+func (bot *TipBot) moneyRequestMenu(request *MoneyRequest) *tb.ReplyMarkup {
+	payButton := moneyRequestMenu.Data(btnPayMoneyRequest.Text, btnPayMoneyRequest.Unique, request.ID)
+	declineButton := moneyRequestMenu.Data(btnDeclineMoneyRequest.Text, btnDeclineMoneyRequest.Unique, request.ID)
+	moneyRequestMenu.Inline(moneyRequestMenu.Row(payButton, declineButton))
+	return moneyRequestMenu
+}
+
+func (bot *TipBot) loadMoneyRequest(id string) (*MoneyRequest, error) {
+	tx := &MoneyRequest{Base: storage.New(storage.ID(id))}
+	sn, err := tx.Get(tx, bot.Bunt)
+	if err != nil {
+		return nil, err
+	}
+	return sn.(*MoneyRequest), nil
+}
+
+// loadMoneyRequestFromCallback loads the request of a button and checks that it can still be answered
+func (bot *TipBot) loadMoneyRequestFromCallback(ctx intercept.Context) (*MoneyRequest, error) {
+	c := ctx.Callback()
+	request, err := bot.loadMoneyRequest(c.Data)
+	if err != nil {
+		return nil, err
+	}
+	if request.To.Telegram.ID != c.Sender.ID {
+		return nil, errors.Create(errors.UnknownError)
+	}
+	if !request.Active {
+		bot.tryEditMessage(c.Message, bot.moneyRequestCardText(request), &tb.ReplyMarkup{})
+		return nil, errors.Create(errors.NotActiveError)
+	}
+	if time.Now().After(request.ExpiresAt) {
+		bot.expireMoneyRequest(request)
+		return nil, errors.Create(errors.NotActiveError)
+	}
+	return request, nil
+}
+
+// payMoneyRequestHandler is invoked when the requested user presses the pay button
+func (bot *TipBot) payMoneyRequestHandler(ctx intercept.Context) (intercept.Context, error) {
+	c := ctx.Callback()
+	mutex.LockWithContext(ctx, c.Data)
+	defer mutex.UnlockWithContext(ctx, c.Data)
+	request, err := bot.loadMoneyRequestFromCallback(ctx)
+	if err != nil {
+		return ctx, err
+	}
+	from := LoadUser(ctx)
+	to, err := GetLnbitsUser(request.From.Telegram, *bot)
+	if err != nil || to.Wallet == nil {
+		ctx.Context = context.WithValue(ctx, "callback_response", Translate(ctx, "errorTryLaterMessage"))
+		return ctx, errors.Create(errors.UserNoWalletError)
+	}
+	t := NewTransaction(bot, from, to, request.Amount, TransactionType("request"))
+	t.Memo = fmt.Sprintf("💸 Request of %s paid by %s.", GetUserStr(to.Telegram), GetUserStr(from.Telegram))
+	success, err := t.Send()
+	if !success || err != nil {
+		if err == nil {
+			err = fmt.Errorf("transaction failed")
+		}
+		log.Warnf("[request] %s could not pay request %s: %s", GetUserStr(from.Telegram), request.ID, err.Error())
+		ctx.Context = context.WithValue(ctx, "callback_response", Translate(ctx, "sendErrorMessage"))
+		bot.trySendMessage(from.Telegram, fmt.Sprintf(Translate(ctx, "requestPayFailedMessage"), str.MarkdownEscape(err.Error())))
+		return ctx, err
+	}
+	request.Status = moneyRequestStatusPaid
+	bot.finishMoneyRequest(request)
+	log.Infof("[request] %s paid %d sat to %s", GetUserStr(from.Telegram), request.Amount, GetUserStr(to.Telegram))
+	bot.tryEditMessage(request.Message, fmt.Sprintf(i18n.Translate(from.Telegram.LanguageCode, "requestPaidMessage"), request.Amount, GetUserStrMd(to.Telegram)), &tb.ReplyMarkup{})
+	bot.trySendMessage(to.Telegram, fmt.Sprintf(i18n.Translate(to.Telegram.LanguageCode, "requestWasPaidMessage"), GetUserStrMd(from.Telegram), request.Amount))
+	return ctx, nil
+}
+
+// declineMoneyRequestHandler is invoked when the requested user presses the decline button
+func (bot *TipBot) declineMoneyRequestHandler(ctx intercept.Context) (intercept.Context, error) {
+	c := ctx.Callback()
+	mutex.LockWithContext(ctx, c.Data)
+	defer mutex.UnlockWithContext(ctx, c.Data)
+	request, err := bot.loadMoneyRequestFromCallback(ctx)
+	if err != nil {
+		return ctx, err
+	}
+	request.Status = moneyRequestStatusDeclined
+	bot.finishMoneyRequest(request)
+	log.Infof("[request] %s declined request of %s", GetUserStr(request.To.Telegram), GetUserStr(request.From.Telegram))
+	bot.tryEditMessage(request.Message, fmt.Sprintf(i18n.Translate(request.To.Telegram.LanguageCode, "requestDeclinedMessage"), request.Amount, GetUserStrMd(request.From.Telegram)), &tb.ReplyMarkup{})
+	bot.trySendMessage(request.From.Telegram, fmt.Sprintf(i18n.Translate(request.From.Telegram.LanguageCode, "requestWasDeclinedMessage"), GetUserStrMd(request.To.Telegram), request.Amount))
+	return ctx, nil
+}
+
+// expireMoneyRequest notifies both users that a request has expired
+func (bot *TipBot) expireMoneyRequest(request *MoneyRequest) {
+	request.Status = moneyRequestStatusExpired
+	bot.finishMoneyRequest(request)
+	bot.tryEditMessage(request.Message, fmt.Sprintf(i18n.Translate(request.To.Telegram.LanguageCode, "requestExpiredMessage"), request.Amount, GetUserStrMd(request.From.Telegram)), &tb.ReplyMarkup{})
+	bot.trySendMessage(request.From.Telegram, fmt.Sprintf(i18n.Translate(request.From.Telegram.LanguageCode, "requestWasExpiredMessage"), request.Amount, GetUserStrMd(request.To.Telegram)))
+}
+
+func (bot *TipBot) finishMoneyRequest(request *MoneyRequest) {
+	runtime.RemoveTicker(request.ID)
+	runtime.IgnoreError(request.Inactivate(request, bot.Bunt))
+}
+
+// startMoneyRequestTimer expires the request after moneyRequestExpiry
+func (bot *TipBot) startMoneyRequestTimer(request *MoneyRequest) {
+	t := runtime.NewResettableFunction(request.ID,
+		runtime.WithTimer(time.NewTimer(time.Until(request.ExpiresAt))))
+	t.Do(func() {
+		mutex.Lock(request.ID)
+		defer mutex.Unlock(request.ID)
+		request, err := bot.loadMoneyRequest(request.ID)
+		if err != nil || !request.Active {
+			return
+		}
+		bot.expireMoneyRequest(request)
+	})
+}
+
+// restartMoneyRequestTimers restarts the expiry timers of all open requests
+func (bot *TipBot) restartMoneyRequestTimers() {
+	for _, request := range bot.getMoneyRequests(0) {
+		bot.startMoneyRequestTimer(request)
+	}
+}
+
+// getMoneyRequests returns all open requests. If userID is not 0, only requests from or to this user are returned.
+func (bot *TipBot) getMoneyRequests(userID int64) []*MoneyRequest {
+	requests := make([]*MoneyRequest, 0)
+	bot.Bunt.View(func(tx *buntdb.Tx) error {
+		return tx.Ascend("money-request", func(key, value string) bool {
+			request := &MoneyRequest{}
+			if err := json.Unmarshal([]byte(value), request); err != nil || !request.Active {
+				return true
+			}
+			if userID == 0 || request.From.Telegram.ID == userID || request.To.Telegram.ID == userID {
+				requests = append(requests, request)
+			}
+			return true // continue iteration
+		})
+	})
+	return requests
+}
+
+// requestsHandler is invoked on /requests and lists what the user owes and is owed
+func (bot *TipBot) requestsHandler(ctx intercept.Context) (intercept.Context, error) {
+	user := LoadUser(ctx)
+	var owe, owed string
+	for _, request := range bot.getMoneyRequests(user.Telegram.ID) {
+		memo := ""
+		if len(request.Memo) > 0 {
+			memo = fmt.Sprintf(" – %s", str.MarkdownEscape(request.Memo))
+		}
+		expires := request.ExpiresAt.Format("2006-01-02 15:04")
+		if request.To.Telegram.ID == user.Telegram.ID {
+			owe += fmt.Sprintf("\n• *%d sat* to %s (expires %s)%s", request.Amount, GetUserStrMd(request.From.Telegram), expires, memo)
+		} else {
+			owed += fmt.Sprintf("\n• *%d sat* from %s (expires %s)%s", request.Amount, GetUserStrMd(request.To.Telegram), expires, memo)
+		}
+	}
+	if len(owe) == 0 && len(owed) == 0 {
+		bot.trySendMessage(user.Telegram, Translate(ctx, "requestsEmptyMessage"))
+		return ctx, nil
+	}
+	text := ""
+	if len(owe) > 0 {
+		text += Translate(ctx, "requestsYouOweMessage") + owe + "\n\n"
+	}
+	if len(owed) > 0 {
+		text += Translate(ctx, "requestsYouAreOwedMessage") + owed
+	}
+	bot.trySendMessage(user.Telegram, strings.TrimSpace(text))
+	return ctx, nil
+}
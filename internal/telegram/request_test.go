@@ -0,0 +1,90 @@
+package telegram
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func TestTipBot_payMoneyRequestHandler(t *testing.T) {
+	test := newTestBot(t)
+	alice := test.addUser(t, 2, 0)
+	bob := test.addUser(t, 3, 1000)
+	mallory := test.addUser(t, 4, 1000)
+
+	ctx := test.message(alice, &tb.Chat{ID: alice.Telegram.ID, Type: tb.ChatPrivate}, "/request 300 @user3 pizza")
+	if _, err := test.requestHandler(ctx); err != nil {
+		t.Fatalf("requestHandler() error = %v", err)
+	}
+	requests := test.getMoneyRequests(bob.Telegram.ID)
+	if len(requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(requests))
+	}
+	request := requests[0]
+	if request.Amount != 300 || request.Memo != "pizza" {
+		t.Fatalf("request = %d sat %q", request.Amount, request.Memo)
+	}
+
+	// only the requested user can pay
+	if _, err := test.payMoneyRequestHandler(test.callback(mallory, &tb.Chat{ID: mallory.Telegram.ID}, request.ID)); err == nil {
+		t.Fatalf("payMoneyRequestHandler() let another user pay")
+	}
+	if _, err := test.payMoneyRequestHandler(test.callback(bob, &tb.Chat{ID: bob.Telegram.ID}, request.ID)); err != nil {
+		t.Fatalf("payMoneyRequestHandler() error = %v", err)
+	}
+	// a request is only paid once
+	if _, err := test.payMoneyRequestHandler(test.callback(bob, &tb.Chat{ID: bob.Telegram.ID}, request.ID)); err == nil {
+		t.Fatalf("payMoneyRequestHandler() paid a request twice")
+	}
+	if got := test.balance(alice.Telegram.ID); got != 300 {
+		t.Errorf("requester balance = %d, want 300", got)
+	}
+	if got := test.balance(bob.Telegram.ID); got != 700 {
+		t.Errorf("payer balance = %d, want 700", got)
+	}
+	if got := test.balance(mallory.Telegram.ID); got != 1000 {
+		t.Errorf("other balance = %d, want 1000", got)
+	}
+}
+
+func TestTipBot_declineMoneyRequestHandler(t *testing.T) {
+	test := newTestBot(t)
+	alice := test.addUser(t, 2, 0)
+	bob := test.addUser(t, 3, 1000)
+
+	ctx := test.message(alice, &tb.Chat{ID: alice.Telegram.ID, Type: tb.ChatPrivate}, "/request 300 @user3")
+	if _, err := test.requestHandler(ctx); err != nil {
+		t.Fatalf("requestHandler() error = %v", err)
+	}
+	request := test.getMoneyRequests(bob.Telegram.ID)[0]
+	if _, err := test.declineMoneyRequestHandler(test.callback(bob, &tb.Chat{ID: bob.Telegram.ID}, request.ID)); err != nil {
+		t.Fatalf("declineMoneyRequestHandler() error = %v", err)
+	}
+	if _, err := test.payMoneyRequestHandler(test.callback(bob, &tb.Chat{ID: bob.Telegram.ID}, request.ID)); err == nil {
+		t.Fatalf("payMoneyRequestHandler() paid a declined request")
+	}
+	if got := test.balance(bob.Telegram.ID); got != 1000 {
+		t.Errorf("payer balance = %d, want 1000", got)
+	}
+}
+
+func TestTipBot_requestHandler_memoLength(t *testing.T) {
+	test := newTestBot(t)
+	alice := test.addUser(t, 2, 0)
+	bob := test.addUser(t, 3, 0)
+	// memos are cut by characters, a multi-byte character is never split
+	memo := "a" + strings.Repeat("☕", moneyRequestMaxMemoLength)
+	ctx := test.message(alice, &tb.Chat{ID: alice.Telegram.ID, Type: tb.ChatPrivate}, "/request 300 @user3 "+memo)
+	if _, err := test.requestHandler(ctx); err != nil {
+		t.Fatalf("requestHandler() error = %v", err)
+	}
+	requests := test.getMoneyRequests(bob.Telegram.ID)
+	if len(requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(requests))
+	}
+	if got := requests[0].Memo; !utf8.ValidString(got) || !strings.HasPrefix(memo, got) || utf8.RuneCountInString(got) > moneyRequestMaxMemoLength {
+		t.Errorf("memo = %q, want a valid prefix of at most %d characters", got, moneyRequestMaxMemoLength)
+	}
+}
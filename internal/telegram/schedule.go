@@ -0,0 +1,441 @@
+package telegram
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fiatjaf/go-lnurl"
+	"github.com/massmux/SatsMobiBot/internal/errors"
+	"github.com/massmux/SatsMobiBot/internal/i18n"
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	"github.com/massmux/SatsMobiBot/internal/runtime"
+	"github.com/massmux/SatsMobiBot/internal/runtime/mutex"
+	"github.com/massmux/SatsMobiBot/internal/storage"
+	"github.com/massmux/SatsMobiBot/internal/str"
+	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/buntdb"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const (
+	PaymentScheduleIndex         = "schedule:*"
+	paymentScheduleTick          = time.Minute
+	paymentScheduleMinInterval   = time.Hour
+	paymentScheduleMaxFailures   = 3
+	paymentScheduleMaxPerUser    = 10
+	paymentScheduleTypeUser      = "user"
+	paymentScheduleTypeLnurl     = "lnurl"
+	paymentScheduleMaxMemoLength = 200
+)
+
+// PaymentSchedule is a recurring payment of a user. It is persisted in bunt and
+// executed by the schedule worker, so it survives restarts.
+type PaymentSchedule struct {
+	*storage.Base
+	OwnerID      int64     `json:"owner_id"`
+	Target       string    `json:"target"`    // telegram username without @, lightning address or LNURL
+	TargetID     int64     `json:"target_id"` // telegram id of the recipient, the username can change
+	TargetType   string    `json:"target_type"`
+	Amount       int64     `json:"amount"`
+	Memo         string    `json:"memo"`
+	Spec         string    `json:"spec"` // cron expression or interval
+	NextRun      time.Time `json:"next_run"`
+	LastRun      time.Time `json:"last_run"`
+	Runs         int       `json:"runs"`
+	Failures     int       `json:"failures"` // consecutive failures
+	Paused       bool      `json:"paused"`
+	LastError    string    `json:"last_error"`
+	LanguageCode string    `json:"languagecode"`
+}
+
+// shortID is the id the user enters to cancel or resume a schedule
+func (schedule *PaymentSchedule) shortID() string {
+	return strings.TrimPrefix(schedule.ID, "schedule:")
+}
+
+// targetStr returns the recipient for messages
+func (schedule *PaymentSchedule) targetStr() string {
+	if schedule.TargetType == paymentScheduleTypeUser {
+		return "@" + schedule.Target
+	}
+	return schedule.Target
+}
+
+// nextScheduleRun returns the first run of spec after t. spec is either a cron expression
+// (5 fields or @hourly, @daily, ...) or an interval like daily, weekly, monthly, 12h, 3d or 2w.
+func nextScheduleRun(spec string, t time.Time) (time.Time, error) {
+	if cron, err := parseCron(spec); err == nil {
+		// at most one run per hour
+		if cron.minute&(cron.minute-1) != 0 {
+			return time.Time{}, fmt.Errorf("schedule runs more than once per hour")
+		}
+		next := cron.Next(t)
+		if next.IsZero() {
+			return next, fmt.Errorf("cron expression never matches")
+		}
+		return next, nil
+	} else if strings.Contains(spec, " ") || strings.HasPrefix(spec, "@") {
+		return time.Time{}, err
+	}
+	spec = strings.ToLower(spec)
+	switch spec {
+	case "hourly":
+		return t.Add(time.Hour), nil
+	case "daily":
+		return t.AddDate(0, 0, 1), nil
+	case "weekly":
+		return t.AddDate(0, 0, 7), nil
+	case "monthly":
+		return t.AddDate(0, 1, 0), nil
+	case "yearly":
+		return t.AddDate(1, 0, 0), nil
+	}
+	if len(spec) < 2 {
+		return time.Time{}, fmt.Errorf("invalid interval %s", spec)
+	}
+	n, err := strconv.Atoi(spec[:len(spec)-1])
+	if err != nil || n < 1 {
+		return time.Time{}, fmt.Errorf("invalid interval %s", spec)
+	}
+	var d time.Duration
+	switch spec[len(spec)-1] {
+	case 'h':
+		d = time.Duration(n) * time.Hour
+	case 'd':
+		d = time.Duration(n) * 24 * time.Hour
+	case 'w':
+		d = time.Duration(n) * 7 * 24 * time.Hour
+	default:
+		return time.Time{}, fmt.Errorf("invalid interval %s", spec)
+	}
+	if d < paymentScheduleMinInterval {
+		return time.Time{}, fmt.Errorf("interval too short")
+	}
+	return t.Add(d), nil
+}
+
+// parseScheduleCommand splits "/schedule <amount> <target> <spec> [memo]". A cron expression
+// with spaces has to be quoted: /schedule 1000 @bob "0 9 1 * *" rent
+func parseScheduleCommand(text string) (amountStr, target, spec, memo string, err error) {
+	fields := strings.Fields(text)
+	if len(fields) < 4 {
+		return "", "", "", "", errors.Create(errors.InvalidSyntaxError)
+	}
+	amountStr, target = fields[1], fields[2]
+	rest := fields[3:]
+	if strings.HasPrefix(rest[0], "\"") {
+		for i, f := range rest {
+			if (i > 0 || len(f) > 1) && strings.HasSuffix(f, "\"") {
+				spec = strings.Trim(strings.Join(rest[:i+1], " "), "\"")
+				rest = rest[i+1:]
+				break
+			}
+		}
+		if len(spec) == 0 {
+			return "", "", "", "", errors.Create(errors.InvalidSyntaxError)
+		}
+	} else {
+		spec = rest[0]
+		rest = rest[1:]
+	}
+	memo = strings.Join(rest, " ")
+	return amountStr, target, spec, memo, nil
+}
+
+// scheduleHandler is invoked on /schedule, /schedule list and /schedule cancel|resume <id>
+func (bot *TipBot) scheduleHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	user := LoadUser(ctx)
+	if user.Wallet == nil {
+		return ctx, errors.Create(errors.UserNoWalletError)
+	}
+	if !m.Private() {
+		bot.trySendMessage(m.Chat, fmt.Sprintf(Translate(ctx, "scheduleHelpMessage"), paymentScheduleMaxFailures))
+		return ctx, errors.Create(errors.NoPrivateChatError)
+	}
+	cmd, err := getArgumentFromCommand(m.Text, 1)
+	if err != nil {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "scheduleHelpMessage"), paymentScheduleMaxFailures))
+		return ctx, err
+	}
+	switch strings.ToLower(cmd) {
+	case "list":
+		return bot.scheduleListHandler(ctx)
+	case "cancel":
+		return bot.scheduleCancelHandler(ctx, false)
+	case "resume":
+		return bot.scheduleCancelHandler(ctx, true)
+	}
+	return bot.scheduleAddHandler(ctx)
+}
+
+// scheduleAddHandler creates a new schedule
+func (bot *TipBot) scheduleAddHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	user := LoadUser(ctx)
+	amountStr, target, spec, memo, err := parseScheduleCommand(m.Text)
+	if err != nil {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "scheduleHelpMessage"), paymentScheduleMaxFailures))
+		return ctx, err
+	}
+	amount, err := GetAmount(amountStr)
+	if err != nil || amount < 1 {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "scheduleHelpMessage"), paymentScheduleMaxFailures))
+		return ctx, errors.Create(errors.InvalidAmountError)
+	}
+	memo = str.Truncate(memo, paymentScheduleMaxMemoLength)
+	nextRun, err := nextScheduleRun(spec, time.Now().UTC())
+	if err != nil {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "scheduleInvalidMessage"), str.MarkdownEscape(err.Error())))
+		return ctx, errors.New(errors.InvalidSyntaxError, err)
+	}
+	schedules := bot.getPaymentSchedules(user.Telegram.ID)
+	if len(schedules) >= paymentScheduleMaxPerUser {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "scheduleMaxReachedMessage"), paymentScheduleMaxPerUser))
+		return ctx, errors.Create(errors.MaxReachedError)
+	}
+
+	schedule := &PaymentSchedule{
+		Base:         storage.New(storage.ID(fmt.Sprintf("schedule:%s", RandStringRunes(8)))),
+		OwnerID:      user.Telegram.ID,
+		Amount:       amount,
+		Memo:         memo,
+		Spec:         spec,
+		NextRun:      nextRun,
+		LanguageCode: user.Telegram.LanguageCode,
+	}
+	// check the recipient once when the schedule is created
+	if strings.HasPrefix(target, "@") {
+		toUser, err := GetUserByTelegramUsername(strings.TrimPrefix(target, "@"), *bot)
+		if err != nil {
+			bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "sendUserHasNoWalletMessage"), str.MarkdownEscape(target)))
+			return ctx, err
+		}
+		if toUser.Telegram.ID == user.Telegram.ID {
+			bot.trySendMessage(m.Sender, Translate(ctx, "sendYourselfMessage"))
+			return ctx, errors.Create(errors.SelfPaymentError)
+		}
+		schedule.Target = toUser.Telegram.Username
+		schedule.TargetID = toUser.Telegram.ID
+		schedule.TargetType = paymentScheduleTypeUser
+	} else {
+		_, params, err := bot.HandleLNURL(target)
+		if err != nil {
+			bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "scheduleInvalidMessage"), str.MarkdownEscape(err.Error())))
+			return ctx, err
+		}
+		if _, ok := params.(lnurl.LNURLPayParams); !ok {
+			bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "scheduleInvalidMessage"), "not an LNURL-pay"))
+			return ctx, errors.Create(errors.InvalidSyntaxError)
+		}
+		schedule.Target = target
+		schedule.TargetType = paymentScheduleTypeLnurl
+	}
+	runtime.IgnoreError(schedule.Set(schedule, bot.Bunt))
+	log.Infof("[schedule] %s scheduled %d sat to %s (%s)", GetUserStr(user.Telegram), amount, schedule.targetStr(), spec)
+	bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "scheduleCreatedMessage"),
+		amount, str.MarkdownEscape(schedule.targetStr()), str.MarkdownEscape(spec), nextRun.Format(time.RFC1123), schedule.shortID()))
+	return ctx, nil
+}
+
+// scheduleListHandler lists all schedules of the user
+func (bot *TipBot) scheduleListHandler(ctx intercept.Context) (intercept.Context, error) {
+	user := LoadUser(ctx)
+	schedules := bot.getPaymentSchedules(user.Telegram.ID)
+	if len(schedules) == 0 {
+		bot.trySendMessage(user.Telegram, Translate(ctx, "scheduleListEmptyMessage"))
+		return ctx, nil
+	}
+	text := Translate(ctx, "scheduleListMessage")
+	for _, schedule := range schedules {
+		status := fmt.Sprintf("next: %s", schedule.NextRun.Format(time.RFC1123))
+		if schedule.Paused {
+			status = fmt.Sprintf("⏸ paused: %s", str.MarkdownEscape(schedule.LastError))
+		}
+		text += fmt.Sprintf("\n\n`%s` – *%d sat* to %s\n🔁 `%s` (%s)", schedule.shortID(), schedule.Amount,
+			str.MarkdownEscape(schedule.targetStr()), schedule.Spec, status)
+		if len(schedule.Memo) > 0 {
+			text += fmt.Sprintf("\n✉️ %s", str.MarkdownEscape(schedule.Memo))
+		}
+	}
+	bot.trySendMessage(user.Telegram, text)
+	return ctx, nil
+}
+
+// scheduleCancelHandler cancels a schedule, or resumes a paused one if resume is set
+func (bot *TipBot) scheduleCancelHandler(ctx intercept.Context, resume bool) (intercept.Context, error) {
+	m := ctx.Message()
+	user := LoadUser(ctx)
+	id, err := getArgumentFromCommand(m.Text, 2)
+	if err != nil {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "scheduleHelpMessage"), paymentScheduleMaxFailures))
+		return ctx, err
+	}
+	// lock before loading, the worker could update the schedule meanwhile
+	mutex.Lock("schedule:" + id)
+	defer mutex.Unlock("schedule:" + id)
+	schedule, err := bot.loadPaymentSchedule("schedule:" + id)
+	if err != nil || schedule.OwnerID != user.Telegram.ID || !schedule.Active {
+		bot.trySendMessage(m.Sender, Translate(ctx, "scheduleNotFoundMessage"))
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	if resume {
+		next, err := nextScheduleRun(schedule.Spec, time.Now().UTC())
+		if err != nil {
+			// the schedule stays paused
+			log.Errorf("[schedule] could not resume schedule %s: %s", schedule.ID, err.Error())
+			bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "scheduleResumeFailedMessage"), str.MarkdownEscape(err.Error())))
+			return ctx, err
+		}
+		schedule.Paused = false
+		schedule.Failures = 0
+		schedule.NextRun = next
+		runtime.IgnoreError(schedule.Set(schedule, bot.Bunt))
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "scheduleResumedMessage"), schedule.shortID(), schedule.NextRun.Format(time.RFC1123)))
+		return ctx, nil
+	}
+	schedule.Canceled = true
+	runtime.IgnoreError(schedule.Delete(schedule, bot.Bunt))
+	log.Infof("[schedule] %s canceled schedule %s", GetUserStr(user.Telegram), schedule.ID)
+	bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "scheduleCanceledMessage"), schedule.shortID()))
+	return ctx, nil
+}
+
+func (bot *TipBot) loadPaymentSchedule(id string) (*PaymentSchedule, error) {
+	tx := &PaymentSchedule{Base: storage.New(storage.ID(id))}
+	sn, err := tx.Get(tx, bot.Bunt)
+	if err != nil {
+		return nil, err
+	}
+	return sn.(*PaymentSchedule), nil
+}
+
+// getPaymentSchedules returns all persisted schedules. If ownerID is not 0, only the
+// schedules of this user are returned.
+func (bot *TipBot) getPaymentSchedules(ownerID int64) []*PaymentSchedule {
+	schedules := make([]*PaymentSchedule, 0)
+	bot.Bunt.View(func(tx *buntdb.Tx) error {
+		return tx.Ascend("schedule", func(key, value string) bool {
+			schedule := &PaymentSchedule{}
+			if err := json.Unmarshal([]byte(value), schedule); err != nil || !schedule.Active {
+				return true
+			}
+			if ownerID == 0 || schedule.OwnerID == ownerID {
+				schedules = append(schedules, schedule)
+			}
+			return true // continue iteration
+		})
+	})
+	return schedules
+}
+
+// startPaymentScheduleWorker runs all due schedules every minute
+func (bot *TipBot) startPaymentScheduleWorker() {
+	ticker := time.NewTicker(paymentScheduleTick)
+	for {
+		now := time.Now().UTC()
+		for _, schedule := range bot.getPaymentSchedules(0) {
+			if !schedule.Paused && !schedule.NextRun.After(now) {
+				bot.runPaymentSchedule(schedule.ID)
+			}
+		}
+		<-ticker.C
+	}
+}
+
+// runPaymentSchedule executes a due schedule once. The next run is persisted before the
+// payment, so a crash during the payment never pays twice.
+func (bot *TipBot) runPaymentSchedule(id string) {
+	mutex.Lock(id)
+	defer mutex.Unlock(id)
+	schedule, err := bot.loadPaymentSchedule(id)
+	if err != nil || !schedule.Active || schedule.Paused {
+		return
+	}
+	now := time.Now().UTC()
+	// runs that were missed while the bot was offline are skipped
+	next := schedule.NextRun
+	for !next.After(now) {
+		next, err = nextScheduleRun(schedule.Spec, next)
+		if err != nil {
+			log.Errorf("[schedule] %s: %s", schedule.ID, err.Error())
+			schedule.Paused = true
+			schedule.LastError = err.Error()
+			runtime.IgnoreError(schedule.Set(schedule, bot.Bunt))
+			return
+		}
+	}
+	schedule.NextRun = next
+	schedule.LastRun = now
+	runtime.IgnoreError(schedule.Set(schedule, bot.Bunt))
+
+	owner, err := GetLnbitsUser(&tb.User{ID: schedule.OwnerID}, *bot)
+	if err != nil || owner.Wallet == nil {
+		log.Errorf("[schedule] could not load owner of %s", schedule.ID)
+		return
+	}
+	err = bot.executePaymentSchedule(owner, schedule)
+	if err != nil {
+		schedule.Failures++
+		schedule.LastError = err.Error()
+		log.Warnf("[schedule] %s of %s failed (%d): %s", schedule.ID, GetUserStr(owner.Telegram), schedule.Failures, err.Error())
+		if schedule.Failures >= paymentScheduleMaxFailures {
+			schedule.Paused = true
+			bot.trySendMessage(owner.Telegram, fmt.Sprintf(i18n.Translate(schedule.LanguageCode, "schedulePausedMessage"),
+				schedule.Amount, str.MarkdownEscape(schedule.targetStr()), schedule.Failures, str.MarkdownEscape(err.Error()), schedule.shortID()))
+		} else {
+			bot.trySendMessage(owner.Telegram, fmt.Sprintf(i18n.Translate(schedule.LanguageCode, "scheduleFailedMessage"),
+				schedule.Amount, str.MarkdownEscape(schedule.targetStr()), str.MarkdownEscape(err.Error())))
+		}
+		runtime.IgnoreError(schedule.Set(schedule, bot.Bunt))
+		return
+	}
+	schedule.Failures = 0
+	schedule.LastError = ""
+	schedule.Runs++
+	runtime.IgnoreError(schedule.Set(schedule, bot.Bunt))
+	log.Infof("[schedule] %s paid %d sat to %s", GetUserStr(owner.Telegram), schedule.Amount, schedule.targetStr())
+	bot.trySendMessage(owner.Telegram, fmt.Sprintf(i18n.Translate(schedule.LanguageCode, "scheduleSentMessage"),
+		schedule.Amount, str.MarkdownEscape(schedule.targetStr()), schedule.NextRun.Format(time.RFC1123)))
+}
+
+// executePaymentSchedule pays the schedule through the internal send or the LNURL-pay path
+func (bot *TipBot) executePaymentSchedule(owner *lnbits.User, schedule *PaymentSchedule) error {
+	if schedule.TargetType == paymentScheduleTypeUser {
+		to, err := GetLnbitsUser(&tb.User{ID: schedule.TargetID}, *bot)
+		if err != nil || to.Wallet == nil {
+			return fmt.Errorf("recipient @%s has no wallet", schedule.Target)
+		}
+		t := NewTransaction(bot, owner, to, schedule.Amount, TransactionType("schedule"))
+		t.Memo = fmt.Sprintf("🔁 Scheduled send from %s to %s.", GetUserStr(owner.Telegram), GetUserStr(to.Telegram))
+		success, err := t.Send()
+		if !success || err != nil {
+			if err == nil {
+				err = fmt.Errorf("transaction failed")
+			}
+			return err
+		}
+		bot.trySendMessage(to.Telegram, fmt.Sprintf(i18n.Translate(to.Telegram.LanguageCode, "sendReceivedMessage"), GetUserStrMd(owner.Telegram), schedule.Amount))
+		if len(schedule.Memo) > 0 {
+			bot.trySendMessage(to.Telegram, fmt.Sprintf("✉️ %s", str.MarkdownEscape(schedule.Memo)))
+		}
+		return nil
+	}
+	balance, err := bot.GetUserBalance(owner)
+	if err != nil {
+		return err
+	}
+	if balance < schedule.Amount {
+		return fmt.Errorf("balance too low")
+	}
+	pr, err := bot.fetchLnurlPayInvoice(schedule.Target, schedule.Amount, schedule.Memo)
+	if err != nil {
+		return err
+	}
+	_, err = owner.Wallet.Pay(lnbits.PaymentParams{Out: true, Bolt11: pr}, bot.Client)
+	return err
+}
@@ -0,0 +1,86 @@
+package telegram
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_nextScheduleRun(t *testing.T) {
+	// a wednesday
+	now := time.Date(2022, 6, 1, 10, 30, 0, 0, time.UTC)
+	tests := []struct {
+		spec    string
+		want    time.Time
+		wantErr bool
+	}{
+		{spec: "0 9 1 * *", want: time.Date(2022, 7, 1, 9, 0, 0, 0, time.UTC)},
+		{spec: "30 9 * * 1-5", want: time.Date(2022, 6, 2, 9, 30, 0, 0, time.UTC)},
+		{spec: "0 0 * * 0", want: time.Date(2022, 6, 5, 0, 0, 0, 0, time.UTC)},
+		{spec: "0 0 * * 7", want: time.Date(2022, 6, 5, 0, 0, 0, 0, time.UTC)},
+		{spec: "0 12 13 * 5", want: time.Date(2022, 6, 3, 12, 0, 0, 0, time.UTC)},
+		{spec: "15 8,20 * * *", want: time.Date(2022, 6, 1, 20, 15, 0, 0, time.UTC)},
+		{spec: "0 8-18/5 * * *", want: time.Date(2022, 6, 1, 13, 0, 0, 0, time.UTC)},
+		{spec: "@hourly", want: time.Date(2022, 6, 1, 11, 0, 0, 0, time.UTC)},
+		{spec: "@daily", want: time.Date(2022, 6, 2, 0, 0, 0, 0, time.UTC)},
+		{spec: "@monthly", want: time.Date(2022, 7, 1, 0, 0, 0, 0, time.UTC)},
+		{spec: "*/30 * * * *", wantErr: true},
+		{spec: "* 9 * * *", wantErr: true},
+		{spec: "0 0 31 2 *", wantErr: true},
+		{spec: "60 * * * *", wantErr: true},
+		{spec: "0 24 * * *", wantErr: true},
+		{spec: "0 9 * *", wantErr: true},
+		{spec: "0 9 5-1 * *", wantErr: true},
+		{spec: "@sometimes", wantErr: true},
+		{spec: "hourly", want: now.Add(time.Hour)},
+		{spec: "daily", want: time.Date(2022, 6, 2, 10, 30, 0, 0, time.UTC)},
+		{spec: "Weekly", want: time.Date(2022, 6, 8, 10, 30, 0, 0, time.UTC)},
+		{spec: "monthly", want: time.Date(2022, 7, 1, 10, 30, 0, 0, time.UTC)},
+		{spec: "yearly", want: time.Date(2023, 6, 1, 10, 30, 0, 0, time.UTC)},
+		{spec: "12h", want: time.Date(2022, 6, 1, 22, 30, 0, 0, time.UTC)},
+		{spec: "3d", want: time.Date(2022, 6, 4, 10, 30, 0, 0, time.UTC)},
+		{spec: "2w", want: time.Date(2022, 6, 15, 10, 30, 0, 0, time.UTC)},
+		{spec: "30m", wantErr: true},
+		{spec: "0d", wantErr: true},
+		{spec: "d", wantErr: true},
+		{spec: "often", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := nextScheduleRun(tt.spec, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("nextScheduleRun() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("nextScheduleRun() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseScheduleCommand(t *testing.T) {
+	tests := []struct {
+		text    string
+		target  string
+		spec    string
+		memo    string
+		wantErr bool
+	}{
+		{text: "/schedule 1000 @bob monthly", target: "@bob", spec: "monthly"},
+		{text: "/schedule 1000 @bob weekly coffee money", target: "@bob", spec: "weekly", memo: "coffee money"},
+		{text: `/schedule 1000 @bob "0 9 1 * *" rent`, target: "@bob", spec: "0 9 1 * *", memo: "rent"},
+		{text: `/schedule 1000 bob@ln.tips "@daily"`, target: "bob@ln.tips", spec: "@daily"},
+		{text: `/schedule 1000 @bob "0 9 1 * *`, wantErr: true},
+		{text: "/schedule 1000 @bob", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			_, target, spec, memo, err := parseScheduleCommand(tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseScheduleCommand() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if target != tt.target || spec != tt.spec || memo != tt.memo {
+				t.Errorf("parseScheduleCommand() = %q, %q, %q", target, spec, memo)
+			}
+		})
+	}
+}
@@ -315,15 +315,9 @@ func (bot *TipBot) confirmSendHandler(ctx intercept.Context) (intercept.Context,
 	}
 	toUserStrMd := GetUserStrMd(to.Telegram)
 	fromUserStrMd := GetUserStrMd(from.Telegram)
-	toUserStr := GetUserStr(to.Telegram)
-	fromUserStr := GetUserStr(from.Telegram)
 
-	transactionMemo := fmt.Sprintf("💸 Send from %s to %s.", fromUserStr, toUserStr)
-	t := NewTransaction(bot, from, to, amount, TransactionType("send"))
-	t.Memo = transactionMemo
-
-	success, err := t.Send()
-	if !success || err != nil {
+	err = bot.transfer(from, to, amount, "send", "")
+	if err != nil {
 		// bot.trySendMessage(c.Sender, sendErrorMessage)
 		errmsg := fmt.Sprintf("[/send] Error: Transaction failed. %s", err.Error())
 		log.Errorln(errmsg)
@@ -332,8 +326,6 @@ func (bot *TipBot) confirmSendHandler(ctx intercept.Context) (intercept.Context,
 	}
 	sendData.Inactivate(sendData, bot.Bunt)
 
-	log.Infof("[💸 send] Send from %s to %s (%d sat).", fromUserStr, toUserStr, amount)
-
 	// notify to user
 	bot.trySendMessage(to.Telegram, fmt.Sprintf(i18n.Translate(to.Telegram.LanguageCode, "sendReceivedMessage"), fromUserStrMd, amount))
 	// bot.trySendMessage(from.Telegram, fmt.Sprintf(Translate(ctx, "sendSentMessage"), amount, toUserStrMd))
@@ -48,15 +48,17 @@ type ShopItem struct {
 
 type Shop struct {
 	*storage.Base
-	Owner        *lnbits.User        `json:"owner"`       // owner of the shop
-	Type         string              `json:"Type"`        // type of the shop
-	Title        string              `json:"title"`       // Title of the item
-	Description  string              `json:"description"` // Description of the item
-	ItemIds      []string            `json:"ItemsIDs"`    //
-	Items        map[string]ShopItem `json:"Items"`       //
-	LanguageCode string              `json:"languagecode"`
-	ShopsID      string              `json:"shopsID"`
-	MaxItems     int                 `json:"maxItems"`
+	Owner         *lnbits.User        `json:"owner"`       // owner of the shop
+	Type          string              `json:"Type"`        // type of the shop
+	Title         string              `json:"title"`       // Title of the item
+	Description   string              `json:"description"` // Description of the item
+	ItemIds       []string            `json:"ItemsIDs"`    //
+	Items         map[string]ShopItem `json:"Items"`       //
+	LanguageCode  string              `json:"languagecode"`
+	ShopsID       string              `json:"shopsID"`
+	MaxItems      int                 `json:"maxItems"`
+	WebhookURL    string              `json:"webhookURL"`    // url to POST sales to
+	WebhookSecret string              `json:"webhookSecret"` // HMAC secret to sign webhook payloads
 }
 
 type Shops struct {
@@ -876,6 +878,8 @@ func (bot *TipBot) shopConfirmBuyHandler(ctx intercept.Context) (intercept.Conte
 	bot.trySendMessage(to.Telegram, fmt.Sprintf("🛍 Someone bought `%s` from your shop `%s` for `%d sat`.", str.MarkdownEscape(shopItemTitle), str.MarkdownEscape(shop.Title), amount))
 	bot.trySendMessage(from.Telegram, fmt.Sprintf("🛍 You bought `%s` from %s's shop `%s` for `%d sat`.", str.MarkdownEscape(shopItemTitle), toUserStrMd, str.MarkdownEscape(shop.Title), amount))
 	log.Infof("[🛍 shop] %s bought from %s shop: %s item: %s  for %d sat.", toUserStr, GetUserStr(to.Telegram), shop.Title, shopItemTitle, amount)
+	bot.enqueueShopSaleWebhook(shop, item, GetUserStr(from.Telegram), t)
+	go bot.sendNostrNotification(to, "nostrDMShopSaleMessage", shopItemTitle, shop.Title, amount)
 	bot.shopSendItemFilesToUser(ctx, user, itemID)
 	return ctx, nil
 }
@@ -964,6 +968,18 @@ func (bot *TipBot) shopsHandler(ctx intercept.Context) (intercept.Context, error
 	user := LoadUser(ctx)
 	shopOwner := user
 
+	// /shops export and /shops import
+	if command, err := getArgumentFromCommand(m.Text, 1); err == nil && strings.HasPrefix(strings.Split(m.Text, " ")[0], "/shop") {
+		switch strings.ToLower(command) {
+		case "export":
+			return bot.shopsExportHandler(ctx)
+		case "import":
+			return bot.shopsImportHandler(ctx)
+		case "webhook":
+			return bot.shopsWebhookHandler(ctx)
+		}
+	}
+
 	// if the user in the command, i.e. /shops @user
 	if len(strings.Split(m.Text, " ")) > 1 && strings.HasPrefix(strings.Split(m.Text, " ")[0], "/shop") {
 		toUserStrMention := ""
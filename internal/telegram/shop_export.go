@@ -0,0 +1,305 @@
+package telegram
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/massmux/SatsMobiBot/internal/errors"
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	"github.com/massmux/SatsMobiBot/internal/runtime"
+	"github.com/massmux/SatsMobiBot/internal/storage"
+	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const (
+	SHOPS_EXPORT_VERSION       = 1
+	SHOPS_IMPORT_MAX_FILE_SIZE = 1 << 20 // 1 MB
+)
+
+var shopItemFileTypes = map[string]bool{
+	"photo":     true,
+	"document":  true,
+	"audio":     true,
+	"video":     true,
+	"voice":     true,
+	"videonote": true,
+	"sticker":   true,
+}
+
+// ShopsExport is the JSON document produced by /shops export and accepted by /shops import
+type ShopsExport struct {
+	Version     int          `json:"version"`
+	Description string       `json:"description"`
+	Shops       []ShopExport `json:"shops"`
+}
+
+type ShopExport struct {
+	ID           string           `json:"id,omitempty"` // if set and owned by the user, the shop is overwritten on import
+	Title        string           `json:"title"`
+	Description  string           `json:"description"`
+	LanguageCode string           `json:"languagecode"`
+	Items        []ShopItemExport `json:"items"`
+}
+
+type ShopItemExport struct {
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Price       int64    `json:"price"`
+	CoverFileID string   `json:"coverFileID"` // Telegram fileID of the item photo
+	FileIDs     []string `json:"fileIDs"`     // Telegram fileID of the item files
+	FileTypes   []string `json:"fileTypes"`   // Telegram file type of the item files
+}
+
+// validate checks the import document against the shop limits
+func (export *ShopsExport) validate() error {
+	if len(export.Shops) > MAX_SHOPS {
+		return fmt.Errorf("too many shops (%d > %d)", len(export.Shops), MAX_SHOPS)
+	}
+	if len(export.Description) > SHOPS_DESCRIPTION_MAX_LENGTH {
+		return fmt.Errorf("description too long")
+	}
+	for i, shop := range export.Shops {
+		if len(strings.TrimSpace(shop.Title)) == 0 || len(shop.Title) > SHOP_TITLE_MAX_LENGTH {
+			return fmt.Errorf("shop %d: title must be 1-%d characters", i+1, SHOP_TITLE_MAX_LENGTH)
+		}
+		if len(shop.Description) > SHOPS_DESCRIPTION_MAX_LENGTH {
+			return fmt.Errorf("shop %d: description too long", i+1)
+		}
+		if len(shop.Items) > MAX_ITEMS_PER_SHOP {
+			return fmt.Errorf("shop %d: too many items (%d > %d)", i+1, len(shop.Items), MAX_ITEMS_PER_SHOP)
+		}
+		for j, item := range shop.Items {
+			if len(strings.TrimSpace(item.Title)) == 0 || len(item.Title) > ITEM_TITLE_MAX_LENGTH {
+				return fmt.Errorf("shop %d, item %d: title must be 1-%d characters", i+1, j+1, ITEM_TITLE_MAX_LENGTH)
+			}
+			if len(item.Description) > SHOPS_DESCRIPTION_MAX_LENGTH {
+				return fmt.Errorf("shop %d, item %d: description too long", i+1, j+1)
+			}
+			if item.Price < 0 {
+				return fmt.Errorf("shop %d, item %d: invalid price", i+1, j+1)
+			}
+			if len(item.CoverFileID) == 0 {
+				return fmt.Errorf("shop %d, item %d: missing cover photo", i+1, j+1)
+			}
+			if len(item.FileIDs) > MAX_FILES_PER_ITEM {
+				return fmt.Errorf("shop %d, item %d: too many files (%d > %d)", i+1, j+1, len(item.FileIDs), MAX_FILES_PER_ITEM)
+			}
+			if len(item.FileIDs) != len(item.FileTypes) {
+				return fmt.Errorf("shop %d, item %d: fileIDs and fileTypes length mismatch", i+1, j+1)
+			}
+			for _, t := range item.FileTypes {
+				if !shopItemFileTypes[t] {
+					return fmt.Errorf("shop %d, item %d: invalid file type %s", i+1, j+1, t)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// shopsExportHandler is invoked when the user enters /shops export
+func (bot *TipBot) shopsExportHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	user := LoadUser(ctx)
+	log.Debugf("[shopsExportHandler] %s", GetUserStr(user.Telegram))
+	shops, err := bot.getUserShops(ctx, user)
+	if err != nil {
+		bot.trySendMessage(m.Sender, Translate(ctx, "shopsExportNoShopsMessage"))
+		return ctx, errors.Create(errors.NoShopError)
+	}
+	export := ShopsExport{
+		Version:     SHOPS_EXPORT_VERSION,
+		Description: shops.Description,
+		Shops:       []ShopExport{},
+	}
+	for _, shopId := range shops.Shops {
+		shop, err := bot.getShop(ctx, shopId)
+		if err != nil {
+			log.Errorf("[shopsExportHandler] %s", err.Error())
+			continue
+		}
+		shopExport := ShopExport{
+			ID:           shop.ID,
+			Title:        shop.Title,
+			Description:  shop.Description,
+			LanguageCode: shop.LanguageCode,
+			Items:        []ShopItemExport{},
+		}
+		for _, itemId := range shop.ItemIds {
+			item, ok := shop.getItem(itemId)
+			if !ok {
+				continue
+			}
+			itemExport := ShopItemExport{
+				Title:       item.Title,
+				Description: item.Description,
+				Price:       item.Price,
+				FileIDs:     item.FileIDs,
+				FileTypes:   item.FileTypes,
+			}
+			if item.TbPhoto != nil {
+				itemExport.CoverFileID = item.TbPhoto.FileID
+			}
+			shopExport.Items = append(shopExport.Items, itemExport)
+		}
+		export.Shops = append(export.Shops, shopExport)
+	}
+	b, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		log.Errorf("[shopsExportHandler] %s", err.Error())
+		return ctx, err
+	}
+	bot.trySendMessage(m.Sender, &tb.Document{
+		File:     tb.File{FileReader: bytes.NewReader(b)},
+		FileName: fmt.Sprintf("shops-%d-%s.json", user.Telegram.ID, time.Now().Format("2006-01-02")),
+		MIME:     "application/json",
+		Caption:  fmt.Sprintf(Translate(ctx, "shopsExportCaptionMessage"), len(export.Shops)),
+	})
+	return ctx, nil
+}
+
+// shopsImportHandler is invoked when the user enters /shops import
+func (bot *TipBot) shopsImportHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	user := LoadUser(ctx)
+	if user.Wallet == nil {
+		return ctx, errors.Create(errors.UserNoWalletError)
+	}
+	log.Debugf("[shopsImportHandler] %s", GetUserStr(user.Telegram))
+	SetUserState(user, bot, lnbits.UserStateShopsImport, "")
+	bot.trySendMessage(m.Sender, Translate(ctx, "shopsImportMessage"))
+	return ctx, nil
+}
+
+// importShopsFileHandler is invoked when the user sends the JSON document after /shops import
+func (bot *TipBot) importShopsFileHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	user := LoadUser(ctx)
+	if user.Wallet == nil {
+		return ctx, errors.Create(errors.UserNoWalletError)
+	}
+	defer ResetUserState(user, bot)
+	if m.Document == nil {
+		bot.trySendMessage(m.Sender, Translate(ctx, "shopsImportNoDocumentMessage"))
+		return ctx, errors.Create(errors.NoFileFoundError)
+	}
+	if m.Document.FileSize > SHOPS_IMPORT_MAX_FILE_SIZE {
+		bot.trySendMessage(m.Sender, Translate(ctx, "shopsImportFileTooLargeMessage"))
+		return ctx, errors.Create(errors.MaxReachedError)
+	}
+	reader, err := bot.Telegram.File(&m.Document.File)
+	if err != nil {
+		log.Errorf("[importShopsFileHandler] %s", err.Error())
+		return ctx, err
+	}
+	defer reader.Close()
+	b, err := io.ReadAll(io.LimitReader(reader, SHOPS_IMPORT_MAX_FILE_SIZE))
+	if err != nil {
+		log.Errorf("[importShopsFileHandler] %s", err.Error())
+		return ctx, err
+	}
+	var export ShopsExport
+	if err = json.Unmarshal(b, &export); err != nil {
+		bot.trySendMessage(m.Sender, Translate(ctx, "shopsImportInvalidFileMessage"))
+		return ctx, errors.New(errors.InvalidSyntaxError, err)
+	}
+	if err = export.validate(); err != nil {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "shopsImportFailedMessage"), err.Error()))
+		return ctx, errors.New(errors.InvalidSyntaxError, err)
+	}
+
+	shops, err := bot.getUserShops(ctx, user)
+	if err != nil {
+		shops, err = bot.initUserShops(ctx, user)
+		if err != nil {
+			return ctx, err
+		}
+	}
+	owned := make(map[string]bool)
+	for _, shopId := range shops.Shops {
+		owned[shopId] = true
+	}
+	// count the shops that will be created to stay within MAX_SHOPS
+	nNew := 0
+	for _, shopExport := range export.Shops {
+		if !owned[shopExport.ID] {
+			nNew++
+		}
+	}
+	if len(shops.Shops)+nNew > shops.MaxShops {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "shopsImportMaxShopsMessage"), shops.MaxShops))
+		return ctx, errors.Create(errors.MaxReachedError)
+	}
+
+	created, updated := 0, 0
+	for _, shopExport := range export.Shops {
+		shop := bot.importShop(user, shops, shopExport, owned[shopExport.ID])
+		if existing, err := bot.getShop(ctx, shop.ID); err == nil && owned[shop.ID] {
+			// keep the webhook settings of overwritten shops
+			shop.WebhookURL = existing.WebhookURL
+			shop.WebhookSecret = existing.WebhookSecret
+		}
+		runtime.IgnoreError(shop.Set(shop, bot.ShopBunt))
+		if owned[shopExport.ID] {
+			updated++
+		} else {
+			shops.Shops = append(shops.Shops, shop.ID)
+			created++
+		}
+	}
+	if len(export.Description) > 0 {
+		shops.Description = export.Description
+	}
+	runtime.IgnoreError(shops.Set(shops, bot.ShopBunt))
+	bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "shopsImportDoneMessage"), created, updated))
+	return ctx, nil
+}
+
+// importShop builds a Shop from its export. If overwrite is set, the existing shop ID is kept.
+func (bot *TipBot) importShop(user *lnbits.User, shops *Shops, shopExport ShopExport, overwrite bool) *Shop {
+	shopId := shopExport.ID
+	if !overwrite {
+		shopId = fmt.Sprintf("shop-%s", RandStringRunes(10))
+	}
+	languageCode := shopExport.LanguageCode
+	if len(languageCode) == 0 {
+		languageCode = user.Telegram.LanguageCode
+	}
+	shop := &Shop{
+		Base:         storage.New(storage.ID(shopId)),
+		Title:        shopExport.Title,
+		Description:  shopExport.Description,
+		Owner:        user,
+		Type:         "photo",
+		Items:        make(map[string]ShopItem),
+		ItemIds:      []string{},
+		LanguageCode: languageCode,
+		ShopsID:      shops.ID,
+		MaxItems:     MAX_ITEMS_PER_SHOP,
+	}
+	for _, itemExport := range shopExport.Items {
+		itemId := fmt.Sprintf("item-%s-%s", shop.ID, RandStringRunes(8))
+		shop.Items[itemId] = ShopItem{
+			ID:           itemId,
+			ShopID:       shop.ID,
+			Owner:        user,
+			Type:         "photo",
+			FileIDs:      itemExport.FileIDs,
+			FileTypes:    itemExport.FileTypes,
+			Title:        itemExport.Title,
+			Description:  itemExport.Description,
+			Price:        itemExport.Price,
+			TbPhoto:      &tb.Photo{File: tb.File{FileID: itemExport.CoverFileID}, Caption: itemExport.Title},
+			LanguageCode: shop.LanguageCode,
+			MaxFiles:     MAX_FILES_PER_ITEM,
+		}
+		shop.ItemIds = append(shop.ItemIds, itemId)
+	}
+	return shop
+}
@@ -0,0 +1,34 @@
+package telegram
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestShopsExport_validate(t *testing.T) {
+	item := func(title string) ShopItemExport {
+		return ShopItemExport{Title: title, Price: 100, CoverFileID: "cover", FileIDs: []string{"file"}, FileTypes: []string{"document"}}
+	}
+	tests := []struct {
+		name    string
+		shop    ShopExport
+		wantErr bool
+	}{
+		{name: "valid", shop: ShopExport{Title: "shop", Items: []ShopItemExport{item("item")}}},
+		{name: "empty shop title", shop: ShopExport{Title: "", Items: []ShopItemExport{item("item")}}, wantErr: true},
+		{name: "blank shop title", shop: ShopExport{Title: "  \n", Items: []ShopItemExport{item("item")}}, wantErr: true},
+		{name: "empty item title", shop: ShopExport{Title: "shop", Items: []ShopItemExport{item("")}}, wantErr: true},
+		{name: "blank item title", shop: ShopExport{Title: "shop", Items: []ShopItemExport{item(" \t ")}}, wantErr: true},
+		{name: "long item title", shop: ShopExport{Title: "shop", Items: []ShopItemExport{item(strings.Repeat("a", ITEM_TITLE_MAX_LENGTH+1))}}, wantErr: true},
+		{name: "negative price", shop: ShopExport{Title: "shop", Items: []ShopItemExport{{Title: "item", Price: -1, CoverFileID: "cover"}}}, wantErr: true},
+		{name: "invalid file type", shop: ShopExport{Title: "shop", Items: []ShopItemExport{{Title: "item", CoverFileID: "cover", FileIDs: []string{"file"}, FileTypes: []string{"exe"}}}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			export := &ShopsExport{Version: SHOPS_EXPORT_VERSION, Shops: []ShopExport{tt.shop}}
+			if err := export.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
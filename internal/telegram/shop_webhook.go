@@ -0,0 +1,307 @@
+package telegram
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/massmux/SatsMobiBot/internal/i18n"
+	"github.com/massmux/SatsMobiBot/internal/network"
+	"github.com/massmux/SatsMobiBot/internal/runtime"
+	"github.com/massmux/SatsMobiBot/internal/storage"
+	"github.com/massmux/SatsMobiBot/internal/str"
+	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/buntdb"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const (
+	ShopWebhookIndex           = "shop-webhook:*"
+	shopWebhookEventSale       = "shop.sale"
+	shopWebhookSignatureHeader = "X-Shop-Signature"
+	shopWebhookMaxAttempts     = 10
+	shopWebhookInitialBackoff  = 30 * time.Second
+	shopWebhookMaxBackoff      = 6 * time.Hour
+)
+
+// ShopSalePayload is the JSON body POSTed to the shop's webhook url on every purchase
+type ShopSalePayload struct {
+	Event       string    `json:"event"`
+	OrderID     string    `json:"order_id"`
+	ShopID      string    `json:"shop_id"`
+	ShopTitle   string    `json:"shop_title"`
+	ItemID      string    `json:"item_id"`
+	ItemTitle   string    `json:"item_title"`
+	Buyer       string    `json:"buyer"`
+	Amount      int64     `json:"amount"`
+	PaymentHash string    `json:"payment_hash"`
+	Time        time.Time `json:"time"`
+}
+
+// ShopWebhookDelivery is a pending webhook delivery. It is persisted in bunt until it
+// was delivered successfully or ran out of attempts.
+type ShopWebhookDelivery struct {
+	*storage.Base
+	ShopID      string          `json:"shop_id"`
+	OwnerID     int64           `json:"owner_id"`
+	URL         string          `json:"url"`
+	Secret      string          `json:"secret"`
+	Payload     json.RawMessage `json:"payload"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"next_attempt"`
+	LastError   string          `json:"last_error"`
+}
+
+// signShopWebhookPayload returns the hex encoded HMAC-SHA256 of the payload
+func signShopWebhookPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// shopWebhookBackoff returns the waiting time before the next delivery attempt
+func shopWebhookBackoff(attempts int) time.Duration {
+	d := shopWebhookInitialBackoff
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= shopWebhookMaxBackoff {
+			return shopWebhookMaxBackoff
+		}
+	}
+	return d
+}
+
+// isPublicIP reports whether ip is routable on the public internet. Webhooks must not
+// reach the bot's own services, like the admin api on localhost, or the local network.
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+	// carrier-grade NAT
+	if ip4 := ip.To4(); ip4 != nil && ip4[0] == 100 && ip4[1]&0xc0 == 64 {
+		return false
+	}
+	return true
+}
+
+// checkShopWebhookURL only accepts https urls of hosts that resolve to public addresses
+func checkShopWebhookURL(target string) (*url.URL, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "https" || len(u.Hostname()) == 0 || u.User != nil {
+		return nil, fmt.Errorf("webhook url must be https")
+	}
+	ips, err := net.LookupIP(u.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		if !isPublicIP(ip) {
+			return nil, fmt.Errorf("webhook host %s is not public", u.Hostname())
+		}
+	}
+	return u, nil
+}
+
+// newShopWebhookSecret returns a random secret for signing webhook payloads
+func newShopWebhookSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// shopsWebhookHandler is invoked when the user enters /shops webhook <shop-id> <url|off>
+func (bot *TipBot) shopsWebhookHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	user := LoadUser(ctx)
+	shopId, err := getArgumentFromCommand(m.Text, 2)
+	if err != nil {
+		bot.trySendMessage(m.Sender, Translate(ctx, "shopWebhookHelpMessage"))
+		return ctx, err
+	}
+	target, err := getArgumentFromCommand(m.Text, 3)
+	if err != nil {
+		bot.trySendMessage(m.Sender, Translate(ctx, "shopWebhookHelpMessage"))
+		return ctx, err
+	}
+	shop, err := bot.getShop(ctx, shopId)
+	if err != nil || shop.Owner.Telegram.ID != user.Telegram.ID {
+		bot.trySendMessage(m.Sender, Translate(ctx, "shopWebhookShopNotFoundMessage"))
+		return ctx, fmt.Errorf("shop not found")
+	}
+	if strings.ToLower(target) == "off" {
+		shop.WebhookURL = ""
+		shop.WebhookSecret = ""
+		runtime.IgnoreError(shop.Set(shop, bot.ShopBunt))
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "shopWebhookRemovedMessage"), str.MarkdownEscape(shop.Title)))
+		return ctx, nil
+	}
+	u, err := checkShopWebhookURL(target)
+	if err != nil {
+		log.Warnf("[shopsWebhookHandler] %s: %s", GetUserStr(user.Telegram), err.Error())
+		bot.trySendMessage(m.Sender, Translate(ctx, "shopWebhookInvalidURLMessage"))
+		return ctx, err
+	}
+	if len(shop.WebhookSecret) == 0 {
+		shop.WebhookSecret, err = newShopWebhookSecret()
+		if err != nil {
+			return ctx, err
+		}
+	}
+	shop.WebhookURL = u.String()
+	runtime.IgnoreError(shop.Set(shop, bot.ShopBunt))
+	bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "shopWebhookSetMessage"),
+		str.MarkdownEscape(shop.Title), u.String(), shopWebhookSignatureHeader, shop.WebhookSecret))
+	return ctx, nil
+}
+
+// enqueueShopSaleWebhook persists a webhook delivery for a completed purchase and tries to deliver it
+func (bot *TipBot) enqueueShopSaleWebhook(shop *Shop, item ShopItem, buyer string, t *Transaction) {
+	if len(shop.WebhookURL) == 0 {
+		return
+	}
+	orderId := fmt.Sprintf("order-%s", RandStringRunes(16))
+	payload, err := json.Marshal(ShopSalePayload{
+		Event:       shopWebhookEventSale,
+		OrderID:     orderId,
+		ShopID:      shop.ID,
+		ShopTitle:   shop.Title,
+		ItemID:      item.ID,
+		ItemTitle:   item.Title,
+		Buyer:       buyer,
+		Amount:      t.Amount,
+		PaymentHash: t.Invoice.PaymentHash,
+		Time:        t.Time,
+	})
+	if err != nil {
+		log.Errorf("[enqueueShopSaleWebhook] %s", err.Error())
+		return
+	}
+	delivery := &ShopWebhookDelivery{
+		Base:        storage.New(storage.ID(fmt.Sprintf("shop-webhook:%s", orderId))),
+		ShopID:      shop.ID,
+		OwnerID:     shop.Owner.Telegram.ID,
+		URL:         shop.WebhookURL,
+		Secret:      shop.WebhookSecret,
+		Payload:     payload,
+		NextAttempt: time.Now(),
+	}
+	err = delivery.Set(delivery, bot.Bunt)
+	if err != nil {
+		log.Errorf("[enqueueShopSaleWebhook] %s", err.Error())
+		return
+	}
+	go bot.deliverShopWebhook(delivery)
+}
+
+// deliverShopWebhook POSTs the payload. On failure, the next attempt is scheduled with backoff.
+func (bot *TipBot) deliverShopWebhook(delivery *ShopWebhookDelivery) {
+	delivery.Attempts++
+	err := postShopWebhook(delivery)
+	if err == nil {
+		log.Infof("[shopWebhook] delivered %s to %s after %d attempts", delivery.ID, delivery.URL, delivery.Attempts)
+		runtime.IgnoreError(delivery.Delete(delivery, bot.Bunt))
+		runtime.RemoveTicker(delivery.Key())
+		return
+	}
+	log.Warnf("[shopWebhook] delivery %s to %s failed (attempt %d): %s", delivery.ID, delivery.URL, delivery.Attempts, err.Error())
+	delivery.LastError = err.Error()
+	if delivery.Attempts >= shopWebhookMaxAttempts {
+		runtime.IgnoreError(delivery.Delete(delivery, bot.Bunt))
+		runtime.RemoveTicker(delivery.Key())
+		if owner, err := GetLnbitsUser(&tb.User{ID: delivery.OwnerID}, *bot); err == nil {
+			bot.trySendMessage(owner.Telegram, fmt.Sprintf(i18n.Translate(owner.Telegram.LanguageCode, "shopWebhookFailedMessage"), delivery.URL, delivery.Attempts, str.MarkdownEscape(delivery.LastError)))
+		}
+		return
+	}
+	delivery.NextAttempt = time.Now().Add(shopWebhookBackoff(delivery.Attempts))
+	runtime.IgnoreError(delivery.Set(delivery, bot.Bunt))
+	bot.startShopWebhookTimer(delivery)
+}
+
+// startShopWebhookTimer schedules the next delivery attempt
+func (bot *TipBot) startShopWebhookTimer(delivery *ShopWebhookDelivery) {
+	t := runtime.NewResettableFunction(delivery.Key(),
+		runtime.WithTimer(time.NewTimer(time.Until(delivery.NextAttempt))))
+	t.Do(func() {
+		bot.deliverShopWebhook(delivery)
+	})
+}
+
+func postShopWebhook(delivery *ShopWebhookDelivery) error {
+	u, err := checkShopWebhookURL(delivery.URL)
+	if err != nil {
+		return err
+	}
+	client, err := network.GetClientForScheme(u)
+	if err != nil {
+		return err
+	}
+	if client.Transport == nil {
+		// check the address again when connecting, the host could resolve differently by now
+		dialer := &net.Dialer{Timeout: 10 * time.Second, Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+				return fmt.Errorf("webhook host %s is not public", host)
+			}
+			return nil
+		}}
+		client.Transport = &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: 10 * time.Second}
+	}
+	// redirects are not followed, they could point to a private host
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	req, err := http.NewRequest(http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Shop-Event", shopWebhookEventSale)
+	req.Header.Set("X-Shop-Delivery", delivery.ID)
+	req.Header.Set(shopWebhookSignatureHeader, "sha256="+signShopWebhookPayload(delivery.Secret, delivery.Payload))
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("HTTP error: %s", resp.Status)
+	}
+	return nil
+}
+
+// restartShopWebhookDeliveries reschedules all pending webhook deliveries after a restart
+func (bot *TipBot) restartShopWebhookDeliveries() {
+	bot.Bunt.View(func(tx *buntdb.Tx) error {
+		err := tx.Ascend("shop-webhook", func(key, value string) bool {
+			delivery := &ShopWebhookDelivery{}
+			err := json.Unmarshal([]byte(value), delivery)
+			if err != nil {
+				return true
+			}
+			bot.startShopWebhookTimer(delivery)
+			return true // continue iteration
+		})
+		return err
+	})
+}
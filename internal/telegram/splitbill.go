@@ -0,0 +1,404 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/massmux/SatsMobiBot/internal/errors"
+	"github.com/massmux/SatsMobiBot/internal/i18n"
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	"github.com/massmux/SatsMobiBot/internal/runtime"
+	"github.com/massmux/SatsMobiBot/internal/runtime/mutex"
+	"github.com/massmux/SatsMobiBot/internal/storage"
+	"github.com/massmux/SatsMobiBot/internal/str"
+	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/buntdb"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+const (
+	SplitBillIndex            = "splitbill:*"
+	splitBillMaxParticipants  = 20
+	splitBillReminderInterval = 24 * time.Hour
+	splitBillMaxReminders     = 3
+	splitBillMaxMemoLength    = 200
+)
+
+var (
+	splitBillMenu     = &tb.ReplyMarkup{ResizeKeyboard: true}
+	btnPaySplitBill   = splitBillMenu.Data("💸 Pay", "splitbill_pay")
+	btnCloseSplitBill = splitBillMenu.Data("🚫 Close", "splitbill_close")
+)
+
+// SplitBillParticipant is a user who has to pay a share of a split bill
+type SplitBillParticipant struct {
+	User   *lnbits.User `json:"user"`
+	Amount int64        `json:"amount"`
+	Paid   bool         `json:"paid"`
+}
+
+// SplitBill is a bill paid by Creator that the participants pay back in shares
+type SplitBill struct {
+	*storage.Base
+	Creator      *lnbits.User           `json:"creator"`
+	Total        int64                  `json:"total"`
+	Memo         string                 `json:"memo"`
+	Participants []SplitBillParticipant `json:"participants"`
+	Message      *tb.Message            `json:"message"` // the tracking message in the group
+	LanguageCode string                 `json:"languagecode"`
+	NextReminder time.Time              `json:"next_reminder"`
+	Reminders    int                    `json:"reminders"`
+}
+
+// collected returns the paid amount and the amount to collect from the participants
+func (bill *SplitBill) collected() (paid int64, total int64) {
+	for _, p := range bill.Participants {
+		total += p.Amount
+		if p.Paid {
+			paid += p.Amount
+		}
+	}
+	return paid, total
+}
+
+func (bill *SplitBill) isSettled() bool {
+	for _, p := range bill.Participants {
+		if !p.Paid {
+			return false
+		}
+	}
+	return true
+}
+
+// splitBillShare is a parsed "@user" or "@user=amount" argument
+type splitBillShare struct {
+	username string
+	amount   int64
+	optional bool // users taken from a replied message are skipped if they have no wallet
+}
+
+// parseSplitBillShares reads the participants from the words of a command. It stops at the first word
+// that is not a mention and returns the index of that word, where the memo starts.
+func parseSplitBillShares(words []string) ([]splitBillShare, int, error) {
+	shares := make([]splitBillShare, 0)
+	i := 0
+	for ; i < len(words); i++ {
+		word := words[i]
+		if !strings.HasPrefix(word, "@") {
+			break
+		}
+		share := splitBillShare{username: strings.TrimPrefix(word, "@")}
+		if name, amountStr, ok := strings.Cut(share.username, "="); ok {
+			amount, err := GetAmount(amountStr)
+			if err != nil || amount < 1 {
+				return nil, 0, fmt.Errorf("invalid share %s", word)
+			}
+			share.username, share.amount = name, amount
+		}
+		shares = append(shares, share)
+	}
+	return shares, i, nil
+}
+
+// splitBillHandler is invoked on /splitbill <total> <@user>[=<amount>] ... [<memo>] in a group chat.
+// If the command replies to a message, the author and the users mentioned in that message are added.
+func (bot *TipBot) splitBillHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	creator := LoadUser(ctx)
+	if creator.Wallet == nil {
+		return ctx, errors.Create(errors.UserNoWalletError)
+	}
+	if m.Private() {
+		bot.trySendMessage(m.Sender, Translate(ctx, "splitBillHelpMessage"))
+		return ctx, errors.Create(errors.NoPrivateChatError)
+	}
+	words := strings.Fields(m.Text)
+	if len(words) < 2 {
+		bot.trySendMessage(m.Chat, Translate(ctx, "splitBillHelpMessage"))
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	total, err := GetAmount(words[1])
+	if err != nil || total < 1 {
+		bot.trySendMessage(m.Chat, Translate(ctx, "splitBillHelpMessage"))
+		return ctx, errors.Create(errors.InvalidAmountError)
+	}
+	shares, memoStart, err := parseSplitBillShares(words[2:])
+	if err != nil {
+		bot.trySendMessage(m.Chat, fmt.Sprintf(Translate(ctx, "splitBillInvalidMessage"), str.MarkdownEscape(err.Error())))
+		return ctx, errors.New(errors.InvalidSyntaxError, err)
+	}
+	memo := strings.Join(words[2+memoStart:], " ")
+	memo = str.Truncate(memo, splitBillMaxMemoLength)
+	if m.ReplyTo != nil {
+		if m.ReplyTo.Sender != nil && !m.ReplyTo.Sender.IsBot && len(m.ReplyTo.Sender.Username) > 0 {
+			shares = append(shares, splitBillShare{username: m.ReplyTo.Sender.Username, optional: true})
+		}
+		replyShares, _, _ := parseSplitBillShares(mentionsInText(m.ReplyTo.Text))
+		for _, share := range replyShares {
+			share.optional = true
+			shares = append(shares, share)
+		}
+	}
+	participants, err := bot.splitBillParticipants(creator, total, shares)
+	if err != nil {
+		bot.trySendMessage(m.Chat, fmt.Sprintf(Translate(ctx, "splitBillInvalidMessage"), str.MarkdownEscape(err.Error())))
+		return ctx, err
+	}
+	bill := &SplitBill{
+		Base:         storage.New(storage.ID(fmt.Sprintf("splitbill:%s", RandStringRunes(10)))),
+		Creator:      creator,
+		Total:        total,
+		Memo:         memo,
+		Participants: participants,
+		LanguageCode: ctx.Value("publicLanguageCode").(string),
+		NextReminder: time.Now().Add(splitBillReminderInterval),
+	}
+	bill.Message = bot.trySendMessageEditable(m.Chat, bot.splitBillText(bill), bot.splitBillKeyboard(bill))
+	if bill.Message == nil {
+		return ctx, fmt.Errorf("could not send split bill")
+	}
+	runtime.IgnoreError(bill.Set(bill, bot.Bunt))
+	bot.startSplitBillReminderTimer(bill)
+	log.Infof("[splitbill] %s split %d sat with %d participants", GetUserStr(creator.Telegram), total, len(participants))
+	return ctx, nil
+}
+
+// mentionsInText returns all words of a text that start with @
+func mentionsInText(text string) []string {
+	mentions := make([]string, 0)
+	for _, word := range strings.Fields(text) {
+		word = strings.TrimRight(word, ".,;:!?")
+		if strings.HasPrefix(word, "@") && len(word) > 1 {
+			mentions = append(mentions, word)
+		}
+	}
+	return mentions
+}
+
+// splitBillParticipants resolves the users and calculates the shares. Users without a custom share
+// pay an equal part of the rest of the bill. The creator counts as one of them and has already paid.
+func (bot *TipBot) splitBillParticipants(creator *lnbits.User, total int64, shares []splitBillShare) ([]SplitBillParticipant, error) {
+	participants := make([]SplitBillParticipant, 0)
+	seen := map[int64]bool{creator.Telegram.ID: true}
+	var custom int64
+	nEqual := int64(1) // the creator
+	for _, share := range shares {
+		user, err := GetUserByTelegramUsername(share.username, *bot)
+		if err != nil || user.Telegram.IsBot {
+			if share.optional {
+				continue
+			}
+			return nil, fmt.Errorf("@%s has no wallet", share.username)
+		}
+		if seen[user.Telegram.ID] {
+			continue
+		}
+		seen[user.Telegram.ID] = true
+		participants = append(participants, SplitBillParticipant{User: user, Amount: share.amount})
+		if share.amount > 0 {
+			custom += share.amount
+		} else {
+			nEqual++
+		}
+	}
+	if len(participants) == 0 {
+		return nil, fmt.Errorf("no participants")
+	}
+	if len(participants) > splitBillMaxParticipants {
+		return nil, fmt.Errorf("too many participants (max %d)", splitBillMaxParticipants)
+	}
+	if custom > total {
+		return nil, fmt.Errorf("the shares are larger than the total")
+	}
+	equalShare := (total - custom) / nEqual
+	for i := range participants {
+		if participants[i].Amount == 0 {
+			if equalShare < 1 {
+				return nil, fmt.Errorf("the total is too small")
+			}
+			participants[i].Amount = equalShare
+		}
+	}
+	return participants, nil
+}
+
+func (bot *TipBot) splitBillText(bill *SplitBill) string {
+	paid, total := bill.collected()
+	text := fmt.Sprintf(i18n.Translate(bill.LanguageCode, "splitBillMessage"), GetUserStrMd(bill.Creator.Telegram), bill.Total, paid, total, MakeProgressbar(paid, total))
+	if len(bill.Memo) > 0 {
+		text += fmt.Sprintf("\n✉️ %s", str.MarkdownEscape(bill.Memo))
+	}
+	text += "\n"
+	for _, p := range bill.Participants {
+		status := "⏳"
+		if p.Paid {
+			status = "✅"
+		}
+		text += fmt.Sprintf("\n%s %s – %d sat", status, GetUserStrMd(p.User.Telegram), p.Amount)
+	}
+	return text
+}
+
+// splitBillKeyboard has a pay button for every participant who has not paid yet
+func (bot *TipBot) splitBillKeyboard(bill *SplitBill) *tb.ReplyMarkup {
+	rows := make([]tb.Row, 0)
+	for i, p := range bill.Participants {
+		if p.Paid {
+			continue
+		}
+		button := splitBillMenu.Data(fmt.Sprintf("%s %s · %d sat", btnPaySplitBill.Text, GetUserStr(p.User.Telegram), p.Amount),
+			btnPaySplitBill.Unique, fmt.Sprintf("%s,%d", bill.ID, i))
+		rows = append(rows, splitBillMenu.Row(button))
+	}
+	rows = append(rows, splitBillMenu.Row(splitBillMenu.Data(btnCloseSplitBill.Text, btnCloseSplitBill.Unique, bill.ID)))
+	splitBillMenu.Inline(rows...)
+	return splitBillMenu
+}
+
+func (bot *TipBot) loadSplitBill(id string) (*SplitBill, error) {
+	tx := &SplitBill{Base: storage.New(storage.ID(id))}
+	sn, err := tx.Get(tx, bot.Bunt)
+	if err != nil {
+		return nil, err
+	}
+	return sn.(*SplitBill), nil
+}
+
+// paySplitBillHandler is invoked when a participant presses the pay button of their share
+func (bot *TipBot) paySplitBillHandler(ctx intercept.Context) (intercept.Context, error) {
+	c := ctx.Callback()
+	id, indexStr, ok := strings.Cut(c.Data, ",")
+	index, err := strconv.Atoi(indexStr)
+	if !ok || err != nil {
+		return ctx, errors.Create(errors.InvalidSyntaxError)
+	}
+	mutex.LockWithContext(ctx, id)
+	defer mutex.UnlockWithContext(ctx, id)
+	bill, err := bot.loadSplitBill(id)
+	if err != nil {
+		return ctx, err
+	}
+	if !bill.Active || index < 0 || index >= len(bill.Participants) {
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	participant := &bill.Participants[index]
+	if participant.User.Telegram.ID != c.Sender.ID {
+		ctx.Context = context.WithValue(ctx, "callback_response", Translate(ctx, "splitBillNotYourShareMessage"))
+		return ctx, errors.Create(errors.UnknownError)
+	}
+	if participant.Paid {
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	from := LoadUser(ctx)
+	to, err := GetLnbitsUser(bill.Creator.Telegram, *bot)
+	if err != nil || to.Wallet == nil {
+		return ctx, errors.Create(errors.UserNoWalletError)
+	}
+	t := NewTransaction(bot, from, to, participant.Amount, TransactionType("splitbill"), TransactionChat(bill.Message.Chat))
+	t.Memo = fmt.Sprintf("🧾 Split bill share of %s for %s.", GetUserStr(from.Telegram), GetUserStr(to.Telegram))
+	success, err := t.Send()
+	if !success || err != nil {
+		if err == nil {
+			err = fmt.Errorf("transaction failed")
+		}
+		log.Warnf("[splitbill] %s could not pay share of %s: %s", GetUserStr(from.Telegram), bill.ID, err.Error())
+		ctx.Context = context.WithValue(ctx, "callback_response", Translate(ctx, "sendErrorMessage"))
+		return ctx, err
+	}
+	participant.Paid = true
+	log.Infof("[splitbill] %s paid %d sat to %s", GetUserStr(from.Telegram), participant.Amount, GetUserStr(to.Telegram))
+	ctx.Context = context.WithValue(ctx, "callback_response", fmt.Sprintf(Translate(ctx, "splitBillPaidMessage"), participant.Amount))
+	bot.trySendMessage(to.Telegram, fmt.Sprintf(i18n.Translate(to.Telegram.LanguageCode, "splitBillSharePaidMessage"), GetUserStrMd(from.Telegram), participant.Amount))
+	if bill.isSettled() {
+		runtime.RemoveTicker(bill.ID)
+		runtime.IgnoreError(bill.Inactivate(bill, bot.Bunt))
+		bot.tryEditStack(bill.Message, bill.ID, bot.splitBillText(bill)+"\n\n"+i18n.Translate(bill.LanguageCode, "splitBillSettledMessage"), &tb.ReplyMarkup{})
+		return ctx, nil
+	}
+	runtime.IgnoreError(bill.Set(bill, bot.Bunt))
+	bot.tryEditStack(bill.Message, bill.ID, bot.splitBillText(bill), bot.splitBillKeyboard(bill))
+	return ctx, nil
+}
+
+// closeSplitBillHandler is invoked when the creator closes the bill
+func (bot *TipBot) closeSplitBillHandler(ctx intercept.Context) (intercept.Context, error) {
+	c := ctx.Callback()
+	mutex.LockWithContext(ctx, c.Data)
+	defer mutex.UnlockWithContext(ctx, c.Data)
+	bill, err := bot.loadSplitBill(c.Data)
+	if err != nil {
+		return ctx, err
+	}
+	if bill.Creator.Telegram.ID != c.Sender.ID {
+		ctx.Context = context.WithValue(ctx, "callback_response", Translate(ctx, "splitBillNotYourBillMessage"))
+		return ctx, errors.Create(errors.UnknownError)
+	}
+	if !bill.Active {
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	runtime.RemoveTicker(bill.ID)
+	bill.Canceled = true
+	runtime.IgnoreError(bill.Inactivate(bill, bot.Bunt))
+	bot.tryEditStack(bill.Message, bill.ID, bot.splitBillText(bill)+"\n\n"+i18n.Translate(bill.LanguageCode, "splitBillClosedMessage"), &tb.ReplyMarkup{})
+	return ctx, nil
+}
+
+// startSplitBillReminderTimer reminds the unpaid participants until the bill is settled
+func (bot *TipBot) startSplitBillReminderTimer(bill *SplitBill) {
+	t := runtime.NewResettableFunction(bill.ID,
+		runtime.WithTimer(time.NewTimer(time.Until(bill.NextReminder))))
+	t.Do(func() {
+		bot.remindSplitBill(bill.ID)
+	})
+}
+
+// remindSplitBill sends a reminder with a pay button to every participant who has not paid yet
+func (bot *TipBot) remindSplitBill(id string) {
+	mutex.Lock(id)
+	defer mutex.Unlock(id)
+	bill, err := bot.loadSplitBill(id)
+	if err != nil || !bill.Active {
+		return
+	}
+	for i, p := range bill.Participants {
+		if p.Paid {
+			continue
+		}
+		menu := &tb.ReplyMarkup{}
+		menu.Inline(menu.Row(menu.Data(btnPaySplitBill.Text, btnPaySplitBill.Unique, fmt.Sprintf("%s,%d", bill.ID, i))))
+		text := fmt.Sprintf(i18n.Translate(p.User.Telegram.LanguageCode, "splitBillReminderMessage"), GetUserStrMd(bill.Creator.Telegram), p.Amount)
+		if len(bill.Memo) > 0 {
+			text += fmt.Sprintf("\n✉️ %s", str.MarkdownEscape(bill.Memo))
+		}
+		bot.trySendMessage(p.User.Telegram, text, menu)
+	}
+	bill.Reminders++
+	if bill.Reminders >= splitBillMaxReminders {
+		runtime.IgnoreError(bill.Set(bill, bot.Bunt))
+		return
+	}
+	bill.NextReminder = time.Now().Add(splitBillReminderInterval)
+	runtime.IgnoreError(bill.Set(bill, bot.Bunt))
+	bot.startSplitBillReminderTimer(bill)
+}
+
+// restartSplitBillReminders restarts the reminder timers of all open bills
+func (bot *TipBot) restartSplitBillReminders() {
+	bot.Bunt.View(func(tx *buntdb.Tx) error {
+		return tx.Ascend("splitbill", func(key, value string) bool {
+			bill := &SplitBill{}
+			if err := json.Unmarshal([]byte(value), bill); err != nil {
+				return true
+			}
+			if bill.Active && bill.Reminders < splitBillMaxReminders {
+				bot.startSplitBillReminderTimer(bill)
+			}
+			return true // continue iteration
+		})
+	})
+}
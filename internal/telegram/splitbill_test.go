@@ -0,0 +1,118 @@
+package telegram
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/tidwall/buntdb"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func Test_parseSplitBillShares(t *testing.T) {
+	shares, memoStart, err := parseSplitBillShares([]string{"@alice", "@bob=300", "dinner", "@carol"})
+	if err != nil {
+		t.Fatalf("parseSplitBillShares() error = %v", err)
+	}
+	want := []splitBillShare{{username: "alice"}, {username: "bob", amount: 300}}
+	if !reflect.DeepEqual(shares, want) || memoStart != 2 {
+		t.Errorf("parseSplitBillShares() = %+v, %d, want %+v, 2", shares, memoStart, want)
+	}
+	for _, words := range [][]string{{"@bob=0"}, {"@bob=-5"}, {"@bob=lots"}} {
+		if _, _, err := parseSplitBillShares(words); err == nil {
+			t.Errorf("parseSplitBillShares(%v) accepted an invalid share", words)
+		}
+	}
+}
+
+func Test_mentionsInText(t *testing.T) {
+	got := mentionsInText("thanks @alice, @bob! and @ carol@example.com")
+	want := []string{"@alice", "@bob"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mentionsInText() = %v, want %v", got, want)
+	}
+}
+
+func TestTipBot_splitBillParticipants(t *testing.T) {
+	test := newTestBot(t)
+	creator := test.addUser(t, 2, 0)
+	test.addUser(t, 3, 0)
+	test.addUser(t, 4, 0)
+	tests := []struct {
+		name    string
+		total   int64
+		shares  []splitBillShare
+		want    []int64
+		wantErr bool
+	}{
+		{name: "equal", total: 900, shares: []splitBillShare{{username: "user3"}, {username: "user4"}}, want: []int64{300, 300}},
+		{name: "custom", total: 900, shares: []splitBillShare{{username: "user3", amount: 500}, {username: "user4"}}, want: []int64{500, 200}},
+		{name: "duplicate", total: 900, shares: []splitBillShare{{username: "user3"}, {username: "user3"}}, want: []int64{450}},
+		{name: "creator", total: 900, shares: []splitBillShare{{username: "user2"}, {username: "user3"}}, want: []int64{450}},
+		{name: "optional without wallet", total: 900, shares: []splitBillShare{{username: "user3"}, {username: "nobody", optional: true}}, want: []int64{450}},
+		{name: "without wallet", total: 900, shares: []splitBillShare{{username: "nobody"}}, wantErr: true},
+		{name: "no participants", total: 900, shares: []splitBillShare{{username: "user2"}}, wantErr: true},
+		{name: "shares above total", total: 900, shares: []splitBillShare{{username: "user3", amount: 1000}}, wantErr: true},
+		{name: "total too small", total: 2, shares: []splitBillShare{{username: "user3"}, {username: "user4"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			participants, err := test.splitBillParticipants(creator, tt.total, tt.shares)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("splitBillParticipants() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			amounts := make([]int64, 0)
+			for _, p := range participants {
+				amounts = append(amounts, p.Amount)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(amounts, tt.want) {
+				t.Errorf("splitBillParticipants() = %v, want %v", amounts, tt.want)
+			}
+		})
+	}
+}
+
+func TestTipBot_paySplitBillHandler(t *testing.T) {
+	test := newTestBot(t)
+	creator := test.addUser(t, 2, 0)
+	alice := test.addUser(t, 3, 1000)
+	bob := test.addUser(t, 4, 1000)
+	group := &tb.Chat{ID: -100, Type: tb.ChatGroup, Title: "group"}
+
+	if _, err := test.splitBillHandler(test.message(creator, group, "/splitbill 900 @user3 @user4 dinner")); err != nil {
+		t.Fatalf("splitBillHandler() error = %v", err)
+	}
+	var bill *SplitBill
+	test.Bunt.View(func(tx *buntdb.Tx) error {
+		return tx.AscendKeys(SplitBillIndex, func(key, value string) bool {
+			bill, _ = test.loadSplitBill(key)
+			return false
+		})
+	})
+	if bill == nil || bill.Memo != "dinner" || len(bill.Participants) != 2 {
+		t.Fatalf("split bill = %+v", bill)
+	}
+	// a participant can only pay their own share, and only once
+	if _, err := test.paySplitBillHandler(test.callback(bob, group, fmt.Sprintf("%s,0", bill.ID))); err == nil {
+		t.Fatalf("paySplitBillHandler() paid the share of another user")
+	}
+	if _, err := test.paySplitBillHandler(test.callback(alice, group, fmt.Sprintf("%s,0", bill.ID))); err != nil {
+		t.Fatalf("paySplitBillHandler() error = %v", err)
+	}
+	if _, err := test.paySplitBillHandler(test.callback(alice, group, fmt.Sprintf("%s,0", bill.ID))); err == nil {
+		t.Fatalf("paySplitBillHandler() paid a share twice")
+	}
+	if _, err := test.paySplitBillHandler(test.callback(bob, group, fmt.Sprintf("%s,1", bill.ID))); err != nil {
+		t.Fatalf("paySplitBillHandler() error = %v", err)
+	}
+	if got := test.balance(creator.Telegram.ID); got != 600 {
+		t.Errorf("creator balance = %d, want 600", got)
+	}
+	if got := test.balance(alice.Telegram.ID); got != 700 {
+		t.Errorf("participant balance = %d, want 700", got)
+	}
+	bill, err := test.loadSplitBill(bill.ID)
+	if err != nil || bill.Active || !bill.isSettled() {
+		t.Errorf("split bill is not settled after all shares were paid")
+	}
+}
@@ -0,0 +1,295 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eko/gocache/store"
+	"github.com/massmux/SatsMobiBot/internal"
+	"github.com/massmux/SatsMobiBot/internal/i18n"
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	"github.com/massmux/SatsMobiBot/internal/price"
+	"github.com/massmux/SatsMobiBot/internal/rate"
+	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
+	i18n2 "github.com/nicksnyder/go-i18n/v2/i18n"
+	gocache "github.com/patrickmn/go-cache"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+const testBotID = 1
+
+func TestMain(m *testing.M) {
+	rate.Start()
+	// amounts in fiat are not converted without a price watcher
+	price.P = &price.PriceWatcher{Currencies: map[string]string{}}
+	os.Exit(m.Run())
+}
+
+// fakeLNbits is an in-process LNbits that keeps the balances of wallets and settles payments
+// between them. Invoices of other nodes can't be paid.
+type fakeLNbits struct {
+	mu       sync.Mutex
+	balances map[string]int64 // msat by wallet ID
+	keys     map[string]string
+	admin    map[string]bool
+	invoices map[string]*fakeInvoice // by payment request
+	// failPays is the number of payments that fail before they are settled
+	failPays int
+	// ambiguousPays is the number of payments that are settled but answered with an error
+	ambiguousPays int
+	// failStatus lets payment status requests fail
+	failStatus bool
+	payments   int
+}
+
+type fakeInvoice struct {
+	hash   string
+	wallet string
+	amount int64
+	memo   string
+	paid   bool
+}
+
+func (ln *fakeLNbits) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ln.mu.Lock()
+	defer ln.mu.Unlock()
+	wallet, ok := ln.keys[r.Header.Get("X-Api-Key")]
+	if !ok {
+		ln.error(w, http.StatusUnauthorized, "invalid key")
+		return
+	}
+	switch {
+	case r.URL.Path == "/tpos/api/v1/tposs":
+		// the main menu of private chats links to a point of sale of the user
+		if r.Method == http.MethodPost {
+			json.NewEncoder(w).Encode(map[string]string{"id": "tpos" + wallet})
+			return
+		}
+		json.NewEncoder(w).Encode([]lnbits.Pos{})
+	case r.Method == http.MethodGet && r.URL.Path == "/api/v1/wallet":
+		json.NewEncoder(w).Encode(lnbits.Wallet{ID: wallet, Balance: ln.balances[wallet]})
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/api/v1/payments/"):
+		if ln.failStatus {
+			ln.error(w, http.StatusInternalServerError, "status unavailable")
+			return
+		}
+		hash := strings.TrimPrefix(r.URL.Path, "/api/v1/payments/")
+		for _, invoice := range ln.invoices {
+			if invoice.hash == hash {
+				json.NewEncoder(w).Encode(lnbits.LNbitsPayment{Paid: invoice.paid})
+				return
+			}
+		}
+		ln.error(w, http.StatusNotFound, "payment does not exist")
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v1/payments":
+		params := struct {
+			Out    bool   `json:"out"`
+			Amount int64  `json:"amount"`
+			Memo   string `json:"memo"`
+			Bolt11 string `json:"bolt11"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+			ln.error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if !params.Out {
+			hash := fmt.Sprintf("%064x", len(ln.invoices)+1)
+			invoice := &fakeInvoice{hash: hash, wallet: wallet, amount: params.Amount, memo: params.Memo}
+			ln.invoices["lnfake"+hash] = invoice
+			json.NewEncoder(w).Encode(lnbits.Invoice{PaymentHash: hash, PaymentRequest: "lnfake" + hash})
+			return
+		}
+		if !ln.admin[r.Header.Get("X-Api-Key")] {
+			ln.error(w, http.StatusUnauthorized, "admin key required")
+			return
+		}
+		invoice, ok := ln.invoices[params.Bolt11]
+		if !ok || invoice.paid {
+			ln.error(w, http.StatusBadRequest, "invalid invoice")
+			return
+		}
+		if ln.failPays > 0 {
+			ln.failPays--
+			ln.error(w, http.StatusInternalServerError, "payment failed")
+			return
+		}
+		if ln.balances[wallet] < invoice.amount*1000 {
+			ln.error(w, http.StatusBadRequest, "insufficient balance")
+			return
+		}
+		ln.balances[wallet] -= invoice.amount * 1000
+		ln.balances[invoice.wallet] += invoice.amount * 1000
+		invoice.paid = true
+		ln.payments++
+		if ln.ambiguousPays > 0 {
+			ln.ambiguousPays--
+			ln.error(w, http.StatusGatewayTimeout, "timeout")
+			return
+		}
+		json.NewEncoder(w).Encode(lnbits.Invoice{PaymentHash: invoice.hash, PaymentRequest: params.Bolt11})
+	default:
+		ln.error(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (ln *fakeLNbits) error(w http.ResponseWriter, status int, detail string) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(lnbits.Error{Detail: detail})
+}
+
+// newWallet creates a wallet with a balance in sat
+func (ln *fakeLNbits) newWallet(id string, balance int64) *lnbits.Wallet {
+	ln.mu.Lock()
+	defer ln.mu.Unlock()
+	wallet := &lnbits.Wallet{ID: id, Adminkey: "admin" + id, Inkey: "in" + id}
+	ln.keys[wallet.Adminkey], ln.keys[wallet.Inkey] = id, id
+	ln.admin[wallet.Adminkey] = true
+	ln.balances[id] = balance * 1000
+	return wallet
+}
+
+// balance returns the balance of the wallet in sat
+func (ln *fakeLNbits) balance(id string) int64 {
+	ln.mu.Lock()
+	defer ln.mu.Unlock()
+	return ln.balances[id] / 1000
+}
+
+// testBot is a bot with in-memory databases, a fake LNbits and a fake Telegram api
+type testBot struct {
+	*TipBot
+	lnbits *fakeLNbits
+	cache  *gocache.Cache
+	mu     sync.Mutex
+	sent   []string // texts of the messages sent to Telegram
+}
+
+func newTestBot(t *testing.T) *testBot {
+	test := &testBot{lnbits: &fakeLNbits{
+		balances: map[string]int64{},
+		keys:     map[string]string{},
+		admin:    map[string]bool{},
+		invoices: map[string]*fakeInvoice{},
+	}, cache: gocache.New(5*time.Minute, 10*time.Minute)}
+	lnbitsServer := httptest.NewServer(test.lnbits)
+	t.Cleanup(lnbitsServer.Close)
+	internal.Configuration.Lnbits.LnbitsPublicUrl = lnbitsServer.URL + "/"
+	telegramServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ParseMultipartForm(1<<20) != nil {
+			r.ParseForm()
+		}
+		test.mu.Lock()
+		test.sent = append(test.sent, r.Form.Get("text")+r.Form.Get("caption"))
+		test.mu.Unlock()
+		document := ""
+		if strings.HasSuffix(r.URL.Path, "/sendDocument") {
+			document = `,"document":{"file_id":"document"}`
+		}
+		fmt.Fprintf(w, `{"ok":true,"result":{"message_id":1,"from":{"id":%d,"is_bot":true},"chat":{"id":1},"date":%d%s}}`, testBotID, time.Now().Unix(), document)
+	}))
+	t.Cleanup(telegramServer.Close)
+
+	telegram, err := tb.NewBot(tb.Settings{URL: telegramServer.URL, Token: "test", Offline: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	telegram.Me = &tb.User{ID: testBotID, Username: "testbot", IsBot: true}
+	test.TipBot = &TipBot{
+		DB: &Databases{
+			Users:        newTestDB(t, &lnbits.User{}),
+			Transactions: newTestDB(t, &Transaction{}),
+			Groups:       newTestDB(t, &Group{}),
+		},
+		Bunt:     createBunt(":memory:"),
+		Telegram: telegram,
+		Client:   lnbits.NewClient("adminkey", lnbitsServer.URL),
+		Cache:    Cache{GoCacheStore: store.NewGoCache(test.cache, nil)},
+	}
+	test.addUser(t, testBotID, 0)
+	return test
+}
+
+func newTestDB(t *testing.T, model interface{}) *gorm.DB {
+	orm, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{DisableForeignKeyConstraintWhenMigrating: true, FullSaveAssociations: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := orm.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection would open its own in-memory database
+	sqlDB.SetMaxOpenConns(1)
+	if err = orm.AutoMigrate(model); err != nil {
+		t.Fatal(err)
+	}
+	return orm
+}
+
+// addUser creates a user with a wallet that holds balance sat
+func (test *testBot) addUser(t *testing.T, id int64, balance int64) *lnbits.User {
+	name := strconv.FormatInt(id, 10)
+	user := &lnbits.User{
+		ID:       "lnbits" + name,
+		Name:     name,
+		Telegram: &tb.User{ID: id, Username: "user" + name},
+		Wallet:   test.lnbits.newWallet("wallet"+name, balance),
+	}
+	if err := test.DB.Users.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// balance returns the balance of the user in sat
+func (test *testBot) balance(id int64) int64 {
+	return test.lnbits.balance("wallet" + strconv.FormatInt(id, 10))
+}
+
+// cacheKeys returns the cached keys with the prefix
+func (test *testBot) cacheKeys(prefix string) []string {
+	keys := make([]string, 0)
+	for key := range test.cache.Items() {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// context returns the context of an update from the user, like the interceptors build it
+func (test *testBot) context(user *lnbits.User, update tb.Update) intercept.Context {
+	localizer := i18n2.NewLocalizer(i18n.Bundle, "en")
+	ctx := context.WithValue(context.Background(), "uid", RandStringRunes(8))
+	ctx = context.WithValue(ctx, "user", user)
+	ctx = context.WithValue(ctx, "publicLanguageCode", "en")
+	ctx = context.WithValue(ctx, "publicLocalizer", localizer)
+	ctx = context.WithValue(ctx, "userLocalizer", localizer)
+	return intercept.Context{Context: ctx, TeleContext: intercept.TeleContext{Context: test.Telegram.NewContext(update)}}
+}
+
+// callback returns the context of a button press of the user in a chat
+func (test *testBot) callback(user *lnbits.User, chat *tb.Chat, data string) intercept.Context {
+	return test.context(user, tb.Update{Callback: &tb.Callback{
+		ID:      RandStringRunes(8),
+		Sender:  user.Telegram,
+		Data:    data,
+		Message: &tb.Message{ID: 1, Sender: &tb.User{ID: testBotID, IsBot: true}, Chat: chat},
+	}})
+}
+
+// message returns the context of a message of the user in a chat
+func (test *testBot) message(user *lnbits.User, chat *tb.Chat, text string) intercept.Context {
+	return test.context(user, tb.Update{Message: &tb.Message{ID: 1, Sender: user.Telegram, Chat: chat, Text: text}})
+}
@@ -25,6 +25,7 @@ var defaultTicketDuration = time.Minute * 15
 // handler will create a new invoice and send it to the group chat.
 // a ticket callback timer function is stored in the blunt db.
 func (bot *TipBot) handleTelegramNewMember(ctx intercept.Context) (intercept.Context, error) {
+	bot.recordPayToPostJoin(ctx.Message())
 	id := strconv.FormatInt(ctx.Chat().ID, 10)
 	group, err := bot.loadGroup(id)
 	if err != nil {
@@ -173,6 +174,10 @@ func (bot *TipBot) stopJoinTicketTimer(event Event) {
 		}
 	}
 
+	if group, err := bot.loadGroup(strconv.FormatInt(ticket.Message.Chat.ID, 10)); err == nil {
+		bot.extendGroupMembership(group, ticket.Sender)
+	}
+
 	d := time.Until(time.Now().Add(defaultTicketDuration))
 	bot.tryDeleteMessage(ev.Message)
 	t := runtime.GetFunction(ticket.Key(), runtime.WithDuration(d))
@@ -84,7 +84,6 @@ func (bot *TipBot) tipHandler(ctx intercept.Context) (intercept.Context, error)
 	toUserStrMd := GetUserStrMd(to.Telegram)
 	fromUserStrMd := GetUserStrMd(from.Telegram)
 	toUserStr := GetUserStr(to.Telegram)
-	fromUserStr := GetUserStr(from.Telegram)
 
 	if _, exists := bot.UserExists(to.Telegram); !exists {
 		log.Infof("[/tip] User %s has no wallet.", toUserStr)
@@ -106,12 +105,8 @@ func (bot *TipBot) tipHandler(ctx intercept.Context) (intercept.Context, error)
 		}
 	}
 
-	// todo: user new get username function to get userStrings
-	transactionMemo := fmt.Sprintf("🏅 Tip from %s to %s.", fromUserStr, toUserStr)
-	t := NewTransaction(bot, from, to, amount, TransactionType("tip"), TransactionChat(m.Chat))
-	t.Memo = transactionMemo
-	success, err := t.Send()
-	if !success {
+	err = bot.transfer(from, to, amount, "tip", "", TransactionChat(m.Chat))
+	if err != nil {
 		NewMessage(m, WithDuration(0, bot))
 		bot.trySendMessage(m.Sender, fmt.Sprintf("%s: %s", Translate(ctx, "tipErrorMessage"), Translate(ctx, "tipUndefinedErrorMsg")))
 		errMsg := fmt.Sprintf("[/tip] Transaction failed: %s", err.Error())
@@ -122,8 +117,6 @@ func (bot *TipBot) tipHandler(ctx intercept.Context) (intercept.Context, error)
 	// update tooltip if necessary
 	messageHasTip := tipTooltipHandler(m, bot, amount, to.Initialized)
 
-	log.Infof("[💸 tip] Tip from %s to %s (%d sat).", fromUserStr, toUserStr, amount)
-
 	// notify users
 	bot.trySendMessage(from.Telegram, fmt.Sprintf(i18n.Translate(from.Telegram.LanguageCode, "tipSentMessage"), amount, toUserStrMd))
 
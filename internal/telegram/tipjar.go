@@ -8,6 +8,7 @@ import (
 
 	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
 
+	"github.com/massmux/SatsMobiBot/internal/runtime"
 	"github.com/massmux/SatsMobiBot/internal/runtime/mutex"
 	"github.com/massmux/SatsMobiBot/internal/storage"
 
@@ -29,16 +30,18 @@ var (
 
 type InlineTipjar struct {
 	*storage.Base
-	Message       string         `json:"inline_tipjar_message"`
-	Amount        int64          `json:"inline_tipjar_amount"`
-	GivenAmount   int64          `json:"inline_tipjar_givenamount"`
-	PerUserAmount int64          `json:"inline_tipjar_peruseramount"`
-	To            *lnbits.User   `json:"inline_tipjar_to"`
-	From          []*lnbits.User `json:"inline_tipjar_from"`
-	Memo          string         `json:"inline_tipjar_memo"`
-	NTotal        int            `json:"inline_tipjar_ntotal"`
-	NGiven        int            `json:"inline_tipjar_ngiven"`
-	LanguageCode  string         `json:"languagecode"`
+	Message       string            `json:"inline_tipjar_message"`
+	Amount        int64             `json:"inline_tipjar_amount"`
+	GivenAmount   int64             `json:"inline_tipjar_givenamount"`
+	PerUserAmount int64             `json:"inline_tipjar_peruseramount"`
+	To            *lnbits.User      `json:"inline_tipjar_to"`
+	From          []*lnbits.User    `json:"inline_tipjar_from"`
+	Memo          string            `json:"inline_tipjar_memo"`
+	NTotal        int               `json:"inline_tipjar_ntotal"`
+	NGiven        int               `json:"inline_tipjar_ngiven"`
+	ExpiresAt     time.Time         `json:"inline_tipjar_expires_at"`
+	Editable      *tb.StoredMessage `json:"inline_tipjar_editable"` // needed to close the tipjar when it expires
+	LanguageCode  string            `json:"languagecode"`
 }
 
 func (bot TipBot) mapTipjarLanguage(ctx context.Context, command string) context.Context {
@@ -68,8 +71,12 @@ func (bot TipBot) createTipjar(ctx context.Context, text string, sender *tb.User
 	nTotal := int(amount / perUserAmount)
 	toUser := LoadUser(ctx)
 	// toUserStr := GetUserStr(sender)
-	// // check for memo in command
-	memo := GetMemoFromCommand(text, 3)
+	// check for expiry and memo in command
+	expiresAt, memoWord, err := getInlineExpiryFromCommand(text, 3)
+	if err != nil {
+		return nil, err
+	}
+	memo := GetMemoFromCommand(text, memoWord)
 
 	inlineMessage := fmt.Sprintf(
 		Translate(ctx, "inlineTipjarMessage"),
@@ -83,6 +90,9 @@ func (bot TipBot) createTipjar(ctx context.Context, text string, sender *tb.User
 	if len(memo) > 0 {
 		inlineMessage = inlineMessage + fmt.Sprintf(Translate(ctx, "inlineTipjarAppendMemo"), memo)
 	}
+	if !expiresAt.IsZero() {
+		inlineMessage = inlineMessage + fmt.Sprintf(Translate(ctx, "inlineAppendExpiry"), expiresAt.UTC().Format("2006-01-02 15:04 UTC"))
+	}
 	id := fmt.Sprintf("tipjar:%s:%d", RandStringRunes(10), amount)
 
 	return &InlineTipjar{
@@ -95,6 +105,7 @@ func (bot TipBot) createTipjar(ctx context.Context, text string, sender *tb.User
 		NTotal:        nTotal,
 		NGiven:        0,
 		GivenAmount:   0,
+		ExpiresAt:     expiresAt,
 		LanguageCode:  ctx.Value("publicLanguageCode").(string),
 	}, nil
 
@@ -119,6 +130,10 @@ func (bot TipBot) makeTipjar(ctx context.Context, m *tb.Message, query bool) (*I
 			bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "inlineTipjarHelpText"), Translate(ctx, "inlineTipjarInvalidPeruserAmountMessage")))
 			bot.tryDeleteMessage(m)
 			return nil, err
+		case errors.InvalidSyntaxError:
+			bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "inlineTipjarHelpText"), Translate(ctx, "inlineInvalidExpiryMessage")))
+			bot.tryDeleteMessage(m)
+			return nil, err
 		case errors.GetBalanceError:
 			// log.Errorln(err.Error())
 			bot.tryDeleteMessage(m)
@@ -149,6 +164,9 @@ func (bot TipBot) makeQueryTipjar(ctx intercept.Context) (*InlineTipjar, error)
 		case errors.InvalidAmountPerUserError:
 			bot.inlineQueryReplyWithError(ctx, TranslateUser(ctx, "inlineTipjarInvalidPeruserAmountMessage"), fmt.Sprintf(TranslateUser(ctx, "inlineQueryTipjarDescription"), bot.Telegram.Me.Username))
 			return nil, err
+		case errors.InvalidSyntaxError:
+			bot.inlineQueryReplyWithError(ctx, TranslateUser(ctx, "inlineInvalidExpiryMessage"), fmt.Sprintf(TranslateUser(ctx, "inlineQueryTipjarDescription"), bot.Telegram.Me.Username))
+			return nil, err
 		case errors.GetBalanceError:
 			bot.inlineQueryReplyWithError(ctx, TranslateUser(ctx, "inlineQueryTipjarTitle"), fmt.Sprintf(TranslateUser(ctx, "inlineQueryTipjarDescription"), bot.Telegram.Me.Username))
 			return nil, err
@@ -189,8 +207,12 @@ func (bot TipBot) tipjarHandler(ctx intercept.Context) (intercept.Context, error
 		return ctx, err
 	}
 	toUserStr := GetUserStr(m.Sender)
-	bot.trySendMessage(m.Chat, inlineTipjar.Message, bot.makeTipjarKeyboard(ctx, inlineTipjar))
+	mTipjar := bot.trySendMessage(m.Chat, inlineTipjar.Message, bot.makeTipjarKeyboard(ctx, inlineTipjar))
 	log.Infof("[tipjar] %s created tipjar %s: %d sat (%d per user)", toUserStr, inlineTipjar.ID, inlineTipjar.Amount, inlineTipjar.PerUserAmount)
+	inlineTipjar.Editable = inlineEditable(mTipjar)
+	if !inlineTipjar.ExpiresAt.IsZero() && inlineTipjar.Editable != nil {
+		bot.startInlineTipjarTimer(inlineTipjar)
+	}
 	return ctx, inlineTipjar.Set(inlineTipjar, bot.Bunt)
 }
 
@@ -256,6 +278,12 @@ func (bot *TipBot) acceptInlineTipjarHandler(ctx intercept.Context) (intercept.C
 		bot.tryEditMessage(c, i18n.Translate(inlineTipjar.LanguageCode, "inlineTipjarCancelledMessage"), &tb.ReplyMarkup{})
 		return ctx, errors.Create(errors.NotActiveError)
 	}
+	// failsafe for expired tipjars whose timer did not fire yet
+	if !inlineTipjar.ExpiresAt.IsZero() && time.Now().After(inlineTipjar.ExpiresAt) {
+		bot.closeExpiredTipjar(c, inlineTipjar)
+		runtime.IgnoreError(inlineTipjar.Set(inlineTipjar, bot.Bunt))
+		return ctx, errors.Create(errors.NotActiveError)
+	}
 
 	if from.Telegram.ID == to.Telegram.ID {
 		bot.trySendMessage(from.Telegram, Translate(ctx, "sendYourselfMessage"))
@@ -317,6 +345,9 @@ func (bot *TipBot) acceptInlineTipjarHandler(ctx intercept.Context) (intercept.C
 		if len(memo) > 0 {
 			inlineTipjar.Message = inlineTipjar.Message + fmt.Sprintf(i18n.Translate(inlineTipjar.LanguageCode, "inlineTipjarAppendMemo"), memo)
 		}
+		if !inlineTipjar.ExpiresAt.IsZero() {
+			inlineTipjar.Message = inlineTipjar.Message + fmt.Sprintf(i18n.Translate(inlineTipjar.LanguageCode, "inlineAppendExpiry"), inlineTipjar.ExpiresAt.UTC().Format("2006-01-02 15:04 UTC"))
+		}
 		// update message
 		log.Infoln(inlineTipjar.Message)
 		bot.tryEditMessage(c, inlineTipjar.Message, bot.makeTipjarKeyboard(ctx, inlineTipjar))
@@ -366,6 +397,44 @@ func (bot *TipBot) cancelInlineTipjarHandler(ctx intercept.Context) (intercept.C
 	return ctx, inlineTipjar.Set(inlineTipjar, bot.Bunt)
 }
 
+// closeExpiredTipjar edits the tipjar message to its final state and sends the summary of what was
+// collected to the creator. The payments of the givers already went to the creator's wallet.
+func (bot *TipBot) closeExpiredTipjar(to tb.Editable, inlineTipjar *InlineTipjar) {
+	inlineTipjar.Message = fmt.Sprintf(
+		i18n.Translate(inlineTipjar.LanguageCode, "inlineTipjarExpiredMessage"),
+		GetUserStrMd(inlineTipjar.To.Telegram),
+		inlineTipjar.GivenAmount,
+		inlineTipjar.NGiven,
+	)
+	bot.tryEditStack(to, inlineTipjar.ID, inlineTipjar.Message, &tb.ReplyMarkup{})
+	log.Infof("[tipjar] Tipjar %s expired. Collected: %d sat", inlineTipjar.ID, inlineTipjar.GivenAmount)
+	if inlineTipjar.Active && inlineTipjar.To.Telegram.ID != 0 {
+		bot.trySendMessage(inlineTipjar.To.Telegram, listTipjarGivers(inlineTipjar))
+	}
+	inlineTipjar.Active = false
+}
+
+// startInlineTipjarTimer closes the tipjar when it expires
+func (bot *TipBot) startInlineTipjarTimer(inlineTipjar *InlineTipjar) {
+	t := runtime.NewResettableFunction(inlineTipjar.ID,
+		runtime.WithTimer(time.NewTimer(time.Until(inlineTipjar.ExpiresAt))))
+	t.Do(func() {
+		mutex.Lock(inlineTipjar.ID)
+		defer mutex.Unlock(inlineTipjar.ID)
+		tx := &InlineTipjar{Base: storage.New(storage.ID(inlineTipjar.ID))}
+		fn, err := tx.Get(tx, bot.Bunt)
+		if err != nil {
+			return
+		}
+		inlineTipjar := fn.(*InlineTipjar)
+		if !inlineTipjar.Active || inlineTipjar.Editable == nil {
+			return
+		}
+		bot.closeExpiredTipjar(inlineTipjar.Editable, inlineTipjar)
+		runtime.IgnoreError(inlineTipjar.Set(inlineTipjar, bot.Bunt))
+	})
+}
+
 func listTipjarGivers(inlineTipjar *InlineTipjar) string {
 	var from_str string
 	from_str = fmt.Sprintf("🍯 *Tipjar summary*\n\nMemo: %s\nCapacity: %d sat\nGivers: %d\nCollected: %d sat\n\n*Givers:*\n\n", inlineTipjar.Memo, inlineTipjar.Amount, inlineTipjar.NGiven, inlineTipjar.GivenAmount)
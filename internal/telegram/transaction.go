@@ -6,6 +6,7 @@ import (
 
 	log "github.com/sirupsen/logrus"
 
+	"github.com/massmux/SatsMobiBot/internal/commands"
 	"github.com/massmux/SatsMobiBot/internal/lnbits"
 	tb "gopkg.in/lightningtipbot/telebot.v3"
 )
@@ -35,6 +36,13 @@ type Transaction struct {
 
 type TransactionOption func(t *Transaction)
 
+// transferMemos are the transaction memos of transfers between users by type
+var transferMemos = map[string]string{
+	"send":   "💸 Send from %s to %s.",
+	"tip":    "🏅 Tip from %s to %s.",
+	"faucet": "🚰 Faucet from %s to %s.",
+}
+
 func TransactionChat(chat *tb.Chat) TransactionOption {
 	return func(t *Transaction) {
 		t.ChatID = chat.ID
@@ -100,9 +108,8 @@ func (t *Transaction) SendTransaction(bot *TipBot, from *lnbits.User, to *lnbits
 	}
 	// check if fromUser has balance
 	if balance < amount {
-		errmsg := fmt.Sprintf("balance too low.")
 		log.Warnf("Balance of user %s too low", fromUserStr)
-		return false, fmt.Errorf(errmsg)
+		return false, commands.ErrBalanceTooLow
 	}
 
 	t.ToWallet = to.ID
@@ -145,3 +152,31 @@ func (t *Transaction) SendTransaction(bot *TipBot, from *lnbits.User, to *lnbits
 
 	return true, err
 }
+
+// transfer moves the amount between two users of the bot and logs the transaction. Sends, tips
+// and faucets of the telegram handlers and of the other frontends are paid with it. A memo is
+// added to the transaction memo. The telegram handlers pass none, they send the memo of the
+// sender to the receiver in a separate message.
+func (bot *TipBot) transfer(from *lnbits.User, to *lnbits.User, amount int64, transactionType string, memo string, opts ...TransactionOption) error {
+	if from.ID == to.ID {
+		return commands.ErrSelfPayment
+	}
+	format, ok := transferMemos[transactionType]
+	if !ok {
+		format = "💸 " + transactionType + " from %s to %s."
+	}
+	t := NewTransaction(bot, from, to, amount, append(opts, TransactionType(transactionType))...)
+	t.Memo = fmt.Sprintf(format, GetUserStr(from.Telegram), GetUserStr(to.Telegram))
+	if len(memo) > 0 {
+		t.Memo += " ✉️ " + memo
+	}
+	success, err := t.Send()
+	if err != nil {
+		return fmt.Errorf("transaction failed: %w", err)
+	}
+	if !success {
+		return fmt.Errorf("transaction failed")
+	}
+	log.Infof("[💸 %s] From %s to %s (%d sat).", transactionType, GetUserStr(from.Telegram), GetUserStr(to.Telegram), amount)
+	return nil
+}
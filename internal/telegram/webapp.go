@@ -0,0 +1,37 @@
+package telegram
+
+import (
+	"fmt"
+
+	"github.com/massmux/SatsMobiBot/internal/commands"
+	"github.com/massmux/SatsMobiBot/internal/i18n"
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+)
+
+// WebAppSend sends sats from the wallet to a @username, a matrix id or a linked npub
+// and notifies telegram receivers. It is used by the Mini App.
+func (bot *TipBot) WebAppSend(user *lnbits.User, recipient string, amount int64, memo string) (commands.User, error) {
+	if amount < 1 {
+		return commands.User{}, commands.ErrInvalidAmount
+	}
+	service := bot.WalletService()
+	from := CommandUser(user)
+	to, err := service.Resolve(from, recipient)
+	if err != nil {
+		return commands.User{}, err
+	}
+	if to.Frontend == from.Frontend && to.ID == from.ID {
+		return commands.User{}, commands.ErrSelfPayment
+	}
+	if err = service.Transfer(from, to, amount, memo, "send"); err != nil {
+		return commands.User{}, err
+	}
+	text := fmt.Sprintf(i18n.Translate(to.LanguageCode, "walletCommandReceivedMessage"), from, amount)
+	if len(memo) > 0 {
+		text += "\n✉️ " + memo
+	}
+	if to.Frontend == TelegramFrontend {
+		_ = TelegramMessenger{bot: bot}.Notify(to, text)
+	}
+	return to, nil
+}
@@ -0,0 +1,336 @@
+package telegram
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	lnurl "github.com/fiatjaf/go-lnurl"
+	decodepay "github.com/fiatjaf/ln-decodepay"
+	"github.com/massmux/SatsMobiBot/internal"
+	"github.com/massmux/SatsMobiBot/internal/errors"
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	"github.com/massmux/SatsMobiBot/internal/network"
+	"github.com/massmux/SatsMobiBot/internal/str"
+	"github.com/massmux/SatsMobiBot/internal/telegram/intercept"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	nostrKindZapRequest = 9734
+	zapQueryTimeout     = 5 * time.Second
+	zapMaxRelays        = 10
+	zapMaxCommentLength = 280
+)
+
+// zapTarget is the decoded recipient of a zap
+type zapTarget struct {
+	PubKey  string
+	EventID string
+	Relays  []string
+}
+
+// zapPayParams are the LNURL-pay parameters including the NIP-57 fields
+type zapPayParams struct {
+	Callback    string `json:"callback"`
+	MinSendable int64  `json:"minSendable"`
+	MaxSendable int64  `json:"maxSendable"`
+	AllowsNostr bool   `json:"allowsNostr"`
+	NostrPubkey string `json:"nostrPubkey"`
+	Status      string `json:"status"`
+	Reason      string `json:"reason"`
+}
+
+// isNostrEntity returns true for npub, note, nevent and nprofile strings
+func isNostrEntity(s string) bool {
+	for _, prefix := range []string{"npub1", "note1", "nevent1", "nprofile1", "nostr:"} {
+		if strings.HasPrefix(strings.ToLower(s), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeZapTarget decodes a NIP-19 entity into a zap target
+func decodeZapTarget(entity string) (*zapTarget, error) {
+	entity = strings.TrimPrefix(strings.ToLower(entity), "nostr:")
+	prefix, value, err := nip19.Decode(entity)
+	if err != nil {
+		return nil, err
+	}
+	switch prefix {
+	case "npub":
+		return &zapTarget{PubKey: value.(string)}, nil
+	case "nprofile":
+		pointer := value.(nostr.ProfilePointer)
+		return &zapTarget{PubKey: pointer.PublicKey, Relays: pointer.Relays}, nil
+	case "note":
+		return &zapTarget{EventID: value.(string)}, nil
+	case "nevent":
+		pointer := value.(nostr.EventPointer)
+		return &zapTarget{EventID: pointer.ID, Relays: pointer.Relays}, nil
+	}
+	return nil, fmt.Errorf("can't zap a %s", prefix)
+}
+
+// zapRelays returns the relays of the target followed by the given and the bot relays
+func (target *zapTarget) zapRelays(relays ...string) []string {
+	relays = uniqueSlice(cleanUrls(append(append(append([]string{}, target.Relays...), relays...), nostrRelays()...)))
+	if len(relays) > zapMaxRelays {
+		relays = relays[:zapMaxRelays]
+	}
+	return relays
+}
+
+// queryNostrRelays queries all relays concurrently and returns the events of all relays
+func queryNostrRelays(relays []string, filter nostr.Filter) []*nostr.Event {
+	events := make([]*nostr.Event, 0)
+	lock := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for _, relayURL := range relays {
+		wg.Add(1)
+		go func(relayURL string) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), zapQueryTimeout)
+			defer cancel()
+			relay, err := nostr.RelayConnect(ctx, relayURL)
+			if err != nil {
+				log.Debugf("[zap] could not connect to %s: %s", relayURL, err.Error())
+				return
+			}
+			defer relay.Close()
+			for _, ev := range relay.QuerySync(ctx, filter) {
+				if ev == nil {
+					continue
+				}
+				lock.Lock()
+				events = append(events, ev)
+				lock.Unlock()
+			}
+		}(relayURL)
+	}
+	wg.Wait()
+	return events
+}
+
+// resolveZapTarget finds the author of a zapped note and the lightning address of the recipient's kind-0 profile
+func resolveZapTarget(target *zapTarget, relays []string) (string, error) {
+	if len(target.EventID) > 0 {
+		events := queryNostrRelays(relays, nostr.Filter{IDs: []string{target.EventID}, Limit: 1})
+		if len(events) == 0 {
+			return "", fmt.Errorf("note not found")
+		}
+		target.PubKey = events[0].PubKey
+	}
+	events := queryNostrRelays(relays, nostr.Filter{Kinds: []int{nostr.KindSetMetadata}, Authors: []string{target.PubKey}, Limit: 1})
+	var profile *nostr.Event
+	for _, ev := range events {
+		if profile == nil || ev.CreatedAt.After(profile.CreatedAt) {
+			profile = ev
+		}
+	}
+	if profile == nil {
+		return "", fmt.Errorf("profile not found")
+	}
+	metadata := struct {
+		Lud16 string `json:"lud16"`
+		Lud06 string `json:"lud06"`
+	}{}
+	if err := json.Unmarshal([]byte(profile.Content), &metadata); err != nil {
+		return "", fmt.Errorf("invalid profile")
+	}
+	if len(metadata.Lud16) > 0 {
+		return strings.TrimSpace(metadata.Lud16), nil
+	}
+	if len(metadata.Lud06) > 0 {
+		return strings.TrimSpace(metadata.Lud06), nil
+	}
+	return "", fmt.Errorf("profile has no lightning address")
+}
+
+// fetchZapPayParams fetches the LNURL-pay parameters of a lightning address or LNURL
+func fetchZapPayParams(address string) (*zapPayParams, error) {
+	var rawurl string
+	if name, domain, ok := lnurl.ParseInternetIdentifier(address); ok {
+		scheme := "https://"
+		if strings.HasSuffix(domain, ".onion") {
+			scheme = "http://"
+		}
+		rawurl = scheme + domain + "/.well-known/lnurlp/" + name
+	} else {
+		var err error
+		rawurl, err = lnurl.LNURLDecodeStrict(address)
+		if err != nil {
+			return nil, err
+		}
+	}
+	parsed, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	client, err := network.GetClientForScheme(parsed)
+	if err != nil {
+		return nil, err
+	}
+	res, err := client.Get(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	params := &zapPayParams{}
+	if err = json.Unmarshal(body, params); err != nil {
+		return nil, err
+	}
+	if params.Status == "ERROR" {
+		return nil, fmt.Errorf(params.Reason)
+	}
+	if !params.AllowsNostr || len(params.NostrPubkey) == 0 {
+		return nil, fmt.Errorf("the wallet of the recipient does not support zaps")
+	}
+	return params, nil
+}
+
+// zapPrivateKey derives the nostr key a user zaps with from the bot key, so zaps of
+// different users can't be linked to each other or to the bot
+func zapPrivateKey(user *lnbits.User) string {
+	mac := hmac.New(sha256.New, []byte(internal.Configuration.Nostr.PrivateKey))
+	mac.Write([]byte(fmt.Sprintf("zap:%d", user.Telegram.ID)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// zapHandler is invoked on /zap <npub|note|nevent|nprofile> <amount> [<comment>].
+// Without a nostr recipient, /zap is a tip as before.
+func (bot *TipBot) zapHandler(ctx intercept.Context) (intercept.Context, error) {
+	m := ctx.Message()
+	entity, err := getArgumentFromCommand(m.Text, 1)
+	if err != nil || !isNostrEntity(entity) {
+		return bot.tipHandler(ctx)
+	}
+	user := LoadUser(ctx)
+	if user.Wallet == nil {
+		return ctx, errors.Create(errors.UserNoWalletError)
+	}
+	if len(internal.Configuration.Nostr.PrivateKey) == 0 {
+		bot.trySendMessage(m.Sender, Translate(ctx, "zapNotAvailableMessage"))
+		return ctx, errors.Create(errors.NotActiveError)
+	}
+	target, err := decodeZapTarget(entity)
+	if err != nil {
+		bot.trySendMessage(m.Sender, Translate(ctx, "zapHelpMessage"))
+		return ctx, errors.New(errors.InvalidSyntaxError, err)
+	}
+	amountStr, err := getArgumentFromCommand(m.Text, 2)
+	if err != nil {
+		bot.trySendMessage(m.Sender, Translate(ctx, "zapHelpMessage"))
+		return ctx, errors.New(errors.InvalidAmountError, err)
+	}
+	amount, err := GetAmount(amountStr)
+	if err != nil || amount < 1 {
+		bot.trySendMessage(m.Sender, Translate(ctx, "zapHelpMessage"))
+		return ctx, errors.Create(errors.InvalidAmountError)
+	}
+	comment := GetMemoFromCommand(m.Text, 3)
+	comment = str.Truncate(comment, zapMaxCommentLength)
+	balance, err := bot.GetUserBalance(user)
+	if err != nil {
+		return ctx, err
+	}
+	if balance < amount {
+		bot.trySendMessage(m.Sender, fmt.Sprintf(Translate(ctx, "insufficientFundsMessage"), balance, amount))
+		return ctx, errors.Create(errors.BalanceToLowError)
+	}
+
+	zapMsg := bot.trySendMessageEditable(m.Chat, Translate(ctx, "zapResolvingMessage"))
+	relays := target.zapRelays()
+	if settings, err := GetLnbitsUserWithSettings(user.Telegram, *bot); err == nil {
+		relays = target.zapRelays(userNostrRelays(settings)...)
+	}
+	address, err := resolveZapTarget(target, relays)
+	if err != nil {
+		bot.tryEditMessage(zapMsg, fmt.Sprintf(Translate(ctx, "zapFailedMessage"), str.MarkdownEscape(err.Error())))
+		return ctx, err
+	}
+	params, err := fetchZapPayParams(address)
+	if err != nil {
+		bot.tryEditMessage(zapMsg, fmt.Sprintf(Translate(ctx, "zapFailedMessage"), str.MarkdownEscape(err.Error())))
+		return ctx, err
+	}
+	amountMsat := amount * 1000
+	if amountMsat < params.MinSendable || amountMsat > params.MaxSendable {
+		err = fmt.Errorf("amount out of bounds (min: %d sat, max: %d sat)", params.MinSendable/1000, params.MaxSendable/1000)
+		bot.tryEditMessage(zapMsg, fmt.Sprintf(Translate(ctx, "zapFailedMessage"), str.MarkdownEscape(err.Error())))
+		return ctx, err
+	}
+
+	// build the zap request (NIP-57)
+	pk := zapPrivateKey(user)
+	pub, err := nostr.GetPublicKey(pk)
+	if err != nil {
+		return ctx, err
+	}
+	tags := nostr.Tags{
+		append(nostr.Tag{"relays"}, relays...),
+		nostr.Tag{"amount", strconv.FormatInt(amountMsat, 10)},
+		nostr.Tag{"p", target.PubKey},
+	}
+	if len(target.EventID) > 0 {
+		tags = append(tags, nostr.Tag{"e", target.EventID})
+	}
+	if !strings.Contains(address, "@") {
+		tags = append(tags, nostr.Tag{"lnurl", address})
+	}
+	zapRequest := nostr.Event{
+		PubKey:    pub,
+		CreatedAt: time.Now(),
+		Kind:      nostrKindZapRequest,
+		Tags:      tags,
+		Content:   comment,
+	}
+	if err = zapRequest.Sign(pk); err != nil {
+		return ctx, err
+	}
+	zapRequestJson, err := json.Marshal(zapRequest)
+	if err != nil {
+		return ctx, err
+	}
+
+	pr, err := fetchLnurlPayCallback(params.Callback, amountMsat, url.Values{"nostr": {string(zapRequestJson)}})
+	if err != nil {
+		bot.tryEditMessage(zapMsg, fmt.Sprintf(Translate(ctx, "zapFailedMessage"), str.MarkdownEscape(err.Error())))
+		return ctx, err
+	}
+	// the invoice has to commit to the zap request
+	bolt11, err := decodepay.Decodepay(pr)
+	if err != nil {
+		return ctx, err
+	}
+	descriptionHash := sha256.Sum256(zapRequestJson)
+	if bolt11.DescriptionHash != hex.EncodeToString(descriptionHash[:]) {
+		err = fmt.Errorf("invoice does not commit to the zap request")
+		bot.tryEditMessage(zapMsg, fmt.Sprintf(Translate(ctx, "zapFailedMessage"), str.MarkdownEscape(err.Error())))
+		return ctx, err
+	}
+	if _, err = user.Wallet.Pay(lnbits.PaymentParams{Out: true, Bolt11: pr}, bot.Client); err != nil {
+		bot.tryEditMessage(zapMsg, fmt.Sprintf(Translate(ctx, "zapFailedMessage"), str.MarkdownEscape(err.Error())))
+		return ctx, err
+	}
+	recipient, _ := nip19.EncodePublicKey(target.PubKey)
+	log.Infof("[zap] %s zapped %d sat to %s", GetUserStr(user.Telegram), amount, recipient)
+	bot.tryEditMessage(zapMsg, fmt.Sprintf(Translate(ctx, "zapSentMessage"), amount, recipient))
+	return ctx, nil
+}
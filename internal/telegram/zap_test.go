@@ -0,0 +1,54 @@
+package telegram
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/massmux/SatsMobiBot/internal/lnbits"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	tb "gopkg.in/lightningtipbot/telebot.v3"
+)
+
+func Test_decodeZapTarget(t *testing.T) {
+	pubkey := strings.Repeat("ab", 32)
+	npub, _ := nip19.EncodePublicKey(pubkey)
+	note, _ := nip19.EncodeNote(strings.Repeat("cd", 32))
+	nsec, _ := nip19.EncodePrivateKey(strings.Repeat("ef", 32))
+
+	for _, entity := range []string{npub, "nostr:" + npub, strings.ToUpper(npub)} {
+		if !isNostrEntity(entity) {
+			t.Errorf("isNostrEntity(%s) = false", entity)
+		}
+		target, err := decodeZapTarget(entity)
+		if err != nil || target.PubKey != pubkey {
+			t.Errorf("decodeZapTarget(%s) = %+v, %v", entity, target, err)
+		}
+	}
+	target, err := decodeZapTarget(note)
+	if err != nil || target.EventID != strings.Repeat("cd", 32) {
+		t.Errorf("decodeZapTarget(note) = %+v, %v", target, err)
+	}
+	if isNostrEntity(nsec) {
+		t.Errorf("isNostrEntity() accepted a private key")
+	}
+	if _, err := decodeZapTarget(nsec); err == nil {
+		t.Errorf("decodeZapTarget() accepted a private key")
+	}
+	if _, err := decodeZapTarget("npub1invalid"); err == nil {
+		t.Errorf("decodeZapTarget() accepted an invalid npub")
+	}
+}
+
+func Test_zapPrivateKey(t *testing.T) {
+	alice := &lnbits.User{Telegram: &tb.User{ID: 1}}
+	bob := &lnbits.User{Telegram: &tb.User{ID: 2}}
+	if zapPrivateKey(alice) != zapPrivateKey(alice) {
+		t.Errorf("zapPrivateKey() is not stable")
+	}
+	if zapPrivateKey(alice) == zapPrivateKey(bob) {
+		t.Errorf("zapPrivateKey() is the same for different users")
+	}
+	if len(zapPrivateKey(alice)) != 64 {
+		t.Errorf("zapPrivateKey() = %s, want 32 bytes hex", zapPrivateKey(alice))
+	}
+}
@@ -0,0 +1,81 @@
+// Package webapp authenticates users of the Telegram Mini App. Telegram signs the initData
+// of the Mini App with the bot token. A valid initData is exchanged for a short-lived session.
+package webapp
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InitDataMaxAge is how long after its creation by Telegram an initData is accepted.
+// The Mini App exchanges it for a session right after it was opened.
+const InitDataMaxAge = 5 * time.Minute
+
+// User is the telegram user in the initData
+type User struct {
+	ID           int64  `json:"id"`
+	FirstName    string `json:"first_name"`
+	LastName     string `json:"last_name"`
+	Username     string `json:"username"`
+	LanguageCode string `json:"language_code"`
+}
+
+// InitData is a validated initData of the Mini App
+type InitData struct {
+	User     User
+	AuthDate time.Time
+	QueryID  string
+}
+
+// Validate checks the hash of the initData with the bot token, see
+// https://core.telegram.org/bots/webapps#validating-data-received-via-the-mini-app
+func Validate(initData string, botToken string, maxAge time.Duration) (InitData, error) {
+	values, err := url.ParseQuery(initData)
+	if err != nil {
+		return InitData{}, err
+	}
+	hash := values.Get("hash")
+	if len(hash) == 0 {
+		return InitData{}, fmt.Errorf("initData has no hash")
+	}
+	if !hmac.Equal([]byte(hash), []byte(sign(values, botToken))) {
+		return InitData{}, fmt.Errorf("invalid initData hash")
+	}
+	authDate, err := strconv.ParseInt(values.Get("auth_date"), 10, 64)
+	if err != nil {
+		return InitData{}, fmt.Errorf("invalid auth_date")
+	}
+	data := InitData{AuthDate: time.Unix(authDate, 0), QueryID: values.Get("query_id")}
+	if time.Since(data.AuthDate) > maxAge {
+		return InitData{}, fmt.Errorf("initData expired")
+	}
+	if err = json.Unmarshal([]byte(values.Get("user")), &data.User); err != nil || data.User.ID == 0 {
+		return InitData{}, fmt.Errorf("initData has no user")
+	}
+	return data, nil
+}
+
+// sign returns the hex HMAC of the sorted key=value lines of all fields except the hash
+func sign(values url.Values, botToken string) string {
+	var lines []string
+	for key := range values {
+		if key == "hash" {
+			continue
+		}
+		lines = append(lines, key+"="+values.Get(key))
+	}
+	sort.Strings(lines)
+	secret := hmac.New(sha256.New, []byte("WebAppData"))
+	secret.Write([]byte(botToken))
+	mac := hmac.New(sha256.New, secret.Sum(nil))
+	mac.Write([]byte(strings.Join(lines, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,98 @@
+package webapp
+
+import (
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const botToken = "123456:test-token"
+
+func signedInitData(authDate time.Time, user string) url.Values {
+	values := url.Values{}
+	values.Set("query_id", "AAHdF6IQAAAAAN0XohDhrOrc")
+	values.Set("user", user)
+	values.Set("auth_date", strconv.FormatInt(authDate.Unix(), 10))
+	values.Set("hash", sign(values, botToken))
+	return values
+}
+
+func Test_Validate(t *testing.T) {
+	user := `{"id":279058397,"first_name":"Alice","username":"alice","language_code":"en"}`
+	valid := signedInitData(time.Now(), user)
+	tampered := signedInitData(time.Now(), user)
+	tampered.Set("user", `{"id":1,"first_name":"Mallory"}`)
+	tests := []struct {
+		name     string
+		initData string
+		token    string
+		wantID   int64
+		wantErr  bool
+	}{
+		{name: "valid", initData: valid.Encode(), token: botToken, wantID: 279058397},
+		{name: "wrong token", initData: valid.Encode(), token: "654321:other", wantErr: true},
+		{name: "tampered user", initData: tampered.Encode(), token: botToken, wantErr: true},
+		{name: "expired", initData: signedInitData(time.Now().Add(-2*InitDataMaxAge), user).Encode(), token: botToken, wantErr: true},
+		{name: "no user", initData: signedInitData(time.Now(), "").Encode(), token: botToken, wantErr: true},
+		{name: "no hash", initData: "auth_date=1&user=%7B%7D", token: botToken, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := Validate(tt.initData, tt.token, InitDataMaxAge)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if data.User.ID != tt.wantID {
+				t.Errorf("Validate() user id = %d, want %d", data.User.ID, tt.wantID)
+			}
+		})
+	}
+}
+
+// fixedInitData is signed with botToken independently of sign(), following the algorithm in the Telegram docs
+const (
+	fixedInitData = "query_id=AAHdF6IQAAAAAN0XohDhrOrc&user=%7B%22id%22%3A279058397%2C%22first_name%22%3A%22Vladislav%22%2C%22last_name%22%3A%22Kibenko%22%2C%22username%22%3A%22vdkfrost%22%2C%22language_code%22%3A%22ru%22%2C%22is_premium%22%3Atrue%7D&auth_date=1662771648&hash=4c17b844cfcda2889edbd4e7315a1ccb95958aeb7aba5249efdf9d1499e6da99"
+	fixedHash     = "4c17b844cfcda2889edbd4e7315a1ccb95958aeb7aba5249efdf9d1499e6da99"
+)
+
+func Test_Validate_fixed(t *testing.T) {
+	values, err := url.ParseQuery(fixedInitData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sign(values, botToken); got != fixedHash {
+		t.Errorf("sign() = %s, want %s", got, fixedHash)
+	}
+	authDate := time.Unix(1662771648, 0)
+	data, err := Validate(fixedInitData, botToken, time.Since(authDate)+time.Hour)
+	if err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	if data.User.ID != 279058397 || data.User.Username != "vdkfrost" || !data.AuthDate.Equal(authDate) {
+		t.Errorf("Validate() = %+v", data)
+	}
+	if _, err = Validate(fixedInitData, botToken, InitDataMaxAge); err == nil {
+		t.Errorf("Validate() accepted an initData from %s", authDate)
+	}
+}
+
+func Test_Sessions(t *testing.T) {
+	sessions := NewSessions(time.Minute)
+	session, err := sessions.Create(42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := sessions.Get(session.Token); !ok || got.TelegramID != 42 {
+		t.Errorf("Get() = %v, %v", got, ok)
+	}
+	sessions.Delete(session.Token)
+	if _, ok := sessions.Get(session.Token); ok {
+		t.Errorf("Get() found a deleted session")
+	}
+	expired := NewSessions(-time.Second)
+	session, _ = expired.Create(42)
+	if _, ok := expired.Get(session.Token); ok {
+		t.Errorf("Get() found an expired session")
+	}
+}
@@ -0,0 +1,71 @@
+package webapp
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// SessionDuration is how long a session of the Mini App is valid
+const SessionDuration = 30 * time.Minute
+
+// Session authorizes requests of a telegram user to the API
+type Session struct {
+	Token      string    `json:"token"`
+	TelegramID int64     `json:"-"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// Sessions keeps the sessions in memory. Users open the Mini App again after a restart,
+// which creates a new session.
+type Sessions struct {
+	lock     sync.Mutex
+	duration time.Duration
+	sessions map[string]Session
+}
+
+func NewSessions(duration time.Duration) *Sessions {
+	return &Sessions{duration: duration, sessions: make(map[string]Session)}
+}
+
+// Create starts a session for the telegram user
+func (s *Sessions) Create(telegramID int64) (Session, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return Session{}, err
+	}
+	session := Session{Token: hex.EncodeToString(b), TelegramID: telegramID, ExpiresAt: time.Now().Add(s.duration)}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.removeExpired()
+	s.sessions[session.Token] = session
+	return session, nil
+}
+
+// Get returns the session of the token if it did not expire
+func (s *Sessions) Get(token string) (Session, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	session, ok := s.sessions[token]
+	if !ok || time.Now().After(session.ExpiresAt) {
+		return Session{}, false
+	}
+	return session, true
+}
+
+// Delete ends a session
+func (s *Sessions) Delete(token string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.sessions, token)
+}
+
+func (s *Sessions) removeExpired() {
+	now := time.Now()
+	for token, session := range s.sessions {
+		if now.After(session.ExpiresAt) {
+			delete(s.sessions, token)
+		}
+	}
+}
@@ -62,7 +62,7 @@ func startApiServer(bot *telegram.TipBot) {
 
 	// nostr nip05 identifier
 	nostr := nostr.New(bot)
-	s.AppendRoute("/.well-known/nostr.json", nostr.Handle, http.MethodGet)
+	s.AppendRoute("/.well-known/nostr.json", nostr.Handle, http.MethodGet, http.MethodOptions)
 
 	// append lndhub ctx functions
 	hub := lndhub.New(bot)
@@ -79,6 +79,17 @@ func startApiServer(bot *telegram.TipBot) {
 	s.AppendAuthorizedRoute(`/api/v1/createinvoice`, api.AuthTypeBasic, api.AccessKeyTypeInvoice, bot.DB.Users, apiService.CreateInvoice, http.MethodPost)
 	s.AppendAuthorizedRoute(`/api/v1/balance`, api.AuthTypeBasic, api.AccessKeyTypeInvoice, bot.DB.Users, apiService.Balance, http.MethodGet)
 
+	// telegram mini app wallet. The mini app authenticates with a session from /app/auth,
+	// REST clients can use these routes with the admin key as well.
+	s.AppendRoute(`/app/auth`, apiService.WebAppAuth, http.MethodPost)
+	s.AppendRoute(`/app/logout`, apiService.WebAppLogout, http.MethodPost)
+	s.AppendAuthorizedRoute(`/app/api/balance`, api.AuthTypeBasic, api.AccessKeyTypeAdmin, bot.DB.Users, apiService.Balance, http.MethodGet)
+	s.AppendAuthorizedRoute(`/app/api/payments`, api.AuthTypeBasic, api.AccessKeyTypeAdmin, bot.DB.Users, apiService.WebAppPayments, http.MethodGet)
+	s.AppendAuthorizedRoute(`/app/api/invoice`, api.AuthTypeBasic, api.AccessKeyTypeAdmin, bot.DB.Users, apiService.CreateInvoice, http.MethodPost)
+	s.AppendAuthorizedRoute(`/app/api/pay`, api.AuthTypeBasic, api.AccessKeyTypeAdmin, bot.DB.Users, apiService.WebAppPay, http.MethodPost)
+	s.AppendAuthorizedRoute(`/app/api/send`, api.AuthTypeBasic, api.AccessKeyTypeAdmin, bot.DB.Users, apiService.WebAppSend, http.MethodPost)
+	s.AppendAuthorizedRoute(`/app/api/settings`, api.AuthTypeBasic, api.AccessKeyTypeAdmin, bot.DB.Users, apiService.WebAppSettings, http.MethodGet, http.MethodPost)
+
 	// start internal admin server
 	adminService := admin.New(bot)
 	internalAdminServer := api.NewServer(internal.Configuration.Bot.AdminAPIHost)
@@ -88,6 +99,8 @@ func startApiServer(bot *telegram.TipBot) {
 	internalAdminServer.AppendRoute("/admin/unban/{id}", adminService.UnbanUser)
 	internalAdminServer.AppendRoute("/admin/dalle/enable", adminService.EnableDalle)
 	internalAdminServer.AppendRoute("/admin/dalle/disable", adminService.DisableDalle)
+	internalAdminServer.AppendRoute("/admin/nostr/relays", adminService.NostrRelays)
+	internalAdminServer.AppendRoute("/admin/nostr/events", adminService.NostrEvents)
 	internalAdminServer.PathPrefix("/debug/pprof/", http.DefaultServeMux)
 
 }
@@ -0,0 +1,107 @@
+package nip17
+
+import (
+	"encoding/json"
+	"math/rand"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const (
+	KindPrivateDirectMessage = 14
+	KindSeal                 = 13
+	KindGiftWrap             = 1059
+
+	// timestamps of seals and gift wraps are randomized up to two days into the past
+	maxTimestampTweak = 2 * 24 * time.Hour
+)
+
+// rumor is an unsigned event. It is serialized with its id but without a signature.
+type rumor struct {
+	ID        string     `json:"id"`
+	PubKey    string     `json:"pubkey"`
+	CreatedAt int64      `json:"created_at"`
+	Kind      int        `json:"kind"`
+	Tags      nostr.Tags `json:"tags"`
+	Content   string     `json:"content"`
+}
+
+func randomTimestamp() time.Time {
+	return time.Now().Add(-time.Duration(rand.Int63n(int64(maxTimestampTweak))))
+}
+
+// GiftWrap returns a gift wrapped private direct message from the owner of sk to the
+// receiver pubkey. The gift wrap is signed by a random key and can be published as is.
+func GiftWrap(message string, receiver string, sk string) (nostr.Event, error) {
+	pub, err := nostr.GetPublicKey(sk)
+	if err != nil {
+		return nostr.Event{}, err
+	}
+	dm := nostr.Event{
+		PubKey:    pub,
+		CreatedAt: time.Now(),
+		Kind:      KindPrivateDirectMessage,
+		Tags:      nostr.Tags{nostr.Tag{"p", receiver}},
+		Content:   message,
+	}
+	rumorJson, err := json.Marshal(rumor{
+		ID:        dm.GetID(),
+		PubKey:    dm.PubKey,
+		CreatedAt: dm.CreatedAt.Unix(),
+		Kind:      dm.Kind,
+		Tags:      dm.Tags,
+		Content:   dm.Content,
+	})
+	if err != nil {
+		return nostr.Event{}, err
+	}
+
+	// the seal is signed by the sender and encrypted to the receiver
+	conversationKey, err := ConversationKey(receiver, sk)
+	if err != nil {
+		return nostr.Event{}, err
+	}
+	sealContent, err := Encrypt(string(rumorJson), conversationKey)
+	if err != nil {
+		return nostr.Event{}, err
+	}
+	seal := nostr.Event{
+		PubKey:    pub,
+		CreatedAt: randomTimestamp(),
+		Kind:      KindSeal,
+		Tags:      nostr.Tags{},
+		Content:   sealContent,
+	}
+	if err = seal.Sign(sk); err != nil {
+		return nostr.Event{}, err
+	}
+	sealJson, err := json.Marshal(seal)
+	if err != nil {
+		return nostr.Event{}, err
+	}
+
+	// the gift wrap hides the sender behind a one-time key
+	wrapKey := nostr.GeneratePrivateKey()
+	wrapPub, err := nostr.GetPublicKey(wrapKey)
+	if err != nil {
+		return nostr.Event{}, err
+	}
+	conversationKey, err = ConversationKey(receiver, wrapKey)
+	if err != nil {
+		return nostr.Event{}, err
+	}
+	wrapContent, err := Encrypt(string(sealJson), conversationKey)
+	if err != nil {
+		return nostr.Event{}, err
+	}
+	wrap := nostr.Event{
+		PubKey:    wrapPub,
+		CreatedAt: randomTimestamp(),
+		Kind:      KindGiftWrap,
+		Tags:      nostr.Tags{nostr.Tag{"p", receiver}},
+		Content:   wrapContent,
+	}
+	err = wrap.Sign(wrapKey)
+	return wrap, err
+}
@@ -0,0 +1,146 @@
+package nip17
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+
+	"github.com/nbd-wtf/go-nostr/nip04"
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	nip44Version   = 2
+	nip44MinLength = 1
+	nip44MaxLength = 65535
+)
+
+// ConversationKey returns the NIP-44 conversation key of a private and a public key (hex)
+func ConversationKey(pub string, sk string) ([]byte, error) {
+	sharedX, err := nip04.ComputeSharedSecret(pub, sk)
+	if err != nil {
+		return nil, err
+	}
+	return hkdf.Extract(sha256.New, sharedX, []byte("nip44-v2")), nil
+}
+
+// paddedLength returns the length of the padded plaintext as defined by NIP-44
+func paddedLength(length int) int {
+	if length <= 32 {
+		return 32
+	}
+	nextPower := 1 << bits.Len(uint(length-1))
+	chunk := 32
+	if nextPower > 256 {
+		chunk = nextPower / 8
+	}
+	return chunk * ((length-1)/chunk + 1)
+}
+
+// messageKeys derives the ChaCha20 key and nonce and the HMAC key of a message from its nonce
+func messageKeys(conversationKey []byte, nonce []byte) (chachaKey []byte, chachaNonce []byte, hmacKey []byte, err error) {
+	if len(conversationKey) != 32 {
+		return nil, nil, nil, fmt.Errorf("invalid conversation key length %d", len(conversationKey))
+	}
+	if len(nonce) != 32 {
+		return nil, nil, nil, fmt.Errorf("invalid nonce length %d", len(nonce))
+	}
+	keys := make([]byte, 76)
+	if _, err = io.ReadFull(hkdf.Expand(sha256.New, conversationKey, nonce), keys); err != nil {
+		return nil, nil, nil, err
+	}
+	return keys[0:32], keys[32:44], keys[44:76], nil
+}
+
+// hmacAad returns the HMAC-SHA256 of the ciphertext with the nonce as associated data
+func hmacAad(hmacKey []byte, nonce []byte, ciphertext []byte) []byte {
+	mac := hmac.New(sha256.New, hmacKey)
+	mac.Write(nonce)
+	mac.Write(ciphertext)
+	return mac.Sum(nil)
+}
+
+// Encrypt encrypts plaintext with a conversation key using NIP-44 version 2
+func Encrypt(plaintext string, conversationKey []byte) (string, error) {
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return encrypt(plaintext, conversationKey, nonce)
+}
+
+func encrypt(plaintext string, conversationKey []byte, nonce []byte) (string, error) {
+	if len(plaintext) < nip44MinLength || len(plaintext) > nip44MaxLength {
+		return "", fmt.Errorf("invalid plaintext length %d", len(plaintext))
+	}
+	chachaKey, chachaNonce, hmacKey, err := messageKeys(conversationKey, nonce)
+	if err != nil {
+		return "", err
+	}
+
+	padded := make([]byte, 2+paddedLength(len(plaintext)))
+	binary.BigEndian.PutUint16(padded, uint16(len(plaintext)))
+	copy(padded[2:], plaintext)
+
+	cipher, err := chacha20.NewUnauthenticatedCipher(chachaKey, chachaNonce)
+	if err != nil {
+		return "", err
+	}
+	ciphertext := make([]byte, len(padded))
+	cipher.XORKeyStream(ciphertext, padded)
+
+	payload := make([]byte, 0, 1+len(nonce)+len(ciphertext)+sha256.Size)
+	payload = append(payload, nip44Version)
+	payload = append(payload, nonce...)
+	payload = append(payload, ciphertext...)
+	payload = append(payload, hmacAad(hmacKey, nonce, ciphertext)...)
+	return base64.StdEncoding.EncodeToString(payload), nil
+}
+
+// Decrypt decrypts a NIP-44 version 2 payload with a conversation key. The MAC is checked
+// before anything is decrypted.
+func Decrypt(payload string, conversationKey []byte) (string, error) {
+	// 132 to 87472 base64 characters carry 99 to 65603 bytes
+	if len(payload) < 132 || len(payload) > 87472 {
+		return "", fmt.Errorf("invalid payload length %d", len(payload))
+	}
+	if payload[0] == '#' {
+		return "", fmt.Errorf("unknown version")
+	}
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return "", fmt.Errorf("invalid base64: %w", err)
+	}
+	if len(data) < 99 || len(data) > 65603 {
+		return "", fmt.Errorf("invalid data length %d", len(data))
+	}
+	if data[0] != nip44Version {
+		return "", fmt.Errorf("unknown version %d", data[0])
+	}
+	nonce, ciphertext, mac := data[1:33], data[33:len(data)-32], data[len(data)-32:]
+	chachaKey, chachaNonce, hmacKey, err := messageKeys(conversationKey, nonce)
+	if err != nil {
+		return "", err
+	}
+	if !hmac.Equal(mac, hmacAad(hmacKey, nonce, ciphertext)) {
+		return "", fmt.Errorf("invalid MAC")
+	}
+
+	cipher, err := chacha20.NewUnauthenticatedCipher(chachaKey, chachaNonce)
+	if err != nil {
+		return "", err
+	}
+	padded := make([]byte, len(ciphertext))
+	cipher.XORKeyStream(padded, ciphertext)
+	length := int(binary.BigEndian.Uint16(padded))
+	if length < nip44MinLength || length > nip44MaxLength || len(padded) != 2+paddedLength(length) {
+		return "", fmt.Errorf("invalid padding")
+	}
+	return string(padded[2 : 2+length]), nil
+}
@@ -0,0 +1,236 @@
+package nip17
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// test vectors from https://github.com/paulmillr/nip44 (nip44.vectors.json, v2)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestConversationKey(t *testing.T) {
+	tests := []struct {
+		sec1            string
+		pub2            string
+		conversationKey string
+	}{
+		{
+			sec1:            "315e59ff51cb9209768cf7da80791ddcaae56ac9775eb25b6dee1234bc5d2268",
+			pub2:            "c2f9d9948dc8c7c38321e4b85c8558872eafa0641cd269db76848a6073e69133",
+			conversationKey: "3dfef0ce2a4d80a25e7a328accf73448ef67096f65f79588e358d9a0eb9013f1",
+		},
+		{
+			sec1:            "a1e37752c9fdc1273be53f68c5f74be7c8905728e8de75800b94262f9497c86e",
+			pub2:            "03bb7947065dde12ba991ea045132581d0954f042c84e06d8c00066e23c1a800",
+			conversationKey: "4d14f36e81b8452128da64fe6f1eae873baae2f444b02c950b90e43553f2178b",
+		},
+		{
+			sec1:            "98a5902fd67518a0c900f0fb62158f278f94a21d6f9d33d30cd3091195500311",
+			pub2:            "aae65c15f98e5e677b5050de82e3aba47a6fe49b3dab7863cf35d9478ba9f7d1",
+			conversationKey: "9c00b769d5f54d02bf175b7284a1cbd28b6911b06cda6666b2243561ac96bad7",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sec1[:8], func(t *testing.T) {
+			key, err := ConversationKey(tt.pub2, tt.sec1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := hex.EncodeToString(key); got != tt.conversationKey {
+				t.Errorf("ConversationKey() = %s, want %s", got, tt.conversationKey)
+			}
+		})
+	}
+}
+
+func TestMessageKeys(t *testing.T) {
+	conversationKey := "a1a3d60f3470a8612633924e91febf96dc5366ce130f658b1f0fc652c20b3b54"
+	tests := []struct {
+		nonce       string
+		chachaKey   string
+		chachaNonce string
+		hmacKey     string
+	}{
+		{
+			nonce:       "e1e6f880560d6d149ed83dcc7e5861ee62a5ee051f7fde9975fe5d25d2a02d72",
+			chachaKey:   "f145f3bed47cb70dbeaac07f3a3fe683e822b3715edb7c4fe310829014ce7d76",
+			chachaNonce: "c4ad129bb01180c0933a160c",
+			hmacKey:     "027c1db445f05e2eee864a0975b0ddef5b7110583c8c192de3732571ca5838c4",
+		},
+		{
+			nonce:       "e1d6d28c46de60168b43d79dacc519698512ec35e8ccb12640fc8e9f26121101",
+			chachaKey:   "e35b88f8d4a8f1606c5082f7a64b100e5d85fcdb2e62aeafbec03fb9e860ad92",
+			chachaNonce: "22925e920cee4a50a478be90",
+			hmacKey:     "46a7c55d4283cb0df1d5e29540be67abfe709e3b2e14b7bf9976e6df994ded30",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.nonce[:8], func(t *testing.T) {
+			chachaKey, chachaNonce, hmacKey, err := messageKeys(mustHex(t, conversationKey), mustHex(t, tt.nonce))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := hex.EncodeToString(chachaKey); got != tt.chachaKey {
+				t.Errorf("chacha key = %s, want %s", got, tt.chachaKey)
+			}
+			if got := hex.EncodeToString(chachaNonce); got != tt.chachaNonce {
+				t.Errorf("chacha nonce = %s, want %s", got, tt.chachaNonce)
+			}
+			if got := hex.EncodeToString(hmacKey); got != tt.hmacKey {
+				t.Errorf("hmac key = %s, want %s", got, tt.hmacKey)
+			}
+		})
+	}
+}
+
+func Test_paddedLength(t *testing.T) {
+	tests := [][2]int{
+		{16, 32}, {32, 32}, {33, 64}, {37, 64}, {45, 64}, {49, 64}, {64, 64}, {65, 96}, {100, 128}, {111, 128},
+		{200, 224}, {250, 256}, {320, 320}, {383, 384}, {384, 384}, {400, 448}, {500, 512}, {512, 512}, {515, 640},
+		{700, 768}, {800, 896}, {900, 1024}, {1020, 1024}, {65536, 65536},
+	}
+	for _, tt := range tests {
+		if got := paddedLength(tt[0]); got != tt[1] {
+			t.Errorf("paddedLength(%d) = %d, want %d", tt[0], got, tt[1])
+		}
+	}
+}
+
+var encryptDecryptVectors = []struct {
+	sec1            string
+	sec2            string
+	conversationKey string
+	nonce           string
+	plaintext       string
+	payload         string
+}{
+	{
+		sec1:            "0000000000000000000000000000000000000000000000000000000000000001",
+		sec2:            "0000000000000000000000000000000000000000000000000000000000000002",
+		conversationKey: "c41c775356fd92eadc63ff5a0dc1da211b268cbea22316767095b2871ea1412d",
+		nonce:           "0000000000000000000000000000000000000000000000000000000000000001",
+		plaintext:       "a",
+		payload:         "AgAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAABee0G5VSK0/9YypIObAtDKfYEAjD35uVkHyB0F4DwrcNaCXlCWZKaArsGrY6M9wnuTMxWfp1RTN9Xga8no+kF5Vsb",
+	},
+	{
+		sec1:            "0000000000000000000000000000000000000000000000000000000000000002",
+		sec2:            "0000000000000000000000000000000000000000000000000000000000000001",
+		conversationKey: "c41c775356fd92eadc63ff5a0dc1da211b268cbea22316767095b2871ea1412d",
+		nonce:           "f00000000000000000000000000000f00000000000000000000000000000000f",
+		plaintext:       "🍕🫃",
+		payload:         "AvAAAAAAAAAAAAAAAAAAAPAAAAAAAAAAAAAAAAAAAAAPSKSK6is9ngkX2+cSq85Th16oRTISAOfhStnixqZziKMDvB0QQzgFZdjLTPicCJaV8nDITO+QfaQ61+KbWQIOO2Yj",
+	},
+	{
+		sec1:            "5c0c523f52a5b6fad39ed2403092df8cebc36318b39383bca6c00808626fab3a",
+		sec2:            "4b22aa260e4acb7021e32f38a6cdf4b673c6a277755bfce287e370c924dc936d",
+		conversationKey: "3e2b52a63be47d34fe0a80e34e73d436d6963bc8f39827f327057a9986c20a45",
+		nonce:           "b635236c42db20f021bb8d1cdff5ca75dd1a0cc72ea742ad750f33010b24f73b",
+		plaintext:       "表ポあA鷗ŒéＢ逍Üßªąñ丂㐀𠀀",
+		payload:         "ArY1I2xC2yDwIbuNHN/1ynXdGgzHLqdCrXUPMwELJPc7s7JqlCMJBAIIjfkpHReBPXeoMCyuClwgbT419jUWU1PwaNl4FEQYKCDKVJz+97Mp3K+Q2YGa77B6gpxB/lr1QgoqpDf7wDVrDmOqGoiPjWDqy8KzLueKDcm9BVP8xeTJIxs=",
+	},
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	for _, tt := range encryptDecryptVectors {
+		t.Run(tt.plaintext, func(t *testing.T) {
+			pub2, err := nostr.GetPublicKey(tt.sec2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			key, err := ConversationKey(pub2, tt.sec1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := hex.EncodeToString(key); got != tt.conversationKey {
+				t.Fatalf("ConversationKey() = %s, want %s", got, tt.conversationKey)
+			}
+			payload, err := encrypt(tt.plaintext, key, mustHex(t, tt.nonce))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if payload != tt.payload {
+				t.Errorf("encrypt() = %s, want %s", payload, tt.payload)
+			}
+			plaintext, err := Decrypt(tt.payload, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if plaintext != tt.plaintext {
+				t.Errorf("Decrypt() = %s, want %s", plaintext, tt.plaintext)
+			}
+		})
+	}
+}
+
+func TestEncrypt_roundtrip(t *testing.T) {
+	key := mustHex(t, encryptDecryptVectors[0].conversationKey)
+	for _, length := range []int{1, 32, 33, 1000, nip44MaxLength} {
+		plaintext := strings.Repeat("x", length)
+		payload, err := Encrypt(plaintext, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := Decrypt(payload, key)
+		if err != nil {
+			t.Fatalf("Decrypt() of %d bytes: %v", length, err)
+		}
+		if got != plaintext {
+			t.Errorf("Decrypt() of %d bytes returned another plaintext", length)
+		}
+	}
+	if _, err := Encrypt("", key); err == nil {
+		t.Errorf("Encrypt() accepted an empty plaintext")
+	}
+	if _, err := Encrypt(strings.Repeat("x", nip44MaxLength+1), key); err == nil {
+		t.Errorf("Encrypt() accepted a plaintext of %d bytes", nip44MaxLength+1)
+	}
+}
+
+func TestDecrypt_invalid(t *testing.T) {
+	vector := encryptDecryptVectors[2]
+	key := mustHex(t, vector.conversationKey)
+	data, err := base64.StdEncoding.DecodeString(vector.payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tamper := func(i int) string {
+		b := append([]byte{}, data...)
+		b[i] ^= 0x01
+		return base64.StdEncoding.EncodeToString(b)
+	}
+	otherKey := mustHex(t, encryptDecryptVectors[2].conversationKey)
+	otherKey[0] ^= 0x01
+	tests := []struct {
+		name    string
+		payload string
+		key     []byte
+	}{
+		{name: "invalid MAC", payload: tamper(len(data) - 1), key: key},
+		{name: "modified ciphertext", payload: tamper(40), key: key},
+		{name: "modified nonce", payload: tamper(1), key: key},
+		{name: "wrong conversation key", payload: vector.payload, key: otherKey},
+		{name: "unknown version", payload: tamper(0), key: key},
+		{name: "unknown encoding", payload: "#" + vector.payload[1:], key: key},
+		{name: "invalid base64", payload: "Ag" + strings.Repeat("!", 130), key: key},
+		{name: "too short", payload: vector.payload[:128], key: key},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Decrypt(tt.payload, tt.key); err == nil {
+				t.Errorf("Decrypt() accepted the payload")
+			}
+		})
+	}
+}